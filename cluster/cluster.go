@@ -4,72 +4,101 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"redisGo/config"
 	"redisGo/datastruct/dict"
 	"redisGo/db"
 	"redisGo/interface/redis"
-	"redisGo/lib/consistenthash"
 	"redisGo/lib/idgenerator"
 	"redisGo/lib/logger"
 	"redisGo/redis/client"
 	"redisGo/redis/reply"
 	"runtime/debug"
 	"strings"
+	"sync"
 
 	pool "github.com/jolestar/go-commons-pool/v2"
 )
 
 type Cluster struct {
 	self           string
-	db             *db.DB
-	peerPicker     *consistenthash.Map
-	peerConnection map[string]*pool.ObjectPool
-
-	transactions *dict.SimpleDict // id -> Transaction
+	db             *db.MultiDB
+	peerPicker     *slotTable
+	connMu         sync.Mutex
+	peerConnection map[string]*pool.ObjectPool // 连接池在第一次使用时创建, 以支持运行时加入的节点
+	redirect       bool                        // 为 true 时不转发命令, 而是返回 MOVED 让客户端连接负责的节点
+	asking         sync.Map                    // redis.Connection -> struct{}, 执行 ASKING 后的下一条命令可以访问正在迁入的槽
+	rebalancing    int32                       // 是否正在后台重新平衡槽
+	bus            *clusterBus                 // 集群总线, 监听失败时为 nil, 此时只使用配置中的节点
+
+	transactions *dict.ConcurrentDict // id -> Transaction, 本节点作为参与者的事务
+	coordinator  *txCoordinator       // 本节点作为协调者的事务
 	idGenerator  *idgenerator.IdGenerator
 }
 
-const (
-	replicas = 4
-)
-
+// MakeCluster 创建集群节点并启动集群总线
 func MakeCluster() *Cluster {
+	cluster := makeCluster()
+	if cluster.self != "" {
+		listener, err := net.Listen("tcp", busListenAddr(cluster.self))
+		if err != nil {
+			logger.Error("cluster bus disabled: " + err.Error())
+		} else {
+			cluster.startBus(listener)
+		}
+	}
+	return cluster
+}
+
+func makeCluster() *Cluster {
 	cluster := &Cluster{
 		self:           config.Properties.Self,
-		db:             db.MakeDB(),
-		peerPicker:     consistenthash.New(replicas, nil),
+		db:             db.MakeMultiDB(),
 		peerConnection: make(map[string]*pool.ObjectPool),
+		redirect:       config.Properties.ClusterRedirect,
 
-		transactions: dict.MakeSimple(),
+		transactions: dict.MakeConcurrent(16),
+		coordinator:  makeCoordinator(config.Properties.ClusterTxLogFilename),
 		idGenerator:  idgenerator.MakeGenerator("godis", config.Properties.Self),
 	}
-	if config.Properties.Peers != nil && len(config.Properties.Peers) > 0 && config.Properties.Self != "" {
-		contains := make(map[string]bool)
-		peers := make([]string, 0, len(config.Properties.Peers)+1)
-		for _, peer := range config.Properties.Peers {
-			if _, ok := contains[peer]; ok {
-				continue
-			}
-			contains[peer] = true
-			peers = append(peers, peer)
-		}
+	peers := make([]string, 0, len(config.Properties.Peers)+1)
+	if config.Properties.Self != "" {
+		// makeSlotTable 会去除重复的地址
+		peers = append(peers, config.Properties.Peers...)
 		peers = append(peers, config.Properties.Self)
-		cluster.peerPicker.Add(peers...)
-		ctx := context.Background()
-		for _, peer := range peers {
-			cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &ConnectionFactory{Peer: peer})
-		}
 	}
+	cluster.peerPicker = makeSlotTable(peers)
+	go cluster.recoverTransactions(cluster.coordinator.pending())
 	return cluster
 }
 
+// localDB 集群模式下只使用 0 号数据库
+func (cluster *Cluster) localDB() *db.DB {
+	return cluster.db.GetDB(0)
+}
+
 type CmdFunc func(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply
 
 func (cluster *Cluster) Close() {
+	if cluster.bus != nil {
+		cluster.bus.close()
+	}
+	cluster.coordinator.close()
+	ctx := context.Background()
+	cluster.connMu.Lock()
+	for _, connectionFactory := range cluster.peerConnection {
+		connectionFactory.Close(ctx)
+	}
+	cluster.connMu.Unlock()
 	cluster.db.Close()
 }
 
-var router = MakeRouter()
+var router map[string]CmdFunc
+
+// 命令处理函数会间接调用 Exec, 因此在 init 中初始化以避免初始化循环
+func init() {
+	router = MakeRouter()
+}
 
 func (cluster *Cluster) Exec(c redis.Connection, args [][]byte) (result redis.Reply) {
 	defer func() {
@@ -79,16 +108,23 @@ func (cluster *Cluster) Exec(c redis.Connection, args [][]byte) (result redis.Re
 		}
 	}()
 	cmd := strings.ToLower(string(args[0]))
+	if c != nil && c.InMultiState() && !isTxControl(cmd) {
+		return cluster.enqueue(c, args)
+	}
 	cmdFunc, ok := router[cmd]
 	if !ok {
-		return reply.MakeErrReply("ERR unknown command `" + cmd + "`")
+		return reply.MakeErrReply("ERR unknown command `" + cmd + "`, or not supported in cluster mode")
+	}
+	if cmd != "asking" {
+		// ASKING 只对紧接着的一条命令有效
+		defer cluster.asking.Delete(c)
 	}
 	result = cmdFunc(cluster, c, args)
 	return
 }
 
 // replay command to peer
-// cannot call Prepare, Commit, Rollback of self node
+// cannot call Prepare, Commit, Rollback of self node, use sendCmdLine instead
 func (cluster *Cluster) Relay(peer string, c redis.Connection, args [][]byte) redis.Reply {
 	if peer == cluster.self {
 		return cluster.db.Exec(c, args)
@@ -105,16 +141,46 @@ func (cluster *Cluster) Relay(peer string, c redis.Connection, args [][]byte) re
 	}
 }
 
-func (cluster *Cluster) AfterClientClose(c redis.Connection) {
+// relayAsking 先发送 ASKING 再发送命令, 用于访问正在迁入目标节点的槽
+func (cluster *Cluster) relayAsking(peer string, c redis.Connection, args [][]byte) redis.Reply {
+	if peer == cluster.self {
+		cluster.asking.Store(c, struct{}{})
+		return cluster.Exec(c, args)
+	}
+	peerClient, err := cluster.getPeerClient(peer)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	defer func() {
+		_ = cluster.returnPeerClient(peer, peerClient)
+	}()
+	if r := peerClient.Send(makeArgs("ASKING")); reply.IsErrorReply(r) {
+		return r
+	}
+	return peerClient.Send(args)
+}
 
+func (cluster *Cluster) AfterClientClose(c redis.Connection) {
+	cluster.asking.Delete(c)
+	cluster.db.AfterClientClose(c)
 }
 
-func (cluster *Cluster) getPeerClient(peer string) (*client.Client, error) {
+func (cluster *Cluster) getPool(peer string) *pool.ObjectPool {
+	cluster.connMu.Lock()
+	defer cluster.connMu.Unlock()
 	connectionFactory, ok := cluster.peerConnection[peer]
 	if !ok {
-		return nil, errors.New("connection factory not found")
+		connectionFactory = pool.NewObjectPoolWithDefaultConfig(context.Background(), &ConnectionFactory{Peer: peer})
+		cluster.peerConnection[peer] = connectionFactory
 	}
-	raw, err := connectionFactory.BorrowObject(context.Background())
+	return connectionFactory
+}
+
+func (cluster *Cluster) getPeerClient(peer string) (*client.Client, error) {
+	if peer == "" {
+		return nil, errors.New("ERR CLUSTERDOWN Hash slot not served")
+	}
+	raw, err := cluster.getPool(peer).BorrowObject(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -126,11 +192,7 @@ func (cluster *Cluster) getPeerClient(peer string) (*client.Client, error) {
 }
 
 func (cluster *Cluster) returnPeerClient(peer string, peerClient *client.Client) error {
-	connectionFactory, ok := cluster.peerConnection[peer]
-	if !ok {
-		return errors.New("connection factory not found")
-	}
-	return connectionFactory.ReturnObject(context.Background(), peerClient)
+	return cluster.getPool(peer).ReturnObject(context.Background(), peerClient)
 }
 func Ping(cluster *Cluster, r redis.Connection, args [][]byte) redis.Reply {
 	if len(args) == 1 {
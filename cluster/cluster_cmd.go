@@ -0,0 +1,348 @@
+package cluster
+
+import (
+	"net"
+	"redisGo/db"
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+// execCluster 实现 CLUSTER 命令, 支持集群客户端获取槽的分布
+func execCluster(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "cluster"}
+	}
+	sub := strings.ToLower(string(args[1]))
+	args = args[2:]
+	switch sub {
+	case "keyslot":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "cluster " + sub}
+		}
+		return reply.MakeIntReply(int64(getSlot(string(args[0]))))
+	case "countkeysinslot":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "cluster " + sub}
+		}
+		slot, errReply := parseSlot(args[0])
+		if errReply != nil {
+			return errReply
+		}
+		return reply.MakeIntReply(int64(cluster.countKeysInSlot(slot)))
+	case "myid":
+		return reply.MakeBulkReply([]byte(genNodeId(cluster.self)))
+	case "info":
+		return cluster.clusterInfo()
+	case "meet":
+		// CLUSTER MEET ip port [cluster-bus-port]
+		if len(args) != 2 && len(args) != 3 {
+			return &reply.ArgNumErrReply{Cmd: "cluster " + sub}
+		}
+		for _, port := range args[1:] {
+			if p, err := strconv.Atoi(string(port)); err != nil || p <= 0 || p > 65535 {
+				return reply.MakeErrReply("ERR Invalid node address specified: " + string(args[0]) + ":" + string(args[1]))
+			}
+		}
+		addr := net.JoinHostPort(string(args[0]), string(args[1]))
+		busAddr := defaultBusAddr(addr)
+		if len(args) == 3 {
+			busAddr = net.JoinHostPort(string(args[0]), string(args[2]))
+		}
+		if addr != cluster.self {
+			cluster.meet(addr, busAddr)
+		}
+		return &reply.OkReply{}
+	case "slots":
+		return cluster.clusterSlots()
+	case "shards":
+		return cluster.clusterShards()
+	case "nodes":
+		return cluster.clusterNodes()
+	case "getkeysinslot":
+		if len(args) != 2 {
+			return &reply.ArgNumErrReply{Cmd: "cluster " + sub}
+		}
+		slot, errReply := parseSlot(args[0])
+		if errReply != nil {
+			return errReply
+		}
+		count, err := strconv.Atoi(string(args[1]))
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR Invalid number of keys")
+		}
+		return cluster.getKeysInSlot(slot, count)
+	case "setslot":
+		return cluster.setSlot(args)
+	case "addnode":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "cluster " + sub}
+		}
+		return cluster.addNode(string(args[0]))
+	case "rebalance":
+		if len(args) != 0 {
+			return &reply.ArgNumErrReply{Cmd: "cluster " + sub}
+		}
+		return cluster.startRebalance()
+	case "registernode":
+		// 集群内部命令, 由 ADDNODE 发出: REGISTERNODE host:port@cport
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "cluster " + sub}
+		}
+		cluster.peerPicker.join(parseNodeAddr(string(args[0])))
+		return &reply.OkReply{}
+	case "setslotrange":
+		// 集群内部命令, 由 ADDNODE 发出, 新节点通过它获得槽的分布: SETSLOTRANGE node-id start end
+		if len(args) != 3 {
+			return &reply.ArgNumErrReply{Cmd: "cluster " + sub}
+		}
+		n := cluster.peerPicker.getNodeById(string(args[0]))
+		if n == nil {
+			return reply.MakeErrReply("ERR Unknown node " + string(args[0]))
+		}
+		start, errReply := parseSlot(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		end, errReply := parseSlot(args[2])
+		if errReply != nil {
+			return errReply
+		}
+		for slot := start; slot <= end; slot++ {
+			cluster.peerPicker.setOwner(slot, n.addr)
+		}
+		return &reply.OkReply{}
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try CLUSTER HELP.")
+}
+
+func parseSlot(raw []byte) (int, redis.Reply) {
+	slot, err := strconv.Atoi(string(raw))
+	if err != nil || slot < 0 || slot >= slotCount {
+		return 0, reply.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+func (cluster *Cluster) countKeysInSlot(slot int) int {
+	count := 0
+	cluster.localDB().ForEach(func(key string, _ *db.DataEntity) bool {
+		if getSlot(key) == slot {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+func (cluster *Cluster) getKeysInSlot(slot int, count int) redis.Reply {
+	keys := make([][]byte, 0)
+	cluster.localDB().ForEach(func(key string, _ *db.DataEntity) bool {
+		if len(keys) >= count {
+			return false
+		}
+		if getSlot(key) == slot {
+			keys = append(keys, []byte(key))
+		}
+		return true
+	})
+	return reply.MakeMultiBulkReply(keys)
+}
+
+// setSlot CLUSTER SETSLOT slot IMPORTING|MIGRATING|NODE node-id, CLUSTER SETSLOT slot STABLE
+func (cluster *Cluster) setSlot(args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "cluster setslot"}
+	}
+	slot, errReply := parseSlot(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		cluster.peerPicker.setStable(slot)
+		return &reply.OkReply{}
+	}
+	if len(args) != 3 {
+		return &reply.SyntaxErrReply{}
+	}
+	n := cluster.peerPicker.getNodeById(string(args[2]))
+	if n == nil {
+		return reply.MakeErrReply("ERR I don't know about node " + string(args[2]))
+	}
+	owner, migrating, _ := cluster.peerPicker.getSlotState(slot)
+	switch action {
+	case "importing":
+		if owner == cluster.self {
+			return reply.MakeErrReply("ERR I'm already the owner of hash slot " + strconv.Itoa(slot))
+		}
+		cluster.peerPicker.setImporting(slot, n.addr)
+	case "migrating":
+		if owner != cluster.self {
+			return reply.MakeErrReply("ERR I'm not the owner of hash slot " + strconv.Itoa(slot))
+		}
+		cluster.peerPicker.setMigrating(slot, n.addr)
+	case "node":
+		// 迁出的槽中还有 key 时不能交给其它节点, 否则这些 key 将无法访问
+		if migrating != "" && n.addr != cluster.self && len(cluster.getKeysInSlot(slot, 1).(*reply.MultiBulkReply).Args) > 0 {
+			return reply.MakeErrReply("ERR Can't assign hashslot " + strconv.Itoa(slot) +
+				" to a different node while I still hold keys for this hash slot.")
+		}
+		if n.addr == cluster.self && owner != cluster.self {
+			cluster.peerPicker.bumpConfigEpoch(cluster.self)
+		}
+		cluster.peerPicker.setOwner(slot, n.addr)
+	default:
+		return &reply.SyntaxErrReply{}
+	}
+	return &reply.OkReply{}
+}
+
+// splitAddr 将节点地址拆分为 host 和 port, 用于回复集群客户端
+func splitAddr(addr string) (string, int) {
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// clusterInfo 负责槽的节点 FAIL 或者有槽没有分配时集群状态为 fail
+func (cluster *Cluster) clusterInfo() redis.Reply {
+	nodes := cluster.peerPicker.getNodes()
+	nodeOf := make(map[string]*node, len(nodes))
+	for _, n := range nodes {
+		nodeOf[n.addr] = n
+	}
+	assigned, pfail, fail := 0, 0, 0
+	masters := make(map[string]struct{})
+	for _, r := range cluster.peerPicker.getRanges() {
+		count := r.end - r.start + 1
+		assigned += count
+		masters[r.addr] = struct{}{}
+		if n := nodeOf[r.addr]; n != nil && n.fail {
+			fail += count
+		} else if n != nil && n.pfail {
+			pfail += count
+		}
+	}
+	state := "ok"
+	if assigned < slotCount || fail > 0 {
+		state = "fail"
+	}
+	var myEpoch int64
+	if myself := nodeOf[cluster.self]; myself != nil {
+		myEpoch = myself.configEpoch
+	}
+	info := "cluster_enabled:1\r\n" +
+		"cluster_state:" + state + "\r\n" +
+		"cluster_slots_assigned:" + strconv.Itoa(assigned) + "\r\n" +
+		"cluster_slots_ok:" + strconv.Itoa(assigned-pfail-fail) + "\r\n" +
+		"cluster_slots_pfail:" + strconv.Itoa(pfail) + "\r\n" +
+		"cluster_slots_fail:" + strconv.Itoa(fail) + "\r\n" +
+		"cluster_known_nodes:" + strconv.Itoa(len(nodes)) + "\r\n" +
+		"cluster_size:" + strconv.Itoa(len(masters)) + "\r\n" +
+		"cluster_current_epoch:" + strconv.FormatInt(cluster.peerPicker.getCurrentEpoch(), 10) + "\r\n" +
+		"cluster_my_epoch:" + strconv.FormatInt(myEpoch, 10) + "\r\n"
+	return reply.MakeBulkReply([]byte(info))
+}
+
+// clusterSlots 返回 [[start, end, [host, port, id]], ...]
+func (cluster *Cluster) clusterSlots() redis.Reply {
+	ranges := cluster.peerPicker.getRanges()
+	replies := make([]redis.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		host, port := splitAddr(r.addr)
+		nodeReply := reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte(host)),
+			reply.MakeIntReply(int64(port)),
+			reply.MakeBulkReply([]byte(genNodeId(r.addr))),
+		})
+		replies = append(replies, reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeIntReply(int64(r.start)),
+			reply.MakeIntReply(int64(r.end)),
+			nodeReply,
+		}))
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// clusterShards 每个节点是一个分片, 返回分片负责的槽以及分片中的节点
+func (cluster *Cluster) clusterShards() redis.Reply {
+	slotsOf := make(map[string][]redis.Reply)
+	for _, r := range cluster.peerPicker.getRanges() {
+		slotsOf[r.addr] = append(slotsOf[r.addr], reply.MakeIntReply(int64(r.start)), reply.MakeIntReply(int64(r.end)))
+	}
+	nodes := cluster.peerPicker.getNodes()
+	replies := make([]redis.Reply, 0, len(nodes))
+	for _, n := range nodes {
+		host, port := splitAddr(n.addr)
+		nodeReply := reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte("id")), reply.MakeBulkReply([]byte(n.id)),
+			reply.MakeBulkReply([]byte("port")), reply.MakeIntReply(int64(port)),
+			reply.MakeBulkReply([]byte("ip")), reply.MakeBulkReply([]byte(host)),
+			reply.MakeBulkReply([]byte("endpoint")), reply.MakeBulkReply([]byte(host)),
+			reply.MakeBulkReply([]byte("role")), reply.MakeBulkReply([]byte("master")),
+			reply.MakeBulkReply([]byte("replication-offset")), reply.MakeIntReply(0),
+			reply.MakeBulkReply([]byte("health")), reply.MakeBulkReply([]byte("online")),
+		})
+		replies = append(replies, reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte("slots")), reply.MakeMultiRawReply(slotsOf[n.addr]),
+			reply.MakeBulkReply([]byte("nodes")), reply.MakeMultiRawReply([]redis.Reply{nodeReply}),
+		}))
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// clusterNodes 格式与 Redis 一致:
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func (cluster *Cluster) clusterNodes() redis.Reply {
+	slotsOf := make(map[string][]string)
+	for _, r := range cluster.peerPicker.getRanges() {
+		s := strconv.Itoa(r.start)
+		if r.end != r.start {
+			s += "-" + strconv.Itoa(r.end)
+		}
+		slotsOf[r.addr] = append(slotsOf[r.addr], s)
+	}
+	// 正在迁移的槽显示在本节点的信息中: [slot->-目标节点] 与 [slot-<-源节点]
+	migrating, importing := cluster.peerPicker.getMigrations()
+	for slot, addr := range migrating {
+		slotsOf[cluster.self] = append(slotsOf[cluster.self], "["+strconv.Itoa(slot)+"->-"+genNodeId(addr)+"]")
+	}
+	for slot, addr := range importing {
+		slotsOf[cluster.self] = append(slotsOf[cluster.self], "["+strconv.Itoa(slot)+"-<-"+genNodeId(addr)+"]")
+	}
+	var sb strings.Builder
+	for _, n := range cluster.peerPicker.getNodes() {
+		host, port := splitAddr(n.addr)
+		_, busPort := splitAddr(n.busAddr)
+		flags, pingSent, pongRecv, linkState := "master", int64(0), int64(0), "connected"
+		if n.addr == cluster.self {
+			flags = "myself,master"
+		} else {
+			if n.fail {
+				flags += ",fail"
+			} else if n.pfail {
+				flags += ",fail?"
+			}
+			if n.handshake {
+				flags += ",handshake"
+			}
+			if !n.pingSent.IsZero() {
+				pingSent = n.pingSent.UnixMilli()
+			}
+			pongRecv = n.pongRecv.UnixMilli()
+			if n.pfail || n.fail {
+				linkState = "disconnected"
+			}
+		}
+		sb.WriteString(n.id + " " + host + ":" + strconv.Itoa(port) + "@" + strconv.Itoa(busPort) + " " +
+			flags + " - " + strconv.FormatInt(pingSent, 10) + " " + strconv.FormatInt(pongRecv, 10) + " " +
+			strconv.FormatInt(n.configEpoch, 10) + " " + linkState)
+		for _, s := range slotsOf[n.addr] {
+			sb.WriteString(" " + s)
+		}
+		sb.WriteString("\n")
+	}
+	return reply.MakeBulkReply([]byte(sb.String()))
+}
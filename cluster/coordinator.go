@@ -0,0 +1,556 @@
+package cluster
+
+import (
+	"os"
+	"redisGo/db"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/redis/parser"
+	"redisGo/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 两阶段提交的协调者.
+// 协调者将事务的参与者与提交结果写入恢复日志, 日志由以下 RESP 数组组成:
+//   BEGIN txId peer [peer ...]
+//   COMMIT txId peer argc arg... [peer argc arg... ...]   每个参与者提交时需要的参数
+//   ROLLBACK txId
+//   END txId                                              所有参与者都已收到结果
+// 没有 COMMIT 记录的事务视为回滚 (presumed abort), 记录 COMMIT 之后不能再回滚. 协调者重启后重新发送未结束事务的结果,
+// 参与者查询 (TXSTATUS) 尚未决定的事务时协调者将其回滚, 因此参与者不会一直等待.
+// 所有参与者都收到结果后协调者记录 END, 然后向参与者发送 END 清理事务的状态
+
+const (
+	txRetryInterval       = time.Second
+	txLogCompactThreshold = 1024 // 没有未结束的事务且日志超过该记录数时清空日志
+)
+
+type txRecord struct {
+	status       int8 // PreparedStatus 表示尚未决定
+	participants []string
+	commits      map[string][][]byte // peer -> COMMIT 的参数
+}
+
+type txCoordinator struct {
+	mu      sync.Mutex
+	records map[string]*txRecord
+	file    *os.File // 为 nil 时不记录日志
+	logged  int
+	closed  chan struct{}
+}
+
+// makeCoordinator 加载恢复日志, 返回的协调者中包含日志里尚未结束的事务
+func makeCoordinator(filename string) *txCoordinator {
+	coordinator := &txCoordinator{
+		records: make(map[string]*txRecord),
+		closed:  make(chan struct{}),
+	}
+	if filename == "" {
+		return coordinator
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		logger.Error("open transaction log failed: " + err.Error())
+		return coordinator
+	}
+	coordinator.file = file
+	parser.ParseStream(file, func(payload *parser.Payload) bool {
+		if payload.Err != nil {
+			// 文件结束或者末尾的记录不完整
+			return false
+		}
+		multiBulk, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) < 2 {
+			return true
+		}
+		coordinator.replay(multiBulk.Args)
+		return true
+	})
+	coordinator.rewrite()
+	return coordinator
+}
+
+func (coordinator *txCoordinator) replay(args [][]byte) {
+	txId := string(args[1])
+	if strings.ToUpper(string(args[0])) == "BEGIN" {
+		participants := make([]string, 0, len(args)-2)
+		for _, peer := range args[2:] {
+			participants = append(participants, string(peer))
+		}
+		coordinator.records[txId] = &txRecord{status: PreparedStatus, participants: participants}
+		return
+	}
+	record, ok := coordinator.records[txId]
+	if !ok {
+		return
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "COMMIT":
+		commits, ok := decodeCommits(args[2:])
+		if !ok {
+			return
+		}
+		record.status = CommittedStatus
+		record.commits = commits
+	case "ROLLBACK":
+		record.status = RollbackedStatus
+	case "END":
+		delete(coordinator.records, txId)
+	}
+}
+
+func encodeCommits(commits map[string][][]byte) [][]byte {
+	result := make([][]byte, 0)
+	for peer, args := range commits {
+		result = append(result, []byte(peer), []byte(strconv.Itoa(len(args))))
+		result = append(result, args...)
+	}
+	return result
+}
+
+func decodeCommits(args [][]byte) (map[string][][]byte, bool) {
+	commits := make(map[string][][]byte)
+	for i := 0; i < len(args); {
+		if i+2 > len(args) {
+			return nil, false
+		}
+		argc, err := strconv.Atoi(string(args[i+1]))
+		if err != nil || argc < 0 || i+2+argc > len(args) {
+			return nil, false
+		}
+		commits[string(args[i])] = args[i+2 : i+2+argc]
+		i += 2 + argc
+	}
+	return commits, true
+}
+
+// appendLog 写入一条日志, 提交与回滚的决定需要在通知参与者之前落盘. 调用者需要持有 mu
+func (coordinator *txCoordinator) appendLog(sync bool, args ...[]byte) {
+	if coordinator.file == nil {
+		return
+	}
+	if _, err := coordinator.file.Write(reply.MakeMultiBulkReply(args).ToBytes()); err != nil {
+		logger.Error("write transaction log failed: " + err.Error())
+		return
+	}
+	coordinator.logged++
+	if sync {
+		if err := coordinator.file.Sync(); err != nil {
+			logger.Error("fsync transaction log failed: " + err.Error())
+		}
+	}
+}
+
+// rewrite 清空日志后重新写入尚未结束的事务. 调用者需要持有 mu 或者协调者尚未开始工作
+func (coordinator *txCoordinator) rewrite() {
+	if coordinator.file == nil {
+		return
+	}
+	if err := coordinator.file.Truncate(0); err != nil {
+		logger.Error("truncate transaction log failed: " + err.Error())
+		return
+	}
+	coordinator.logged = 0
+	for txId, record := range coordinator.records {
+		beginArgs := [][]byte{[]byte("BEGIN"), []byte(txId)}
+		for _, peer := range record.participants {
+			beginArgs = append(beginArgs, []byte(peer))
+		}
+		coordinator.appendLog(false, beginArgs...)
+		switch record.status {
+		case CommittedStatus:
+			coordinator.appendLog(false, append([][]byte{[]byte("COMMIT"), []byte(txId)}, encodeCommits(record.commits)...)...)
+		case RollbackedStatus:
+			coordinator.appendLog(false, []byte("ROLLBACK"), []byte(txId))
+		}
+	}
+	if err := coordinator.file.Sync(); err != nil {
+		logger.Error("fsync transaction log failed: " + err.Error())
+	}
+}
+
+func (coordinator *txCoordinator) begin(txId string, participants []string) {
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	coordinator.records[txId] = &txRecord{status: PreparedStatus, participants: participants}
+	args := [][]byte{[]byte("BEGIN"), []byte(txId)}
+	for _, peer := range participants {
+		args = append(args, []byte(peer))
+	}
+	coordinator.appendLog(false, args...)
+}
+
+// decideCommit 记录提交的决定, 事务已经因为参与者的查询而回滚时返回 false
+func (coordinator *txCoordinator) decideCommit(txId string, commits map[string][][]byte) bool {
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	record, ok := coordinator.records[txId]
+	if !ok || record.status != PreparedStatus {
+		return false
+	}
+	record.status = CommittedStatus
+	record.commits = commits
+	coordinator.appendLog(true, append([][]byte{[]byte("COMMIT"), []byte(txId)}, encodeCommits(commits)...)...)
+	return true
+}
+
+// decideRollback 记录回滚的决定, 已经记录提交的事务不能再回滚
+func (coordinator *txCoordinator) decideRollback(txId string) {
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	record, ok := coordinator.records[txId]
+	if !ok || record.status != PreparedStatus {
+		return
+	}
+	record.status = RollbackedStatus
+	coordinator.appendLog(true, []byte("ROLLBACK"), []byte(txId))
+}
+
+// end 所有参与者都已收到结果, 不再需要保留事务
+func (coordinator *txCoordinator) end(txId string) {
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	if _, ok := coordinator.records[txId]; !ok {
+		return
+	}
+	delete(coordinator.records, txId)
+	coordinator.appendLog(false, []byte("END"), []byte(txId))
+	if len(coordinator.records) == 0 && coordinator.logged > txLogCompactThreshold {
+		coordinator.rewrite()
+	}
+}
+
+// status 返回参与者应当执行的操作, 尚未决定的事务在此时回滚.
+// 没有记录的事务返回 unknown, 协调者不会再发送它的结果
+func (coordinator *txCoordinator) status(txId string, peer string) redis.Reply {
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	record, ok := coordinator.records[txId]
+	if !ok {
+		return reply.MakeMultiBulkReply([][]byte{[]byte("unknown")})
+	}
+	if record.status == PreparedStatus {
+		record.status = RollbackedStatus
+		coordinator.appendLog(true, []byte("ROLLBACK"), []byte(txId))
+	}
+	if record.status == RollbackedStatus {
+		return reply.MakeMultiBulkReply([][]byte{[]byte("rollback")})
+	}
+	return reply.MakeMultiBulkReply(append([][]byte{[]byte("commit")}, record.commits[peer]...))
+}
+
+// pending 返回尚未结束的事务, 启动时即为恢复日志中需要恢复的事务
+func (coordinator *txCoordinator) pending() map[string]*txRecord {
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	result := make(map[string]*txRecord, len(coordinator.records))
+	for txId, record := range coordinator.records {
+		copied := *record
+		result[txId] = &copied
+	}
+	return result
+}
+
+func (coordinator *txCoordinator) close() {
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	close(coordinator.closed)
+	if coordinator.file != nil {
+		_ = coordinator.file.Close()
+		coordinator.file = nil
+	}
+}
+
+// execTxStatus TXSTATUS txId peer, 参与者等待超时后向协调者查询事务的结果,
+// 返回 commit [arg ...], rollback 或 unknown
+func execTxStatus(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return &reply.ArgNumErrReply{Cmd: "txstatus"}
+	}
+	return cluster.coordinator.status(string(args[1]), string(args[2]))
+}
+
+// txSplitter 将命令拆分为只涉及各个节点上的 key 的子命令, 参与者在提交时直接执行子命令
+type txSplitter struct {
+	split func(cmdLine [][]byte, groups map[string][]string) map[string][][]byte
+	merge func(results []redis.Reply) redis.Reply
+}
+
+var txSplitters = map[string]*txSplitter{
+	"del": {
+		split: func(cmdLine [][]byte, groups map[string][]string) map[string][][]byte {
+			result := make(map[string][][]byte, len(groups))
+			for peer, keys := range groups {
+				result[peer] = makeArgs("DEL", keys...)
+			}
+			return result
+		},
+		merge: func(results []redis.Reply) redis.Reply {
+			var deleted int64
+			for _, r := range results {
+				if intReply, ok := r.(*reply.IntReply); ok {
+					deleted += intReply.Code
+				}
+			}
+			return reply.MakeIntReply(deleted)
+		},
+	},
+	"mset": {
+		split: func(cmdLine [][]byte, groups map[string][]string) map[string][][]byte {
+			values := make(map[string][]byte)
+			for i := 1; i+1 < len(cmdLine); i += 2 {
+				values[string(cmdLine[i])] = cmdLine[i+1]
+			}
+			result := make(map[string][][]byte, len(groups))
+			for peer, keys := range groups {
+				args := makeArgs("MSET")
+				for _, key := range keys {
+					args = append(args, []byte(key), values[key])
+				}
+				result[peer] = args
+			}
+			return result
+		},
+		merge: func(results []redis.Reply) redis.Reply {
+			return &reply.OkReply{}
+		},
+	},
+}
+
+// txPlan 描述一次两阶段提交: 参与者以及发给它们的 PREPARE 命令.
+// LOCK 模式下由 simulate 根据参与者返回的快照计算结果, EXEC 模式下由 merge 合并参与者提交的结果
+type txPlan struct {
+	peers    []string // 按地址排序, 按相同的顺序准备以避免两个协调者互相等待对方持有的锁
+	prepares map[string][][]byte
+	simulate func(snapshots []*keyImage) (redis.Reply, []*keyImage)
+	merge    func(results map[string]redis.Reply) redis.Reply
+}
+
+func (cluster *Cluster) nextTxId() string {
+	return cluster.self + "#" + strconv.FormatInt(cluster.idGenerator.NextId(), 10)
+}
+
+func sortedPeers(groups map[string][]string) []string {
+	peers := make([]string, 0, len(groups))
+	for peer := range groups {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// execTx 原子地执行涉及多个节点的写命令.
+// DEL, MSET 等可以拆分的命令由各个参与者在提交时执行子命令;
+// 其它命令由参与者锁定 key 并返回快照, 协调者在临时 DB 中执行命令, 提交时参与者写入结果的快照
+func (cluster *Cluster) execTx(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	writeKeys, readKeys, ok := db.GetRelatedKeys(cmdLine)
+	if !ok {
+		return reply.MakeErrReply("ERR unknown command `" + cmdName + "`")
+	}
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(keys, writeKeys...)
+	keys = append(keys, readKeys...)
+	groups := cluster.groupBy(keys)
+	if len(groups) == 0 {
+		// 参数错误, 由 db 返回错误信息
+		return cluster.db.Exec(c, cmdLine)
+	}
+	if len(groups) == 1 {
+		return cluster.execByKeys(c, keys, cmdLine)
+	}
+
+	txId := cluster.nextTxId()
+	plan := &txPlan{
+		peers:    sortedPeers(groups),
+		prepares: make(map[string][][]byte, len(groups)),
+	}
+	if splitter := txSplitters[cmdName]; splitter != nil {
+		subCmds := splitter.split(cmdLine, groups)
+		for _, peer := range plan.peers {
+			plan.prepares[peer] = append(makeArgs("PREPARE", txId, txModeExec), subCmds[peer]...)
+		}
+		plan.merge = func(results map[string]redis.Reply) redis.Reply {
+			replies := make([]redis.Reply, 0, len(results))
+			for _, r := range results {
+				replies = append(replies, r)
+			}
+			return splitter.merge(replies)
+		}
+	} else {
+		for _, peer := range plan.peers {
+			plan.prepares[peer] = append(makeArgs("PREPARE", txId, txModeLock), cmdLine...)
+		}
+		plan.simulate = func(snapshots []*keyImage) (redis.Reply, []*keyImage) {
+			results, images := simulate([][][]byte{cmdLine}, snapshots, writeKeys)
+			return results[0], images
+		}
+	}
+	return cluster.runTx(txId, plan)
+}
+
+// runTx 执行两阶段提交, 任何参与者准备失败时回滚并返回错误
+func (cluster *Cluster) runTx(txId string, plan *txPlan) redis.Reply {
+	cluster.coordinator.begin(txId, plan.peers)
+	snapshots := make([]*keyImage, 0)
+	for _, peer := range plan.peers {
+		result := cluster.sendCmdLine(peer, plan.prepares[peer])
+		if reply.IsErrorReply(result) {
+			cluster.abortTx(txId, plan.peers)
+			return result
+		}
+		if plan.simulate != nil {
+			multiBulk, ok := result.(*reply.MultiBulkReply)
+			images, valid := []*keyImage(nil), false
+			if ok {
+				images, valid = decodeImages(multiBulk.Args)
+			}
+			if !valid {
+				cluster.abortTx(txId, plan.peers)
+				return reply.MakeErrReply("ERR unexpected reply from " + peer)
+			}
+			snapshots = append(snapshots, images...)
+		}
+	}
+
+	commits := make(map[string][][]byte, len(plan.peers))
+	for _, peer := range plan.peers {
+		commits[peer] = [][]byte{}
+	}
+	var result redis.Reply
+	if plan.simulate != nil {
+		var images []*keyImage
+		result, images = plan.simulate(snapshots)
+		if reply.IsErrorReply(result) {
+			cluster.abortTx(txId, plan.peers)
+			return result
+		}
+		for _, image := range images {
+			owner := cluster.peerPicker.Get(image.key)
+			commits[owner] = append(commits[owner], encodeImages([]*keyImage{image})...)
+		}
+	}
+	if !cluster.coordinator.decideCommit(txId, commits) {
+		cluster.abortTx(txId, plan.peers)
+		return reply.MakeErrReply("ERR transaction " + txId + " aborted")
+	}
+
+	// 提交的决定已经落盘, 之后不能再回滚. 没有送达的参与者由 deliver 在后台重试,
+	// 参与者等待超时后也会向协调者查询到提交的结果
+	cmdLines := make(map[string][][]byte, len(plan.peers))
+	for _, peer := range plan.peers {
+		cmdLines[peer] = append(makeArgs("COMMIT", txId), commits[peer]...)
+	}
+	results := cluster.deliver(txId, cmdLines)
+	if plan.merge == nil {
+		return result
+	}
+	if len(results) < len(plan.peers) {
+		return reply.MakeErrReply("ERR transaction " + txId + " committed, but some nodes have not confirmed yet")
+	}
+	return plan.merge(results)
+}
+
+// simulate 在临时 DB 中根据快照依次执行命令, 返回每条命令的结果与 writeKeys 执行后的快照
+func simulate(cmdLines [][][]byte, snapshots []*keyImage, writeKeys []string) ([]redis.Reply, []*keyImage) {
+	scratch := db.MakeDB()
+	for _, image := range snapshots {
+		image.restore(scratch)
+	}
+	results := make([]redis.Reply, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		results = append(results, scratch.ExecWithLock(cmdLine))
+	}
+	writeKeys = uniqueKeys(writeKeys)
+	images := make([]*keyImage, 0, len(writeKeys))
+	for _, key := range writeKeys {
+		images = append(images, takeImage(scratch, key))
+	}
+	return results, images
+}
+
+// abortTx 记录回滚的决定并通知所有参与者
+func (cluster *Cluster) abortTx(txId string, peers []string) {
+	cluster.coordinator.decideRollback(txId)
+	cmdLines := make(map[string][][]byte, len(peers))
+	for _, peer := range peers {
+		cmdLines[peer] = makeArgs("ROLLBACK", txId)
+	}
+	cluster.deliver(txId, cmdLines)
+}
+
+// deliver 将事务的结果发送给参与者并返回第一次就送达的回复, 发送失败的在后台重试, 全部送达后结束事务.
+// 参与者不认识该事务或者已经处于另一种状态时记录错误并停止重试, 事务保留在恢复日志中不会结束
+func (cluster *Cluster) deliver(txId string, cmdLines map[string][][]byte) map[string]redis.Reply {
+	results := make(map[string]redis.Reply, len(cmdLines))
+	failed := make(map[string][][]byte)
+	for peer, cmdLine := range cmdLines {
+		if r := cluster.sendCmdLine(peer, cmdLine); reply.IsErrorReply(r) {
+			failed[peer] = cmdLine
+		} else {
+			results[peer] = r
+		}
+	}
+	if len(failed) == 0 {
+		cluster.endTx(txId, cmdLines)
+		return results
+	}
+	go func() {
+		conflicted := false
+		for len(failed) > 0 {
+			select {
+			case <-cluster.coordinator.closed:
+				return
+			case <-time.After(txRetryInterval):
+			}
+			for peer, cmdLine := range failed {
+				r := cluster.sendCmdLine(peer, cmdLine)
+				if !reply.IsErrorReply(r) {
+					delete(failed, peer)
+				} else if strings.HasPrefix(r.(reply.ErrorReply).Error(), "ERR transaction") {
+					// 参与者已经处于另一种状态或者丢失了事务, 重试没有意义
+					logger.Error("deliver " + string(cmdLine[0]) + " of " + txId + " to " + peer + ": " + string(r.ToBytes()))
+					delete(failed, peer)
+					conflicted = true
+				}
+			}
+		}
+		if !conflicted {
+			cluster.endTx(txId, cmdLines)
+		}
+	}()
+	return results
+}
+
+// endTx 所有参与者都已收到结果, 先记录 END 再通知参与者清理, 参与者没有收到 END 时只是多保留一份事务的状态
+func (cluster *Cluster) endTx(txId string, cmdLines map[string][][]byte) {
+	cluster.coordinator.end(txId)
+	for peer := range cmdLines {
+		if r := cluster.sendTo(peer, "END", txId); reply.IsErrorReply(r) {
+			logger.Warn("end transaction " + txId + " on " + peer + ": " + string(r.ToBytes()))
+		}
+	}
+}
+
+// recoverTransactions 重新发送恢复日志中尚未结束的事务的结果
+func (cluster *Cluster) recoverTransactions(records map[string]*txRecord) {
+	for txId, record := range records {
+		cmdLines := make(map[string][][]byte, len(record.participants))
+		for _, peer := range record.participants {
+			if record.status == CommittedStatus {
+				cmdLines[peer] = append(makeArgs("COMMIT", txId), record.commits[peer]...)
+			} else {
+				cmdLines[peer] = makeArgs("ROLLBACK", txId)
+			}
+		}
+		if record.status != CommittedStatus {
+			cluster.coordinator.decideRollback(txId)
+		}
+		logger.Info("recover transaction " + txId + ": " + strings.ToLower(string(cmdLines[record.participants[0]][0])))
+		cluster.deliver(txId, cmdLines)
+	}
+}
@@ -0,0 +1,546 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/redis/parser"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	busPortOffset      = 10000 // 默认的集群总线端口为数据端口 + 10000
+	defaultNodeTimeout = 15 * time.Second
+	maxGossipPeriod    = time.Second
+)
+
+// 集群总线上的消息类型
+const (
+	msgPing = "PING"
+	msgPong = "PONG"
+	msgMeet = "MEET" // 与 PING 相同, 但接收者会加入发送者所在的集群
+	msgFail = "FAIL" // 通知其它节点某个节点已经客观下线
+)
+
+// gossipMessage 集群总线上传输的消息, 编码为 JSON 后作为 RESP 命令 <type> <payload> 发送, PONG 以 bulk 回复返回
+type gossipMessage struct {
+	Type         string        `json:"-"`
+	Sender       string        `json:"sender"`
+	BusAddr      string        `json:"bus"`
+	CurrentEpoch int64         `json:"currentEpoch"`
+	ConfigEpoch  int64         `json:"configEpoch"`
+	Slots        []byte        `json:"slots"` // 发送者负责的槽, 每个槽占一位
+	Gossip       []gossipEntry `json:"gossip,omitempty"`
+	Failed       string        `json:"failed,omitempty"` // FAIL 消息中下线的节点
+}
+
+// gossipEntry 发送者眼中其它节点的状态, 用于发现新节点以及收集下线报告
+type gossipEntry struct {
+	Addr    string `json:"addr"`
+	BusAddr string `json:"bus"`
+	PFail   bool   `json:"pfail,omitempty"`
+	Fail    bool   `json:"fail,omitempty"`
+}
+
+var errLinkBusy = errors.New("bus link busy")
+
+// busLink 到其它节点总线的连接, 同一时间只发送一个请求, 出错时关闭连接, 下次使用时重新建立
+type busLink struct {
+	busy    int32
+	conn    net.Conn
+	replies <-chan *parser.Payload
+}
+
+func (link *busLink) send(busAddr string, args [][]byte, timeout time.Duration) (redis.Reply, error) {
+	if !atomic.CompareAndSwapInt32(&link.busy, 0, 1) {
+		return nil, errLinkBusy
+	}
+	defer atomic.StoreInt32(&link.busy, 0)
+	if link.conn == nil {
+		conn, err := net.DialTimeout("tcp", busAddr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		link.conn = conn
+		link.replies = parser.Parse(conn)
+	}
+	_ = link.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := link.conn.Write(reply.MakeMultiBulkReply(args).ToBytes()); err != nil {
+		link.closeConn()
+		return nil, err
+	}
+	select {
+	case payload, ok := <-link.replies:
+		if !ok || payload.Err != nil {
+			link.closeConn()
+			return nil, errors.New("bus link closed")
+		}
+		return payload.Data, nil
+	case <-time.After(timeout):
+		link.closeConn()
+		return nil, errors.New("bus link timeout")
+	}
+}
+
+func (link *busLink) closeConn() {
+	if link.conn != nil {
+		_ = link.conn.Close()
+		link.conn = nil
+	}
+}
+
+// clusterBus 集群总线: 接收其它节点的消息, 定期向所有节点发送 PING, 根据回复判断节点是否下线.
+// 节点在 nodeTimeout 内没有回复 PONG 时标记为 PFAIL, 负责槽的节点中多数认为其 PFAIL 时标记为 FAIL 并通知所有节点
+type clusterBus struct {
+	cluster     *Cluster
+	listener    net.Listener
+	nodeTimeout time.Duration
+	period      time.Duration
+
+	linksMu sync.Mutex
+	links   map[string]*busLink // addr -> link
+	conns   sync.Map            // 接收的连接, 关闭时一并关闭
+
+	closed chan struct{}
+}
+
+// busListenAddr 集群总线监听的地址, 端口由 cluster-port 指定, 默认为数据端口 + 10000
+func busListenAddr(self string) string {
+	port := config.Properties.ClusterPort
+	if port == 0 {
+		_, selfPort := splitAddr(self)
+		port = selfPort + busPortOffset
+	}
+	return net.JoinHostPort(config.Properties.Bind, strconv.Itoa(port))
+}
+
+// startBus 在 listener 上启动集群总线
+func (cluster *Cluster) startBus(listener net.Listener) {
+	bus := &clusterBus{
+		cluster:     cluster,
+		listener:    listener,
+		nodeTimeout: time.Duration(config.Properties.ClusterNodeTimeout) * time.Millisecond,
+		links:       make(map[string]*busLink),
+		closed:      make(chan struct{}),
+	}
+	if bus.nodeTimeout <= 0 {
+		bus.nodeTimeout = defaultNodeTimeout
+	}
+	// 下线判定时间内至少发送 5 次 PING
+	bus.period = bus.nodeTimeout / 5
+	if bus.period > maxGossipPeriod {
+		bus.period = maxGossipPeriod
+	}
+	// 其它节点通过 gossip 得知本节点实际监听的总线端口
+	host, _ := splitAddr(cluster.self)
+	_, port := splitAddr(listener.Addr().String())
+	t := cluster.peerPicker
+	t.mu.Lock()
+	t.nodes[cluster.self].busAddr = net.JoinHostPort(host, strconv.Itoa(port))
+	t.mu.Unlock()
+
+	cluster.bus = bus
+	go bus.serve()
+	go bus.cronLoop()
+}
+
+func (bus *clusterBus) close() {
+	close(bus.closed)
+	_ = bus.listener.Close()
+	bus.conns.Range(func(key, _ interface{}) bool {
+		_ = key.(net.Conn).Close()
+		return true
+	})
+	bus.linksMu.Lock()
+	defer bus.linksMu.Unlock()
+	for _, link := range bus.links {
+		if atomic.CompareAndSwapInt32(&link.busy, 0, 1) {
+			link.closeConn()
+		}
+	}
+}
+
+func (bus *clusterBus) serve() {
+	for {
+		conn, err := bus.listener.Accept()
+		if err != nil {
+			return
+		}
+		go bus.handleConn(conn)
+	}
+}
+
+func (bus *clusterBus) handleConn(conn net.Conn) {
+	bus.conns.Store(conn, struct{}{})
+	defer func() {
+		bus.conns.Delete(conn)
+		_ = conn.Close()
+	}()
+	for payload := range parser.Parse(conn) {
+		if payload.Err != nil {
+			return
+		}
+		args, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(args.Args) != 2 {
+			return
+		}
+		msg := &gossipMessage{}
+		if err := json.Unmarshal(args.Args[1], msg); err != nil {
+			logger.Warn("illegal cluster bus message: " + err.Error())
+			return
+		}
+		msg.Type = strings.ToUpper(string(args.Args[0]))
+		bus.process(msg)
+
+		var result redis.Reply = &reply.OkReply{}
+		if msg.Type == msgPing || msg.Type == msgMeet {
+			pong, _ := json.Marshal(bus.makeMessage())
+			result = reply.MakeBulkReply(pong)
+		}
+		if _, err := conn.Write(result.ToBytes()); err != nil {
+			return
+		}
+	}
+}
+
+// send 通过总线向节点发送消息
+func (bus *clusterBus) send(n *node, msgType string, msg *gossipMessage) (redis.Reply, error) {
+	bus.linksMu.Lock()
+	link, ok := bus.links[n.addr]
+	if !ok {
+		link = &busLink{}
+		bus.links[n.addr] = link
+	}
+	bus.linksMu.Unlock()
+	payload, _ := json.Marshal(msg)
+	return link.send(n.busAddr, [][]byte{[]byte(msgType), payload}, bus.nodeTimeout)
+}
+
+// makeMessage 生成包含本节点纪元, 负责的槽以及其它节点状态的消息
+func (bus *clusterBus) makeMessage() *gossipMessage {
+	t := bus.cluster.peerPicker
+	self := bus.cluster.self
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	slots := make([]byte, slotCount/8)
+	for slot, owner := range t.owners {
+		if owner == self {
+			slots[slot/8] |= 1 << (slot % 8)
+		}
+	}
+	msg := &gossipMessage{
+		Sender:       self,
+		BusAddr:      t.nodes[self].busAddr,
+		CurrentEpoch: t.currentEpoch,
+		ConfigEpoch:  t.nodes[self].configEpoch,
+		Slots:        slots,
+	}
+	for _, n := range t.nodes {
+		if n.addr == self || n.handshake {
+			continue
+		}
+		msg.Gossip = append(msg.Gossip, gossipEntry{
+			Addr:    n.addr,
+			BusAddr: n.busAddr,
+			PFail:   n.pfail,
+			Fail:    n.fail,
+		})
+	}
+	return msg
+}
+
+// process 根据收到的消息更新节点状态与槽的归属, 只接受已知节点的消息, MEET 除外
+func (bus *clusterBus) process(msg *gossipMessage) {
+	t := bus.cluster.peerPicker
+	self := bus.cluster.self
+	if msg.Sender == self {
+		return
+	}
+	failed := make([]string, 0)
+	t.mu.Lock()
+	sender, known := t.nodes[msg.Sender]
+	if !known {
+		if msg.Type != msgMeet {
+			t.mu.Unlock()
+			return
+		}
+		sender = t.joinLocked(msg.Sender, msg.BusAddr)
+	}
+	if msg.CurrentEpoch > t.currentEpoch {
+		t.currentEpoch = msg.CurrentEpoch
+	}
+	sender.configEpoch = msg.ConfigEpoch
+	sender.busAddr = msg.BusAddr
+	if msg.Type == msgPong {
+		sender.handshake = false
+		sender.pingSent = time.Time{}
+		sender.pongRecv = time.Now()
+		if sender.pfail {
+			sender.pfail = false
+			logger.Info("-pfail " + sender.addr)
+		}
+		if sender.fail {
+			sender.fail = false
+			logger.Info("-fail " + sender.addr)
+		}
+	}
+	if msg.Type == msgFail {
+		if n, ok := t.nodes[msg.Failed]; ok && n.addr != self && !n.fail {
+			n.fail = true
+			logger.Warn("+fail " + n.addr + " reported by " + sender.addr)
+		}
+		t.mu.Unlock()
+		return
+	}
+	bus.updateSlotsLocked(sender, msg.Slots)
+	for _, entry := range msg.Gossip {
+		if entry.Addr == self {
+			continue
+		}
+		n, ok := t.nodes[entry.Addr]
+		if !ok {
+			// 通过 gossip 发现新节点, 此后由定时任务发送 PING
+			if !entry.Fail {
+				t.nodes[entry.Addr] = newNode(entry.Addr, entry.BusAddr)
+				logger.Info("+node " + entry.Addr + " learned from " + sender.addr)
+			}
+			continue
+		}
+		if entry.PFail || entry.Fail {
+			n.failReports[sender.addr] = time.Now()
+		} else {
+			delete(n.failReports, sender.addr)
+		}
+		if bus.markFailLocked(n) {
+			failed = append(failed, n.addr)
+		}
+	}
+	t.mu.Unlock()
+	for _, addr := range failed {
+		bus.broadcastFail(addr)
+	}
+}
+
+// joinLocked 加入一个节点, 本节点单独运行时放弃所有的槽, 之后通过 gossip 获得所在集群的槽分布, 调用者需持有 t.mu
+func (t *slotTable) joinLocked(addr string, busAddr string) *node {
+	if len(t.nodes) == 1 {
+		logger.Info("joining the cluster of " + addr + ", releasing all slots")
+		for slot := range t.owners {
+			t.owners[slot] = ""
+		}
+		t.migrating = make(map[int]string)
+		t.importing = make(map[int]string)
+	}
+	n := newNode(addr, busAddr)
+	t.nodes[addr] = n
+	logger.Info("+node " + addr)
+	return n
+}
+
+// join 加入一个节点, 节点已存在时不做任何事
+func (t *slotTable) join(addr string, busAddr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.nodes[addr]; !ok {
+		t.joinLocked(addr, busAddr)
+	}
+}
+
+// updateSlotsLocked 与 Redis 一致, 槽的声明以纪元更大的节点为准, 正在迁入本节点的槽不受影响, 调用者需持有 t.mu
+func (bus *clusterBus) updateSlotsLocked(sender *node, claims []byte) {
+	t := bus.cluster.peerPicker
+	if len(claims) != slotCount/8 {
+		return
+	}
+	lost := 0
+	for slot := 0; slot < slotCount; slot++ {
+		if claims[slot/8]&(1<<(slot%8)) == 0 {
+			continue
+		}
+		owner := t.owners[slot]
+		if owner == sender.addr {
+			continue
+		}
+		if _, ok := t.importing[slot]; ok {
+			continue
+		}
+		if current, ok := t.nodes[owner]; ok && current.configEpoch >= sender.configEpoch {
+			continue
+		}
+		if owner == bus.cluster.self {
+			lost++
+		}
+		t.owners[slot] = sender.addr
+		delete(t.migrating, slot)
+	}
+	if lost > 0 {
+		logger.Warn(strconv.Itoa(lost) + " slots taken over by " + sender.addr + " with a greater config epoch")
+	}
+}
+
+// markFailLocked 节点 PFAIL 且负责槽的节点中多数报告其下线时标记为 FAIL, 返回是否新标记为 FAIL, 调用者需持有 t.mu
+func (bus *clusterBus) markFailLocked(n *node) bool {
+	if !n.pfail || n.fail {
+		return false
+	}
+	for reporter, reportedAt := range n.failReports {
+		if time.Since(reportedAt) > 2*bus.nodeTimeout {
+			delete(n.failReports, reporter)
+		}
+	}
+	t := bus.cluster.peerPicker
+	masters := make(map[string]struct{})
+	for _, owner := range t.owners {
+		if owner != "" {
+			masters[owner] = struct{}{}
+		}
+	}
+	// 本节点也认为其 PFAIL
+	if len(n.failReports)+1 < len(masters)/2+1 {
+		return false
+	}
+	n.fail = true
+	logger.Warn("+fail " + n.addr)
+	return true
+}
+
+// broadcastFail 通知所有节点 addr 已经下线
+func (bus *clusterBus) broadcastFail(addr string) {
+	msg := bus.makeMessage()
+	msg.Failed = addr
+	for _, n := range bus.cluster.peerPicker.getNodes() {
+		if n.addr == bus.cluster.self || n.addr == addr {
+			continue
+		}
+		go func(n *node) {
+			_, _ = bus.send(n, msgFail, msg)
+		}(n)
+	}
+}
+
+func (bus *clusterBus) cronLoop() {
+	ticker := time.NewTicker(bus.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bus.closed:
+			return
+		case <-ticker.C:
+		}
+		bus.cron()
+	}
+}
+
+// cron 检查节点是否下线, 并向所有节点发送 PING, 通过 MEET 加入但一直没有回复的节点会被移除
+func (bus *clusterBus) cron() {
+	t := bus.cluster.peerPicker
+	self := bus.cluster.self
+	now := time.Now()
+	failed := make([]string, 0)
+	targets := make([]*node, 0)
+	t.mu.Lock()
+	for addr, n := range t.nodes {
+		if addr == self {
+			continue
+		}
+		if now.Sub(n.pongRecv) > bus.nodeTimeout {
+			if n.handshake {
+				delete(t.nodes, addr)
+				logger.Warn("handshake with " + addr + " timed out")
+				continue
+			}
+			if !n.pfail {
+				n.pfail = true
+				logger.Warn("+pfail " + addr)
+			}
+		}
+		if bus.markFailLocked(n) {
+			failed = append(failed, addr)
+		}
+		if n.pingSent.IsZero() {
+			n.pingSent = now
+		}
+		copied := *n
+		targets = append(targets, &copied)
+	}
+	t.mu.Unlock()
+	for _, addr := range failed {
+		bus.broadcastFail(addr)
+	}
+	for _, n := range targets {
+		go bus.ping(n)
+	}
+}
+
+// ping 发送 PING, 尚未完成握手的节点发送 MEET, 然后处理回复的 PONG
+func (bus *clusterBus) ping(n *node) {
+	msgType := msgPing
+	if n.handshake {
+		msgType = msgMeet
+	}
+	result, err := bus.send(n, msgType, bus.makeMessage())
+	if err != nil {
+		return
+	}
+	bulk, ok := result.(*reply.BulkReply)
+	if !ok {
+		return
+	}
+	msg := &gossipMessage{}
+	if err := json.Unmarshal(bulk.Arg, msg); err != nil {
+		return
+	}
+	msg.Type = msgPong
+	bus.process(msg)
+}
+
+// meet 与节点握手, 对方会加入本节点所在的集群
+func (cluster *Cluster) meet(addr string, busAddr string) {
+	t := cluster.peerPicker
+	t.mu.Lock()
+	n, ok := t.nodes[addr]
+	if !ok {
+		n = newNode(addr, busAddr)
+		n.handshake = true
+		t.nodes[addr] = n
+	}
+	copied := *n
+	t.mu.Unlock()
+	if cluster.bus != nil && !ok {
+		go cluster.bus.ping(&copied)
+	}
+}
+
+// bumpConfigEpoch 迁入槽后增大本节点的纪元, 使本节点对槽的声明覆盖原来的节点, 本节点的纪元已经是最大时不变
+func (t *slotTable) bumpConfigEpoch(self string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	maxEpoch := t.currentEpoch
+	for _, n := range t.nodes {
+		if n.configEpoch > maxEpoch {
+			maxEpoch = n.configEpoch
+		}
+	}
+	myself := t.nodes[self]
+	if myself.configEpoch == 0 || myself.configEpoch != maxEpoch {
+		t.currentEpoch = maxEpoch + 1
+		myself.configEpoch = t.currentEpoch
+		logger.Info("config epoch set to " + strconv.FormatInt(myself.configEpoch, 10))
+	}
+}
+
+// isFailed 节点是否已经被集群认定下线
+func (t *slotTable) isFailed(addr string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n, ok := t.nodes[addr]
+	return ok && n.fail
+}
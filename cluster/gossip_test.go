@@ -0,0 +1,140 @@
+package cluster
+
+import (
+	"net"
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+// startGossipNode 启动只监听集群总线的节点, 数据端口不会被使用
+func startGossipNode(t *testing.T, self string, listener net.Listener, peers []string) *Cluster {
+	config.Properties = &config.PropertyHolder{
+		Self:               self,
+		Peers:              peers,
+		ClusterNodeTimeout: 500,
+	}
+	cluster := makeCluster()
+	cluster.startBus(listener)
+	return cluster
+}
+
+func TestGossip(t *testing.T) {
+	addrs := []string{"127.0.0.1:7000", "127.0.0.1:7001", "127.0.0.1:7002", "127.0.0.1:7003"}
+	listeners := make([]net.Listener, len(addrs))
+	peers := make([]string, len(addrs))
+	for i := range addrs {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = listener
+		peers[i] = addrs[i] + "@" + strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	}
+	// 前三个节点通过配置组成集群, 最后一个节点单独运行
+	nodes := make([]*Cluster, len(addrs))
+	for i := 0; i < 3; i++ {
+		nodes[i] = startGossipNode(t, addrs[i], listeners[i], peers[:3])
+	}
+	nodes[3] = startGossipNode(t, addrs[3], listeners[3], nil)
+	defer func() {
+		for i, n := range nodes {
+			if i != 2 {
+				n.Close()
+			}
+		}
+	}()
+	c := connection.NewFakeConn()
+	exec := func(cluster *Cluster, args ...string) string {
+		return string(cluster.Exec(c, makeArgs(args[0], args[1:]...)).ToBytes())
+	}
+
+	// 新节点通过 MEET 加入, 其它节点通过 gossip 得知新节点, 新节点通过 gossip 获得槽的分布
+	busPort := strconv.Itoa(listeners[3].Addr().(*net.TCPAddr).Port)
+	if r := exec(nodes[0], "cluster", "meet", "127.0.0.1", "7003", busPort); r != "+OK\r\n" {
+		t.Fatalf("meet failed: %q", r)
+	}
+	ok := waitUntil(5*time.Second, func() bool {
+		for _, n := range nodes {
+			if len(n.peerPicker.getNodes()) != 4 {
+				return false
+			}
+		}
+		for _, slot := range []int{0, 8000, 16383} {
+			if nodes[3].peerPicker.getOwner(slot) != nodes[0].peerPicker.getOwner(slot) {
+				return false
+			}
+		}
+		return true
+	})
+	if !ok {
+		t.Fatalf("new node did not join the cluster:\n%s", exec(nodes[3], "cluster", "nodes"))
+	}
+	if nodes[3].peerPicker.getOwner(0) == addrs[3] {
+		t.Error("new node should release its slots after joining")
+	}
+
+	// 迁入槽的节点增大纪元, 没有收到 SETSLOT 的节点也会通过 gossip 更新槽的归属
+	slot := nodes[0].peerPicker.getSlotsOf(addrs[1])[0]
+	exec(nodes[0], "cluster", "setslot", strconv.Itoa(slot), "importing", genNodeId(addrs[1]))
+	if r := exec(nodes[0], "cluster", "setslot", strconv.Itoa(slot), "node", genNodeId(addrs[0])); r != "+OK\r\n" {
+		t.Fatalf("setslot node failed: %q", r)
+	}
+	ok = waitUntil(5*time.Second, func() bool {
+		for _, n := range nodes {
+			if n.peerPicker.getOwner(slot) != addrs[0] {
+				return false
+			}
+		}
+		return true
+	})
+	if !ok {
+		t.Fatal("slot ownership was not propagated by gossip")
+	}
+	if r := exec(nodes[3], "cluster", "info"); !strings.Contains(r, "cluster_current_epoch:1\r\n") {
+		t.Errorf("current epoch should be propagated, got %q", r)
+	}
+
+	// 节点宕机后被标记为 PFAIL, 多数节点确认后标记为 FAIL
+	nodes[2].Close()
+	ok = waitUntil(5*time.Second, func() bool {
+		for i, n := range nodes {
+			if i != 2 && !n.peerPicker.isFailed(addrs[2]) {
+				return false
+			}
+		}
+		return true
+	})
+	if !ok {
+		t.Fatalf("failed node was not detected:\n%s", exec(nodes[0], "cluster", "nodes"))
+	}
+	if r := exec(nodes[0], "cluster", "nodes"); !strings.Contains(r, "master,fail - ") {
+		t.Errorf("failed node should be flagged in cluster nodes, got %q", r)
+	}
+	if r := exec(nodes[0], "cluster", "info"); !strings.Contains(r, "cluster_state:fail\r\n") {
+		t.Errorf("cluster state should be fail, got %q", r)
+	}
+	var key string
+	for i := 0; key == ""; i++ {
+		if nodes[0].peerPicker.Get("key"+strconv.Itoa(i)) == addrs[2] {
+			key = "key" + strconv.Itoa(i)
+		}
+	}
+	if r := exec(nodes[0], "get", key); r != "-CLUSTERDOWN The cluster is down\r\n" {
+		t.Errorf("keys of a failed node should be rejected, got %q", r)
+	}
+}
@@ -0,0 +1,308 @@
+package cluster
+
+import (
+	"net"
+	"redisGo/db"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/redis/connection"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	migrateBatchSize = 100  // 每次 MIGRATE 迁移的 key 数量
+	migrateTimeout   = 5000 // MIGRATE 的超时时间, 单位毫秒
+)
+
+// execAsking 允许下一条命令访问正在迁入本节点的槽
+func execAsking(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return &reply.ArgNumErrReply{Cmd: "asking"}
+	}
+	cluster.asking.Store(c, struct{}{})
+	return &reply.OkReply{}
+}
+
+// execMigrate MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]
+// 逐个将 key 通过 EntityToCmd 序列化后发送到目标节点, 成功后删除本地的 key.
+// 迁移期间持有 key 的锁, 对这些 key 的其它命令会等待迁移完成
+func execMigrate(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 6 {
+		return &reply.ArgNumErrReply{Cmd: "migrate"}
+	}
+	target := net.JoinHostPort(string(args[1]), string(args[2]))
+	if string(args[4]) != "0" {
+		return reply.MakeErrReply("ERR SELECT is not allowed in cluster mode")
+	}
+	if _, err := strconv.Atoi(string(args[5])); err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	keys := make([]string, 0)
+	if len(args[3]) > 0 {
+		keys = append(keys, string(args[3]))
+	}
+	copyKeys, replace := false, false
+	for i := 6; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "keys":
+			if len(args[3]) > 0 {
+				return reply.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(args)
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+	if target == cluster.self {
+		return reply.MakeErrReply("ERR Target instance is the same as the source")
+	}
+	return cluster.migrateKeys(c, target, keys, copyKeys, replace)
+}
+
+func (cluster *Cluster) migrateKeys(c redis.Connection, target string, keys []string, copyKeys bool, replace bool) redis.Reply {
+	localDB := cluster.localDB()
+	localDB.Locks(keys...)
+	defer localDB.Unlocks(keys...)
+
+	mode := "NOREPLACE"
+	if replace {
+		mode = "REPLACE"
+	}
+	migrated := 0
+	for _, key := range keys {
+		entity, ok := localDB.Get(key)
+		if !ok {
+			continue
+		}
+		expireAt := "0"
+		if t, ok := localDB.ExpireTime(key); ok {
+			expireAt = strconv.FormatInt(t.UnixMilli(), 10)
+		}
+		restoreArgs := makeArgs("RESTOREKEY", key, expireAt, mode)
+		restoreArgs = append(restoreArgs, db.EntityToCmd(key, entity).Args...)
+		result := cluster.Relay(target, c, restoreArgs)
+		if reply.IsErrorReply(result) {
+			return result
+		}
+		if !copyKeys {
+			localDB.Remove(key)
+			localDB.AddAof(reply.MakeMultiBulkReply(makeArgs("DEL", key)))
+		}
+		migrated++
+	}
+	if migrated == 0 {
+		return reply.MakeStatusReply("NOKEY")
+	}
+	return &reply.OkReply{}
+}
+
+// execRestoreKey 集群内部命令, 由 MIGRATE 发送给目标节点:
+// RESTOREKEY key expire-at-ms(0 表示不过期) REPLACE|NOREPLACE command [arg ...]
+func execRestoreKey(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 6 {
+		return &reply.ArgNumErrReply{Cmd: "restorekey"}
+	}
+	key := string(args[1])
+	expireAt, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	replace := strings.ToUpper(string(args[3])) == "REPLACE"
+	cmdLine := args[4:]
+	if string(cmdLine[1]) != key {
+		return reply.MakeErrReply("ERR restore command does not match the key")
+	}
+
+	localDB := cluster.localDB()
+	defer localDB.ServeBlocked(key)
+	localDB.Lock(key)
+	defer localDB.Unlock(key)
+	if _, exists := localDB.Get(key); exists {
+		if !replace {
+			return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+		}
+		localDB.ExecWithLock(makeArgs("DEL", key))
+	}
+	if expireAt > 0 && expireAt <= time.Now().UnixMilli() {
+		// 传输过程中已经过期
+		return &reply.OkReply{}
+	}
+	result := localDB.ExecWithLock(cmdLine)
+	if reply.IsErrorReply(result) {
+		return result
+	}
+	if expireAt > 0 {
+		localDB.ExecWithLock(makeArgs("PEXPIREAT", key, strconv.FormatInt(expireAt, 10)))
+	}
+	return &reply.OkReply{}
+}
+
+// sendTo 向节点发送命令, 发给自己时直接执行, 用于驱动迁移的管理命令
+func (cluster *Cluster) sendTo(addr string, args ...string) redis.Reply {
+	return cluster.sendCmdLine(addr, makeArgs(args[0], args[1:]...))
+}
+
+// sendCmdLine 与 sendTo 相同, 用于参数中包含二进制数据的命令
+func (cluster *Cluster) sendCmdLine(addr string, cmdLine [][]byte) redis.Reply {
+	if addr == cluster.self {
+		return cluster.Exec(connection.NewFakeConn(), cmdLine)
+	}
+	return cluster.Relay(addr, nil, cmdLine)
+}
+
+// migrateSlot 按照 redis-cli 重新分片的流程将一个槽从 src 迁移到 dst:
+// 目标节点设置 IMPORTING, 源节点设置 MIGRATING, 分批 MIGRATE 槽中的 key,
+// 最后依次通知目标节点, 源节点以及其它节点槽的新归属
+func (cluster *Cluster) migrateSlot(slot int, src string, dst string) redis.Reply {
+	slotStr := strconv.Itoa(slot)
+	srcId, dstId := genNodeId(src), genNodeId(dst)
+	if r := cluster.sendTo(dst, "CLUSTER", "SETSLOT", slotStr, "IMPORTING", srcId); reply.IsErrorReply(r) {
+		return r
+	}
+	if r := cluster.sendTo(src, "CLUSTER", "SETSLOT", slotStr, "MIGRATING", dstId); reply.IsErrorReply(r) {
+		return r
+	}
+	host, port, _ := net.SplitHostPort(dst)
+	for {
+		r := cluster.sendTo(src, "CLUSTER", "GETKEYSINSLOT", slotStr, strconv.Itoa(migrateBatchSize))
+		if reply.IsErrorReply(r) {
+			return r
+		}
+		multiBulk, ok := r.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) == 0 {
+			break
+		}
+		migrateArgs := []string{"MIGRATE", host, port, "", "0", strconv.Itoa(migrateTimeout), "REPLACE", "KEYS"}
+		for _, key := range multiBulk.Args {
+			migrateArgs = append(migrateArgs, string(key))
+		}
+		if r := cluster.sendTo(src, migrateArgs...); reply.IsErrorReply(r) {
+			return r
+		}
+	}
+	if r := cluster.sendTo(dst, "CLUSTER", "SETSLOT", slotStr, "NODE", dstId); reply.IsErrorReply(r) {
+		return r
+	}
+	if r := cluster.sendTo(src, "CLUSTER", "SETSLOT", slotStr, "NODE", dstId); reply.IsErrorReply(r) {
+		return r
+	}
+	for _, n := range cluster.peerPicker.getNodes() {
+		if n.addr == src || n.addr == dst {
+			continue
+		}
+		if r := cluster.sendTo(n.addr, "CLUSTER", "SETSLOT", slotStr, "NODE", dstId); reply.IsErrorReply(r) {
+			logger.Warn("failed to notify " + n.addr + " of slot " + slotStr + ": " + string(r.ToBytes()))
+		}
+	}
+	return &reply.OkReply{}
+}
+
+// addNode 将新节点加入集群: 新节点获得所有节点与槽的分布, 其它节点加入新节点, 然后重新平衡槽.
+// 地址的格式为 host:port[@cport]
+func (cluster *Cluster) addNode(raw string) redis.Reply {
+	addr, busAddr := parseNodeAddr(raw)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return reply.MakeErrReply("ERR Invalid node address " + raw)
+	}
+	if cluster.peerPicker.getNode(addr) != nil {
+		return reply.MakeErrReply("ERR Node " + addr + " is already in the cluster")
+	}
+	nodes := cluster.peerPicker.getNodes()
+	for _, n := range nodes {
+		if r := cluster.sendTo(addr, "CLUSTER", "REGISTERNODE", nodeAddrWithBus(n.addr, n.busAddr)); reply.IsErrorReply(r) {
+			return r
+		}
+	}
+	for _, r := range cluster.peerPicker.getRanges() {
+		result := cluster.sendTo(addr, "CLUSTER", "SETSLOTRANGE", genNodeId(r.addr), strconv.Itoa(r.start), strconv.Itoa(r.end))
+		if reply.IsErrorReply(result) {
+			return result
+		}
+	}
+	for _, n := range nodes {
+		if r := cluster.sendTo(n.addr, "CLUSTER", "REGISTERNODE", nodeAddrWithBus(addr, busAddr)); reply.IsErrorReply(r) {
+			return r
+		}
+	}
+	logger.Info("node " + addr + " joined the cluster")
+	return cluster.startRebalance()
+}
+
+// startRebalance 在后台迁移槽, 进度可以通过 CLUSTER NODES 查看, 同一时间只允许一个重新平衡任务
+func (cluster *Cluster) startRebalance() redis.Reply {
+	if !atomic.CompareAndSwapInt32(&cluster.rebalancing, 0, 1) {
+		return reply.MakeErrReply("ERR Rebalance already in progress")
+	}
+	go func() {
+		defer atomic.StoreInt32(&cluster.rebalancing, 0)
+		if r := cluster.rebalance(); reply.IsErrorReply(r) {
+			logger.Error("rebalance failed: " + r.(reply.ErrorReply).Error())
+		}
+	}()
+	return &reply.OkReply{}
+}
+
+// rebalance 让每个节点负责的槽数量尽量相同, 负责槽过多的节点将编号最大的槽迁移给负责槽过少的节点
+func (cluster *Cluster) rebalance() redis.Reply {
+	nodes := cluster.peerPicker.getNodes()
+	if len(nodes) == 0 {
+		return &reply.OkReply{}
+	}
+	type balance struct {
+		addr   string
+		slots  []int
+		target int
+	}
+	donors := make([]*balance, 0)
+	receivers := make([]*balance, 0)
+	for i, n := range nodes {
+		b := &balance{
+			addr:   n.addr,
+			slots:  cluster.peerPicker.getSlotsOf(n.addr),
+			target: slotCount / len(nodes),
+		}
+		if i < slotCount%len(nodes) {
+			b.target++
+		}
+		if len(b.slots) > b.target {
+			donors = append(donors, b)
+		} else if len(b.slots) < b.target {
+			receivers = append(receivers, b)
+		}
+	}
+	moved := 0
+	for _, receiver := range receivers {
+		for len(receiver.slots) < receiver.target {
+			var donor *balance
+			for _, d := range donors {
+				if len(d.slots) > d.target {
+					donor = d
+					break
+				}
+			}
+			if donor == nil {
+				break
+			}
+			slot := donor.slots[len(donor.slots)-1]
+			if r := cluster.migrateSlot(slot, donor.addr, receiver.addr); reply.IsErrorReply(r) {
+				return r
+			}
+			donor.slots = donor.slots[:len(donor.slots)-1]
+			receiver.slots = append(receiver.slots, slot)
+			moved++
+		}
+	}
+	logger.Info("rebalance finished, " + strconv.Itoa(moved) + " slots moved")
+	return &reply.OkReply{}
+}
@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigratingSlot(t *testing.T) {
+	self, peer := "127.0.0.1:7000", "127.0.0.1:7001"
+	config.Properties = &config.PropertyHolder{
+		Self:            self,
+		Peers:           []string{peer},
+		ClusterRedirect: true,
+	}
+	cluster := MakeCluster()
+	defer cluster.Close()
+	c := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(cluster.Exec(c, makeArgs(args[0], args[1:]...)).ToBytes())
+	}
+
+	var localKey, remoteKey string
+	for i := 0; localKey == "" || remoteKey == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if cluster.peerPicker.Get(key) == self {
+			localKey = key
+		} else {
+			remoteKey = key
+		}
+	}
+
+	// 迁出: 本地还有的 key 正常访问, 已经不在本地的 key 返回 ASK
+	slot := strconv.Itoa(getSlot(localKey))
+	missingKey := "{" + localKey + "}missing"
+	exec("set", localKey, "1")
+	if r := exec("cluster", "setslot", slot, "migrating", genNodeId(peer)); r != "+OK\r\n" {
+		t.Fatalf("setslot migrating failed: %q", r)
+	}
+	if r := exec("get", localKey); r != "$1\r\n1\r\n" {
+		t.Errorf("existing key should be served locally, got %q", r)
+	}
+	if r := exec("get", missingKey); r != "-ASK "+slot+" "+peer+"\r\n" {
+		t.Errorf("missing key should be redirected with ASK, got %q", r)
+	}
+	if r := exec("mget", localKey, missingKey); !strings.HasPrefix(r, "-TRYAGAIN") {
+		t.Errorf("partially migrated keys should get TRYAGAIN, got %q", r)
+	}
+	if r := exec("cluster", "setslot", slot, "node", genNodeId(peer)); !strings.HasPrefix(r, "-ERR Can't assign") {
+		t.Errorf("slot with keys should not be assigned away, got %q", r)
+	}
+
+	// 迁入: 只有紧跟在 ASKING 之后的命令可以访问
+	slot = strconv.Itoa(getSlot(remoteKey))
+	if r := exec("cluster", "setslot", slot, "importing", genNodeId(peer)); r != "+OK\r\n" {
+		t.Fatalf("setslot importing failed: %q", r)
+	}
+	moved := "-MOVED " + slot + " " + peer + "\r\n"
+	if r := exec("get", remoteKey); r != moved {
+		t.Errorf("expected %q without asking, got %q", moved, r)
+	}
+	exec("asking")
+	if r := exec("set", remoteKey, "2"); r != "+OK\r\n" {
+		t.Errorf("importing slot should be writable after asking, got %q", r)
+	}
+	if r := exec("get", remoteKey); r != moved {
+		t.Errorf("asking should only affect one command, got %q", r)
+	}
+	if r := exec("cluster", "setslot", slot, "node", genNodeId(self)); r != "+OK\r\n" {
+		t.Fatalf("setslot node failed: %q", r)
+	}
+	if r := exec("get", remoteKey); r != "$1\r\n2\r\n" {
+		t.Errorf("imported key should be served after the slot is assigned, got %q", r)
+	}
+}
+
+func TestRestoreKeyAof(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	config.Properties = &config.PropertyHolder{
+		Self:           "127.0.0.1:7000",
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    "always",
+	}
+	cluster := MakeCluster()
+	defer cluster.Close()
+	c := connection.NewFakeConn()
+	expireAt := strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10)
+	r := cluster.Exec(c, makeArgs("restorekey", "k", expireAt, "NOREPLACE", "SET", "k", "v"))
+	if string(r.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("restorekey failed: %q", r.ToBytes())
+	}
+	if r := cluster.Exec(c, makeArgs("pttl", "k")); string(r.ToBytes()) == ":-1\r\n" {
+		t.Error("ttl should be restored")
+	}
+	content, err := os.ReadFile(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(content), "PEXPIREAT"); n != 1 {
+		t.Errorf("expected PEXPIREAT once in aof, got %d: %q", n, content)
+	}
+}
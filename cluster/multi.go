@@ -0,0 +1,256 @@
+package cluster
+
+import (
+	"redisGo/db"
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+// 集群模式下的 MULTI/EXEC.
+// MULTI 之后的命令进入连接的事务队列, EXEC 时按 key 所在的节点分组, 通过两阶段提交原子地执行:
+// 每条命令只涉及一个节点时由该节点在提交时执行, 否则由协调者根据快照计算结果.
+// WATCH 从 key 所在的节点获取版本号, 参与者在准备阶段检查版本号, 发生变化时 EXEC 返回 nil
+
+// rawReply 参与者返回的已经编码的结果
+type rawReply []byte
+
+func (r rawReply) ToBytes() []byte {
+	return r
+}
+
+// isTxControl MULTI 之后仍然直接执行的命令
+func isTxControl(cmd string) bool {
+	switch cmd {
+	case "multi", "exec", "discard", "watch", "unwatch":
+		return true
+	}
+	return false
+}
+
+func execMulti(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return &reply.ArgNumErrReply{Cmd: "multi"}
+	}
+	return db.StartMulti(c)
+}
+
+func execDiscard(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return &reply.ArgNumErrReply{Cmd: "discard"}
+	}
+	return db.DiscardMulti(c)
+}
+
+func execUnWatch(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return &reply.ArgNumErrReply{Cmd: "unwatch"}
+	}
+	return db.UnWatch(c)
+}
+
+// enqueue 将 MULTI 之后的命令加入事务队列, 无法在事务中执行的命令使事务被标记为 dirty
+func (cluster *Cluster) enqueue(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if _, ok := router[cmdName]; !ok {
+		c.SetTxDirty(true)
+		return reply.MakeErrReply("ERR unknown command `" + cmdName + "`, or not supported in cluster mode")
+	}
+	writeKeys, readKeys, ok := db.GetRelatedKeys(cmdLine)
+	if !ok {
+		c.SetTxDirty(true)
+		return reply.MakeErrReply("ERR " + strings.ToUpper(cmdName) + " inside MULTI is not allowed")
+	}
+	if cluster.redirect {
+		keys := append(writeKeys, readKeys...)
+		for _, queued := range c.GetQueuedCmdLine() {
+			write, read, _ := db.GetRelatedKeys(queued)
+			keys = append(keys, write...)
+			keys = append(keys, read...)
+		}
+		for key := range c.GetWatching() {
+			keys = append(keys, key)
+		}
+		if errReply := cluster.checkSameLocalSlot(keys); errReply != nil {
+			c.SetTxDirty(true)
+			return errReply
+		}
+	}
+	c.EnqueueCmd(cmdLine)
+	return &reply.QueuedReply{}
+}
+
+// checkSameLocalSlot 检查 key 属于同一个由本节点负责的槽, 否则返回 CROSSSLOT 或 MOVED
+func (cluster *Cluster) checkSameLocalSlot(keys []string) redis.Reply {
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := getSlot(keys[0])
+	for _, key := range keys[1:] {
+		if getSlot(key) != slot {
+			return crossSlotErr
+		}
+	}
+	if owner := cluster.peerPicker.getOwner(slot); owner != cluster.self {
+		if owner == "" {
+			return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+		}
+		return makeMovedReply(slot, owner)
+	}
+	return nil
+}
+
+// execWatch 从 key 所在的节点获取版本号
+func execWatch(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "watch"}
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	keys := make([]string, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		keys[i-1] = string(args[i])
+	}
+	if cluster.redirect {
+		for _, key := range keys {
+			if errReply := cluster.checkSameLocalSlot([]string{key}); errReply != nil {
+				return errReply
+			}
+		}
+	}
+	watching := c.GetWatching()
+	for peer, group := range cluster.groupBy(keys) {
+		resp := cluster.sendCmdLine(peer, makeArgs("GETVERSION", group...))
+		if reply.IsErrorReply(resp) {
+			return resp
+		}
+		multiBulk, ok := resp.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) != len(group) {
+			return reply.MakeErrReply("ERR unexpected reply from " + peer)
+		}
+		for i, key := range group {
+			version, _ := strconv.ParseUint(string(multiBulk.Args[i]), 10, 32)
+			watching[key] = uint32(version)
+		}
+	}
+	return &reply.OkReply{}
+}
+
+// execGetVersion GETVERSION key [key ...], 集群内部命令, 返回 key 在本节点的版本号
+func execGetVersion(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "getversion"}
+	}
+	versions := make([][]byte, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		versions[i-1] = []byte(strconv.FormatUint(uint64(cluster.localDB().GetVersion(string(args[i]))), 10))
+	}
+	return reply.MakeMultiBulkReply(versions)
+}
+
+func execExec(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return &reply.ArgNumErrReply{Cmd: "exec"}
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	cmdLines := c.GetQueuedCmdLine()
+	watching := make(map[string]uint32, len(c.GetWatching()))
+	for key, version := range c.GetWatching() {
+		watching[key] = version
+	}
+	dirty := c.IsTxDirty()
+	c.SetMultiState(false)
+	if dirty {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return cluster.execMultiTx(cmdLines, watching)
+}
+
+// execMultiTx 通过两阶段提交执行事务队列中的命令, 按原始顺序返回每条命令的结果
+func (cluster *Cluster) execMultiTx(cmdLines [][][]byte, watching map[string]uint32) redis.Reply {
+	cmdKeys := make([][]string, len(cmdLines))
+	writeKeys := make([]string, 0)
+	allKeys := make([]string, 0)
+	splittable := true
+	for i, cmdLine := range cmdLines {
+		write, read, _ := db.GetRelatedKeys(cmdLine)
+		cmdKeys[i] = append(write, read...)
+		writeKeys = append(writeKeys, write...)
+		allKeys = append(allKeys, cmdKeys[i]...)
+		if len(cluster.groupBy(cmdKeys[i])) > 1 {
+			splittable = false
+		}
+	}
+	watchedOf := make(map[string][][]byte)
+	for key, version := range watching {
+		allKeys = append(allKeys, key)
+		owner := cluster.peerPicker.Get(key)
+		watchedOf[owner] = append(watchedOf[owner], []byte(key), []byte(strconv.FormatUint(uint64(version), 10)))
+	}
+	groups := cluster.groupBy(allKeys)
+	if len(groups) == 0 {
+		groups[cluster.self] = nil
+	}
+
+	txId := cluster.nextTxId()
+	plan := &txPlan{
+		peers:    sortedPeers(groups),
+		prepares: make(map[string][][]byte, len(groups)),
+	}
+	prepareArgs := func(peer string, mode string) [][]byte {
+		args := makeArgs("PREPAREMULTI", txId, mode, strconv.Itoa(len(watchedOf[peer])/2))
+		return append(args, watchedOf[peer]...)
+	}
+	if splittable {
+		// 每条命令交给 key 所在的节点执行, 不涉及 key 的命令交给第一个参与者
+		indices := make(map[string][]int)
+		for i := range cmdLines {
+			peer := plan.peers[0]
+			if len(cmdKeys[i]) > 0 {
+				peer = cluster.peerPicker.Get(cmdKeys[i][0])
+			}
+			indices[peer] = append(indices[peer], i)
+		}
+		for _, peer := range plan.peers {
+			subCmds := make([][][]byte, 0, len(indices[peer]))
+			for _, i := range indices[peer] {
+				subCmds = append(subCmds, cmdLines[i])
+			}
+			plan.prepares[peer] = append(prepareArgs(peer, txModeExec), encodeCmdLines(subCmds)...)
+		}
+		plan.merge = func(results map[string]redis.Reply) redis.Reply {
+			replies := make([]redis.Reply, len(cmdLines))
+			for peer, r := range results {
+				if len(indices[peer]) == 0 {
+					// 只负责检查 WATCH 的节点
+					continue
+				}
+				multiBulk, ok := r.(*reply.MultiBulkReply)
+				if !ok || len(multiBulk.Args) != len(indices[peer]) {
+					return reply.MakeErrReply("ERR unexpected reply from " + peer)
+				}
+				for j, i := range indices[peer] {
+					replies[i] = rawReply(multiBulk.Args[j])
+				}
+			}
+			return reply.MakeMultiRawReply(replies)
+		}
+	} else {
+		for _, peer := range plan.peers {
+			plan.prepares[peer] = append(prepareArgs(peer, txModeLock), encodeCmdLines(cmdLines)...)
+		}
+		plan.simulate = func(snapshots []*keyImage) (redis.Reply, []*keyImage) {
+			results, images := simulate(cmdLines, snapshots, writeKeys)
+			return reply.MakeMultiRawReply(results), images
+		}
+	}
+	result := cluster.runTx(txId, plan)
+	if errReply, ok := result.(reply.ErrorReply); ok && errReply.Error() == watchChangedErr.Error() {
+		return &reply.NullMultiBulkReply{}
+	}
+	return result
+}
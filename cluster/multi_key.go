@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"redisGo/db"
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"strings"
+)
+
+var crossSlotErr = reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+
+// execSelect 集群模式下只使用 0 号数据库
+func execSelect(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return &reply.ArgNumErrReply{Cmd: "select"}
+	}
+	if string(args[1]) != "0" {
+		return reply.MakeErrReply("ERR SELECT is not allowed in cluster mode")
+	}
+	return &reply.OkReply{}
+}
+
+// relayAllKeys 用于 RENAME, SINTERSTORE 等需要原子执行的多 key 命令,
+// 重定向模式下要求所有 key 属于同一个槽, 转发模式下 key 分布在多个节点时通过两阶段提交执行
+func relayAllKeys(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: strings.ToLower(string(args[0]))}
+	}
+	if cluster.redirect {
+		writeKeys, readKeys, _ := db.GetRelatedKeys(args)
+		keys := append(writeKeys, readKeys...)
+		if len(keys) == 0 {
+			return cluster.db.Exec(c, args)
+		}
+		return cluster.execByKeys(c, keys, args)
+	}
+	return cluster.execTx(c, args)
+}
+
+// MGet 按节点分组后分别查询, 再按参数顺序合并结果
+func MGet(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "mget"}
+	}
+	keys := make([]string, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		keys[i-1] = string(args[i])
+	}
+	if cluster.redirect {
+		return cluster.execByKeys(c, keys, args)
+	}
+	groupMap := cluster.groupBy(keys)
+	if len(groupMap) == 1 {
+		return cluster.execByKeys(c, keys, args)
+	}
+
+	values := make(map[string][]byte)
+	for peer, group := range groupMap {
+		resp := cluster.execByKeys(c, group, makeArgs("MGET", group...))
+		if reply.IsErrorReply(resp) {
+			return resp
+		}
+		multiBulk, ok := resp.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) != len(group) {
+			return reply.MakeErrReply("ERR unexpected reply from " + peer)
+		}
+		for i, key := range group {
+			values[key] = multiBulk.Args[i]
+		}
+	}
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = values[key]
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// MSet 需要保证原子性, key 分布在多个节点时通过两阶段提交执行
+func MSet(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return &reply.ArgNumErrReply{Cmd: "mset"}
+	}
+	keys := make([]string, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+	}
+	if cluster.redirect {
+		return cluster.execByKeys(c, keys, args)
+	}
+	return cluster.execTx(c, args)
+}
+
+// Exists 按节点分组后分别查询, 返回存在的 key 的总数
+func Exists(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "exists"}
+	}
+	keys := make([]string, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		keys[i-1] = string(args[i])
+	}
+	if cluster.redirect {
+		return cluster.execByKeys(c, keys, args)
+	}
+	var count int64
+	for peer, group := range cluster.groupBy(keys) {
+		resp := cluster.execByKeys(c, group, makeArgs("EXISTS", group...))
+		if reply.IsErrorReply(resp) {
+			return resp
+		}
+		intReply, ok := resp.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR unexpected reply from " + peer)
+		}
+		count += intReply.Code
+	}
+	return reply.MakeIntReply(count)
+}
+
+// execBlocking BLPOP, BLMOVE 等阻塞命令只能在 key 所在的节点上等待, 不会转发,
+// 所有 key 必须属于同一个由本节点负责的槽, 否则返回 CROSSSLOT 或 MOVED
+func execBlocking(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	writeKeys, _, ok := db.GetRelatedKeys(args)
+	if !ok {
+		return &reply.ArgNumErrReply{Cmd: strings.ToLower(string(args[0]))}
+	}
+	if errReply := cluster.checkSameLocalSlot(writeKeys); errReply != nil {
+		return errReply
+	}
+	return cluster.db.Exec(c, args)
+}
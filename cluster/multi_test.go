@@ -0,0 +1,121 @@
+package cluster
+
+import (
+	"net"
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMultiAcrossNodes(t *testing.T) {
+	selfAddr := "127.0.0.1:7000"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peerAddr := listener.Addr().String()
+	peers := []string{selfAddr, peerAddr}
+
+	config.Properties = &config.PropertyHolder{Self: peerAddr, Peers: peers}
+	peer := makeCluster()
+	defer peer.Close()
+	go serveCluster(listener, peer)
+	config.Properties = &config.PropertyHolder{Self: selfAddr, Peers: peers}
+	cluster := makeCluster()
+	defer cluster.Close()
+
+	keyOn := make(map[string]string)
+	for i := 0; len(keyOn) < 2; i++ {
+		key := "key" + strconv.Itoa(i)
+		if _, ok := keyOn[cluster.peerPicker.Get(key)]; !ok {
+			keyOn[cluster.peerPicker.Get(key)] = key
+		}
+	}
+	a, b := keyOn[selfAddr], keyOn[peerAddr]
+	c := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(cluster.Exec(c, makeArgs(args[0], args[1:]...)).ToBytes())
+	}
+
+	// 每条命令只涉及一个节点, 结果按入队顺序返回
+	exec("multi")
+	for _, cmd := range [][]string{{"set", a, "1"}, {"set", b, "2"}, {"incr", a}, {"get", b}} {
+		if r := exec(cmd...); r != "+QUEUED\r\n" {
+			t.Fatalf("%s should be queued, got %q", cmd[0], r)
+		}
+	}
+	if r := exec("exec"); r != "*4\r\n+OK\r\n+OK\r\n:2\r\n$1\r\n2\r\n" {
+		t.Fatalf("unexpected exec reply: %q", r)
+	}
+
+	// 跨节点的命令
+	exec("multi")
+	exec("rename", a, b)
+	exec("get", b)
+	exec("exists", a)
+	if r := exec("exec"); r != "*3\r\n+OK\r\n$1\r\n2\r\n:0\r\n" {
+		t.Fatalf("unexpected exec reply: %q", r)
+	}
+	if r := exec("get", b); r != "$1\r\n2\r\n" {
+		t.Errorf("rename should be committed, got %q", r)
+	}
+
+	// 其它节点上被 WATCH 的 key 发生变化时放弃执行
+	if r := exec("watch", a, b); r != "+OK\r\n" {
+		t.Fatalf("watch failed: %q", r)
+	}
+	peer.Exec(connection.NewFakeConn(), makeArgs("set", b, "3"))
+	exec("multi")
+	exec("set", a, "4")
+	exec("set", b, "4")
+	if r := exec("exec"); r != "*-1\r\n" {
+		t.Fatalf("exec should be aborted, got %q", r)
+	}
+	if r := exec("get", b); r != "$1\r\n3\r\n" {
+		t.Errorf("aborted transaction should not change data, got %q", r)
+	}
+	if r := exec("exists", a); r != ":0\r\n" {
+		t.Errorf("aborted transaction should not change data, got %q", r)
+	}
+	exec("watch", b)
+	exec("multi")
+	exec("set", a, "5")
+	if r := exec("exec"); r != "*1\r\n+OK\r\n" {
+		t.Errorf("unchanged watched key should not abort, got %q", r)
+	}
+
+	// 入队失败的事务整体放弃
+	exec("multi")
+	exec("set", a, "6")
+	if r := exec("nosuchcommand"); !strings.HasPrefix(r, "-ERR unknown command") {
+		t.Errorf("unexpected reply: %q", r)
+	}
+	if r := exec("exec"); !strings.HasPrefix(r, "-EXECABORT") {
+		t.Errorf("exec should be aborted, got %q", r)
+	}
+	if r := exec("get", a); r != "$1\r\n5\r\n" {
+		t.Errorf("aborted transaction should not change data, got %q", r)
+	}
+	if r := exec("exec"); !strings.HasPrefix(r, "-ERR EXEC without MULTI") {
+		t.Errorf("unexpected reply: %q", r)
+	}
+
+	// 跨节点的 LMPOP 和 LMOVE 通过两阶段提交执行
+	exec("del", a, b)
+	exec("rpush", b, "x", "y")
+	if r := exec("lmpop", "2", a, b, "left", "count", "1"); r != "*2\r\n$"+strconv.Itoa(len(b))+"\r\n"+b+"\r\n*1\r\n$1\r\nx\r\n" {
+		t.Errorf("unexpected lmpop reply: %q", r)
+	}
+	if r := exec("lmove", b, a, "left", "right"); r != "$1\r\ny\r\n" {
+		t.Errorf("unexpected lmove reply: %q", r)
+	}
+	if r := exec("lrange", a, "0", "-1"); r != "*1\r\n$1\r\ny\r\n" {
+		t.Errorf("unexpected list: %q", r)
+	}
+	if r := exec("exists", b); r != ":0\r\n" {
+		t.Errorf("empty list should be removed, got %q", r)
+	}
+}
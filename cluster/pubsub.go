@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/redis/reply"
+)
+
+// 集群模式下的发布订阅.
+// 每个节点只能将消息推送给连接到本节点的订阅者, 因此 PUBLISH 通过 LOCALEXEC 发给所有节点, 接收方只在本地发布.
+// 分片频道按照频道名计算槽, 只由负责该槽的节点处理, SPUBLISH 转发给该节点, 避免广播的开销
+
+// execPublish 在所有节点上发布消息, 返回收到消息的订阅者总数, 失败的节点只记录日志
+func execPublish(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return &reply.ArgNumErrReply{Cmd: "publish"}
+	}
+	results, failures := cluster.broadcast(c, args)
+	for addr, msg := range failures {
+		logger.Warn("publish to " + addr + " failed: " + msg)
+	}
+	var receivers int64
+	for _, r := range results {
+		if intReply, ok := r.(*reply.IntReply); ok {
+			receivers += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(receivers)
+}
+
+// execSPublish 将消息转发给负责频道所在槽的节点
+func execSPublish(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return &reply.ArgNumErrReply{Cmd: "spublish"}
+	}
+	channel := string(args[1])
+	owner := cluster.peerPicker.Get(channel)
+	if owner == cluster.self {
+		return cluster.db.Exec(c, args)
+	}
+	if cluster.redirect {
+		return cluster.checkSameLocalSlot([]string{channel})
+	}
+	// 使用 LOCALEXEC, 即使两个节点的槽信息不一致也不会来回转发
+	return cluster.Relay(owner, c, append([][]byte{[]byte("LOCALEXEC")}, args...))
+}
+
+// execSSubscribe 订阅者必须连接到负责频道所在槽的节点, 其它节点返回 MOVED
+func execSSubscribe(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "ssubscribe"}
+	}
+	channels := make([]string, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		channels[i-1] = string(args[i])
+	}
+	if errReply := cluster.checkSameLocalSlot(channels); errReply != nil {
+		return errReply
+	}
+	return cluster.db.Exec(c, args)
+}
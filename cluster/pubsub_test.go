@@ -0,0 +1,81 @@
+package cluster
+
+import (
+	"net"
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestClusterPubSub(t *testing.T) {
+	selfAddr := "127.0.0.1:7000"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peerAddr := listener.Addr().String()
+	peers := []string{selfAddr, peerAddr}
+
+	config.Properties = &config.PropertyHolder{Self: peerAddr, Peers: peers}
+	peer := makeCluster()
+	defer peer.Close()
+	go serveCluster(listener, peer)
+	config.Properties = &config.PropertyHolder{Self: selfAddr, Peers: peers}
+	cluster := makeCluster()
+	defer cluster.Close()
+	c := connection.NewFakeConn()
+
+	// 订阅者连接到另一个节点
+	var channel string
+	for i := 0; channel == ""; i++ {
+		if name := "channel" + strconv.Itoa(i); cluster.peerPicker.Get(name) == peerAddr {
+			channel = name
+		}
+	}
+	subscriber := connection.NewFakeConn()
+	peer.Exec(subscriber, makeArgs("subscribe", channel))
+	shardSubscriber := connection.NewFakeConn()
+	if r := peer.Exec(shardSubscriber, makeArgs("ssubscribe", channel)); string(r.ToBytes()) != "" {
+		t.Fatalf("ssubscribe on owner failed: %q", r.ToBytes())
+	}
+	subscriber.Clean()
+	shardSubscriber.Clean()
+
+	if r := cluster.Exec(c, makeArgs("publish", channel, "hello")); string(r.ToBytes()) != ":1\r\n" {
+		t.Errorf("publish should reach subscribers on other nodes, got %q", r.ToBytes())
+	}
+	expected := "*3\r\n$7\r\nmessage\r\n$" + strconv.Itoa(len(channel)) + "\r\n" + channel + "\r\n$5\r\nhello\r\n"
+	if string(subscriber.Bytes()) != expected {
+		t.Errorf("unexpected message: %q", subscriber.Bytes())
+	}
+	if len(shardSubscriber.Bytes()) != 0 {
+		t.Errorf("publish should not reach shard subscribers, got %q", shardSubscriber.Bytes())
+	}
+
+	// 分片频道只转发给负责的节点
+	subscriber.Clean()
+	if r := cluster.Exec(c, makeArgs("spublish", channel, "hi")); string(r.ToBytes()) != ":1\r\n" {
+		t.Errorf("spublish should be relayed to the owner, got %q", r.ToBytes())
+	}
+	expected = "*3\r\n$8\r\nsmessage\r\n$" + strconv.Itoa(len(channel)) + "\r\n" + channel + "\r\n$2\r\nhi\r\n"
+	if string(shardSubscriber.Bytes()) != expected {
+		t.Errorf("unexpected shard message: %q", shardSubscriber.Bytes())
+	}
+	if len(subscriber.Bytes()) != 0 {
+		t.Errorf("spublish should not reach normal subscribers, got %q", subscriber.Bytes())
+	}
+	if r := cluster.Exec(connection.NewFakeConn(), makeArgs("ssubscribe", channel)); !strings.HasPrefix(string(r.ToBytes()), "-MOVED") {
+		t.Errorf("ssubscribe on other nodes should be redirected, got %q", r.ToBytes())
+	}
+	if r := peer.Exec(connection.NewFakeConn(), makeArgs("ssubscribe", "{a}1", "{b}1")); !strings.HasPrefix(string(r.ToBytes()), "-CROSSSLOT") {
+		t.Errorf("channels in different slots should be rejected, got %q", r.ToBytes())
+	}
+
+	peer.Exec(shardSubscriber, makeArgs("sunsubscribe"))
+	if r := cluster.Exec(c, makeArgs("spublish", channel, "bye")); string(r.ToBytes()) != ":0\r\n" {
+		t.Errorf("unsubscribed client should not be counted, got %q", r.ToBytes())
+	}
+}
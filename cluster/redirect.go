@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+// maxRedirects 转发模式下代替客户端跟随 MOVED/ASK 的最大次数
+const maxRedirects = 5
+
+// makeMovedReply 告诉客户端该槽由另一个节点负责, 客户端应当更新槽的路由表
+func makeMovedReply(slot int, addr string) redis.Reply {
+	return reply.MakeErrReply("MOVED " + strconv.Itoa(slot) + " " + addr)
+}
+
+// makeAskReply 告诉客户端 key 正在迁移到另一个节点, 客户端只需将这一次请求发送给该节点
+func makeAskReply(slot int, addr string) redis.Reply {
+	return reply.MakeErrReply("ASK " + strconv.Itoa(slot) + " " + addr)
+}
+
+var tryAgainErr = reply.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+
+// execByKeys 执行 key 已知的命令.
+// 重定向模式下要求所有 key 属于同一个槽, 不能在本节点执行时将 MOVED/ASK 返回给客户端;
+// 转发模式下由本节点跟随重定向, 将命令转发给负责的节点
+func (cluster *Cluster) execByKeys(c redis.Connection, keys []string, args [][]byte) redis.Reply {
+	if cluster.redirect {
+		slot := getSlot(keys[0])
+		for _, key := range keys[1:] {
+			if getSlot(key) != slot {
+				return crossSlotErr
+			}
+		}
+		return cluster.execLocalKeys(c, keys, args)
+	}
+	result := cluster.execLocalKeys(c, keys, args)
+	for i := 0; i < maxRedirects; i++ {
+		errReply, ok := result.(reply.ErrorReply)
+		if !ok {
+			return result
+		}
+		fields := strings.Fields(errReply.Error())
+		if len(fields) != 3 {
+			return result
+		}
+		switch fields[0] {
+		case "MOVED":
+			result = cluster.Relay(fields[2], c, args)
+		case "ASK":
+			result = cluster.relayAsking(fields[2], c, args)
+		default:
+			return result
+		}
+	}
+	return result
+}
+
+// execLocalKeys 根据槽的归属与迁移状态判断命令能否在本节点执行:
+// 槽由其它节点负责时返回 MOVED, 客户端执行过 ASKING 且槽正在迁入本节点时除外;
+// 槽正在迁出且 key 已经不在本节点时返回 ASK
+func (cluster *Cluster) execLocalKeys(c redis.Connection, keys []string, args [][]byte) redis.Reply {
+	_, asking := cluster.asking.Load(c)
+	present, missing := 0, 0
+	askSlot, askAddr := -1, ""
+	for _, key := range keys {
+		slot := getSlot(key)
+		owner, migrating, importing := cluster.peerPicker.getSlotState(slot)
+		if owner != cluster.self {
+			if importing != "" && asking {
+				continue
+			}
+			if owner == "" {
+				return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+			}
+			if cluster.peerPicker.isFailed(owner) {
+				return reply.MakeErrReply("CLUSTERDOWN The cluster is down")
+			}
+			return makeMovedReply(slot, owner)
+		}
+		if migrating == "" {
+			continue
+		}
+		// 此时还没有锁定 key, 只检查是否存在
+		if _, ok := cluster.localDB().Peek(key); ok {
+			present++
+			continue
+		}
+		missing++
+		if askAddr != "" && askAddr != migrating {
+			return tryAgainErr
+		}
+		askSlot, askAddr = slot, migrating
+	}
+	if missing > 0 {
+		// 部分 key 已经迁移走, 无法在任何一个节点上完整执行
+		if present > 0 {
+			return tryAgainErr
+		}
+		return makeAskReply(askSlot, askAddr)
+	}
+	return cluster.db.Exec(c, args)
+}
@@ -1,15 +1,168 @@
 package cluster
 
-import "redisGo/interface/redis"
+import (
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+)
 
+// defaultFunc 将只涉及一个 key 的命令交给 key 所在的节点执行
 func defaultFunc(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
-	key := string(args[1])
-	return cluster.Relay(key, c, args)
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: string(args[0])}
+	}
+	return cluster.execByKeys(c, []string{string(args[1])}, args)
+}
+
+// execObject OBJECT subcommand key 由 key 所在的节点执行, 没有 key 的子命令在本节点执行
+func execObject(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return cluster.db.Exec(c, args)
+	}
+	return cluster.execByKeys(c, []string{string(args[2])}, args)
+}
+
+// execLocal 不涉及 key 的命令在本节点执行
+func execLocal(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	return cluster.db.Exec(c, args)
 }
 
 func MakeRouter() map[string]CmdFunc {
 	router := make(map[string]CmdFunc)
 
+	router["ping"] = Ping
+	router["info"] = execLocal
+	router["select"] = execSelect
+	router["subscribe"] = execLocal
+	router["unsubscribe"] = execLocal
+	router["publish"] = execPublish
+	router["ssubscribe"] = execSSubscribe
+	router["sunsubscribe"] = execLocal
+	router["spublish"] = execSPublish
+	router["cluster"] = execCluster
+	router["asking"] = execAsking
+	router["migrate"] = execMigrate
+
+	router["multi"] = execMulti
+	router["exec"] = execExec
+	router["discard"] = execDiscard
+	router["watch"] = execWatch
+	router["unwatch"] = execUnWatch
+
+	router["get"] = defaultFunc
+	router["set"] = defaultFunc
+	router["getset"] = defaultFunc
+	router["incr"] = defaultFunc
+	router["incrby"] = defaultFunc
+	router["incrbyfloat"] = defaultFunc
+	router["decr"] = defaultFunc
+	router["decrby"] = defaultFunc
+	router["decrbyfloat"] = defaultFunc
+	router["mset"] = MSet
+	router["mget"] = MGet
+
 	router["del"] = Del
+	router["exists"] = Exists
+	router["isexpired"] = defaultFunc
+	router["expire"] = defaultFunc
+	router["expireat"] = defaultFunc
+	router["pexpire"] = defaultFunc
+	router["pexpireat"] = defaultFunc
+	router["ttl"] = defaultFunc
+	router["pttl"] = defaultFunc
+	router["persist"] = defaultFunc
+	router["type"] = defaultFunc
+	router["object"] = execObject
+	router["rename"] = relayAllKeys
+	router["renamenx"] = relayAllKeys
+	router["keys"] = execKeys
+	router["scan"] = execScan
+	router["dbsize"] = execDBSize
+	router["randomkey"] = execRandomKey
+	router["flushall"] = execFlushAll
+	router["flushdb"] = execFlushAll
+
+	router["rpush"] = defaultFunc
+	router["lindex"] = defaultFunc
+	router["llen"] = defaultFunc
+	router["lpop"] = defaultFunc
+	router["lpush"] = defaultFunc
+	router["lrange"] = defaultFunc
+	router["lrem"] = defaultFunc
+	router["lset"] = defaultFunc
+	router["rpop"] = defaultFunc
+	router["rpoplpush"] = relayAllKeys
+	router["lmove"] = relayAllKeys
+	router["lmpop"] = relayAllKeys
+	router["lpushx"] = defaultFunc
+	router["rpushx"] = defaultFunc
+	router["linsert"] = defaultFunc
+	router["ltrim"] = defaultFunc
+	router["lpos"] = defaultFunc
+	router["blpop"] = execBlocking
+	router["brpop"] = execBlocking
+	router["brpoplpush"] = execBlocking
+	router["blmove"] = execBlocking
+
+	router["hset"] = defaultFunc
+	router["hsetnx"] = defaultFunc
+	router["hget"] = defaultFunc
+	router["hexists"] = defaultFunc
+	router["hdel"] = defaultFunc
+	router["hlen"] = defaultFunc
+	router["hmset"] = defaultFunc
+	router["hmget"] = defaultFunc
+	router["hkeys"] = defaultFunc
+	router["hvals"] = defaultFunc
+	router["hgetall"] = defaultFunc
+	router["hincrby"] = defaultFunc
+	router["hincrbyfloat"] = defaultFunc
+	router["hscan"] = defaultFunc
+
+	router["sadd"] = defaultFunc
+	router["sismember"] = defaultFunc
+	router["srem"] = defaultFunc
+	router["smove"] = relayAllKeys
+	router["scard"] = defaultFunc
+	router["smembers"] = defaultFunc
+	router["srandmember"] = defaultFunc
+	router["sscan"] = defaultFunc
+	router["sinter"] = relayAllKeys
+	router["sinterstore"] = relayAllKeys
+	router["sunion"] = relayAllKeys
+	router["sunionstore"] = relayAllKeys
+	router["sdiff"] = relayAllKeys
+	router["sdiffstore"] = relayAllKeys
+
+	router["zadd"] = defaultFunc
+	router["zscore"] = defaultFunc
+	router["zincrby"] = defaultFunc
+	router["zrem"] = defaultFunc
+	router["zcard"] = defaultFunc
+	router["zcount"] = defaultFunc
+	router["zrange"] = defaultFunc
+	router["zrevrange"] = defaultFunc
+	router["zrangebyscore"] = defaultFunc
+	router["zrevrangebyscore"] = defaultFunc
+	router["zrank"] = defaultFunc
+	router["zrevrank"] = defaultFunc
+	router["zremrangebyscore"] = defaultFunc
+	router["zremrangebyrank"] = defaultFunc
+	router["zpopmin"] = defaultFunc
+	router["zpopmax"] = defaultFunc
+
+	// 两阶段提交: 协调者发给参与者的命令, 以及参与者向协调者查询事务结果的命令
+	router["prepare"] = execPrepare
+	router["preparemulti"] = execPrepareMulti
+	router["commit"] = execCommit
+	router["rollback"] = execRollback
+	router["end"] = execEnd
+	router["txstatus"] = execTxStatus
+	// WATCH 向 key 所在的节点查询版本号
+	router["getversion"] = execGetVersion
+	// KEYS, SCAN 等遍历整个集群的命令发给各节点的命令
+	router["localexec"] = execLocalExec
+	router["localscan"] = execLocalScan
+	// MIGRATE 发给目标节点的命令
+	router["restorekey"] = execRestoreKey
 	return router
 }
@@ -0,0 +1,231 @@
+package cluster
+
+import (
+	"math/rand"
+	"redisGo/db"
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 遍历整个集群的命令: 并发地发给所有节点, 再合并各节点的结果.
+// 节点之间通过 LOCALEXEC 和 LOCALSCAN 转发, 接收方只在本地执行, 不会再次广播.
+// 重定向模式下客户端自己连接每个节点, 这些命令只在本节点执行
+
+// nodeIndexBits SCAN 的复合游标中低位保存节点下标, 高位保存节点本地的游标
+const nodeIndexBits = 10
+
+// nodeAddrs 返回按地址排序的所有节点, 复合游标中的节点下标以此为准
+func (cluster *Cluster) nodeAddrs() []string {
+	nodes := cluster.peerPicker.getNodes()
+	addrs := make([]string, len(nodes))
+	for i, n := range nodes {
+		addrs[i] = n.addr
+	}
+	if len(addrs) == 0 {
+		addrs = append(addrs, cluster.self)
+	}
+	return addrs
+}
+
+// broadcast 并发地在所有节点上执行命令, 返回成功的节点的结果以及失败的节点 -> 错误信息
+func (cluster *Cluster) broadcast(c redis.Connection, args [][]byte) (map[string]redis.Reply, map[string]string) {
+	addrs := cluster.nodeAddrs()
+	results := make(map[string]redis.Reply, len(addrs))
+	failures := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			cmdLine := args
+			if addr != cluster.self {
+				cmdLine = append([][]byte{[]byte("LOCALEXEC")}, args...)
+			}
+			r := cluster.Relay(addr, c, cmdLine)
+			mu.Lock()
+			defer mu.Unlock()
+			if errReply, ok := r.(reply.ErrorReply); ok {
+				failures[addr] = errReply.Error()
+				return
+			}
+			results[addr] = r
+		}(addr)
+	}
+	wg.Wait()
+	return results, failures
+}
+
+// makePartialErrReply 部分节点失败时返回的错误, 列出失败的节点
+func makePartialErrReply(total int, failures map[string]string) redis.Reply {
+	addrs := make([]string, 0, len(failures))
+	for addr := range failures {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	msgs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		msgs[i] = addr + " (" + failures[addr] + ")"
+	}
+	return reply.MakeErrReply("ERR partial result, " + strconv.Itoa(len(failures)) + " of " + strconv.Itoa(total) +
+		" nodes failed: " + strings.Join(msgs, ", "))
+}
+
+// execKeys 合并所有节点的 KEYS 结果, 任何节点失败时返回错误而不是不完整的结果
+func execKeys(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return &reply.ArgNumErrReply{Cmd: "keys"}
+	}
+	if cluster.redirect {
+		return cluster.db.Exec(c, args)
+	}
+	results, failures := cluster.broadcast(c, args)
+	if len(failures) > 0 {
+		return makePartialErrReply(len(results)+len(failures), failures)
+	}
+	keys := make([][]byte, 0)
+	for _, r := range results {
+		if multiBulk, ok := r.(*reply.MultiBulkReply); ok {
+			keys = append(keys, multiBulk.Args...)
+		}
+	}
+	return reply.MakeMultiBulkReply(keys)
+}
+
+func execDBSize(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return &reply.ArgNumErrReply{Cmd: "dbsize"}
+	}
+	if cluster.redirect {
+		return cluster.db.Exec(c, args)
+	}
+	results, failures := cluster.broadcast(c, args)
+	if len(failures) > 0 {
+		return makePartialErrReply(len(results)+len(failures), failures)
+	}
+	var size int64
+	for _, r := range results {
+		if intReply, ok := r.(*reply.IntReply); ok {
+			size += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(size)
+}
+
+// execFlushAll 清空所有节点, 部分节点失败时其它节点仍然会被清空
+func execFlushAll(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return &reply.ArgNumErrReply{Cmd: strings.ToLower(string(args[0]))}
+	}
+	if cluster.redirect {
+		return cluster.db.Exec(c, args)
+	}
+	results, failures := cluster.broadcast(c, args)
+	if len(failures) > 0 {
+		return makePartialErrReply(len(results)+len(failures), failures)
+	}
+	return &reply.OkReply{}
+}
+
+// execRandomKey 从各节点返回的随机 key 中任选一个, 只要有节点返回了 key 就忽略失败的节点
+func execRandomKey(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return &reply.ArgNumErrReply{Cmd: "randomkey"}
+	}
+	if cluster.redirect {
+		return cluster.db.Exec(c, args)
+	}
+	results, failures := cluster.broadcast(c, args)
+	keys := make([][]byte, 0, len(results))
+	for _, r := range results {
+		if bulk, ok := r.(*reply.BulkReply); ok && bulk.Arg != nil {
+			keys = append(keys, bulk.Arg)
+		}
+	}
+	if len(keys) > 0 {
+		return reply.MakeBulkReply(keys[rand.Intn(len(keys))])
+	}
+	if len(failures) > 0 {
+		return makePartialErrReply(len(results)+len(failures), failures)
+	}
+	return &reply.NullBulkReply{}
+}
+
+// execScan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type], 依次遍历每个节点, 游标由节点下标和节点本地的游标组成.
+// 每次调用只访问一个节点, 某个节点失败时返回错误, 客户端可以使用同一个游标重试
+func execScan(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "scan"}
+	}
+	if cluster.redirect {
+		return cluster.db.Exec(c, args)
+	}
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	// 先检查选项, 避免每个节点返回同样的错误
+	if _, errReply := db.ParseScanOptions(args[2:], true); errReply != nil {
+		return errReply
+	}
+
+	addrs := cluster.nodeAddrs()
+	index := int(cursor & (1<<nodeIndexBits - 1))
+	local := cursor >> nodeIndexBits
+	if index >= len(addrs) {
+		return reply.MakeMultiRawReply([]redis.Reply{reply.MakeBulkReply([]byte("0")), reply.MakeEmptyMultiBulkReply()})
+	}
+	addr := addrs[index]
+	cmdLine := append(makeArgs("LOCALSCAN", strconv.FormatUint(local, 10)), args[2:]...)
+	r := cluster.sendCmdLine(addr, cmdLine)
+	if errReply, ok := r.(reply.ErrorReply); ok {
+		return reply.MakeErrReply("ERR scan failed on " + addr + ": " + errReply.Error())
+	}
+	multiBulk, ok := r.(*reply.MultiBulkReply)
+	if !ok || len(multiBulk.Args) == 0 {
+		return reply.MakeErrReply("ERR unexpected reply from " + addr)
+	}
+	local, err = strconv.ParseUint(string(multiBulk.Args[0]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR unexpected reply from " + addr)
+	}
+	if local == 0 {
+		index++
+	}
+	next := uint64(0)
+	if index < len(addrs) {
+		next = local<<nodeIndexBits | uint64(index)
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(next, 10))),
+		reply.MakeMultiBulkReply(multiBulk.Args[1:]),
+	})
+}
+
+// execLocalScan LOCALSCAN cursor [options...], 集群内部命令, 在本节点执行 SCAN 并返回 [下一个游标, key...]
+// 节点之间的协议不支持嵌套的数组, 因此不直接使用 SCAN 的返回格式
+func execLocalScan(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "localscan"}
+	}
+	r := cluster.db.Exec(c, append(makeArgs("SCAN"), args[1:]...))
+	scanReply, ok := r.(*reply.MultiRawReply)
+	if !ok {
+		return r
+	}
+	next := scanReply.Replies[0].(*reply.BulkReply).Arg
+	keys := scanReply.Replies[1].(*reply.MultiBulkReply).Args
+	return reply.MakeMultiBulkReply(append([][]byte{next}, keys...))
+}
+
+// execLocalExec LOCALEXEC cmd [args...], 集群内部命令, 只在本节点执行命令
+func execLocalExec(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "localexec"}
+	}
+	return cluster.db.Exec(c, args[1:])
+}
@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"net"
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/redis/connection"
+	"redisGo/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestScatterGather(t *testing.T) {
+	selfAddr := "127.0.0.1:7000"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peerAddr := listener.Addr().String()
+	peers := []string{selfAddr, peerAddr}
+
+	config.Properties = &config.PropertyHolder{Self: peerAddr, Peers: peers}
+	peer := makeCluster()
+	defer peer.Close()
+	go serveCluster(listener, peer)
+	config.Properties = &config.PropertyHolder{Self: selfAddr, Peers: peers}
+	cluster := makeCluster()
+	defer cluster.Close()
+	c := connection.NewFakeConn()
+	exec := func(args ...string) redis.Reply {
+		return cluster.Exec(c, makeArgs(args[0], args[1:]...))
+	}
+
+	expected := make([]string, 50)
+	for i := range expected {
+		expected[i] = "key" + strconv.Itoa(i)
+		exec("set", expected[i], "1")
+	}
+	sort.Strings(expected)
+	if peer.localDB().DBSize() == 0 || cluster.localDB().DBSize() == 0 {
+		t.Fatal("keys should be spread over both nodes")
+	}
+	if r := exec("dbsize"); string(r.ToBytes()) != ":50\r\n" {
+		t.Errorf("unexpected dbsize: %q", r.ToBytes())
+	}
+	if r := exec("keys", "key1*"); len(r.(*reply.MultiBulkReply).Args) != 11 {
+		t.Errorf("unexpected keys: %q", r.ToBytes())
+	}
+
+	// 使用复合游标遍历所有节点
+	scan := func(options ...string) []string {
+		scanned := make([]string, 0)
+		cursor := "0"
+		for i := 0; ; i++ {
+			r, ok := exec(append([]string{"scan", cursor}, options...)...).(*reply.MultiRawReply)
+			if !ok || i > 100 {
+				t.Fatalf("scan failed at cursor %s", cursor)
+			}
+			cursor = string(r.Replies[0].(*reply.BulkReply).Arg)
+			for _, key := range r.Replies[1].(*reply.MultiBulkReply).Args {
+				scanned = append(scanned, string(key))
+			}
+			if cursor == "0" {
+				break
+			}
+		}
+		sort.Strings(scanned)
+		return scanned
+	}
+	if scanned := scan("count", "7"); strings.Join(scanned, ",") != strings.Join(expected, ",") {
+		t.Errorf("scan should return every key exactly once, got %v", scanned)
+	}
+	if scanned := scan("match", "key[1-2]?", "type", "string"); len(scanned) != 20 {
+		t.Errorf("unexpected keys matching key[1-2]?: %v", scanned)
+	}
+	if r := exec("scan", "0", "type"); !reply.IsErrorReply(r) {
+		t.Errorf("invalid options should be rejected, got %q", r.ToBytes())
+	}
+
+	if r, ok := exec("randomkey").(*reply.BulkReply); !ok || !strings.HasPrefix(string(r.Arg), "key") {
+		t.Errorf("unexpected randomkey reply")
+	}
+	if r := exec("flushall"); string(r.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("flushall failed: %q", r.ToBytes())
+	}
+	if peer.localDB().DBSize() != 0 || cluster.localDB().DBSize() != 0 {
+		t.Error("flushall should clear every node")
+	}
+	if r := exec("randomkey"); string(r.ToBytes()) != "$-1\r\n" {
+		t.Errorf("randomkey on empty cluster should return nil, got %q", r.ToBytes())
+	}
+}
+
+func TestScatterPartialFailure(t *testing.T) {
+	// 第二个节点不可达
+	deadAddr := "127.0.0.1:1"
+	config.Properties = &config.PropertyHolder{Self: "127.0.0.1:7000", Peers: []string{deadAddr}}
+	cluster := makeCluster()
+	defer cluster.Close()
+	c := connection.NewFakeConn()
+	var localKey string
+	for i := 0; localKey == ""; i++ {
+		if key := "key" + strconv.Itoa(i); cluster.peerPicker.Get(key) == cluster.self {
+			localKey = key
+		}
+	}
+	cluster.localDB().ExecWithLock(makeArgs("SET", localKey, "1"))
+
+	r := cluster.Exec(c, makeArgs("dbsize"))
+	if !strings.HasPrefix(string(r.ToBytes()), "-ERR partial result, 1 of 2 nodes failed: "+deadAddr) {
+		t.Errorf("unexpected dbsize reply: %q", r.ToBytes())
+	}
+	if r := cluster.Exec(c, makeArgs("randomkey")); string(r.ToBytes()) != "$"+strconv.Itoa(len(localKey))+"\r\n"+localKey+"\r\n" {
+		t.Errorf("randomkey should ignore failed nodes, got %q", r.ToBytes())
+	}
+	if r := cluster.Exec(c, makeArgs("flushall")); !reply.IsErrorReply(r) {
+		t.Errorf("flushall should report failed nodes, got %q", r.ToBytes())
+	}
+	if cluster.localDB().DBSize() != 0 {
+		t.Error("reachable nodes should be flushed")
+	}
+}
@@ -0,0 +1,255 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"redisGo/lib/consistenthash"
+	"redisGo/lib/crc16"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slotCount 与 Redis Cluster 一致, 所有 key 被映射到 16384 个槽中
+const slotCount = 16384
+
+// getSlot 计算 key 所属的槽, 带有 hash tag 的 key 只使用 tag 计算
+func getSlot(key string) int {
+	partitionKey := consistenthash.GetPartitionKey(key)
+	return int(crc16.Checksum([]byte(partitionKey))) & (slotCount - 1)
+}
+
+// node 集群中的一个节点, 除 id 和 addr 外的字段由 gossip 更新, 需要持有 slotTable.mu
+type node struct {
+	id          string
+	addr        string // host:port
+	busAddr     string // 集群总线地址
+	configEpoch int64  // 节点声明的槽以该纪元为准, 纪元更大的声明覆盖旧的声明
+
+	handshake   bool                 // 通过 CLUSTER MEET 加入, 尚未收到回复, 此时发送 MEET 而不是 PING
+	pingSent    time.Time            // 最近一次发送 PING 的时间
+	pongRecv    time.Time            // 最近一次收到 PONG 的时间
+	pfail       bool                 // 本节点认为该节点下线
+	fail        bool                 // 集群中多数负责槽的节点认为该节点下线
+	failReports map[string]time.Time // 认为该节点 PFAIL 的节点 -> 报告时间
+}
+
+func newNode(addr string, busAddr string) *node {
+	return &node{
+		id:          genNodeId(addr),
+		addr:        addr,
+		busAddr:     busAddr,
+		pongRecv:    time.Now(),
+		failReports: make(map[string]time.Time),
+	}
+}
+
+// parseNodeAddr 解析 host:port[@cport], 没有指定总线端口时使用 port+10000
+func parseNodeAddr(raw string) (addr string, busAddr string) {
+	addr = raw
+	if i := strings.Index(raw, "@"); i >= 0 {
+		addr = raw[:i]
+		host, _, _ := net.SplitHostPort(addr)
+		return addr, net.JoinHostPort(host, raw[i+1:])
+	}
+	return addr, defaultBusAddr(addr)
+}
+
+// nodeAddrWithBus 生成 parseNodeAddr 能够解析的 host:port@cport
+func nodeAddrWithBus(addr string, busAddr string) string {
+	_, busPort := splitAddr(busAddr)
+	return addr + "@" + strconv.Itoa(busPort)
+}
+
+func defaultBusAddr(addr string) string {
+	host, port := splitAddr(addr)
+	return net.JoinHostPort(host, strconv.Itoa(port+busPortOffset))
+}
+
+// genNodeId 根据地址生成 40 位的节点 id, 各节点不需要通信就能得到相同的 id
+func genNodeId(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+// slotRange 一段连续的由同一个节点负责的槽
+type slotRange struct {
+	start int
+	end   int // 包含
+	addr  string
+}
+
+// slotTable 记录每个槽由哪个节点负责, 以及正在迁移的槽的状态
+type slotTable struct {
+	mu           sync.RWMutex
+	owners       [slotCount]string // slot -> 节点地址
+	nodes        map[string]*node  // addr -> node
+	migrating    map[int]string    // slot -> 目标节点地址, 在源节点上设置
+	importing    map[int]string    // slot -> 源节点地址, 在目标节点上设置
+	currentEpoch int64             // 集群中见过的最大纪元
+}
+
+// makeSlotTable 将所有槽按节点地址排序后平均分成连续的区间, 每个节点使用相同的配置时会得到相同的分配结果.
+// 地址的格式为 host:port[@cport]
+func makeSlotTable(addrs []string) *slotTable {
+	t := &slotTable{
+		nodes:     make(map[string]*node),
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+	}
+	sorted := make([]string, 0, len(addrs))
+	for _, raw := range addrs {
+		addr, busAddr := parseNodeAddr(raw)
+		if _, ok := t.nodes[addr]; ok || addr == "" {
+			continue
+		}
+		t.nodes[addr] = newNode(addr, busAddr)
+		sorted = append(sorted, addr)
+	}
+	sort.Strings(sorted)
+	for i, addr := range sorted {
+		start := i * slotCount / len(sorted)
+		end := (i + 1) * slotCount / len(sorted)
+		for slot := start; slot < end; slot++ {
+			t.owners[slot] = addr
+		}
+	}
+	return t
+}
+
+// Get 返回负责 key 的节点地址
+func (t *slotTable) Get(key string) string {
+	return t.getOwner(getSlot(key))
+}
+
+func (t *slotTable) getOwner(slot int) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.owners[slot]
+}
+
+// getSlotState 返回槽的负责节点以及迁移状态, 没有迁移时 migrating 和 importing 为空
+func (t *slotTable) getSlotState(slot int) (owner string, migrating string, importing string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.owners[slot], t.migrating[slot], t.importing[slot]
+}
+
+func (t *slotTable) getNode(addr string) *node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nodes[addr]
+}
+
+func (t *slotTable) getNodeById(id string) *node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, n := range t.nodes {
+		if n.id == id {
+			return n
+		}
+	}
+	return nil
+}
+
+// setOwner 将槽交给指定节点, 同时结束槽的迁移状态
+func (t *slotTable) setOwner(slot int, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.owners[slot] = addr
+	delete(t.migrating, slot)
+	delete(t.importing, slot)
+}
+
+func (t *slotTable) setMigrating(slot int, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.migrating[slot] = addr
+}
+
+func (t *slotTable) setImporting(slot int, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.importing[slot] = addr
+}
+
+func (t *slotTable) setStable(slot int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.migrating, slot)
+	delete(t.importing, slot)
+}
+
+// getSlotsOf 返回节点负责的所有槽, 按槽的顺序排列
+func (t *slotTable) getSlotsOf(addr string) []int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	slots := make([]int, 0)
+	for slot, owner := range t.owners {
+		if owner == addr {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// getMigrations 返回正在迁移的槽, 用于 CLUSTER NODES
+func (t *slotTable) getMigrations() (map[int]string, map[int]string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	migrating := make(map[int]string, len(t.migrating))
+	for slot, addr := range t.migrating {
+		migrating[slot] = addr
+	}
+	importing := make(map[int]string, len(t.importing))
+	for slot, addr := range t.importing {
+		importing[slot] = addr
+	}
+	return migrating, importing
+}
+
+func (t *slotTable) getCurrentEpoch() int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.currentEpoch
+}
+
+// getNodes 返回按地址排序的所有节点的副本
+func (t *slotTable) getNodes() []*node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	nodes := make([]*node, 0, len(t.nodes))
+	for _, n := range t.nodes {
+		copied := *n
+		copied.failReports = nil
+		nodes = append(nodes, &copied)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].addr < nodes[j].addr
+	})
+	return nodes
+}
+
+// getRanges 按槽的顺序返回所有已分配的连续区间
+func (t *slotTable) getRanges() []*slotRange {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ranges := make([]*slotRange, 0)
+	var current *slotRange
+	for slot, addr := range t.owners {
+		if current != nil && current.addr == addr && current.end == slot-1 {
+			current.end = slot
+			continue
+		}
+		if addr == "" {
+			current = nil
+			continue
+		}
+		current = &slotRange{start: slot, end: slot, addr: addr}
+		ranges = append(ranges, current)
+	}
+	return ranges
+}
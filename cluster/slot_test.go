@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGetSlot(t *testing.T) {
+	// 与 Redis CLUSTER KEYSLOT 的结果一致
+	cases := map[string]int{
+		"123456789":            12739,
+		"foo":                  12182,
+		"{user1000}.following": getSlot("user1000"),
+		"foo{}{bar}":           getSlot("foo{}{bar}"),
+		"foo{{bar}}zap":        getSlot("{bar"),
+		"foo{bar}{zap}":        getSlot("bar"),
+	}
+	for key, expected := range cases {
+		if slot := getSlot(key); slot != expected {
+			t.Errorf("slot of %s: expected %d, got %d", key, expected, slot)
+		}
+	}
+}
+
+func TestSlotTable(t *testing.T) {
+	table := makeSlotTable([]string{"127.0.0.1:7002", "127.0.0.1:7000", "127.0.0.1:7001", "127.0.0.1:7000"})
+	ranges := table.getRanges()
+	if len(ranges) != 3 {
+		t.Fatalf("expected 3 ranges, got %d", len(ranges))
+	}
+	if ranges[0].start != 0 || ranges[0].addr != "127.0.0.1:7000" || ranges[2].end != slotCount-1 {
+		t.Errorf("unexpected ranges: %+v %+v %+v", ranges[0], ranges[1], ranges[2])
+	}
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].start != ranges[i-1].end+1 {
+			t.Errorf("ranges should be continuous: %+v %+v", ranges[i-1], ranges[i])
+		}
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	self, peer := "127.0.0.1:7000", "127.0.0.1:7001"
+	config.Properties = &config.PropertyHolder{
+		Self:            self,
+		Peers:           []string{peer},
+		ClusterRedirect: true,
+	}
+	cluster := MakeCluster()
+	defer cluster.Close()
+	c := connection.NewFakeConn()
+
+	// 找到分别属于两个节点的 key
+	var localKey, remoteKey string
+	for i := 0; localKey == "" || remoteKey == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if cluster.peerPicker.Get(key) == self {
+			localKey = key
+		} else {
+			remoteKey = key
+		}
+	}
+
+	exec := func(args ...string) string {
+		return string(cluster.Exec(c, makeArgs(args[0], args[1:]...)).ToBytes())
+	}
+	if r := exec("set", localKey, "1"); r != "+OK\r\n" {
+		t.Errorf("local key should be set, got %q", r)
+	}
+	expected := "-MOVED " + strconv.Itoa(getSlot(remoteKey)) + " " + peer + "\r\n"
+	if r := exec("get", remoteKey); r != expected {
+		t.Errorf("expected %q, got %q", expected, r)
+	}
+	if r := exec("mget", localKey, remoteKey); !strings.HasPrefix(r, "-CROSSSLOT") {
+		t.Errorf("keys in different slots should be rejected, got %q", r)
+	}
+	if r := exec("cluster", "countkeysinslot", strconv.Itoa(getSlot(localKey))); r != ":1\r\n" {
+		t.Errorf("unexpected countkeysinslot reply: %q", r)
+	}
+	if r := exec("cluster", "keyslot", "foo"); r != ":12182\r\n" {
+		t.Errorf("unexpected keyslot reply: %q", r)
+	}
+	expected = "*2\r\n" +
+		"*3\r\n:0\r\n:8191\r\n*3\r\n$9\r\n127.0.0.1\r\n:7000\r\n$40\r\n" + genNodeId(self) + "\r\n" +
+		"*3\r\n:8192\r\n:16383\r\n*3\r\n$9\r\n127.0.0.1\r\n:7001\r\n$40\r\n" + genNodeId(peer) + "\r\n"
+	if r := exec("cluster", "slots"); r != expected {
+		t.Errorf("unexpected cluster slots reply: %q", r)
+	}
+	nodes := exec("cluster", "nodes")
+	if !strings.Contains(nodes, genNodeId(self)+" 127.0.0.1:7000@17000 myself,master - 0 0 0 connected 0-8191\n") {
+		t.Errorf("unexpected cluster nodes reply: %q", nodes)
+	}
+}
@@ -1,7 +1,6 @@
 package cluster
 
 import (
-	"fmt"
 	"redisGo/db"
 	"redisGo/interface/redis"
 	"redisGo/lib/logger"
@@ -13,206 +12,414 @@ import (
 	"time"
 )
 
-type Transaction struct {
-	id      string
-	args    [][]byte
-	cluster *Cluster
-	conn    redis.Connection
-
-	keys       []string // related keys
-	lockedKeys bool
-	undoLog    map[string][][]byte // store data for undolog
+// 两阶段提交的参与者.
+// PREPARE 对本节点负责的 key 加锁并记录回滚日志, PREPAREMULTI 用于 MULTI/EXEC, 可以包含多条命令并检查 WATCH 的 key,
+// COMMIT 执行命令后释放锁, ROLLBACK 释放锁, END 表示协调者已经结束事务, 参与者此时才清理事务的状态和回滚日志,
+// 在此之前重复或迟到的 COMMIT, ROLLBACK 都能找到事务, 已经提交的事务仍然可以根据回滚日志撤销.
+// 事务 id 的格式为 协调者地址#序号, 参与者超过 maxLockTime 没有收到结果时向协调者查询事务的状态
 
-	status int8
-	mu     *sync.Mutex
-}
+const maxLockTime = 3 * time.Second
 
 const (
-	maxLockTime       = 3 * time.Second
-	waitBeforeCleanTx = 2 * time.Second
-	CreatedStatus     = iota
-	PreparedStatus
+	PreparedStatus = iota
 	CommittedStatus
 	RollbackedStatus
 )
 
-func genTaskKey(txId string) string {
-	return "tx:" + txId
+const (
+	// txModeExec 提交时在本节点执行 PREPARE 中的命令, 命令涉及的 key 都由本节点负责
+	txModeExec = "EXEC"
+	// txModeLock 命令涉及多个节点, 参与者只负责加锁并返回 key 的快照,
+	// 由协调者计算命令执行的结果, 提交时参与者写入新的快照
+	txModeLock = "LOCK"
+)
+
+// keyImage 某一时刻 key 的完整内容, cmdLine 为 nil 表示 key 不存在
+type keyImage struct {
+	key      string
+	cmdLine  [][]byte
+	expireAt int64 // 过期时间的毫秒时间戳, 0 表示不过期
+}
+
+func takeImage(d *db.DB, key string) *keyImage {
+	image := &keyImage{key: key}
+	entity, ok := d.Get(key)
+	if !ok {
+		return image
+	}
+	image.cmdLine = db.EntityToCmd(key, entity).Args
+	if expireTime, ok := d.ExpireTime(key); ok {
+		image.expireAt = expireTime.UnixMilli()
+	}
+	return image
 }
 
-func NewTransaction(cluster *Cluster, c redis.Connection, id string, args [][]byte, keys []string) *Transaction {
-	return &Transaction{
-		id:      id,
-		args:    args,
-		cluster: cluster,
-		conn:    c,
-		keys:    keys,
-		status:  CreatedStatus,
-		mu:      new(sync.Mutex),
+// restore 将 key 恢复为快照的内容并写入 aof, 调用者需要持有 key 的锁
+func (image *keyImage) restore(d *db.DB) {
+	_, existed := d.Get(image.key)
+	d.Persist(image.key)
+	d.Remove(image.key)
+	if existed {
+		d.AddAof(reply.MakeMultiBulkReply(makeArgs("DEL", image.key)))
+	}
+	if image.cmdLine == nil || (image.expireAt > 0 && image.expireAt <= time.Now().UnixMilli()) {
+		return
+	}
+	// 命令自身会写入 aof
+	d.ExecWithLock(image.cmdLine)
+	if image.expireAt > 0 {
+		d.ExecWithLock(makeArgs("PEXPIREAT", image.key, strconv.FormatInt(image.expireAt, 10)))
 	}
 }
 
-// Reentrant
-// invoker should hold tx.mu
-func (tx *Transaction) lockKeys() {
-	if !tx.lockedKeys {
-		tx.cluster.db.Locks(tx.keys...)
-		tx.lockedKeys = true
+// encodeImages 将快照编码为 key expire-at argc arg... 的平铺格式, 以便放入 RESP 数组中传输
+func encodeImages(images []*keyImage) [][]byte {
+	result := make([][]byte, 0)
+	for _, image := range images {
+		result = append(result,
+			[]byte(image.key),
+			[]byte(strconv.FormatInt(image.expireAt, 10)),
+			[]byte(strconv.Itoa(len(image.cmdLine))))
+		result = append(result, image.cmdLine...)
 	}
+	return result
 }
 
-func (tx *Transaction) unLockKeys() {
-	if tx.lockedKeys {
-		tx.cluster.db.Unlocks(tx.keys...)
-		tx.lockedKeys = false
+// encodeCmdLines 将多条命令编码为 argc arg... 的平铺格式
+func encodeCmdLines(cmdLines [][][]byte) [][]byte {
+	result := make([][]byte, 0)
+	for _, cmdLine := range cmdLines {
+		result = append(result, []byte(strconv.Itoa(len(cmdLine))))
+		result = append(result, cmdLine...)
 	}
+	return result
 }
 
-// t should contains keys field and Id field
-func (tx *Transaction) prepare() error {
-	tx.mu.Lock()
-	defer tx.mu.Unlock()
-	// lock keys
-	tx.lockKeys()
-
-	// build undoLog
-	tx.undoLog = make(map[string][][]byte)
-	for _, key := range tx.keys {
-		entity, ok := tx.cluster.db.Get(key)
-		if ok {
-			blob := db.EntityToCmd(key, entity)
-			tx.undoLog[key] = blob.Args
-		} else {
-			tx.undoLog[key] = nil // entity was nil, should be removed while rollback
-		}
-	}
-	tx.status = PreparedStatus
-	taskKey := genTaskKey(tx.id)
-	timewheel.Delay(maxLockTime, taskKey, func() {
-		if tx.status == PreparedStatus { // rollback transaction uncommitted until expire
-			logger.Info("abort transaction: " + tx.id)
-			_ = tx.rollback()
+func decodeCmdLines(args [][]byte) ([][][]byte, bool) {
+	cmdLines := make([][][]byte, 0)
+	for i := 0; i < len(args); {
+		argc, err := strconv.Atoi(string(args[i]))
+		if err != nil || argc <= 0 || i+1+argc > len(args) {
+			return nil, false
 		}
-	})
-	return nil
+		cmdLines = append(cmdLines, args[i+1:i+1+argc])
+		i += 1 + argc
+	}
+	return cmdLines, true
 }
 
-func (tx *Transaction) rollback() error {
-	curStatus := tx.status
-	tx.mu.Lock()
-	defer tx.mu.Unlock()
+func decodeImages(args [][]byte) ([]*keyImage, bool) {
+	images := make([]*keyImage, 0)
+	for i := 0; i < len(args); {
+		if i+3 > len(args) {
+			return nil, false
+		}
+		expireAt, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		argc, err := strconv.Atoi(string(args[i+2]))
+		if err != nil || argc < 0 || i+3+argc > len(args) {
+			return nil, false
+		}
+		image := &keyImage{key: string(args[i]), expireAt: expireAt}
+		if argc > 0 {
+			image.cmdLine = args[i+3 : i+3+argc]
+		}
+		images = append(images, image)
+		i += 3 + argc
+	}
+	return images, true
+}
+
+type Transaction struct {
+	id       string
+	mode     string
+	cmdLines [][][]byte
+	multi    bool // 由 PREPAREMULTI 创建, 提交的结果为每条命令的结果组成的数组
+	cluster  *Cluster
+
+	// 由本节点负责的 key
+	writeKeys []string
+	readKeys  []string
+	undoLog   []*keyImage // 准备时 writeKeys 的快照
+	result    redis.Reply // 提交的结果, 重复的 COMMIT 直接返回
+
+	status int8
+	mu     sync.Mutex
+}
+
+func genTaskKey(txId string) string {
+	return "tx:" + txId
+}
+
+// coordinatorOf 从事务 id 中取出协调者的地址
+func coordinatorOf(txId string) string {
+	return txId[:strings.LastIndex(txId, "#")]
+}
 
-	if tx.status != curStatus {
-		return fmt.Errorf("tx %s status changed", tx.id)
+var watchChangedErr = reply.MakeErrReply("WATCHCHANGED Watched keys have been modified")
+
+// execPrepare PREPARE txId EXEC|LOCK command [arg ...]
+// EXEC 模式返回 OK, LOCK 模式返回本节点负责的 key 的快照
+func execPrepare(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 4 {
+		return &reply.ArgNumErrReply{Cmd: "prepare"}
+	}
+	return cluster.prepareTx(string(args[1]), string(args[2]), [][][]byte{args[3:]}, nil, false)
+}
+
+// execPrepareMulti PREPAREMULTI txId EXEC|LOCK watch-count [key version ...] [argc arg ...] ...
+// WATCH 的 key 版本号发生变化时返回 WATCHCHANGED
+func execPrepareMulti(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 4 {
+		return &reply.ArgNumErrReply{Cmd: "preparemulti"}
 	}
-	if tx.status == RollbackedStatus {
-		return nil
+	watchCount, err := strconv.Atoi(string(args[3]))
+	if err != nil || watchCount < 0 || 4+watchCount*2 > len(args) {
+		return &reply.SyntaxErrReply{}
 	}
-	tx.lockKeys()
-	for key, blob := range tx.undoLog {
-		if len(blob) > 0 {
-			tx.cluster.db.Remove(key)
-			tx.cluster.db.Exec(nil, blob)
-		} else {
-			tx.cluster.db.Remove(key)
+	watching := make(map[string]uint32, watchCount)
+	for i := 0; i < watchCount; i++ {
+		version, err := strconv.ParseUint(string(args[5+i*2]), 10, 32)
+		if err != nil {
+			return &reply.SyntaxErrReply{}
 		}
+		watching[string(args[4+i*2])] = uint32(version)
 	}
-	tx.unLockKeys()
-	tx.status = RollbackedStatus
-	return nil
+	cmdLines, ok := decodeCmdLines(args[4+watchCount*2:])
+	if !ok {
+		return &reply.SyntaxErrReply{}
+	}
+	return cluster.prepareTx(string(args[1]), string(args[2]), cmdLines, watching, true)
 }
 
-// rollback local transaction
-func Rollback(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rollback' command")
+func (cluster *Cluster) prepareTx(txId string, mode string, cmdLines [][][]byte, watching map[string]uint32, multi bool) redis.Reply {
+	mode = strings.ToUpper(mode)
+	if mode != txModeExec && mode != txModeLock || !strings.Contains(txId, "#") {
+		return &reply.SyntaxErrReply{}
 	}
-	txId := string(args[1])
-	raw, ok := cluster.transactions.Get(txId)
-	if !ok {
-		return reply.MakeIntReply(0)
+	writeKeys := make([]string, 0)
+	readKeys := make([]string, 0)
+	for _, cmdLine := range cmdLines {
+		write, read, ok := db.GetRelatedKeys(cmdLine)
+		if !ok {
+			return reply.MakeErrReply("ERR unknown command `" + strings.ToLower(string(cmdLine[0])) + "`")
+		}
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
 	}
-	tx, _ := raw.(*Transaction)
-	err := tx.rollback()
-	if err != nil {
-		return reply.MakeErrReply(err.Error())
+	if mode == txModeLock {
+		writeKeys = cluster.filterLocalKeys(writeKeys)
+		readKeys = cluster.filterLocalKeys(readKeys)
 	}
-	// clean transaction
-	timewheel.Delay(waitBeforeCleanTx, "", func() {
-		cluster.transactions.Remove(tx.id)
-	})
-	return reply.MakeIntReply(1)
-}
-
-func Commit(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'commit' command")
+	writeKeys = uniqueKeys(writeKeys)
+	for key := range watching {
+		readKeys = append(readKeys, key)
 	}
-	txId := string(args[1])
-	raw, ok := cluster.transactions.Get(txId)
-	if !ok {
-		return reply.MakeIntReply(0)
+	readKeys = uniqueKeys(readKeys)
+	tx := &Transaction{
+		id:        txId,
+		mode:      mode,
+		cmdLines:  cmdLines,
+		multi:     multi,
+		cluster:   cluster,
+		writeKeys: writeKeys,
+		readKeys:  readKeys,
+		status:    PreparedStatus,
 	}
-	tx, _ := raw.(*Transaction)
-
+	// 加锁完成之前不处理 COMMIT 和 ROLLBACK
 	tx.mu.Lock()
 	defer tx.mu.Unlock()
+	if cluster.transactions.PutIfAbsent(txId, tx) == 0 {
+		return reply.MakeErrReply("ERR transaction " + txId + " already exists")
+	}
 
-	cmd := strings.ToLower(string(tx.args[0]))
-	var result redis.Reply
-	if cmd == "del" {
-		result = CommitDel(cluster, c, tx)
+	localDB := cluster.localDB()
+	localDB.RWLocks(writeKeys, readKeys)
+	for key, version := range watching {
+		if localDB.GetVersion(key) != version {
+			localDB.RWUnLocks(writeKeys, readKeys)
+			tx.status = RollbackedStatus
+			return watchChangedErr
+		}
 	}
-	if reply.IsErrorReply(result) {
-		//failed
-		err2 := tx.rollback()
-		return reply.MakeErrReply(fmt.Sprintf("err occurs when rollback: %v. origin err: %v", err2, result))
-	} else {
-		// after committed
-		tx.unLockKeys()
-		tx.status = CommittedStatus
-		// clean transaction
-		// do not clean immediately, in case rollback
-		timewheel.Delay(waitBeforeCleanTx, "", func() {
-			cluster.transactions.Remove(tx.id)
-		})
+	tx.undoLog = make([]*keyImage, 0, len(writeKeys))
+	for _, key := range writeKeys {
+		tx.undoLog = append(tx.undoLog, takeImage(localDB, key))
+	}
+	tx.waitForDecision()
+	if mode == txModeExec {
+		return &reply.OkReply{}
+	}
+	images := make([]*keyImage, 0, len(writeKeys)+len(readKeys))
+	images = append(images, tx.undoLog...)
+	written := make(map[string]struct{}, len(writeKeys))
+	for _, key := range writeKeys {
+		written[key] = struct{}{}
+	}
+	for _, key := range readKeys {
+		if _, ok := written[key]; !ok {
+			images = append(images, takeImage(localDB, key))
+		}
+	}
+	return reply.MakeMultiBulkReply(encodeImages(images))
+}
+
+func uniqueKeys(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			result = append(result, key)
+		}
 	}
 	return result
 }
 
-// request all node rollback transaction as leader
-func RequestRollback(cluster *Cluster, c redis.Connection, txId int64, peers map[string][]string) {
-	txIdStr := strconv.FormatInt(txId, 10)
-	for peer := range peers {
-		if peer == cluster.self {
-			Rollback(cluster, c, makeArgs("rollback", txIdStr))
-		} else {
-			cluster.Relay(peer, c, makeArgs("rollback", txIdStr))
+// waitForDecision 超过 maxLockTime 没有收到结果时向协调者查询, 协调者不可达时继续等待并保持锁,
+// 不会自行回滚, 以免与已经提交的其它参与者不一致. 调用者需要持有 tx.mu
+func (tx *Transaction) waitForDecision() {
+	timewheel.Delay(maxLockTime, genTaskKey(tx.id), func() {
+		tx.mu.Lock()
+		prepared := tx.status == PreparedStatus
+		tx.mu.Unlock()
+		if !prepared {
+			return
 		}
+		result := tx.cluster.sendTo(coordinatorOf(tx.id), "TXSTATUS", tx.id, tx.cluster.self)
+		status, ok := result.(*reply.MultiBulkReply)
+		if !ok || len(status.Args) == 0 {
+			logger.Warn("transaction " + tx.id + " in doubt, coordinator unreachable: " + string(result.ToBytes()))
+			tx.mu.Lock()
+			if tx.status == PreparedStatus {
+				tx.waitForDecision()
+			}
+			tx.mu.Unlock()
+			return
+		}
+		switch string(status.Args[0]) {
+		case "commit":
+			logger.Info("commit in-doubt transaction " + tx.id)
+			tx.commit(status.Args[1:])
+		case "rollback":
+			logger.Info("abort in-doubt transaction " + tx.id)
+			tx.rollback()
+		default:
+			// 协调者没有该事务的记录, 不会再发送结果和 END
+			logger.Info("abort unknown transaction " + tx.id)
+			tx.rollback()
+			tx.cluster.transactions.Remove(tx.id)
+		}
+	})
+}
+
+func (tx *Transaction) commit(images [][]byte) redis.Reply {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	switch tx.status {
+	case CommittedStatus:
+		return tx.result
+	case RollbackedStatus:
+		return reply.MakeErrReply("ERR transaction " + tx.id + " has been rolled back")
 	}
+	localDB := tx.cluster.localDB()
+	if tx.mode == txModeExec {
+		results := make([][]byte, 0, len(tx.cmdLines))
+		for _, cmdLine := range tx.cmdLines {
+			tx.result = localDB.ExecWithLock(cmdLine)
+			results = append(results, tx.result.ToBytes())
+		}
+		if tx.multi {
+			// 结果可能是任意类型, 编码后放入数组, 由协调者按原始顺序组装
+			tx.result = reply.MakeMultiBulkReply(results)
+		}
+	} else {
+		decoded, ok := decodeImages(images)
+		if !ok {
+			return reply.MakeErrReply("ERR invalid key images")
+		}
+		for _, image := range decoded {
+			image.restore(localDB)
+		}
+		tx.result = &reply.OkReply{}
+	}
+	localDB.AddVersion(tx.writeKeys...)
+	localDB.RWUnLocks(tx.writeKeys, tx.readKeys)
+	localDB.ServeBlocked(tx.writeKeys...)
+	timewheel.Cancel(genTaskKey(tx.id))
+	tx.status = CommittedStatus
+	return tx.result
 }
 
-// request all node commit transaction as leader
-func RequestCommit(cluster *Cluster, c redis.Connection, txId int64, peers map[string][]string) ([]redis.Reply, reply.ErrorReply) {
-	var errReply reply.ErrorReply
-	txIdStr := strconv.FormatInt(txId, 10)
-	respList := make([]redis.Reply, 0, len(peers))
-	for peer := range peers {
-		var resp redis.Reply
-		if peer == cluster.self {
-			resp = Commit(cluster, c, makeArgs("commit", txIdStr))
-		} else {
-			resp = cluster.Relay(peer, c, makeArgs("commit", txIdStr))
+// rollback 准备阶段的事务尚未修改数据, 只需释放锁; 已经提交的事务根据回滚日志恢复 key 和过期时间
+func (tx *Transaction) rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	localDB := tx.cluster.localDB()
+	switch tx.status {
+	case RollbackedStatus:
+		return
+	case PreparedStatus:
+		localDB.RWUnLocks(tx.writeKeys, tx.readKeys)
+		timewheel.Cancel(genTaskKey(tx.id))
+	case CommittedStatus:
+		localDB.Locks(tx.writeKeys...)
+		for _, image := range tx.undoLog {
+			image.restore(localDB)
 		}
-		if reply.IsErrorReply(resp) {
-			errReply = resp.(reply.ErrorReply)
-			break
+		localDB.AddVersion(tx.writeKeys...)
+		localDB.Unlocks(tx.writeKeys...)
+		localDB.ServeBlocked(tx.writeKeys...)
+	}
+	tx.status = RollbackedStatus
+}
+
+// filterLocalKeys 返回由本节点负责的 key
+func (cluster *Cluster) filterLocalKeys(keys []string) []string {
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if cluster.peerPicker.Get(key) == cluster.self {
+			result = append(result, key)
 		}
-		respList = append(respList, resp)
 	}
-	if errReply != nil {
-		RequestRollback(cluster, c, txId, peers)
-		return nil, errReply
+	return result
+}
+
+// execCommit COMMIT txId [key-image ...], 重复提交返回第一次提交的结果.
+// 事务不存在说明参与者丢失了准备的状态, 返回错误, 协调者不会将其当作已经送达
+func execCommit(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return &reply.ArgNumErrReply{Cmd: "commit"}
+	}
+	raw, ok := cluster.transactions.Get(string(args[1]))
+	if !ok {
+		return reply.MakeErrReply("ERR transaction " + string(args[1]) + " not found")
+	}
+	return raw.(*Transaction).commit(args[2:])
+}
+
+// execRollback ROLLBACK txId, 事务不存在时 (PREPARE 没有送达) 记录为已回滚并返回 0, 迟到的 PREPARE 会被拒绝
+func execRollback(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return &reply.ArgNumErrReply{Cmd: "rollback"}
+	}
+	txId := string(args[1])
+	aborted := &Transaction{id: txId, cluster: cluster, status: RollbackedStatus}
+	if cluster.transactions.PutIfAbsent(txId, aborted) > 0 {
+		return reply.MakeIntReply(0)
+	}
+	if raw, ok := cluster.transactions.Get(txId); ok {
+		raw.(*Transaction).rollback()
+	}
+	return reply.MakeIntReply(1)
+}
+
+// execEnd END txId, 协调者已经结束事务, 不会再发送 COMMIT 或 ROLLBACK, 清理事务的状态和回滚日志
+func execEnd(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return &reply.ArgNumErrReply{Cmd: "end"}
 	}
-	return respList, nil
+	return reply.MakeIntReply(int64(cluster.transactions.Remove(string(args[1]))))
 }
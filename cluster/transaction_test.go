@@ -0,0 +1,302 @@
+package cluster
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/redis/connection"
+	"redisGo/redis/parser"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveCluster 在 listener 上处理其它节点发来的命令
+func serveCluster(listener net.Listener, cluster *Cluster) {
+	serveClusterWith(listener, cluster, nil)
+}
+
+// serveClusterWith 与 serveCluster 相同, intercept 返回非 nil 时以其代替命令的执行结果, 用于模拟失败
+func serveClusterWith(listener net.Listener, cluster *Cluster, intercept func(args [][]byte) redis.Reply) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			c := connection.NewFakeConn()
+			for payload := range parser.Parse(conn) {
+				if payload.Err != nil {
+					return
+				}
+				args, ok := payload.Data.(*reply.MultiBulkReply)
+				if !ok {
+					continue
+				}
+				var result redis.Reply
+				if intercept != nil {
+					result = intercept(args.Args)
+				}
+				if result == nil {
+					result = cluster.Exec(c, args.Args)
+				}
+				if _, err := conn.Write(result.ToBytes()); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestTransactionUndo(t *testing.T) {
+	config.Properties = &config.PropertyHolder{Self: "127.0.0.1:7000"}
+	cluster := makeCluster()
+	defer cluster.Close()
+	c := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(cluster.Exec(c, makeArgs(args[0], args[1:]...)).ToBytes())
+	}
+
+	exec("set", "a", "1")
+	exec("pexpire", "a", "100000")
+	exec("rpush", "b", "x", "y")
+	txId := cluster.self + "#1"
+	if r := exec("prepare", txId, "exec", "del", "a", "b", "c"); r != "+OK\r\n" {
+		t.Fatalf("prepare failed: %q", r)
+	}
+	if r := exec("commit", txId); r != ":2\r\n" {
+		t.Fatalf("commit failed: %q", r)
+	}
+	if r := exec("commit", txId); r != ":2\r\n" {
+		t.Errorf("repeated commit should return the first result, got %q", r)
+	}
+	if r := exec("exists", "a", "b"); r != ":0\r\n" {
+		t.Fatalf("keys should be deleted, got %q", r)
+	}
+	// 提交后回滚, 恢复 key 的内容和过期时间
+	if r := exec("rollback", txId); r != ":1\r\n" {
+		t.Fatalf("rollback failed: %q", r)
+	}
+	if r := exec("get", "a"); r != "$1\r\n1\r\n" {
+		t.Errorf("string should be restored, got %q", r)
+	}
+	if r := exec("lrange", "b", "0", "-1"); r != "*2\r\n$1\r\nx\r\n$1\r\ny\r\n" {
+		t.Errorf("list should be restored, got %q", r)
+	}
+	if r := exec("pttl", "a"); r[0] != ':' || r[1] == '-' || r == ":0\r\n" {
+		t.Errorf("ttl should be restored, got %q", r)
+	}
+	if r := exec("exists", "c"); r != ":0\r\n" {
+		t.Errorf("absent key should stay absent, got %q", r)
+	}
+	if r := exec("commit", txId); r[0] != '-' {
+		t.Errorf("commit after rollback should fail, got %q", r)
+	}
+}
+
+func TestTransactionUndoAof(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	config.Properties = &config.PropertyHolder{
+		Self:           "127.0.0.1:7000",
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    "always",
+	}
+	cluster := makeCluster()
+	defer cluster.Close()
+	c := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(cluster.Exec(c, makeArgs(args[0], args[1:]...)).ToBytes())
+	}
+
+	exec("rpush", "b", "x")
+	exec("pexpire", "b", "100000")
+	txId := cluster.self + "#1"
+	exec("prepare", txId, "exec", "del", "b")
+	exec("commit", txId)
+	if r := exec("rollback", txId); r != ":1\r\n" {
+		t.Fatalf("rollback failed: %q", r)
+	}
+	content, err := os.ReadFile(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 用户的命令和回滚各写入一次
+	for _, cmd := range []string{"PEXPIREAT", "RPUSH"} {
+		if n := strings.Count(strings.ToUpper(string(content)), cmd); n != 2 {
+			t.Errorf("expected %s twice in aof, got %d: %q", cmd, n, content)
+		}
+	}
+}
+
+func TestTransactionRecovery(t *testing.T) {
+	coordinatorAddr := "127.0.0.1:7000"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	participantAddr := listener.Addr().String()
+	peers := []string{coordinatorAddr, participantAddr}
+
+	config.Properties = &config.PropertyHolder{Self: participantAddr, Peers: peers}
+	participant := makeCluster()
+	defer participant.Close()
+	go serveCluster(listener, participant)
+
+	logFile := filepath.Join(t.TempDir(), "txlog.aof")
+	startCoordinator := func() *Cluster {
+		config.Properties = &config.PropertyHolder{
+			Self:                 coordinatorAddr,
+			Peers:                peers,
+			ClusterTxLogFilename: logFile,
+		}
+		return makeCluster()
+	}
+	var key string
+	for i := 0; key == ""; i++ {
+		if participant.peerPicker.Get("key"+strconv.Itoa(i)) == participantAddr {
+			key = "key" + strconv.Itoa(i)
+		}
+	}
+	participant.localDB().ExecWithLock(makeArgs("SET", key, "1"))
+	txStatus := func(txId string) int8 {
+		raw, ok := participant.transactions.Get(txId)
+		if !ok {
+			return -1
+		}
+		tx := raw.(*Transaction)
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		return tx.status
+	}
+	valueOf := func() string {
+		entity, _ := participant.localDB().Get(key)
+		return string(entity.Data.([]byte))
+	}
+
+	// 协调者记录提交的决定后崩溃, 重启后完成提交
+	coordinator := startCoordinator()
+	txId := coordinator.self + "#1"
+	coordinator.coordinator.begin(txId, []string{participantAddr})
+	prepareArgs := append(makeArgs("PREPARE", txId, txModeExec), makeArgs("SET", key, "2")...)
+	if r := coordinator.sendCmdLine(participantAddr, prepareArgs); reply.IsErrorReply(r) {
+		t.Fatalf("prepare failed: %q", r.ToBytes())
+	}
+	if !coordinator.coordinator.decideCommit(txId, map[string][][]byte{participantAddr: {}}) {
+		t.Fatal("decide commit failed")
+	}
+	coordinator.Close()
+	if valueOf() != "1" {
+		t.Fatal("transaction should not be committed before the coordinator sends COMMIT")
+	}
+	coordinator = startCoordinator()
+	// 提交之后协调者发送 END, 参与者清理事务
+	if !waitUntil(2*time.Second, func() bool { return valueOf() == "2" && txStatus(txId) == -1 }) {
+		t.Fatalf("in-doubt transaction should be committed and ended after the coordinator restarts, value %s", valueOf())
+	}
+
+	// 协调者在决定之前崩溃, 重启后回滚并释放参与者的锁
+	txId = coordinator.self + "#2"
+	coordinator.coordinator.begin(txId, []string{participantAddr})
+	prepareArgs = append(makeArgs("PREPARE", txId, txModeExec), makeArgs("SET", key, "3")...)
+	if r := coordinator.sendCmdLine(participantAddr, prepareArgs); reply.IsErrorReply(r) {
+		t.Fatalf("prepare failed: %q", r.ToBytes())
+	}
+	coordinator.Close()
+	coordinator = startCoordinator()
+	defer coordinator.Close()
+	if !waitUntil(2*time.Second, func() bool { return txStatus(txId) == -1 }) {
+		t.Fatal("undecided transaction should be rolled back and ended after the coordinator restarts")
+	}
+	c := connection.NewFakeConn()
+	if r := participant.localDB().Exec(c, makeArgs("GET", key)); string(r.ToBytes()) != "$1\r\n2\r\n" {
+		t.Errorf("lock should be released and value unchanged, got %q", r.ToBytes())
+	}
+	if len(coordinator.coordinator.pending()) != 0 {
+		t.Error("recovered transactions should be ended")
+	}
+}
+
+// 记录提交的决定之后, 某个参与者提交失败时重试直到成功, 已经提交的参与者不会被回滚,
+// 在协调者发送 END 之前参与者一直保留事务的状态和回滚日志
+func TestCommitRetry(t *testing.T) {
+	selfAddr := "127.0.0.1:7000"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peerAddr := listener.Addr().String()
+	peers := []string{selfAddr, peerAddr}
+
+	config.Properties = &config.PropertyHolder{Self: peerAddr, Peers: peers}
+	peer := makeCluster()
+	defer peer.Close()
+	var accept int32
+	go serveClusterWith(listener, peer, func(args [][]byte) redis.Reply {
+		if strings.ToUpper(string(args[0])) == "COMMIT" && atomic.LoadInt32(&accept) == 0 {
+			return reply.MakeErrReply("ERR connection reset")
+		}
+		return nil
+	})
+	config.Properties = &config.PropertyHolder{Self: selfAddr, Peers: peers}
+	cluster := makeCluster()
+	defer cluster.Close()
+
+	keyOn := make(map[string]string)
+	for i := 0; len(keyOn) < 2; i++ {
+		key := "key" + strconv.Itoa(i)
+		if _, ok := keyOn[cluster.peerPicker.Get(key)]; !ok {
+			keyOn[cluster.peerPicker.Get(key)] = key
+		}
+	}
+	a, b := keyOn[selfAddr], keyOn[peerAddr]
+	c := connection.NewFakeConn()
+	r := cluster.Exec(c, makeArgs("MSET", a, "1", b, "2"))
+	if !strings.Contains(string(r.ToBytes()), "not confirmed") {
+		t.Errorf("expected unconfirmed commit, got %q", r.ToBytes())
+	}
+
+	// 超过原来 2 秒的清理时间后, 已经提交的参与者仍然保留事务, 迟到的 ROLLBACK 也能撤销
+	time.Sleep(2500 * time.Millisecond)
+	if cluster.transactions.Len() != 1 || peer.transactions.Len() != 1 {
+		t.Fatalf("transactions should be kept before END, got %d and %d",
+			cluster.transactions.Len(), peer.transactions.Len())
+	}
+	cluster.transactions.ForEach(func(_ string, raw interface{}) bool {
+		tx := raw.(*Transaction)
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		if tx.status != CommittedStatus || tx.undoLog == nil {
+			t.Errorf("committed transaction should keep its undo log, status %d", tx.status)
+		}
+		return true
+	})
+	if len(cluster.coordinator.pending()) != 1 {
+		t.Error("transaction should not be ended before every participant commits")
+	}
+
+	atomic.StoreInt32(&accept, 1)
+	if !waitUntil(5*time.Second, func() bool {
+		return len(cluster.coordinator.pending()) == 0 && cluster.transactions.Len() == 0 && peer.transactions.Len() == 0
+	}) {
+		t.Fatal("commit should be delivered after retrying and the transaction ended on every participant")
+	}
+	for _, kv := range [][2]string{{a, "1"}, {b, "2"}} {
+		if r := cluster.Exec(c, makeArgs("GET", kv[0])); string(r.ToBytes()) != "$1\r\n"+kv[1]+"\r\n" {
+			t.Errorf("%s should be committed, got %q", kv[0], r.ToBytes())
+		}
+	}
+	// 已经结束的事务不能再提交, 协调者需要把它当作错误而不是已经送达
+	txId := selfAddr + "#1"
+	if r := execCommit(cluster, c, makeArgs("COMMIT", txId)); !reply.IsErrorReply(r) {
+		t.Errorf("commit of an unknown transaction should fail, got %q", r.ToBytes())
+	}
+}
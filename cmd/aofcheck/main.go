@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"redisGo/lib/rdb"
+	"redisGo/redis/parser"
+	"redisGo/redis/reply"
+	"strings"
+)
+
+/*
+ * aofcheck 离线检查 aof 文件, 找到最后一条完整命令的结束位置, 使用 --fix 时将文件截断到该位置
+ * usage: aofcheck [--fix] <file.aof>
+ */
+
+type checkResult struct {
+	size    int64 // 文件大小
+	validTo int64 // 最后一条完整命令的结束位置
+	err     error // 第一处错误, nil 表示文件完整
+}
+
+func checkAof(filename string) (*checkResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &checkResult{size: info.Size()}
+
+	reader := bufio.NewReader(file)
+	var base int64
+	if header, err := reader.Peek(5); err == nil && bytes.Equal(header, []byte("REDIS")) {
+		decoder := rdb.NewDecoder(reader)
+		err = decoder.Parse(func(obj *rdb.Object) bool {
+			return true
+		})
+		if err != nil {
+			result.err = errors.New("illegal rdb preamble: " + err.Error())
+			return result, nil
+		}
+		base = decoder.Consumed()
+		fmt.Printf("RDB preamble is OK, %d bytes\n", base)
+	}
+
+	var lastOffset int64
+	var multiStart int64
+	inMulti := false
+	result.validTo = base
+	parser.ParseStream(reader, func(p *parser.Payload) bool {
+		if p.Err != nil {
+			if p.Err != io.EOF {
+				result.err = p.Err
+			}
+			return false
+		}
+		r, ok := p.Data.(*reply.MultiBulkReply)
+		if !ok {
+			result.err = errors.New("require multi bulk reply")
+			return false
+		}
+		switch strings.ToLower(string(r.Args[0])) {
+		case "multi":
+			if inMulti {
+				result.err = errors.New("unexpected MULTI")
+				return false
+			}
+			inMulti = true
+			multiStart = lastOffset
+		case "exec", "discard":
+			if !inMulti {
+				result.err = errors.New("unexpected " + strings.ToUpper(string(r.Args[0])))
+				return false
+			}
+			inMulti = false
+		}
+		lastOffset = p.Offset
+		if !inMulti {
+			result.validTo = base + lastOffset
+		}
+		return true
+	})
+	if inMulti && result.err == nil {
+		result.err = errors.New("MULTI without EXEC")
+	}
+	if inMulti {
+		result.validTo = base + multiStart
+	}
+	return result, nil
+}
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file to the last valid command")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--fix] <file.aof>\n", os.Args[0])
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+
+	result, err := checkAof(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if result.err != nil {
+		fmt.Printf("0x%x: %v\n", result.validTo, result.err)
+	}
+	fmt.Printf("AOF analyzed: size=%d, ok_up_to=%d, diff=%d\n", result.size, result.validTo, result.size-result.validTo)
+	if result.err == nil && result.validTo == result.size {
+		fmt.Println("AOF is valid")
+		return
+	}
+	if !*fix {
+		fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+		os.Exit(1)
+	}
+	err = os.Truncate(filename, result.validTo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to truncate AOF: "+err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("Successfully truncated AOF")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"redisGo/config"
+	"redisGo/lib/logger"
+	"redisGo/sentinel"
+	"redisGo/tcp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * sentinel 监控主节点, 主节点客观下线后与其它哨兵选举出领头哨兵并完成故障转移
+ * usage: CONFIG=sentinel.conf sentinel
+ */
+
+func main() {
+	configFilename := os.Getenv("CONFIG")
+	if configFilename == "" {
+		configFilename = "sentinel.conf"
+	}
+	config.SetupConfig(configFilename)
+	logger.Setup(&logger.Settings{
+		Path:       "logs",
+		Name:       "sentinel",
+		Ext:        "log",
+		TimeFormat: "2006-01-02",
+	})
+
+	// sentinel-monitor <master-name> <host> <port> <quorum>
+	fields := strings.Fields(config.Properties.SentinelMonitor)
+	if len(fields) != 4 {
+		logger.Fatal("illegal sentinel-monitor config: " + config.Properties.SentinelMonitor)
+		return
+	}
+	quorum, err := strconv.Atoi(fields[3])
+	if err != nil {
+		logger.Fatal("illegal quorum: " + fields[3])
+		return
+	}
+	s := sentinel.MakeSentinel(&sentinel.Config{
+		MasterName:      fields[0],
+		MasterAddr:      net.JoinHostPort(fields[1], fields[2]),
+		Quorum:          quorum,
+		DownAfter:       time.Duration(config.Properties.SentinelDownAfter) * time.Millisecond,
+		FailoverTimeout: time.Duration(config.Properties.SentinelFailoverTimeout) * time.Millisecond,
+		Peers:           config.Properties.SentinelPeers,
+	})
+	s.Start()
+
+	cfg := &tcp.Config{
+		Address:    fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port),
+		MaxConnect: uint32(config.Properties.MaxClients),
+		Timeout:    2 * time.Second,
+	}
+	tcp.ListenAndServe(cfg, sentinel.MakeHandler(s))
+}
@@ -10,17 +10,65 @@ import (
 )
 
 type PropertyHolder struct {
-	Bind           string   `cfg:"bind"`
-	Port           int      `cfg:"port"`
-	AppendOnly     bool     `cfg:"appendOnly"`
-	AppendFilename string   `cfg:"appendFilename"`
-	MaxClients     int      `cfg:"maxClients"`
-	Peers          []string `cfg:"peers"`
-	Self           string   `cfg:"self"`
+	Bind              string   `cfg:"bind"`
+	Port              int      `cfg:"port"`
+	AppendOnly        bool     `cfg:"appendOnly"`
+	AppendFilename    string   `cfg:"appendFilename"`
+	AppendFsync       string   `cfg:"appendfsync"`          // always, everysec(默认) 或 no
+	AofUseRdbPreamble bool     `cfg:"aof-use-rdb-preamble"` // 重写 aof 时以 rdb 快照作为文件开头
+	AofLoadTruncated  bool     `cfg:"aof-load-truncated"`   // 启动时截断 aof 文件末尾不完整的命令, 默认为 yes
+	MaxClients        int      `cfg:"maxClients"`
+	Peers             []string `cfg:"peers"`
+	Self              string   `cfg:"self"`
+	ClusterRedirect   bool     `cfg:"cluster-redirect"` // 集群模式下返回 MOVED 重定向而不是代为转发
+	Databases         int      `cfg:"databases"`
+	DBFilename        string   `cfg:"dbfilename"`
+	Save              string   `cfg:"save"`              // save <seconds> <changes> [<seconds> <changes> ...]
+	ReplicaOf         string   `cfg:"replicaof"`         // replicaof <host> <port>, 启动后作为副本同步该主节点
+	ReplBacklogSize   int      `cfg:"repl-backlog-size"` // 复制积压缓冲区大小, 单位字节
+
+	// quicklist 每个节点的大小, 正数为元素数量, -1 到 -5 表示 4KB 到 64KB, 默认为 -2
+	ListMaxListpackSize int `cfg:"list-max-listpack-size"`
+	// 小集合和小哈希表使用紧凑编码的上限, 超过后转换为哈希表, 为 0 时使用默认值
+	SetMaxIntsetEntries    int `cfg:"set-max-intset-entries"`    // 默认为 512
+	SetMaxListpackEntries  int `cfg:"set-max-listpack-entries"`  // 默认为 128
+	SetMaxListpackValue    int `cfg:"set-max-listpack-value"`    // 默认为 64
+	HashMaxListpackEntries int `cfg:"hash-max-listpack-entries"` // 默认为 128
+	HashMaxListpackValue   int `cfg:"hash-max-listpack-value"`   // 默认为 64
+
+	// 内存上限, 可以使用 kb, mb, gb 等单位, 为 0 时不限制
+	MaxMemory int `cfg:"maxmemory"`
+	// 超过内存上限时的淘汰策略: noeviction(默认), allkeys-lru, allkeys-lfu, allkeys-random,
+	// volatile-lru, volatile-lfu, volatile-random, volatile-ttl
+	MaxMemoryPolicy  string `cfg:"maxmemory-policy"`
+	MaxMemorySamples int    `cfg:"maxmemory-samples"` // 每次淘汰时采样的 key 数量, 默认为 5
+
+	// 键空间通知的类型, 与 Redis 相同由 K, E, g, $, l, s, h, z, x, e, n, A 等字符组成, 为空时不发送通知
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
+
+	// 集群总线
+	ClusterPort        int `cfg:"cluster-port"`         // 默认为 port + 10000
+	ClusterNodeTimeout int `cfg:"cluster-node-timeout"` // 节点超过该时间没有回复则认为下线, 单位毫秒
+
+	// 集群事务的恢复日志, 协调者重启后据此完成尚未结束的跨节点事务, 为空时不记录
+	ClusterTxLogFilename string `cfg:"cluster-txlog-filename"`
+
+	// 哨兵模式
+	SentinelMonitor         string   `cfg:"sentinel-monitor"`                 // <master-name> <host> <port> <quorum>
+	SentinelDownAfter       int      `cfg:"sentinel-down-after-milliseconds"` // 超过该时间没有回复则认为主观下线
+	SentinelFailoverTimeout int      `cfg:"sentinel-failover-timeout"`        // 单位毫秒
+	SentinelPeers           []string `cfg:"sentinel-peers"`                   // 其它哨兵的地址, 以逗号分隔
 }
 
 var Properties *PropertyHolder
 
+// defaultProperties 配置文件中没有出现的项使用的默认值, 与 Redis 相同
+func defaultProperties() *PropertyHolder {
+	return &PropertyHolder{
+		AofLoadTruncated: true,
+	}
+}
+
 func LoadConfig(configFilename string) *PropertyHolder {
 	// open config file
 	file, err := os.Open(configFilename)
@@ -49,7 +97,7 @@ func LoadConfig(configFilename string) *PropertyHolder {
 	}
 
 	// parse config
-	config := &PropertyHolder{}
+	config := defaultProperties()
 	t := reflect.TypeOf(config)
 	v := reflect.ValueOf(config)
 	n := t.Elem().NumField()
@@ -66,7 +114,7 @@ func LoadConfig(configFilename string) *PropertyHolder {
 			case reflect.String:
 				fieldVal.SetString(value)
 			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
+				intValue, err := ParseMemory(value)
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
@@ -84,6 +132,30 @@ func LoadConfig(configFilename string) *PropertyHolder {
 	return config
 }
 
+var memoryUnits = []struct {
+	suffix string
+	unit   int64
+}{
+	{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory 解析整数, 与 Redis 相同支持 1k, 1kb, 1mb, 1gb 等单位 (k 为 1000, kb 为 1024)
+func ParseMemory(raw string) (int64, error) {
+	lower := strings.ToLower(raw)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			n, err := strconv.ParseInt(lower[:len(lower)-len(u.suffix)], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * u.unit, nil
+		}
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
 func SetupConfig(configFilename string) {
 	Properties = LoadConfig(configFilename)
 }
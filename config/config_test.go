@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadConfigString(t *testing.T, content string) *PropertyHolder {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(filename)
+}
+
+func TestAofLoadTruncatedDefault(t *testing.T) {
+	if !loadConfigString(t, "port 6399\n").AofLoadTruncated {
+		t.Error("aof-load-truncated should default to yes")
+	}
+	if loadConfigString(t, "aof-load-truncated no\n").AofLoadTruncated {
+		t.Error("aof-load-truncated no should be respected")
+	}
+	if !loadConfigString(t, "aof-load-truncated yes\n").AofLoadTruncated {
+		t.Error("aof-load-truncated yes should be respected")
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	cases := map[string]int64{"100": 100, "1k": 1000, "1kb": 1024, "2mb": 2 << 20, "1gb": 1 << 30, "10b": 10}
+	for raw, expected := range cases {
+		if n, err := ParseMemory(raw); err != nil || n != expected {
+			t.Errorf("%s: expected %d, got %d, %v", raw, expected, n, err)
+		}
+	}
+}
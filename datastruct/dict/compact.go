@@ -0,0 +1,204 @@
+package dict
+
+import (
+	"math/rand"
+	"redisGo/datastruct/listpack"
+	"redisGo/interface/dict"
+)
+
+// CompactDict 元素较少时将 field 和 value 交替保存在 listpack 中, 超过上限或 value 不是 []byte 时转换为 SimpleDict.
+// 不是并发安全的
+type CompactDict struct {
+	lp *listpack.Listpack
+	m  *SimpleDict
+	// maxEntries 对应 hash-max-listpack-entries
+	maxEntries int
+	// maxValue 对应 hash-max-listpack-value, field 和 value 的最大长度
+	maxValue int
+}
+
+const (
+	defaultMaxListpackEntries = 128
+	defaultMaxListpackValue   = 64
+)
+
+// MakeCompact 参数为 0 时使用默认值
+func MakeCompact(maxEntries int, maxValue int) *CompactDict {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxListpackEntries
+	}
+	if maxValue <= 0 {
+		maxValue = defaultMaxListpackValue
+	}
+	return &CompactDict{
+		lp:         &listpack.Listpack{},
+		maxEntries: maxEntries,
+		maxValue:   maxValue,
+	}
+}
+
+// Encoding 返回 OBJECT ENCODING 中的编码名称
+func (d *CompactDict) Encoding() string {
+	if d.lp != nil {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// find 返回 field 对应的 value 的位置, 不存在时 valueOffset 为 -1
+func (d *CompactDict) find(key string) (fieldOffset int, valueOffset int, end int) {
+	offset := 0
+	for i := 0; i < d.lp.Len(); i += 2 {
+		field, next := d.lp.Next(offset)
+		_, valueEnd := d.lp.Next(next)
+		if string(field) == key {
+			return offset, next, valueEnd
+		}
+		offset = valueEnd
+	}
+	return -1, -1, -1
+}
+
+// fits 能否继续使用 listpack 保存
+func (d *CompactDict) fits(key string, val interface{}) ([]byte, bool) {
+	bytes, ok := val.([]byte)
+	return bytes, ok && len(key) <= d.maxValue && len(bytes) <= d.maxValue
+}
+
+func (d *CompactDict) toSimple() {
+	m := MakeSimple()
+	d.ForEach(func(key string, val interface{}) bool {
+		m.Put(key, val)
+		return true
+	})
+	d.lp = nil
+	d.m = m
+}
+
+func (d *CompactDict) Get(key string) (val interface{}, exists bool) {
+	if d.lp == nil {
+		return d.m.Get(key)
+	}
+	_, valueOffset, _ := d.find(key)
+	if valueOffset < 0 {
+		return nil, false
+	}
+	value, _ := d.lp.Next(valueOffset)
+	return listpack.CopyBytes(value), true
+}
+
+func (d *CompactDict) Len() int {
+	if d.lp == nil {
+		return d.m.Len()
+	}
+	return d.lp.Len() / 2
+}
+
+func (d *CompactDict) Put(key string, val interface{}) int {
+	if d.lp != nil {
+		bytes, ok := d.fits(key, val)
+		_, valueOffset, end := d.find(key)
+		switch {
+		case ok && valueOffset >= 0:
+			d.lp.RemoveAt(valueOffset, end)
+			d.lp.InsertAt(valueOffset, bytes)
+			return 0
+		case ok && d.Len() < d.maxEntries:
+			d.lp.InsertAt(d.lp.Size(), []byte(key))
+			d.lp.InsertAt(d.lp.Size(), bytes)
+			return 1
+		}
+		d.toSimple()
+	}
+	return d.m.Put(key, val)
+}
+
+func (d *CompactDict) PutIfExists(key string, val interface{}) int {
+	if _, exists := d.Get(key); !exists {
+		return 0
+	}
+	d.Put(key, val)
+	return 1
+}
+
+func (d *CompactDict) PutIfAbsent(key string, val interface{}) int {
+	if _, exists := d.Get(key); exists {
+		return 0
+	}
+	return d.Put(key, val)
+}
+
+func (d *CompactDict) Remove(key string) int {
+	if d.lp == nil {
+		return d.m.Remove(key)
+	}
+	fieldOffset, valueOffset, end := d.find(key)
+	if fieldOffset < 0 {
+		return 0
+	}
+	d.lp.RemoveAt(valueOffset, end)
+	d.lp.RemoveAt(fieldOffset, valueOffset)
+	return 1
+}
+
+func (d *CompactDict) ForEach(consumer dict.Consumer) {
+	if d.lp == nil {
+		d.m.ForEach(consumer)
+		return
+	}
+	offset := 0
+	for i := 0; i < d.lp.Len(); i += 2 {
+		var field, value []byte
+		field, offset = d.lp.Next(offset)
+		value, offset = d.lp.Next(offset)
+		if !consumer(string(field), listpack.CopyBytes(value)) {
+			return
+		}
+	}
+}
+
+func (d *CompactDict) Keys() []string {
+	if d.lp == nil {
+		return d.m.Keys()
+	}
+	keys := make([]string, 0, d.Len())
+	d.ForEach(func(key string, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (d *CompactDict) RandomKeys(n int) []string {
+	if d.lp == nil {
+		return d.m.RandomKeys(n)
+	}
+	keys := d.Keys()
+	if len(keys) == 0 {
+		return nil
+	}
+	result := make([]string, n)
+	for i := range result {
+		result[i] = keys[rand.Intn(len(keys))]
+	}
+	return result
+}
+
+func (d *CompactDict) RandomDistinctKeys(n int) []string {
+	if d.lp == nil {
+		return d.m.RandomDistinctKeys(n)
+	}
+	keys := d.Keys()
+	if n > len(keys) {
+		n = len(keys)
+	}
+	rand.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	return keys[:n]
+}
+
+// ScanKeys 与 SimpleDict 相同, 一次返回所有的 key
+func (d *CompactDict) ScanKeys(cursor int, count int) ([]string, int) {
+	return d.Keys(), 0
+}
@@ -0,0 +1,65 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCompactDict(t *testing.T) {
+	d := MakeCompact(3, 5)
+	if d.Put("a", []byte("1")) != 1 || d.Put("b", []byte("2")) != 1 || d.Put("a", []byte("3")) != 0 {
+		t.Fatal("unexpected put result")
+	}
+	if val, ok := d.Get("a"); !ok || string(val.([]byte)) != "3" {
+		t.Errorf("expected 3, got %v", val)
+	}
+	if d.PutIfAbsent("a", []byte("x")) != 0 || d.PutIfExists("c", []byte("x")) != 0 {
+		t.Error("unexpected conditional put result")
+	}
+	if d.Remove("b") != 1 || d.Remove("b") != 0 || d.Len() != 1 {
+		t.Error("unexpected remove result")
+	}
+	if d.Encoding() != "listpack" {
+		t.Errorf("expected listpack, got %s", d.Encoding())
+	}
+
+	// value 过长时转换为哈希表
+	d.Put("b", []byte("toolong"))
+	if d.Encoding() != "hashtable" || d.Len() != 2 {
+		t.Errorf("expected hashtable with 2 fields, got %s with %d", d.Encoding(), d.Len())
+	}
+	if val, _ := d.Get("a"); string(val.([]byte)) != "3" {
+		t.Errorf("lost field after conversion: %v", val)
+	}
+
+	// 元素数量超过上限时转换为哈希表
+	d = MakeCompact(3, 5)
+	for i := 0; i < 3; i++ {
+		d.Put(strconv.Itoa(i), []byte("v"))
+	}
+	if d.Encoding() != "listpack" {
+		t.Errorf("expected listpack, got %s", d.Encoding())
+	}
+	d.Put("3", []byte("v"))
+	if d.Encoding() != "hashtable" || d.Len() != 4 {
+		t.Errorf("expected hashtable with 4 fields, got %s with %d", d.Encoding(), d.Len())
+	}
+}
+
+func TestCompactDictForEach(t *testing.T) {
+	d := MakeCompact(0, 0)
+	for i := 0; i < 10; i++ {
+		d.Put("f"+strconv.Itoa(i), []byte(strconv.Itoa(i)))
+	}
+	seen := 0
+	d.ForEach(func(key string, val interface{}) bool {
+		if key != "f"+string(val.([]byte)) {
+			t.Errorf("field %s has value %s", key, val)
+		}
+		seen++
+		return true
+	})
+	if seen != 10 || len(d.Keys()) != 10 || len(d.RandomDistinctKeys(20)) != 10 || len(d.RandomKeys(20)) != 20 {
+		t.Error("unexpected iteration result")
+	}
+}
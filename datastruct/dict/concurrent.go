@@ -82,10 +82,10 @@ func (d *ConcurrentDict) Put(key string, val interface{}) int {
 	defer shard.mutex.Unlock()
 
 	_, exists := shard.m[key]
+	shard.m[key] = val
 	if exists {
 		return 0
 	} else {
-		shard.m[key] = val
 		atomic.AddInt32(&d.count, 1)
 		return 1
 	}
@@ -101,6 +101,7 @@ func (d *ConcurrentDict) PutIfAbsent(key string, val interface{}) int {
 		return 0
 	} else {
 		shard.m[key] = val
+		atomic.AddInt32(&d.count, 1)
 		return 1
 	}
 }
@@ -168,6 +169,25 @@ func (d *ConcurrentDict) Keys() []string {
 	return keys
 }
 
+// ScanKeys 以分片下标作为游标, 每次返回若干完整的分片,
+// 遍历期间一直存在的 key 一定会被返回, 不会因为其它 key 的插入或删除而遗漏
+func (d *ConcurrentDict) ScanKeys(cursor int, count int) ([]string, int) {
+	keys := make([]string, 0, count)
+	for cursor < len(d.table) && len(keys) < count {
+		shard := d.table[cursor]
+		shard.mutex.RLock()
+		for key := range shard.m {
+			keys = append(keys, key)
+		}
+		shard.mutex.RUnlock()
+		cursor++
+	}
+	if cursor >= len(d.table) {
+		cursor = 0
+	}
+	return keys, cursor
+}
+
 func (shard *Shard) RandomKey() string {
 	if shard == nil {
 		panic("shard is nil")
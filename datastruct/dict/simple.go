@@ -104,3 +104,8 @@ func (d *SimpleDict) RandomDistinctKeys(n int) []string {
 	}
 	return result
 }
+
+// ScanKeys SimpleDict 不是并发安全的, 一次返回所有的 key
+func (d *SimpleDict) ScanKeys(cursor int, count int) ([]string, int) {
+	return d.Keys(), 0
+}
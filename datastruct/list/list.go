@@ -90,6 +90,56 @@ func (list *LinkedList) Insert(index int, val interface{}) {
 	}
 }
 
+// findVal 返回第一个与 val 相等的节点
+func (list *LinkedList) findVal(val interface{}) *node {
+	for n := list.first; n != nil; n = n.next {
+		if utils.Equals(n.val, val) {
+			return n
+		}
+	}
+	return nil
+}
+
+// InsertBefore 在第一个与 pivot 相等的元素之前插入 val, 没有找到 pivot 时返回 false
+func (list *LinkedList) InsertBefore(pivot interface{}, val interface{}) bool {
+	if list == nil {
+		panic("list is nil")
+	}
+	p := list.findVal(pivot)
+	if p == nil {
+		return false
+	}
+	n := &node{val: val, prev: p.prev, next: p}
+	if p.prev == nil {
+		list.first = n
+	} else {
+		p.prev.next = n
+	}
+	p.prev = n
+	list.size++
+	return true
+}
+
+// InsertAfter 在第一个与 pivot 相等的元素之后插入 val, 没有找到 pivot 时返回 false
+func (list *LinkedList) InsertAfter(pivot interface{}, val interface{}) bool {
+	if list == nil {
+		panic("list is nil")
+	}
+	p := list.findVal(pivot)
+	if p == nil {
+		return false
+	}
+	n := &node{val: val, prev: p, next: p.next}
+	if p.next == nil {
+		list.last = n
+	} else {
+		p.next.prev = n
+	}
+	p.next = n
+	list.size++
+	return true
+}
+
 func (list *LinkedList) removeNode(n *node) {
 	if n.prev == nil {
 		list.first = n.next
@@ -175,7 +225,9 @@ func (list *LinkedList) RemoveByVal(val interface{}, count int) int {
 	}
 	n := list.first
 	c := 0
-	for i := 0; i < list.size; i++ {
+	for n != nil {
+		// removeNode 会清空 n.next, 需要先保存
+		next := n.next
 		if utils.Equals(n.val, val) {
 			list.removeNode(n)
 			c++
@@ -183,7 +235,7 @@ func (list *LinkedList) RemoveByVal(val interface{}, count int) int {
 				break
 			}
 		}
-		n = n.next
+		n = next
 	}
 	return c
 }
@@ -197,7 +249,8 @@ func (list *LinkedList) ReverseRemoveByVal(val interface{}, count int) int {
 	}
 	n := list.last
 	c := 0
-	for i := list.size - 1; i >= 0; i-- {
+	for n != nil {
+		prev := n.prev
 		if utils.Equals(n.val, val) {
 			list.removeNode(n)
 			c++
@@ -205,11 +258,65 @@ func (list *LinkedList) ReverseRemoveByVal(val interface{}, count int) int {
 				break
 			}
 		}
-		n = n.prev
+		n = prev
 	}
 	return c
 }
 
+// Trim 只保留下标在 [start, stop) 中的元素
+func (list *LinkedList) Trim(start int, stop int) {
+	if list == nil {
+		panic("list is nil")
+	}
+	if start < 0 || stop > list.size || stop < start {
+		panic("index out of bounds")
+	}
+	if start == stop {
+		list.first = nil
+		list.last = nil
+		list.size = 0
+		return
+	}
+	first := list.find(start)
+	last := list.find(stop - 1)
+	first.prev = nil
+	last.next = nil
+	list.first = first
+	list.last = last
+	list.size = stop - start
+}
+
+// Search 从表头 (reverse 为 true 时从表尾) 开始查找与 val 相等的元素, 对每个匹配的下标调用 consumer, consumer 返回 false 时停止.
+// maxLen 大于 0 时最多比较 maxLen 个元素
+func (list *LinkedList) Search(val interface{}, reverse bool, maxLen int, consumer func(index int) bool) {
+	if list == nil {
+		panic("list is nil")
+	}
+	if maxLen <= 0 || maxLen > list.size {
+		maxLen = list.size
+	}
+	n := list.first
+	if reverse {
+		n = list.last
+	}
+	for i := 0; i < maxLen; i++ {
+		if utils.Equals(n.val, val) {
+			index := i
+			if reverse {
+				index = list.size - 1 - i
+			}
+			if !consumer(index) {
+				return
+			}
+		}
+		if reverse {
+			n = n.prev
+		} else {
+			n = n.next
+		}
+	}
+}
+
 func (list *LinkedList) Len() int {
 	if list == nil {
 		panic("list is nil")
@@ -0,0 +1,89 @@
+package list
+
+import (
+	"testing"
+)
+
+func toSlice(list *LinkedList) []interface{} {
+	vals := make([]interface{}, 0, list.Len())
+	list.ForEach(func(_ int, v interface{}) bool {
+		vals = append(vals, v)
+		return true
+	})
+	return vals
+}
+
+func assertList(t *testing.T, list *LinkedList, expected ...interface{}) {
+	actual := toSlice(list)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, actual)
+		}
+	}
+	// 反向遍历检查 prev 指针
+	n := list.last
+	for i := len(expected) - 1; i >= 0; i-- {
+		if n == nil || n.val != expected[i] {
+			t.Fatalf("broken prev links, expected %v", expected)
+		}
+		n = n.prev
+	}
+	if n != nil {
+		t.Fatalf("broken prev links, expected %v", expected)
+	}
+}
+
+func TestInsertRelative(t *testing.T) {
+	list := Make(1, 2, 3)
+	if !list.InsertBefore(1, 0) || !list.InsertAfter(3, 4) || !list.InsertAfter(1, 5) || !list.InsertBefore(3, 6) {
+		t.Fatal("pivot should be found")
+	}
+	assertList(t, list, 0, 1, 5, 2, 6, 3, 4)
+	if list.InsertBefore(7, 8) || list.InsertAfter(7, 8) {
+		t.Fatal("pivot should not be found")
+	}
+	if list.Len() != 7 {
+		t.Fatalf("unexpected size %d", list.Len())
+	}
+}
+
+func TestTrim(t *testing.T) {
+	list := Make(0, 1, 2, 3, 4)
+	list.Trim(1, 4)
+	assertList(t, list, 1, 2, 3)
+	list.Trim(0, 3)
+	assertList(t, list, 1, 2, 3)
+	list.Trim(2, 3)
+	assertList(t, list, 3)
+	list.Trim(0, 0)
+	assertList(t, list)
+	list.Add(5)
+	assertList(t, list, 5)
+}
+
+func TestSearch(t *testing.T) {
+	list := Make(1, 2, 1, 3, 1)
+	search := func(reverse bool, maxLen int, limit int) []int {
+		indexes := make([]int, 0)
+		list.Search(1, reverse, maxLen, func(index int) bool {
+			indexes = append(indexes, index)
+			return len(indexes) < limit
+		})
+		return indexes
+	}
+	if r := search(false, 0, 10); len(r) != 3 || r[0] != 0 || r[1] != 2 || r[2] != 4 {
+		t.Errorf("unexpected indexes: %v", r)
+	}
+	if r := search(true, 0, 2); len(r) != 2 || r[0] != 4 || r[1] != 2 {
+		t.Errorf("unexpected indexes: %v", r)
+	}
+	if r := search(false, 2, 10); len(r) != 1 || r[0] != 0 {
+		t.Errorf("unexpected indexes: %v", r)
+	}
+	if r := search(true, 1, 10); len(r) != 1 || r[0] != 4 {
+		t.Errorf("unexpected indexes: %v", r)
+	}
+}
@@ -0,0 +1,388 @@
+package list
+
+import (
+	"bytes"
+	"redisGo/datastruct/listpack"
+)
+
+// DefaultFill list-max-listpack-size 的默认值, 每个节点最多 8KB
+const DefaultFill = -2
+
+// QuickList 由多个 listpack 节点组成的双向链表, 只能保存 []byte.
+// 与 LinkedList 相比每个元素不需要单独分配节点, 按下标访问时只需逐个跳过节点
+type QuickList struct {
+	first *quickNode
+	last  *quickNode
+	size  int
+	// fill 为正数时是每个节点的最大元素数量, -1 到 -5 表示每个节点最多 4KB, 8KB, 16KB, 32KB, 64KB
+	fill int
+}
+
+type quickNode struct {
+	lp   listpack.Listpack
+	prev *quickNode
+	next *quickNode
+}
+
+// MakeQuickList fill 为 list-max-listpack-size, 为 0 时使用默认值
+func MakeQuickList(fill int) *QuickList {
+	if fill == 0 {
+		fill = DefaultFill
+	} else if fill < -5 {
+		fill = -5
+	}
+	return &QuickList{fill: fill}
+}
+
+func toBytes(val interface{}) []byte {
+	b, ok := val.([]byte)
+	if !ok {
+		panic("quicklist only supports []byte")
+	}
+	return b
+}
+
+// hasRoom 节点能否再放入编码后长度为 size 的元素, 空节点总是可以放入一个元素
+func (ql *QuickList) hasRoom(n *quickNode, size int) bool {
+	if n.lp.Len() == 0 {
+		return true
+	}
+	if ql.fill > 0 {
+		return n.lp.Len() < ql.fill
+	}
+	return n.lp.Size()+size <= 4096<<(-ql.fill-1)
+}
+
+// insertNodeAfter 在 prev 之后插入空节点, prev 为 nil 时插入到表头
+func (ql *QuickList) insertNodeAfter(prev *quickNode) *quickNode {
+	n := &quickNode{prev: prev}
+	if prev == nil {
+		n.next = ql.first
+		ql.first = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		ql.last = n
+	} else {
+		n.next.prev = n
+	}
+	return n
+}
+
+func (ql *QuickList) removeNode(n *quickNode) {
+	if n.prev == nil {
+		ql.first = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		ql.last = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev = nil
+	n.next = nil
+}
+
+// find 返回第 index 个元素所在的节点以及在节点中的下标
+func (ql *QuickList) find(index int) (*quickNode, int) {
+	if index < ql.size/2 {
+		n := ql.first
+		for index >= n.lp.Len() {
+			index -= n.lp.Len()
+			n = n.next
+		}
+		return n, index
+	}
+	// 从表尾开始数的下标
+	index = ql.size - 1 - index
+	n := ql.last
+	for index >= n.lp.Len() {
+		index -= n.lp.Len()
+		n = n.prev
+	}
+	return n, n.lp.Len() - 1 - index
+}
+
+// insertAt 在节点 n 的第 i 个元素之前插入, i 可以等于节点的元素数量
+func (ql *QuickList) insertAt(n *quickNode, i int, val []byte) {
+	size := listpack.EntrySize(val)
+	switch {
+	case ql.hasRoom(n, size):
+		n.lp.Insert(i, val)
+	case i == 0 && n.prev != nil && ql.hasRoom(n.prev, size):
+		n.prev.lp.Insert(n.prev.lp.Len(), val)
+	case i == n.lp.Len() && n.next != nil && ql.hasRoom(n.next, size):
+		n.next.lp.Insert(0, val)
+	default:
+		// 节点已满, 在 i 处拆分
+		right := ql.insertNodeAfter(n)
+		right.lp = n.lp.Split(i)
+		if ql.hasRoom(n, size) {
+			n.lp.Insert(i, val)
+		} else {
+			right.lp.Insert(0, val)
+		}
+	}
+	ql.size++
+}
+
+func (ql *QuickList) Add(val interface{}) {
+	b := toBytes(val)
+	if ql.last == nil || !ql.hasRoom(ql.last, listpack.EntrySize(b)) {
+		ql.insertNodeAfter(ql.last)
+	}
+	ql.last.lp.Insert(ql.last.lp.Len(), b)
+	ql.size++
+}
+
+func (ql *QuickList) Get(index int) (val interface{}) {
+	if index < 0 || index >= ql.size {
+		panic("index out of bounds")
+	}
+	n, i := ql.find(index)
+	return n.lp.Get(i)
+}
+
+func (ql *QuickList) Set(index int, val interface{}) {
+	if index < 0 || index >= ql.size {
+		panic("index out of bounds")
+	}
+	n, i := ql.find(index)
+	n.lp.Set(i, toBytes(val))
+}
+
+func (ql *QuickList) Insert(index int, val interface{}) {
+	if index < 0 || index > ql.size {
+		panic("index out of bounds")
+	}
+	if index == ql.size {
+		ql.Add(val)
+		return
+	}
+	n, i := ql.find(index)
+	ql.insertAt(n, i, toBytes(val))
+}
+
+// findVal 返回第一个与 val 相等的元素所在的节点以及在节点中的下标
+func (ql *QuickList) findVal(val []byte) (*quickNode, int) {
+	for n := ql.first; n != nil; n = n.next {
+		offset := 0
+		for i := 0; i < n.lp.Len(); i++ {
+			var entry []byte
+			entry, offset = n.lp.Next(offset)
+			if bytes.Equal(entry, val) {
+				return n, i
+			}
+		}
+	}
+	return nil, 0
+}
+
+func (ql *QuickList) InsertBefore(pivot interface{}, val interface{}) bool {
+	n, i := ql.findVal(toBytes(pivot))
+	if n == nil {
+		return false
+	}
+	ql.insertAt(n, i, toBytes(val))
+	return true
+}
+
+func (ql *QuickList) InsertAfter(pivot interface{}, val interface{}) bool {
+	n, i := ql.findVal(toBytes(pivot))
+	if n == nil {
+		return false
+	}
+	ql.insertAt(n, i+1, toBytes(val))
+	return true
+}
+
+func (ql *QuickList) Remove(index int) (val interface{}) {
+	if index < 0 || index >= ql.size {
+		panic("index out of bounds")
+	}
+	n, i := ql.find(index)
+	v := n.lp.Remove(i)
+	if n.lp.Len() == 0 {
+		ql.removeNode(n)
+	}
+	ql.size--
+	return v
+}
+
+func (ql *QuickList) RemoveLast() (val interface{}) {
+	if ql.size == 0 {
+		return nil
+	}
+	return ql.Remove(ql.size - 1)
+}
+
+// removeByVal 从表头 (reverse 为 true 时从表尾) 开始删除最多 count 个与 val 相等的元素, count 小于等于 0 时全部删除
+func (ql *QuickList) removeByVal(val []byte, count int, reverse bool) int {
+	removed := 0
+	n := ql.first
+	if reverse {
+		n = ql.last
+	}
+	for n != nil && (count <= 0 || removed < count) {
+		next := n.next
+		if reverse {
+			next = n.prev
+			// 从后向前删除不影响前面元素的位置
+			offsets := n.lp.Offsets()
+			for i := len(offsets) - 1; i >= 0 && (count <= 0 || removed < count); i-- {
+				entry, end := n.lp.Next(offsets[i])
+				if bytes.Equal(entry, val) {
+					n.lp.RemoveAt(offsets[i], end)
+					removed++
+				}
+			}
+		} else {
+			offset := 0
+			for i, total := 0, n.lp.Len(); i < total && (count <= 0 || removed < count); i++ {
+				entry, end := n.lp.Next(offset)
+				if bytes.Equal(entry, val) {
+					n.lp.RemoveAt(offset, end)
+					removed++
+				} else {
+					offset = end
+				}
+			}
+		}
+		if n.lp.Len() == 0 {
+			ql.removeNode(n)
+		}
+		n = next
+	}
+	ql.size -= removed
+	return removed
+}
+
+func (ql *QuickList) RemoveAllByVal(val interface{}) int {
+	return ql.removeByVal(toBytes(val), 0, false)
+}
+
+func (ql *QuickList) RemoveByVal(val interface{}, count int) int {
+	if count <= 0 {
+		return 0
+	}
+	return ql.removeByVal(toBytes(val), count, false)
+}
+
+func (ql *QuickList) ReverseRemoveByVal(val interface{}, count int) int {
+	if count <= 0 {
+		return 0
+	}
+	return ql.removeByVal(toBytes(val), count, true)
+}
+
+// Trim 只保留下标在 [start, stop) 中的元素, 整个节点被删除时不需要逐个删除元素
+func (ql *QuickList) Trim(start int, stop int) {
+	if start < 0 || stop > ql.size || stop < start {
+		panic("index out of bounds")
+	}
+	for drop := ql.size - stop; drop > 0; {
+		n := ql.last
+		if n.lp.Len() <= drop {
+			drop -= n.lp.Len()
+			ql.removeNode(n)
+		} else {
+			n.lp.Truncate(n.lp.Len() - drop)
+			drop = 0
+		}
+	}
+	for drop := start; drop > 0; {
+		n := ql.first
+		if n.lp.Len() <= drop {
+			drop -= n.lp.Len()
+			ql.removeNode(n)
+		} else {
+			n.lp.DropHead(drop)
+			drop = 0
+		}
+	}
+	ql.size = stop - start
+}
+
+func (ql *QuickList) Search(val interface{}, reverse bool, maxLen int, consumer func(index int) bool) {
+	target := toBytes(val)
+	if maxLen <= 0 || maxLen > ql.size {
+		maxLen = ql.size
+	}
+	compared := 0
+	if !reverse {
+		for n := ql.first; n != nil && compared < maxLen; n = n.next {
+			offset := 0
+			for i := 0; i < n.lp.Len() && compared < maxLen; i++ {
+				var entry []byte
+				entry, offset = n.lp.Next(offset)
+				if bytes.Equal(entry, target) && !consumer(compared) {
+					return
+				}
+				compared++
+			}
+		}
+		return
+	}
+	for n := ql.last; n != nil && compared < maxLen; n = n.prev {
+		offsets := n.lp.Offsets()
+		for i := len(offsets) - 1; i >= 0 && compared < maxLen; i-- {
+			entry, _ := n.lp.Next(offsets[i])
+			if bytes.Equal(entry, target) && !consumer(ql.size-1-compared) {
+				return
+			}
+			compared++
+		}
+	}
+}
+
+func (ql *QuickList) Len() int {
+	return ql.size
+}
+
+// ForEach consumer 收到的是元素的副本
+func (ql *QuickList) ForEach(consumer func(int, interface{}) bool) {
+	index := 0
+	for n := ql.first; n != nil; n = n.next {
+		offset := 0
+		for i := 0; i < n.lp.Len(); i++ {
+			var entry []byte
+			entry, offset = n.lp.Next(offset)
+			if !consumer(index, listpack.CopyBytes(entry)) {
+				return
+			}
+			index++
+		}
+	}
+}
+
+func (ql *QuickList) Contains(val interface{}) bool {
+	contains := false
+	ql.Search(val, false, 0, func(int) bool {
+		contains = true
+		return false
+	})
+	return contains
+}
+
+func (ql *QuickList) Range(start int, stop int) []interface{} {
+	if start < 0 || start >= ql.size || stop < start || stop > ql.size {
+		panic("index out of bounds")
+	}
+	vals := make([]interface{}, 0, stop-start)
+	n, i := ql.find(start)
+	offset := n.lp.Offset(i)
+	for len(vals) < stop-start {
+		if i == n.lp.Len() {
+			n, i, offset = n.next, 0, 0
+			continue
+		}
+		var entry []byte
+		entry, offset = n.lp.Next(offset)
+		vals = append(vals, listpack.CopyBytes(entry))
+		i++
+	}
+	return vals
+}
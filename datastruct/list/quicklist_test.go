@@ -0,0 +1,204 @@
+package list
+
+import (
+	"bytes"
+	"math/rand"
+	"redisGo/interface/list"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func assertSameList(t *testing.T, expected list.List, actual list.List) {
+	t.Helper()
+	if expected.Len() != actual.Len() {
+		t.Fatalf("expected size %d, got %d", expected.Len(), actual.Len())
+	}
+	if expected.Len() == 0 {
+		return
+	}
+	vals := actual.Range(0, actual.Len())
+	expected.ForEach(func(i int, v interface{}) bool {
+		if !bytes.Equal(v.([]byte), vals[i].([]byte)) {
+			t.Fatalf("index %d: expected %s, got %s", i, v, vals[i])
+		}
+		if !bytes.Equal(v.([]byte), actual.Get(i).([]byte)) {
+			t.Fatalf("get %d: expected %s, got %s", i, v, actual.Get(i))
+		}
+		return true
+	})
+}
+
+// 随机操作与 LinkedList 的结果比较, 节点很小以便覆盖节点的拆分和删除
+func TestQuickListRandom(t *testing.T) {
+	for _, fill := range []int{1, 3, -1} {
+		r := rand.New(rand.NewSource(int64(fill)))
+		var expected list.List = Make()
+		var actual list.List = MakeQuickList(fill)
+		randVal := func() []byte {
+			// fill 为 -1 时使用较长的元素, 使节点按字节数拆分
+			n := r.Intn(5)
+			if fill < 0 {
+				n = r.Intn(2000)
+			}
+			return append(bytes.Repeat([]byte{'x'}, n), byte('a'+r.Intn(3)))
+		}
+		for i := 0; i < 3000; i++ {
+			size := expected.Len()
+			switch op := r.Intn(12); {
+			case op < 3 || size == 0:
+				v := randVal()
+				expected.Add(v)
+				actual.Add(v)
+			case op == 3:
+				index := r.Intn(size + 1)
+				v := randVal()
+				expected.Insert(index, v)
+				actual.Insert(index, v)
+			case op == 4:
+				index := r.Intn(size)
+				v := randVal()
+				expected.Set(index, v)
+				actual.Set(index, v)
+			case op == 5:
+				index := r.Intn(size)
+				if !bytes.Equal(expected.Remove(index).([]byte), actual.Remove(index).([]byte)) {
+					t.Fatalf("remove %d returned different values", index)
+				}
+			case op == 6:
+				if !bytes.Equal(expected.RemoveLast().([]byte), actual.RemoveLast().([]byte)) {
+					t.Fatal("remove last returned different values")
+				}
+			case op == 7:
+				v := randVal()
+				count := r.Intn(3)
+				if expected.RemoveByVal(v, count) != actual.RemoveByVal(v, count) ||
+					expected.ReverseRemoveByVal(v, count) != actual.ReverseRemoveByVal(v, count) {
+					t.Fatal("remove by val returned different counts")
+				}
+			case op == 8:
+				pivot := expected.Get(r.Intn(size))
+				v := randVal()
+				if r.Intn(2) == 0 {
+					expected.InsertBefore(pivot, v)
+					actual.InsertBefore(pivot, v)
+				} else {
+					expected.InsertAfter(pivot, v)
+					actual.InsertAfter(pivot, v)
+				}
+			case op == 9 && r.Intn(10) == 0:
+				start := r.Intn(size)
+				stop := start + r.Intn(size-start+1)
+				expected.Trim(start, stop)
+				actual.Trim(start, stop)
+			case op == 10:
+				v := randVal()
+				reverse := r.Intn(2) == 0
+				maxLen := r.Intn(size + 1)
+				var e, a []int
+				expected.Search(v, reverse, maxLen, func(index int) bool {
+					e = append(e, index)
+					return true
+				})
+				actual.Search(v, reverse, maxLen, func(index int) bool {
+					a = append(a, index)
+					return true
+				})
+				if len(e) != len(a) {
+					t.Fatalf("search: expected %v, got %v", e, a)
+				}
+				for j := range e {
+					if e[j] != a[j] {
+						t.Fatalf("search: expected %v, got %v", e, a)
+					}
+				}
+			case op == 11 && r.Intn(20) == 0:
+				v := randVal()
+				if expected.RemoveAllByVal(v) != actual.RemoveAllByVal(v) {
+					t.Fatal("remove all by val returned different counts")
+				}
+			}
+			assertSameList(t, expected, actual)
+		}
+	}
+}
+
+func TestQuickListNodeSize(t *testing.T) {
+	ql := MakeQuickList(4)
+	for i := 0; i < 10; i++ {
+		ql.Add([]byte(strconv.Itoa(i)))
+	}
+	nodes := 0
+	for n := ql.first; n != nil; n = n.next {
+		if n.lp.Len() > 4 {
+			t.Errorf("node has %d elements, limit is 4", n.lp.Len())
+		}
+		nodes++
+	}
+	if nodes != 3 {
+		t.Errorf("expected 3 nodes, got %d", nodes)
+	}
+
+	// 按字节数限制时每个节点不超过 4KB, 超过限制的单个元素独占一个节点
+	ql = MakeQuickList(-1)
+	for i := 0; i < 100; i++ {
+		ql.Add(make([]byte, 100))
+	}
+	ql.Add(make([]byte, 10000))
+	for n := ql.first; n != nil; n = n.next {
+		if n.lp.Size() > 4096 && n.lp.Len() > 1 {
+			t.Errorf("node has %d bytes in %d elements", n.lp.Size(), n.lp.Len())
+		}
+	}
+	if ql.Get(100).([]byte) == nil || len(ql.Get(100).([]byte)) != 10000 {
+		t.Errorf("unexpected large element")
+	}
+}
+
+const benchListSize = 1 << 20
+
+func fillList(l list.List) list.List {
+	for i := 0; i < benchListSize; i++ {
+		l.Add([]byte("element:" + strconv.Itoa(i)))
+	}
+	return l
+}
+
+// benchMemory 报告每个元素占用的堆内存
+func benchMemory(b *testing.B, makeList func() list.List) {
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		l := fillList(makeList())
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/benchListSize, "bytes/elem")
+		runtime.KeepAlive(l)
+	}
+}
+
+func BenchmarkLinkedListMemory(b *testing.B) {
+	benchMemory(b, func() list.List { return Make() })
+}
+
+func BenchmarkQuickListMemory(b *testing.B) {
+	benchMemory(b, func() list.List { return MakeQuickList(DefaultFill) })
+}
+
+// benchIndex 随机下标的 LINDEX
+func benchIndex(b *testing.B, l list.List) {
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Get(r.Intn(benchListSize))
+	}
+}
+
+func BenchmarkLinkedListIndex(b *testing.B) {
+	benchIndex(b, fillList(Make()))
+}
+
+func BenchmarkQuickListIndex(b *testing.B) {
+	benchIndex(b, fillList(MakeQuickList(DefaultFill)))
+}
@@ -0,0 +1,134 @@
+// Package listpack 实现 quicklist 的节点以及小哈希表, 小集合使用的紧凑编码
+package listpack
+
+import "encoding/binary"
+
+// Listpack 将多个元素紧凑地编码在一个 []byte 中, 每个元素为 uvarint 编码的长度加上内容.
+// 元素数量应当较少, 按下标访问时从头扫描
+type Listpack struct {
+	buf   []byte
+	count int
+}
+
+// Len 元素数量
+func (lp *Listpack) Len() int {
+	return lp.count
+}
+
+// Size 编码后的字节数
+func (lp *Listpack) Size() int {
+	return len(lp.buf)
+}
+
+// EntrySize 元素编码后的长度
+func EntrySize(val []byte) int {
+	var header [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(header[:], uint64(len(val))) + len(val)
+}
+
+// Next 返回从 offset 开始的元素以及下一个元素的位置, 返回的切片引用 buf, 不能在修改 Listpack 之后使用
+func (lp *Listpack) Next(offset int) ([]byte, int) {
+	n, w := binary.Uvarint(lp.buf[offset:])
+	start := offset + w
+	end := start + int(n)
+	return lp.buf[start:end], end
+}
+
+// Offset 返回第 index 个元素的位置, index 等于 count 时返回结尾
+func (lp *Listpack) Offset(index int) int {
+	if index == lp.count {
+		return len(lp.buf)
+	}
+	offset := 0
+	for i := 0; i < index; i++ {
+		_, offset = lp.Next(offset)
+	}
+	return offset
+}
+
+// Offsets 返回所有元素的位置, 用于从后向前遍历
+func (lp *Listpack) Offsets() []int {
+	offsets := make([]int, lp.count)
+	offset := 0
+	for i := range offsets {
+		offsets[i] = offset
+		_, offset = lp.Next(offset)
+	}
+	return offsets
+}
+
+func (lp *Listpack) Get(index int) []byte {
+	val, _ := lp.Next(lp.Offset(index))
+	return CopyBytes(val)
+}
+
+func (lp *Listpack) Insert(index int, val []byte) {
+	lp.InsertAt(lp.Offset(index), val)
+}
+
+// InsertAt 在 offset 处插入元素
+func (lp *Listpack) InsertAt(offset int, val []byte) {
+	size := EntrySize(val)
+	total := len(lp.buf) + size
+	if total > cap(lp.buf) {
+		buf := make([]byte, len(lp.buf), total+total/4)
+		copy(buf, lp.buf)
+		lp.buf = buf
+	}
+	lp.buf = lp.buf[:total]
+	copy(lp.buf[offset+size:], lp.buf[offset:total-size])
+	w := binary.PutUvarint(lp.buf[offset:], uint64(len(val)))
+	copy(lp.buf[offset+w:], val)
+	lp.count++
+}
+
+func (lp *Listpack) Remove(index int) []byte {
+	offset := lp.Offset(index)
+	val, end := lp.Next(offset)
+	val = CopyBytes(val)
+	lp.RemoveAt(offset, end)
+	return val
+}
+
+// RemoveAt 删除位于 [offset, end) 的一个元素
+func (lp *Listpack) RemoveAt(offset int, end int) {
+	lp.buf = append(lp.buf[:offset], lp.buf[end:]...)
+	lp.count--
+}
+
+func (lp *Listpack) Set(index int, val []byte) {
+	offset := lp.Offset(index)
+	_, end := lp.Next(offset)
+	lp.RemoveAt(offset, end)
+	lp.InsertAt(offset, val)
+}
+
+// Split 将下标 index 及之后的元素移入新的 Listpack
+func (lp *Listpack) Split(index int) Listpack {
+	offset := lp.Offset(index)
+	right := Listpack{
+		buf:   append([]byte(nil), lp.buf[offset:]...),
+		count: lp.count - index,
+	}
+	lp.buf = lp.buf[:offset]
+	lp.count = index
+	return right
+}
+
+// DropHead 删除前 n 个元素
+func (lp *Listpack) DropHead(n int) {
+	lp.buf = append([]byte(nil), lp.buf[lp.Offset(n):]...)
+	lp.count -= n
+}
+
+// Truncate 只保留前 n 个元素
+func (lp *Listpack) Truncate(n int) {
+	lp.buf = lp.buf[:lp.Offset(n)]
+	lp.count = n
+}
+
+func CopyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,111 @@
+package set
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// intset 有序的整数数组, 所有元素使用相同的宽度 (2, 4 或 8 字节) 以小端序保存,
+// 插入的元素超出当前宽度时整体升级
+type intset struct {
+	width int
+	buf   []byte
+}
+
+func makeIntset() *intset {
+	return &intset{width: 2}
+}
+
+func widthOf(v int64) int {
+	if v >= math.MinInt16 && v <= math.MaxInt16 {
+		return 2
+	}
+	if v >= math.MinInt32 && v <= math.MaxInt32 {
+		return 4
+	}
+	return 8
+}
+
+func (is *intset) len() int {
+	return len(is.buf) / is.width
+}
+
+func (is *intset) get(i int) int64 {
+	b := is.buf[i*is.width:]
+	switch is.width {
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(b)))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+func (is *intset) put(i int, v int64) {
+	b := is.buf[i*is.width:]
+	switch is.width {
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(b, uint64(v))
+	}
+}
+
+// search 二分查找, 不存在时返回应当插入的位置
+func (is *intset) search(v int64) (int, bool) {
+	lo, hi := 0, is.len()
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		cur := is.get(mid)
+		if cur == v {
+			return mid, true
+		}
+		if cur < v {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, false
+}
+
+func (is *intset) has(v int64) bool {
+	_, ok := is.search(v)
+	return ok
+}
+
+// upgrade 将所有元素改为更大的宽度
+func (is *intset) upgrade(width int) {
+	old := *is
+	is.width = width
+	is.buf = make([]byte, old.len()*width, (old.len()+1)*width)
+	for i := 0; i < old.len(); i++ {
+		is.put(i, old.get(i))
+	}
+}
+
+func (is *intset) add(v int64) bool {
+	if w := widthOf(v); w > is.width {
+		is.upgrade(w)
+	}
+	i, ok := is.search(v)
+	if ok {
+		return false
+	}
+	n := is.len()
+	is.buf = append(is.buf, make([]byte, is.width)...)
+	copy(is.buf[(i+1)*is.width:], is.buf[i*is.width:n*is.width])
+	is.put(i, v)
+	return true
+}
+
+func (is *intset) remove(v int64) bool {
+	i, ok := is.search(v)
+	if !ok {
+		return false
+	}
+	is.buf = append(is.buf[:i*is.width], is.buf[(i+1)*is.width:]...)
+	return true
+}
@@ -1,51 +1,204 @@
 package set
 
 import (
+	"math/rand"
 	Dict "redisGo/datastruct/dict"
+	"redisGo/datastruct/listpack"
 	"redisGo/interface/dict"
+	"strconv"
 )
 
+// Limits 紧凑编码的上限, 超过后转换为哈希表, 字段为 0 时使用 DefaultLimits 中的值
+type Limits struct {
+	// MaxIntsetEntries 对应 set-max-intset-entries
+	MaxIntsetEntries int
+	// MaxListpackEntries 对应 set-max-listpack-entries
+	MaxListpackEntries int
+	// MaxListpackValue 对应 set-max-listpack-value, listpack 中单个元素的最大长度
+	MaxListpackValue int
+}
+
+var DefaultLimits = Limits{
+	MaxIntsetEntries:   512,
+	MaxListpackEntries: 128,
+	MaxListpackValue:   64,
+}
+
+// Set 元素较少时使用 intset (全部为整数) 或 listpack 编码, 否则使用哈希表. 三者中只有一个不为 nil
 type Set struct {
-	dict dict.Dict
+	limits   Limits
+	intset   *intset
+	listpack *listpack.Listpack
+	dict     dict.Dict
 }
 
 func Make(hint int) *Set {
-	return &Set{
-		dict: Dict.MakeConcurrent(hint),
+	return MakeWithLimits(hint, DefaultLimits)
+}
+
+// MakeWithLimits hint 超过 intset 的上限时直接使用哈希表
+func MakeWithLimits(hint int, limits Limits) *Set {
+	if limits.MaxIntsetEntries <= 0 {
+		limits.MaxIntsetEntries = DefaultLimits.MaxIntsetEntries
+	}
+	if limits.MaxListpackEntries <= 0 {
+		limits.MaxListpackEntries = DefaultLimits.MaxListpackEntries
 	}
+	if limits.MaxListpackValue <= 0 {
+		limits.MaxListpackValue = DefaultLimits.MaxListpackValue
+	}
+	set := &Set{limits: limits}
+	if hint > limits.MaxIntsetEntries {
+		set.dict = Dict.MakeConcurrent(hint)
+	} else {
+		set.intset = makeIntset()
+	}
+	return set
 }
 
 func MakeFromVals(members ...string) *Set {
-	set := &Set{
-		dict: Dict.MakeConcurrent(10),
-	}
+	set := Make(len(members))
 	for _, member := range members {
 		set.Add(member)
 	}
 	return set
 }
 
+// makeLike 创建使用相同上限的空集合
+func (set *Set) makeLike() *Set {
+	return MakeWithLimits(0, set.limits)
+}
+
+// Encoding 返回 OBJECT ENCODING 中的编码名称
+func (set *Set) Encoding() string {
+	switch {
+	case set.intset != nil:
+		return "intset"
+	case set.listpack != nil:
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// parseInt 只接受规范形式的整数, 例如 "01" 和 "+1" 需要按字符串保存
+func parseInt(val string) (int64, bool) {
+	v, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != val {
+		return 0, false
+	}
+	return v, true
+}
+
+// findInListpack 返回元素在 listpack 中的位置, 不存在时返回 -1
+func (set *Set) findInListpack(val string) (int, int) {
+	offset := 0
+	for i := 0; i < set.listpack.Len(); i++ {
+		entry, end := set.listpack.Next(offset)
+		if string(entry) == val {
+			return offset, end
+		}
+		offset = end
+	}
+	return -1, -1
+}
+
+// toListpack 将 intset 转换为 listpack
+func (set *Set) toListpack() {
+	lp := &listpack.Listpack{}
+	for i := 0; i < set.intset.len(); i++ {
+		lp.InsertAt(lp.Size(), []byte(strconv.FormatInt(set.intset.get(i), 10)))
+	}
+	set.intset = nil
+	set.listpack = lp
+}
+
+// toHashtable 将紧凑编码转换为哈希表
+func (set *Set) toHashtable() {
+	d := Dict.MakeConcurrent(set.Len())
+	set.ForEach(func(member string) bool {
+		d.Put(member, true)
+		return true
+	})
+	set.intset = nil
+	set.listpack = nil
+	set.dict = d
+}
+
 func (set *Set) Add(val string) int {
+	if set.intset != nil {
+		if v, ok := parseInt(val); ok {
+			if set.intset.has(v) {
+				return 0
+			}
+			if set.intset.len() < set.limits.MaxIntsetEntries {
+				set.intset.add(v)
+				return 1
+			}
+			set.toHashtable()
+		} else if set.intset.len() < set.limits.MaxListpackEntries && len(val) <= set.limits.MaxListpackValue {
+			set.toListpack()
+		} else {
+			set.toHashtable()
+		}
+	}
+	if set.listpack != nil {
+		if offset, _ := set.findInListpack(val); offset >= 0 {
+			return 0
+		}
+		if set.listpack.Len() < set.limits.MaxListpackEntries && len(val) <= set.limits.MaxListpackValue {
+			set.listpack.InsertAt(set.listpack.Size(), []byte(val))
+			return 1
+		}
+		set.toHashtable()
+	}
 	return set.dict.Put(val, true)
 }
 
 func (set *Set) Remove(val string) int {
+	switch {
+	case set.intset != nil:
+		if v, ok := parseInt(val); ok && set.intset.remove(v) {
+			return 1
+		}
+		return 0
+	case set.listpack != nil:
+		offset, end := set.findInListpack(val)
+		if offset < 0 {
+			return 0
+		}
+		set.listpack.RemoveAt(offset, end)
+		return 1
+	}
 	return set.dict.Remove(val)
 }
 
 func (set *Set) Has(val string) bool {
+	switch {
+	case set.intset != nil:
+		v, ok := parseInt(val)
+		return ok && set.intset.has(v)
+	case set.listpack != nil:
+		offset, _ := set.findInListpack(val)
+		return offset >= 0
+	}
 	_, exists := set.dict.Get(val)
 	return exists
 }
 
 func (set *Set) Len() int {
+	switch {
+	case set.intset != nil:
+		return set.intset.len()
+	case set.listpack != nil:
+		return set.listpack.Len()
+	}
 	return set.dict.Len()
 }
 
 func (set *Set) ToSlice() []string {
 	slice := make([]string, set.Len())
 	i := 0
-	set.dict.ForEach(func(key string, _ interface{}) bool {
+	set.ForEach(func(key string) bool {
 		if i < len(slice) {
 			slice[i] = key
 		} else {
@@ -58,16 +211,34 @@ func (set *Set) ToSlice() []string {
 }
 
 func (set *Set) ForEach(consumer func(key string) bool) {
-	set.dict.ForEach(func(key string, _ interface{}) bool {
-		return consumer(key)
-	})
+	switch {
+	case set.intset != nil:
+		for i := 0; i < set.intset.len(); i++ {
+			if !consumer(strconv.FormatInt(set.intset.get(i), 10)) {
+				return
+			}
+		}
+	case set.listpack != nil:
+		offset := 0
+		for i := 0; i < set.listpack.Len(); i++ {
+			var entry []byte
+			entry, offset = set.listpack.Next(offset)
+			if !consumer(string(entry)) {
+				return
+			}
+		}
+	default:
+		set.dict.ForEach(func(key string, _ interface{}) bool {
+			return consumer(key)
+		})
+	}
 }
 
 func (set *Set) Intersect(another *Set) *Set {
 	if set == nil {
 		panic("set is nil")
 	}
-	result := Make(0)
+	result := set.makeLike()
 	another.ForEach(func(member string) bool {
 		if set.Has(member) {
 			result.Add(member)
@@ -81,7 +252,7 @@ func (set *Set) Union(another *Set) *Set {
 	if set == nil {
 		panic("set is nil")
 	}
-	result := Make(0)
+	result := set.makeLike()
 	another.ForEach(func(member string) bool {
 		result.Add(member)
 		return true
@@ -97,7 +268,7 @@ func (set *Set) Diff(another *Set) *Set {
 	if set == nil {
 		panic("set is nil")
 	}
-	result := Make(0)
+	result := set.makeLike()
 	set.ForEach(func(member string) bool {
 		if !another.Has(member) {
 			result.Add(member)
@@ -107,10 +278,39 @@ func (set *Set) Diff(another *Set) *Set {
 	return result
 }
 
+// ScanMembers 游标的含义与 dict.Dict 的 ScanKeys 相同, 紧凑编码时一次返回所有元素
+func (set *Set) ScanMembers(cursor int, count int) ([]string, int) {
+	if set.dict == nil {
+		return set.ToSlice(), 0
+	}
+	return set.dict.ScanKeys(cursor, count)
+}
+
 func (set *Set) RandomMembers(limit int) []string {
-	return set.dict.RandomKeys(limit)
+	if set.dict != nil {
+		return set.dict.RandomKeys(limit)
+	}
+	members := set.ToSlice()
+	if len(members) == 0 {
+		return nil
+	}
+	result := make([]string, limit)
+	for i := range result {
+		result[i] = members[rand.Intn(len(members))]
+	}
+	return result
 }
 
 func (set *Set) RandomDistinctMembers(limit int) []string {
-	return set.dict.RandomDistinctKeys(limit)
+	if set.dict != nil {
+		return set.dict.RandomDistinctKeys(limit)
+	}
+	members := set.ToSlice()
+	if limit > len(members) {
+		limit = len(members)
+	}
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	return members[:limit]
 }
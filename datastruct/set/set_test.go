@@ -0,0 +1,140 @@
+package set
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestIntset(t *testing.T) {
+	is := makeIntset()
+	vals := []int64{5, -3, 1 << 20, 7, -(1 << 40), 0}
+	for _, v := range vals {
+		if !is.add(v) {
+			t.Fatalf("add %d failed", v)
+		}
+	}
+	if is.add(7) {
+		t.Error("duplicate add should return false")
+	}
+	if is.width != 8 {
+		t.Errorf("expected width 8, got %d", is.width)
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+	for i, v := range vals {
+		if is.get(i) != v {
+			t.Errorf("index %d: expected %d, got %d", i, v, is.get(i))
+		}
+	}
+	if !is.remove(-3) || is.remove(-3) || is.has(-3) || is.len() != len(vals)-1 {
+		t.Error("remove failed")
+	}
+}
+
+func TestSetEncoding(t *testing.T) {
+	limits := Limits{MaxIntsetEntries: 4, MaxListpackEntries: 3, MaxListpackValue: 5}
+
+	s := MakeWithLimits(0, limits)
+	for i := 0; i < 4; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != "intset" {
+		t.Errorf("expected intset, got %s", s.Encoding())
+	}
+	s.Add("4")
+	if s.Encoding() != "hashtable" || s.Len() != 5 {
+		t.Errorf("expected hashtable with 5 members, got %s with %d", s.Encoding(), s.Len())
+	}
+
+	// 非规范形式的整数按字符串保存
+	s = MakeWithLimits(0, limits)
+	s.Add("1")
+	s.Add("01")
+	if s.Encoding() != "listpack" || !s.Has("1") || !s.Has("01") || s.Has("001") {
+		t.Errorf("expected listpack containing 1 and 01, got %s %v", s.Encoding(), s.ToSlice())
+	}
+	s.Add("a")
+	if s.Add("a") != 0 || s.Encoding() != "listpack" {
+		t.Errorf("expected listpack, got %s", s.Encoding())
+	}
+	s.Add("b")
+	if s.Encoding() != "hashtable" || s.Len() != 4 {
+		t.Errorf("expected hashtable with 4 members, got %s with %d", s.Encoding(), s.Len())
+	}
+
+	s = MakeWithLimits(0, limits)
+	s.Add("toolong")
+	if s.Encoding() != "hashtable" {
+		t.Errorf("expected hashtable for long member, got %s", s.Encoding())
+	}
+
+	if MakeWithLimits(5, limits).Encoding() != "hashtable" {
+		t.Error("large hint should use hashtable")
+	}
+}
+
+// 随机操作与 map 的结果比较, 覆盖各种编码之间的转换
+func TestSetRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	limits := Limits{MaxIntsetEntries: 16, MaxListpackEntries: 8, MaxListpackValue: 4}
+	for round := 0; round < 50; round++ {
+		s := MakeWithLimits(0, limits)
+		expected := make(map[string]struct{})
+		strMembers := round%2 == 0
+		for i := 0; i < 200; i++ {
+			member := strconv.Itoa(r.Intn(40) - 20)
+			if strMembers && r.Intn(10) == 0 {
+				member = "m" + member
+			}
+			_, exists := expected[member]
+			if r.Intn(3) == 0 {
+				delete(expected, member)
+				if s.Remove(member) != boolToInt(exists) {
+					t.Fatalf("remove %s returned wrong result", member)
+				}
+			} else {
+				expected[member] = struct{}{}
+				if s.Add(member) != boolToInt(!exists) {
+					t.Fatalf("add %s returned wrong result", member)
+				}
+			}
+			if s.Len() != len(expected) {
+				t.Fatalf("expected size %d, got %d", len(expected), s.Len())
+			}
+		}
+		for _, member := range s.ToSlice() {
+			if _, ok := expected[member]; !ok {
+				t.Fatalf("unexpected member %s", member)
+			}
+		}
+		for member := range expected {
+			if !s.Has(member) {
+				t.Fatalf("missing member %s", member)
+			}
+		}
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestSetRandomMembers(t *testing.T) {
+	s := MakeFromVals("a", "b", "c")
+	if len(s.RandomMembers(5)) != 5 {
+		t.Error("random members should allow duplicates")
+	}
+	members := s.RandomDistinctMembers(5)
+	if len(members) != 3 {
+		t.Errorf("expected 3 distinct members, got %v", members)
+	}
+	for _, m := range members {
+		if !s.Has(m) {
+			t.Errorf("unexpected member %s", m)
+		}
+	}
+}
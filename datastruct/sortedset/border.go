@@ -0,0 +1,189 @@
+package sortedset
+
+import (
+	"errors"
+	"strconv"
+)
+
+/*
+ * Border 表示区间查询的边界, 区间查询 (ZRANGEBYSCORE, ZRANGEBYLEX 等) 使用 min 和 max 两个边界
+ * - min.less(element) 为 true 表示 element 在下边界之上
+ * - max.greater(element) 为 true 表示 element 在上边界之下
+ */
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+type Border interface {
+	greater(element *Element) bool
+	less(element *Element) bool
+	isIntersected(max Border) bool
+}
+
+// ScoreBorder 表示 score 边界, 如 1, (1, -inf, +inf
+type ScoreBorder struct {
+	Inf     int8
+	Value   float64
+	Exclude bool
+}
+
+func (border *ScoreBorder) greater(element *Element) bool {
+	value := element.Score
+	if border.Inf == negativeInf {
+		return false
+	} else if border.Inf == positiveInf {
+		return true
+	}
+	if border.Exclude {
+		return border.Value > value
+	}
+	return border.Value >= value
+}
+
+func (border *ScoreBorder) less(element *Element) bool {
+	value := element.Score
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < value
+	}
+	return border.Value <= value
+}
+
+// isIntersected 返回 [border, max] 是否为空区间
+func (border *ScoreBorder) isIntersected(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	minValue := border.Value
+	maxValue := maxBorder.Value
+	return minValue > maxValue || (minValue == maxValue && (border.Exclude || maxBorder.Exclude))
+}
+
+var scorePositiveInfBorder = &ScoreBorder{
+	Inf: positiveInf,
+}
+
+var scoreNegativeInfBorder = &ScoreBorder{
+	Inf: negativeInf,
+}
+
+// ParseScoreBorder 解析 ZRANGEBYSCORE 等命令中的 score 边界
+func ParseScoreBorder(s string) (Border, error) {
+	if s == "inf" || s == "+inf" {
+		return scorePositiveInfBorder, nil
+	}
+	if s == "-inf" {
+		return scoreNegativeInfBorder, nil
+	}
+	if len(s) > 0 && s[0] == '(' {
+		value, err := strconv.ParseFloat(s[1:], 64)
+		if err != nil {
+			return nil, errors.New("ERR min or max is not a float")
+		}
+		return &ScoreBorder{
+			Value:   value,
+			Exclude: true,
+		}, nil
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	return &ScoreBorder{
+		Value:   value,
+		Exclude: false,
+	}, nil
+}
+
+// LexBorder 表示字典序边界, 如 [a, (a, -, +
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+func (border *LexBorder) greater(element *Element) bool {
+	value := element.Member
+	if border.Inf == negativeInf {
+		return false
+	} else if border.Inf == positiveInf {
+		return true
+	}
+	if border.Exclude {
+		return border.Value > value
+	}
+	return border.Value >= value
+}
+
+func (border *LexBorder) less(element *Element) bool {
+	value := element.Member
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < value
+	}
+	return border.Value <= value
+}
+
+func (border *LexBorder) isIntersected(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	minValue := border.Value
+	maxValue := maxBorder.Value
+	return minValue > maxValue || (minValue == maxValue && (border.Exclude || maxBorder.Exclude))
+}
+
+var lexPositiveInfBorder = &LexBorder{
+	Inf: positiveInf,
+}
+
+var lexNegativeInfBorder = &LexBorder{
+	Inf: negativeInf,
+}
+
+// ParseLexBorder 解析 ZRANGEBYLEX 等命令中的字典序边界
+func ParseLexBorder(s string) (Border, error) {
+	if s == "+" {
+		return lexPositiveInfBorder, nil
+	}
+	if s == "-" {
+		return lexNegativeInfBorder, nil
+	}
+	if len(s) > 0 && s[0] == '(' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: true,
+		}, nil
+	}
+	if len(s) > 0 && s[0] == '[' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: false,
+		}, nil
+	}
+	return nil, errors.New("ERR min or max not valid string range item")
+}
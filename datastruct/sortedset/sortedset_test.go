@@ -0,0 +1,93 @@
+package sortedset
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSortedSetRank(t *testing.T) {
+	set := Make()
+	for i := 0; i < 100; i++ {
+		set.Add("m"+strconv.Itoa(i), float64(i))
+	}
+	if set.Len() != 100 {
+		t.Errorf("expected 100 elements, got %d", set.Len())
+	}
+	for i := 0; i < 100; i++ {
+		member := "m" + strconv.Itoa(i)
+		if rank := set.GetRank(member, false); rank != int64(i) {
+			t.Errorf("rank of %s: expected %d, got %d", member, i, rank)
+		}
+		if rank := set.GetRank(member, true); rank != int64(99-i) {
+			t.Errorf("rev rank of %s: expected %d, got %d", member, 99-i, rank)
+		}
+	}
+	// update score changes order
+	set.Add("m0", 1000)
+	if rank := set.GetRank("m0", false); rank != 99 {
+		t.Errorf("rank of updated m0: expected 99, got %d", rank)
+	}
+	elements := set.RangeByRank(0, 3, true)
+	if elements[0].Member != "m0" || elements[1].Member != "m99" || elements[2].Member != "m98" {
+		t.Errorf("unexpected desc range: %s %s %s", elements[0].Member, elements[1].Member, elements[2].Member)
+	}
+}
+
+func TestSortedSetRangeByScore(t *testing.T) {
+	set := Make()
+	for i := 0; i < 10; i++ {
+		set.Add("m"+strconv.Itoa(i), float64(i))
+	}
+	min, _ := ParseScoreBorder("(2")
+	max, _ := ParseScoreBorder("5")
+	if count := set.RangeCount(min, max); count != 3 {
+		t.Errorf("expected 3 elements in (2, 5], got %d", count)
+	}
+	elements := set.Range(min, max, 1, 1, false)
+	if len(elements) != 1 || elements[0].Member != "m4" {
+		t.Errorf("expected [m4] with offset 1 limit 1, got %v", elements)
+	}
+	elements = set.Range(min, max, 0, -1, true)
+	if len(elements) != 3 || elements[0].Member != "m5" || elements[2].Member != "m3" {
+		t.Errorf("unexpected desc range: %v", elements)
+	}
+	if removed := set.RemoveRange(min, max); removed != 3 {
+		t.Errorf("expected 3 removed, got %d", removed)
+	}
+	if set.Len() != 7 {
+		t.Errorf("expected 7 elements left, got %d", set.Len())
+	}
+
+	popped := set.PopMin(2)
+	if len(popped) != 2 || popped[0].Member != "m0" || popped[1].Member != "m1" {
+		t.Errorf("unexpected PopMin result: %v", popped)
+	}
+	popped = set.PopMax(1)
+	if len(popped) != 1 || popped[0].Member != "m9" {
+		t.Errorf("unexpected PopMax result: %v", popped)
+	}
+	if removed := set.RemoveByRank(0, 2); removed != 2 {
+		t.Errorf("expected 2 removed by rank, got %d", removed)
+	}
+	if set.Len() != 2 {
+		t.Errorf("expected 2 elements left, got %d", set.Len())
+	}
+}
+
+func TestSortedSetRangeByLex(t *testing.T) {
+	set := Make()
+	for _, member := range []string{"a", "b", "c", "d", "e"} {
+		set.Add(member, 0)
+	}
+	min, _ := ParseLexBorder("[b")
+	max, _ := ParseLexBorder("(e")
+	elements := set.Range(min, max, 0, -1, false)
+	if len(elements) != 3 || elements[0].Member != "b" || elements[2].Member != "d" {
+		t.Errorf("unexpected lex range: %v", elements)
+	}
+	min, _ = ParseLexBorder("-")
+	max, _ = ParseLexBorder("+")
+	if count := set.RangeCount(min, max); count != 5 {
+		t.Errorf("expected 5 elements in [-, +], got %d", count)
+	}
+}
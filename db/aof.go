@@ -1,20 +1,26 @@
 package db
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"redisGo/config"
-	Dict "redisGo/datastruct/dict"
-	"redisGo/datastruct/list"
-	"redisGo/datastruct/lock"
 	"redisGo/datastruct/set"
+	SortedSet "redisGo/datastruct/sortedset"
 	"redisGo/interface/dict"
+	"redisGo/interface/list"
 	"redisGo/lib/logger"
+	"redisGo/redis/connection"
 	"redisGo/redis/parser"
 	"redisGo/redis/reply"
 	"redisGo/utils"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,110 +41,353 @@ func makeAofCmd(cmd string, args [][]byte) *reply.MultiBulkReply {
 	return reply.MakeMultiBulkReply(params)
 }
 
+// appendfsync 配置的取值
+const (
+	FsyncAlways   = "always"   // 每次写入后都 fsync, 命令在 fsync 完成后才返回
+	FsyncEverySec = "everysec" // 后台每秒 fsync 一次
+	FsyncNo       = "no"       // 由操作系统决定何时落盘
+)
+
+// aofPayload 一次发送给 aof goroutine 的命令, 事务中的命令作为一个 payload 整体写入
+type aofPayload struct {
+	dbIndex  int
+	cmdLines []*reply.MultiBulkReply
+	// appendfsync always 时不为 nil, aof goroutine 完成 fsync 后关闭
+	done chan struct{}
+}
+
 // AddAof send command to aof goroutine through channel
 func (db *DB) AddAof(args *reply.MultiBulkReply) {
-	if config.Properties.AppendOnly && db.aofChan != nil {
-		db.aofChan <- args
+	if db.txAofBuffer != nil { // executing transaction
+		db.txAofBuffer = append(db.txAofBuffer, args)
+		return
 	}
+	db.addAof(db.index, args)
+}
+
+func (mdb *MultiDB) addAof(dbIndex int, cmdLines ...*reply.MultiBulkReply) {
+	atomic.AddInt64(&mdb.dirty, int64(len(cmdLines)))
+	mdb.feedReplication(dbIndex, cmdLines)
+	if config.Properties.AppendOnly && mdb.aofChan != nil {
+		p := &aofPayload{
+			dbIndex:  dbIndex,
+			cmdLines: cmdLines,
+		}
+		if mdb.aofFsync == FsyncAlways {
+			p.done = make(chan struct{})
+		}
+		mdb.aofChan <- p
+		if p.done != nil {
+			// 等待落盘后再向客户端返回结果
+			<-p.done
+		}
+	}
+}
+
+var selectCmd = []byte("SELECT")
+
+func makeSelectCmd(index int) *reply.MultiBulkReply {
+	return reply.MakeMultiBulkReply([][]byte{selectCmd, []byte(strconv.Itoa(index))})
+}
+
+// writePayload 将 payload 序列化, 若 payload 所属的数据库与 currentDB 不同则先写入 SELECT, 返回写入的字节数
+func writePayload(file *os.File, p *aofPayload, currentDB *int) (int, error) {
+	buf := &bytes.Buffer{}
+	if p.dbIndex != *currentDB {
+		buf.Write(makeSelectCmd(p.dbIndex).ToBytes())
+		*currentDB = p.dbIndex
+	}
+	for _, cmdLine := range p.cmdLines {
+		buf.Write(cmdLine.ToBytes())
+	}
+	return file.Write(buf.Bytes())
 }
 
 // handleAof listen aof channel and write to aof file
-func (db *DB) handleAof() {
-	for cmd := range db.aofChan {
-		db.pausingAof.RLock()
-		if db.aofRewriteChan != nil {
-			db.aofRewriteChan <- cmd
+func (mdb *MultiDB) handleAof() {
+	for p := range mdb.aofChan {
+		mdb.writeAofBatch(mdb.collectPayloads(p))
+	}
+	mdb.aofFinished <- struct{}{}
+}
+
+// collectPayloads 取出队列中已有的全部命令, appendfsync always 时整批只需要一次 fsync
+func (mdb *MultiDB) collectPayloads(first *aofPayload) []*aofPayload {
+	batch := []*aofPayload{first}
+	for {
+		select {
+		case p, ok := <-mdb.aofChan:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, p)
+		default:
+			return batch
+		}
+	}
+}
+
+func (mdb *MultiDB) writeAofBatch(batch []*aofPayload) {
+	mdb.pausingAof.RLock()
+	defer mdb.pausingAof.RUnlock()
+	for _, p := range batch {
+		if mdb.aofRewriteChan != nil {
+			mdb.aofRewriteChan <- p
 		}
-		_, err := db.aofFile.Write(cmd.ToBytes())
+		n, err := writePayload(mdb.aofFile, p, &mdb.aofCurrentDB)
 		if err != nil {
 			logger.Warn(err)
 		}
-		db.pausingAof.RUnlock()
+		atomic.AddInt64(&mdb.aofPendingBytes, int64(n))
 	}
-	db.aofFinished <- struct{}{}
+	if mdb.aofFsync == FsyncAlways {
+		err := mdb.fsyncAof()
+		if err != nil {
+			logger.Warn("aof fsync failed: " + err.Error())
+		}
+	}
+	for _, p := range batch {
+		if p.done != nil {
+			close(p.done)
+		}
+	}
+}
+
+// fsyncAof 将 aof 文件落盘并记录耗时, 调用者需要持有 pausingAof 的读锁
+func (mdb *MultiDB) fsyncAof() error {
+	pending := atomic.LoadInt64(&mdb.aofPendingBytes)
+	start := time.Now()
+	err := mdb.aofFile.Sync()
+	if err != nil {
+		return err
+	}
+	latency := time.Since(start)
+	atomic.StoreInt64(&mdb.aofFsyncLatency, int64(latency/time.Microsecond))
+	atomic.StoreInt64(&mdb.aofLastFsync, time.Now().Unix())
+	atomic.AddInt64(&mdb.aofPendingBytes, -pending)
+	return nil
+}
+
+// aofSyncer appendfsync everysec 时每秒在后台 fsync 一次
+func (mdb *MultiDB) aofSyncer() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mdb.closed:
+			return
+		case <-ticker.C:
+			if atomic.LoadInt64(&mdb.aofPendingBytes) == 0 {
+				continue
+			}
+			mdb.pausingAof.RLock()
+			err := mdb.fsyncAof()
+			mdb.pausingAof.RUnlock()
+			if err != nil {
+				logger.Warn("aof fsync failed: " + err.Error())
+				continue
+			}
+			if atomic.LoadInt64(&mdb.aofFsyncLatency) > int64(2*time.Second/time.Microsecond) {
+				atomic.AddInt64(&mdb.aofDelayedFsync, 1)
+				logger.Warn("asynchronous aof fsync is taking too long (disk is busy?)")
+			}
+		}
+	}
+}
+
+// aofFsyncLag 距离上次 fsync 的秒数, 没有未落盘的数据时为 0
+func (mdb *MultiDB) aofFsyncLag() int64 {
+	if atomic.LoadInt64(&mdb.aofPendingBytes) == 0 {
+		return 0
+	}
+	return time.Now().Unix() - atomic.LoadInt64(&mdb.aofLastFsync)
+}
+
+// aofLoadError aof 文件中存在无法解析的数据, offset 为最后一条完整命令的结束位置
+type aofLoadError struct {
+	offset    int64
+	truncated bool // 仅文件末尾的命令不完整, 截断到 offset 即可修复
+	err       error
 }
 
-func (db *DB) loadAof(maxBytes int) {
+func (e *aofLoadError) Error() string {
+	if e.truncated {
+		return fmt.Sprintf("unexpected end of append only file, last valid command ends at offset %d: %v", e.offset, e.err)
+	}
+	return fmt.Sprintf("bad file format reading the append only file at offset %d: %v", e.offset, e.err)
+}
+
+// loadAof 通过伪客户端重放 aof 文件, SELECT, MULTI/EXEC 等命令与正常执行时的行为一致
+func (mdb *MultiDB) loadAof(maxBytes int) error {
 	// delete aofChan to prevent write again
-	aofChan := db.aofChan
-	db.aofChan = nil
-	defer func(aofChan chan *reply.MultiBulkReply) {
-		db.aofChan = aofChan
+	aofChan := mdb.aofChan
+	mdb.aofChan = nil
+	defer func(aofChan chan *aofPayload) {
+		mdb.aofChan = aofChan
 	}(aofChan)
 
 	// load aof
-	file, err := os.Open(db.aofFilename)
+	file, err := os.Open(mdb.aofFilename)
 	if err != nil {
-		logger.Error(err)
-		return
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
 	}
 	defer file.Close()
 
-	reader := utils.NewLimitedReader(file, maxBytes)
-	ch := parser.Parse(reader)
-	for p := range ch {
+	fakeConn := connection.NewFakeConn()
+	// parser 与 rdb.NewDecoder 都会直接使用传入的 *bufio.Reader, 因此读完 rdb 前导部分后可以继续解析命令
+	reader := bufio.NewReader(utils.NewLimitedReader(file, maxBytes))
+	var base int64 // rdb 前导部分的长度
+	if isRDBPreamble(reader) {
+		base, err = mdb.loadRDBFrom(reader)
+		if err != nil {
+			return &aofLoadError{offset: 0, err: errors.New("illegal rdb preamble: " + err.Error())}
+		}
+	}
+
+	var loadErr *aofLoadError
+	var lastOffset int64 // 上一条命令的结束位置
+	var multiStart int64 // 未完成的事务中 MULTI 的起始位置
+	parser.ParseStream(reader, func(p *parser.Payload) bool {
 		if p.Err != nil {
 			if p.Err == io.EOF {
-				break
+				return false
 			}
-			logger.Error("parse error: " + p.Err.Error())
-			continue
-		}
-		if p.Data == nil {
-			logger.Error("empty payload")
-			continue
+			loadErr = &aofLoadError{
+				offset:    base + p.Offset,
+				truncated: p.Err == io.ErrUnexpectedEOF,
+				err:       p.Err,
+			}
+			return false
 		}
 		r, ok := p.Data.(*reply.MultiBulkReply)
 		if !ok {
-			logger.Error("require multi bulk reply")
-			continue
+			loadErr = &aofLoadError{offset: base + lastOffset, err: errors.New("require multi bulk reply")}
+			return false
 		}
-		cmd := strings.ToLower(string(r.Args[0]))
-		cmdFunc, ok := router[cmd]
-		if ok {
-			cmdFunc(db, r.Args[1:])
+		if !fakeConn.InMultiState() && strings.ToLower(string(r.Args[0])) == "multi" {
+			multiStart = lastOffset
 		}
+		// 加载时不检查副本只读以及 maxmemory 的限制
+		ret := mdb.exec(fakeConn, r.Args)
+		if reply.IsErrorReply(ret) {
+			logger.Error("exec err", string(ret.ToBytes()))
+		}
+		lastOffset = p.Offset
+		return true
+	})
+	if fakeConn.InMultiState() && (loadErr == nil || loadErr.truncated) {
+		// 事务只写入了一部分, 队列中的命令不会执行, 文件需要截断到 MULTI 之前
+		loadErr = &aofLoadError{offset: base + multiStart, truncated: true, err: errors.New("MULTI without EXEC")}
+	}
+	if loadErr != nil {
+		return loadErr
 	}
+	return nil
+}
+
+// handleAofLoadError aof 无法修复时拒绝启动
+func (mdb *MultiDB) handleAofLoadError(err error) {
+	if err = mdb.repairAof(err); err != nil {
+		logger.Fatal(err.Error())
+	}
+}
+
+// repairAof 开启 aof-load-truncated 时截断文件末尾不完整的命令, 其它情况返回无法启动的原因
+func (mdb *MultiDB) repairAof(err error) error {
+	loadErr, ok := err.(*aofLoadError)
+	if ok && loadErr.truncated && config.Properties.AofLoadTruncated {
+		logger.Warn(fmt.Sprintf("!!! %s, truncating the AOF %s at offset %d", err.Error(), mdb.aofFilename, loadErr.offset))
+		if err := os.Truncate(mdb.aofFilename, loadErr.offset); err != nil {
+			return errors.New("failed to truncate AOF: " + err.Error())
+		}
+		return nil
+	}
+	if ok && loadErr.truncated {
+		return errors.New(err.Error() + ". You can: 1) make a backup of your AOF file, then use aofcheck --fix <filename>. " +
+			"2) set aof-load-truncated to yes and restart the server")
+	}
+	return errors.New(err.Error() + ". Make a backup of your AOF file, then use aofcheck --fix <filename>")
+}
+
+var rdbMagic = []byte("REDIS")
+
+// isRDBPreamble 开启 aof-use-rdb-preamble 后重写的 aof 文件以 rdb 快照开头
+func isRDBPreamble(reader *bufio.Reader) bool {
+	header, err := reader.Peek(len(rdbMagic))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(header, rdbMagic)
 }
 
 /* aof rewrite 主要是aof文件很大之后影响读写性能，需要重写aof文件，重写aof文件会简化中间的操作过程，仅保证最终的数据一致 */
-func (db *DB) aofRewrite() {
-	file, fileSize, err := db.startRewrite()
+func (mdb *MultiDB) aofRewrite() {
+	file, fileSize, err := mdb.startRewrite()
 	if err != nil {
 		logger.Warn(err)
 		return
 	}
 
 	// load aof file
-	tmpDB := &DB{
-		data:        Dict.MakeConcurrent(dataDictSize),
-		ttlMap:      Dict.MakeConcurrent(ttlDictSize),
-		locker:      lock.Make(lockerSize),
-		interval:    5 * time.Second,
-		aofFilename: db.aofFilename,
+	tmpDB := makeBasicMultiDB()
+	tmpDB.aofFilename = mdb.aofFilename
+	// maxBytes 为 0 时 loadAof 不限制长度, 重写开始时文件为空则不加载, 否则会重复读入之后追加的命令
+	if fileSize > 0 {
+		err = tmpDB.loadAof(int(fileSize))
+	}
+	if err != nil {
+		logger.Warn("aof rewrite failed: " + err.Error())
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		mdb.abortRewrite()
+		return
 	}
-	tmpDB.loadAof(int(fileSize))
 
 	// rewrite aof file
-	tmpDB.data.ForEach(func(key string, raw interface{}) bool {
-		var cmd *reply.MultiBulkReply
-		entity, _ := raw.(*DataEntity)
-		cmd = EntityToCmd(key, entity)
-		if cmd != nil {
-			_, _ = file.Write(cmd.ToBytes())
+	currentDB := -1
+	if config.Properties.AofUseRdbPreamble {
+		// 以 rdb 快照作为前导, 之后的命令由 finishRewrite 和 handleAof 以 RESP 格式追加
+		err = tmpDB.writeRDB(file)
+		if err != nil {
+			logger.Warn(err)
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+			mdb.abortRewrite()
+			return
 		}
-		return true
-	})
-
-	tmpDB.ttlMap.ForEach(func(key string, raw interface{}) bool {
-		expireTime, _ := raw.(time.Time)
-		cmd := makeExpireCmd(key, expireTime)
-		if cmd != nil {
-			_, _ = file.Write(cmd.ToBytes())
+		mdb.finishRewrite(file, currentDB)
+		return
+	}
+	for i := range tmpDB.dbSet {
+		db := tmpDB.GetDB(i)
+		if db.data.Len() == 0 {
+			continue
 		}
-		return true
-	})
-	db.finishRewrite(file)
+		_, _ = file.Write(makeSelectCmd(i).ToBytes())
+		currentDB = i
+		db.data.ForEach(func(key string, raw interface{}) bool {
+			var cmd *reply.MultiBulkReply
+			entity, _ := raw.(*DataEntity)
+			cmd = EntityToCmd(key, entity)
+			if cmd != nil {
+				_, _ = file.Write(cmd.ToBytes())
+			}
+			return true
+		})
+
+		db.ttlMap.ForEach(func(key string, raw interface{}) bool {
+			expireTime, _ := raw.(time.Time)
+			cmd := makeExpireCmd(key, expireTime)
+			if cmd != nil {
+				_, _ = file.Write(cmd.ToBytes())
+			}
+			return true
+		})
+	}
+	mdb.finishRewrite(file, currentDB)
 }
 
 var setCmd = []byte("SET")
@@ -153,7 +402,7 @@ func persistString(key string, bytes []byte) *reply.MultiBulkReply {
 
 var rPushAllCmd = []byte("RPUSH")
 
-func persistList(key string, list *list.LinkedList) *reply.MultiBulkReply {
+func persistList(key string, list list.List) *reply.MultiBulkReply {
 	args := make([][]byte, 2+list.Len())
 	args[0] = rPushAllCmd
 	args[1] = []byte(key)
@@ -196,6 +445,25 @@ func persistHash(key string, hash dict.Dict) *reply.MultiBulkReply {
 	return reply.MakeMultiBulkReply(args)
 }
 
+var zAddCmd = []byte("ZADD")
+
+func persistSortedSet(key string, sortedSet *SortedSet.SortedSet) *reply.MultiBulkReply {
+	args := make([][]byte, 2+sortedSet.Len()*2)
+	args[0] = zAddCmd
+	args[1] = []byte(key)
+	i := 0
+	size := sortedSet.Len()
+	if size > 0 {
+		sortedSet.ForEachByRank(0, size, false, func(element *SortedSet.Element) bool {
+			args[2+i*2] = formatScore(element.Score)
+			args[3+i*2] = []byte(element.Member)
+			i++
+			return true
+		})
+	}
+	return reply.MakeMultiBulkReply(args)
+}
+
 // serialize data entity to redis command
 func EntityToCmd(key string, entity *DataEntity) *reply.MultiBulkReply {
 	if entity == nil {
@@ -205,35 +473,37 @@ func EntityToCmd(key string, entity *DataEntity) *reply.MultiBulkReply {
 	switch val := entity.Data.(type) {
 	case []byte:
 		cmd = persistString(key, val)
-	case *list.LinkedList:
+	case list.List:
 		cmd = persistList(key, val)
 	case *set.Set:
 		cmd = persistSet(key, val)
 	case dict.Dict:
 		cmd = persistHash(key, val)
+	case *SortedSet.SortedSet:
+		cmd = persistSortedSet(key, val)
 	}
 	return cmd
 }
 
-func (db *DB) startRewrite() (*os.File, int64, error) {
-	db.pausingAof.Lock() // pausing aof
-	defer db.pausingAof.Unlock()
+func (mdb *MultiDB) startRewrite() (*os.File, int64, error) {
+	mdb.pausingAof.Lock() // pausing aof
+	defer mdb.pausingAof.Unlock()
 
-	err := db.aofFile.Sync() // 强制写磁盘
+	err := mdb.aofFile.Sync() // 强制写磁盘
 	if err != nil {
 		logger.Warn("fsync failed")
 		return nil, 0, err
 	}
 
 	// create rewrite channel
-	db.aofRewriteChan = make(chan *reply.MultiBulkReply, aofQueueSize)
+	mdb.aofRewriteChan = make(chan *aofPayload, aofQueueSize)
 
 	// get current aof file size
-	fileInfo, _ := os.Stat(db.aofFilename)
+	fileInfo, _ := os.Stat(mdb.aofFilename)
 	filesize := fileInfo.Size()
 
 	// create tmp file
-	file, err := os.CreateTemp("", "aof")
+	file, err := os.CreateTemp(filepath.Dir(mdb.aofFilename), "temp-*.aof")
 	if err != nil {
 		logger.Warn("tmp file create failed")
 		return nil, 0, err
@@ -241,16 +511,25 @@ func (db *DB) startRewrite() (*os.File, int64, error) {
 	return file, filesize, nil
 }
 
-func (db *DB) finishRewrite(tmpFile *os.File) {
-	db.pausingAof.Lock() // pausing aof
-	defer db.pausingAof.Unlock()
+// abortRewrite 重写失败时停止向 aofRewriteChan 转发命令
+func (mdb *MultiDB) abortRewrite() {
+	mdb.pausingAof.Lock()
+	defer mdb.pausingAof.Unlock()
+	close(mdb.aofRewriteChan)
+	mdb.aofRewriteChan = nil
+}
+
+// finishRewrite currentDB 为重写后文件中最后一次 SELECT 的数据库
+func (mdb *MultiDB) finishRewrite(tmpFile *os.File, currentDB int) {
+	mdb.pausingAof.Lock() // pausing aof
+	defer mdb.pausingAof.Unlock()
 
 	// 将执行rewriteAof过程中接收到的命令写入tmpFile
 loop:
 	for {
 		select {
-		case cmd := <-db.aofRewriteChan:
-			_, err := tmpFile.Write(cmd.ToBytes())
+		case p := <-mdb.aofRewriteChan:
+			_, err := writePayload(tmpFile, p, &currentDB)
 			if err != nil {
 				logger.Warn(err)
 			}
@@ -259,16 +538,26 @@ loop:
 		}
 	}
 
-	close(db.aofRewriteChan)
-	db.aofRewriteChan = nil
+	close(mdb.aofRewriteChan)
+	mdb.aofRewriteChan = nil
+
+	// 新文件替换旧文件前先落盘
+	err := tmpFile.Sync()
+	if err != nil {
+		logger.Warn(err)
+	}
+	_ = tmpFile.Close()
 
 	// rename tmp file
-	_ = db.aofFile.Close()
-	_ = os.Rename(tmpFile.Name(), db.aofFilename)
+	_ = mdb.aofFile.Close()
+	_ = os.Rename(tmpFile.Name(), mdb.aofFilename)
 
-	aofFile, err := os.OpenFile(db.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	aofFile, err := os.OpenFile(mdb.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
 		panic(err)
 	}
-	db.aofFile = aofFile
+	mdb.aofFile = aofFile
+	mdb.aofCurrentDB = currentDB
+	atomic.StoreInt64(&mdb.aofPendingBytes, 0)
+	atomic.StoreInt64(&mdb.aofLastFsync, time.Now().Unix())
 }
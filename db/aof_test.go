@@ -0,0 +1,189 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAofRdbPreamble(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	config.Properties = &config.PropertyHolder{
+		AppendOnly:        true,
+		AppendFilename:    aofFilename,
+		AofUseRdbPreamble: true,
+	}
+	mdb := MakeMultiDB()
+	conn := connection.NewFakeConn()
+	mdb.Exec(conn, toArgs("set", "a", "1"))
+	mdb.Exec(conn, toArgs("select", "1"))
+	mdb.Exec(conn, toArgs("rpush", "list", "x", "y"))
+	mdb.Exec(conn, toArgs("zadd", "zset", "1", "m"))
+	mdb.aofRewrite()
+
+	// 重写后追加的命令以 RESP 格式写在快照之后
+	mdb.Exec(conn, toArgs("rpush", "list", "z"))
+	mdb.Exec(conn, toArgs("select", "0"))
+	mdb.Exec(conn, toArgs("set", "b", "2"))
+	mdb.Close()
+
+	content, err := os.ReadFile(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, []byte("REDIS")) {
+		t.Fatalf("aof file should start with rdb preamble")
+	}
+
+	loaded := MakeMultiDB()
+	defer loaded.Close()
+	conn = connection.NewFakeConn()
+	if r := loaded.Exec(conn, toArgs("mget", "a", "b")); string(r.ToBytes()) != "*2\r\n$1\r\n1\r\n$1\r\n2\r\n" {
+		t.Errorf("unexpected mget result: %q", r.ToBytes())
+	}
+	conn.SelectDB(1)
+	if r := loaded.Exec(conn, toArgs("lrange", "list", "0", "-1")); string(r.ToBytes()) != "*3\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nz\r\n" {
+		t.Errorf("unexpected list: %q", r.ToBytes())
+	}
+	if r := loaded.Exec(conn, toArgs("zscore", "zset", "m")); string(r.ToBytes()) != "$1\r\n1\r\n" {
+		t.Errorf("unexpected zscore: %q", r.ToBytes())
+	}
+}
+
+func TestAppendFsyncAlways(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	config.Properties = &config.PropertyHolder{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    FsyncAlways,
+	}
+	mdb := MakeMultiDB()
+	defer mdb.Close()
+	conn := connection.NewFakeConn()
+	mdb.Exec(conn, toArgs("set", "a", "1"))
+
+	// always 模式下命令返回时已经写入并落盘
+	content, err := os.ReadFile(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(content, []byte("$1\r\na\r\n$1\r\n1\r\n")) {
+		t.Errorf("command should be in aof file when reply is returned, got %q", content)
+	}
+	info := string(mdb.Exec(conn, toArgs("info", "persistence")).ToBytes())
+	if !strings.Contains(info, "aof_fsync_policy:always") || !strings.Contains(info, "aof_pending_bytes:0") {
+		t.Errorf("unexpected info: %q", info)
+	}
+}
+
+// 过期时间以绝对时间写入 aof, 重启以及重写之后保持不变
+func TestAofExpire(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	config.Properties = &config.PropertyHolder{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+	}
+	mdb := MakeMultiDB()
+	conn := connection.NewFakeConn()
+	mdb.Exec(conn, toArgs("set", "a", "1"))
+	mdb.Exec(conn, toArgs("expire", "a", "100"))
+	mdb.Exec(conn, toArgs("set", "b", "2", "PX", "100000"))
+	expected := make(map[string]time.Time)
+	for _, key := range []string{"a", "b"} {
+		expected[key], _ = mdb.GetDB(0).ExpireTime(key)
+	}
+	mdb.Close()
+
+	for _, rewrite := range []bool{false, true} {
+		loaded := MakeMultiDB()
+		if rewrite {
+			loaded.aofRewrite()
+		}
+		for key, expireTime := range expected {
+			actual, ok := loaded.GetDB(0).ExpireTime(key)
+			if !ok || actual.UnixMilli() != expireTime.UnixMilli() {
+				t.Errorf("rewrite %v: expire time of %s should be %v, got %v", rewrite, key, expireTime, actual)
+			}
+		}
+		loaded.Close()
+	}
+}
+
+func TestAofLoadTruncated(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	valid := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n" +
+		"*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n*1\r\n$4\r\nEXEC\r\n"
+	// 末尾有一个不完整的事务以及写了一半的命令
+	tail := "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$1\r\n3\r\n*3\r\n$3\r\nSET\r\n$1\r\nd"
+	if err := os.WriteFile(aofFilename, []byte(valid+tail), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Properties = &config.PropertyHolder{
+		AppendOnly:       true,
+		AppendFilename:   aofFilename,
+		AofLoadTruncated: true,
+	}
+	mdb := MakeMultiDB()
+	conn := connection.NewFakeConn()
+	if r := mdb.Exec(conn, toArgs("mget", "a", "b", "c")); string(r.ToBytes()) != "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$-1\r\n" {
+		t.Errorf("unexpected mget result: %q", r.ToBytes())
+	}
+	mdb.Exec(conn, toArgs("set", "e", "5"))
+	mdb.Close()
+
+	content, err := os.ReadFile(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, []byte(valid+"*2\r\n$6\r\nSELECT")) {
+		t.Errorf("file should be truncated to the last valid command, got %q", content)
+	}
+}
+
+func TestAofLoadTruncatedDisabled(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	content := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*3\r\n$3\r\nSET\r\n$1\r\nb"
+	if err := os.WriteFile(aofFilename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Properties = &config.PropertyHolder{
+		AppendOnly:       true,
+		AppendFilename:   aofFilename,
+		AofLoadTruncated: false,
+	}
+	mdb := makeBasicMultiDB()
+	mdb.aofFilename = aofFilename
+	err := mdb.repairAof(mdb.loadAof(0))
+	if err == nil || !strings.Contains(err.Error(), "aof-load-truncated") {
+		t.Errorf("expected refusing to start, got %v", err)
+	}
+	if raw, _ := os.ReadFile(aofFilename); string(raw) != content {
+		t.Errorf("aof file should not be modified, got %q", raw)
+	}
+}
+
+func TestAofLoadCorrupted(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	valid := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	corrupted := valid + "*3\r\n$3\r\nSET\r\n$x\r\nb\r\n$1\r\n2\r\n" + valid
+	if err := os.WriteFile(aofFilename, []byte(corrupted), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Properties = &config.PropertyHolder{
+		AppendOnly:       true,
+		AppendFilename:   aofFilename,
+		AofLoadTruncated: true,
+	}
+	mdb := makeBasicMultiDB()
+	mdb.aofFilename = aofFilename
+	err := mdb.loadAof(0)
+	loadErr, ok := err.(*aofLoadError)
+	if !ok || loadErr.truncated || loadErr.offset != int64(len(valid)) {
+		t.Errorf("expected corruption at offset %d, got %v", len(valid), err)
+	}
+}
@@ -0,0 +1,371 @@
+package db
+
+import (
+	List "redisGo/datastruct/list"
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 阻塞的列表命令: BLPOP, BRPOP, BLMOVE, BRPOPLPUSH.
+// 所有列表都为空时, 客户端在持有 key 锁的情况下登记到这些 key 的等待队列中, 释放锁之后等待.
+// 写命令释放锁之后调用 ServeBlocked, 按照阻塞的先后顺序为等待的客户端弹出元素并把结果交给它们.
+// 写入 AOF 和复制流的是实际生效的非阻塞命令 (LPOP, RPOP, LMOVE, RPOPLPUSH)
+
+// blockingOp 解析后的阻塞命令
+type blockingOp struct {
+	keys    []string      // 依次尝试的源 key
+	dest    string        // BLMOVE 和 BRPOPLPUSH 的目标 key
+	timeout time.Duration // 0 表示一直等待
+	// pop 从 key 中弹出元素并写入 aof, 列表不存在时返回 nil
+	pop  func(db *DB, key string) (redis.Reply, reply.ErrorReply)
+	null redis.Reply // 超时的回复
+}
+
+func isBlockingCommand(cmd string) bool {
+	switch cmd {
+	case "blpop", "brpop", "brpoplpush", "blmove":
+		return true
+	}
+	return false
+}
+
+func parseTimeout(arg []byte) (time.Duration, reply.ErrorReply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, reply.MakeErrReply("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parseBlockingOp args 不包括命令名
+func parseBlockingOp(cmd string, args [][]byte) (*blockingOp, reply.ErrorReply) {
+	switch cmd {
+	case "blpop", "brpop":
+		if len(args) < 2 {
+			return nil, reply.MakeErrReply("ERR wrong number of arguments for '" + cmd + "' command")
+		}
+		timeout, errReply := parseTimeout(args[len(args)-1])
+		if errReply != nil {
+			return nil, errReply
+		}
+		keys := make([]string, len(args)-1)
+		for i := range keys {
+			keys[i] = string(args[i])
+		}
+		fromLeft := cmd == "blpop"
+		return &blockingOp{
+			keys:    keys,
+			timeout: timeout,
+			pop: func(db *DB, key string) (redis.Reply, reply.ErrorReply) {
+				return db.popFrom(key, fromLeft)
+			},
+			null: &reply.NullMultiBulkReply{},
+		}, nil
+	case "brpoplpush":
+		if len(args) != 3 {
+			return nil, reply.MakeErrReply("ERR wrong number of arguments for 'brpoplpush' command")
+		}
+		timeout, errReply := parseTimeout(args[2])
+		if errReply != nil {
+			return nil, errReply
+		}
+		return makeMoveOp(args[0], args[1], false, true, timeout, makeAofCmd("rpoplpush", args[:2])), nil
+	case "blmove":
+		if len(args) != 5 {
+			return nil, reply.MakeErrReply("ERR wrong number of arguments for 'blmove' command")
+		}
+		fromLeft, ok1 := parseDirection(args[2])
+		toLeft, ok2 := parseDirection(args[3])
+		if !ok1 || !ok2 {
+			return nil, &reply.SyntaxErrReply{}
+		}
+		timeout, errReply := parseTimeout(args[4])
+		if errReply != nil {
+			return nil, errReply
+		}
+		return makeMoveOp(args[0], args[1], fromLeft, toLeft, timeout, makeAofCmd("lmove", args[:4])), nil
+	}
+	return nil, reply.MakeErrReply("ERR unknown command `" + cmd + "`")
+}
+
+func makeMoveOp(src []byte, dst []byte, fromLeft bool, toLeft bool, timeout time.Duration, aofCmd *reply.MultiBulkReply) *blockingOp {
+	return &blockingOp{
+		keys:    []string{string(src)},
+		dest:    string(dst),
+		timeout: timeout,
+		pop: func(db *DB, key string) (redis.Reply, reply.ErrorReply) {
+			val, errReply := db.lmove(key, string(dst), fromLeft, toLeft)
+			if errReply != nil || val == nil {
+				return nil, errReply
+			}
+			db.AddAof(aofCmd)
+			return reply.MakeBulkReply(val), nil
+		},
+		null: &reply.NullBulkReply{},
+	}
+}
+
+// popFrom BLPOP 和 BRPOP 弹出一个元素, 返回 [key, value]
+func (db *DB) popFrom(key string, fromLeft bool) (redis.Reply, reply.ErrorReply) {
+	list, errReply := db.getAsList(key)
+	if errReply != nil || list == nil {
+		return nil, errReply
+	}
+	val := db.popN(key, list, 1, fromLeft)[0]
+	aofCmd := "rpop"
+	if fromLeft {
+		aofCmd = "lpop"
+	}
+	db.AddAof(makeAofCmd(aofCmd, [][]byte{[]byte(key)}))
+	return reply.MakeMultiBulkReply([][]byte{[]byte(key), val}), nil
+}
+
+// lockKeys 执行时需要加锁的 key
+func (op *blockingOp) lockKeys() []string {
+	if op.dest == "" {
+		return op.keys
+	}
+	return append([]string{op.dest}, op.keys...)
+}
+
+// try 依次尝试每个 key, 都为空时返回 nil
+func (op *blockingOp) try(db *DB) redis.Reply {
+	for _, key := range op.keys {
+		r, errReply := op.pop(db, key)
+		if errReply != nil {
+			return errReply
+		}
+		if r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// execBlockingOnce MULTI 中的阻塞命令不会阻塞, 列表都为空时直接返回 nil
+func execBlockingOnce(db *DB, cmd string, args [][]byte) redis.Reply {
+	op, errReply := parseBlockingOp(cmd, args)
+	if errReply != nil {
+		return errReply
+	}
+	if r := op.try(db); r != nil {
+		return r
+	}
+	return op.null
+}
+
+func BLPop(db *DB, args [][]byte) redis.Reply {
+	return execBlockingOnce(db, "blpop", args)
+}
+
+func BRPop(db *DB, args [][]byte) redis.Reply {
+	return execBlockingOnce(db, "brpop", args)
+}
+
+func BRPopLPush(db *DB, args [][]byte) redis.Reply {
+	return execBlockingOnce(db, "brpoplpush", args)
+}
+
+func BLMove(db *DB, args [][]byte) redis.Reply {
+	return execBlockingOnce(db, "blmove", args)
+}
+
+// blockedClient 阻塞中的客户端
+type blockedClient struct {
+	conn   redis.Connection
+	op     *blockingOp
+	queued bool             // 是否仍在等待队列中, 由 blockingQueue.mu 保护
+	result chan redis.Reply // 由将其移出等待队列的一方写入
+}
+
+// blockingQueue 各 key 上阻塞的客户端, 按照阻塞的先后顺序排列
+type blockingQueue struct {
+	mu      sync.Mutex
+	waiters map[string]*List.LinkedList // key -> *blockedClient
+	clients map[redis.Connection]*blockedClient
+}
+
+func makeBlockingQueue() *blockingQueue {
+	return &blockingQueue{
+		waiters: make(map[string]*List.LinkedList),
+		clients: make(map[redis.Connection]*blockedClient),
+	}
+}
+
+func (q *blockingQueue) add(c redis.Connection, op *blockingOp) *blockedClient {
+	w := &blockedClient{
+		conn:   c,
+		op:     op,
+		queued: true,
+		result: make(chan redis.Reply, 1),
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, key := range op.keys {
+		waiters, ok := q.waiters[key]
+		if !ok {
+			waiters = List.Make()
+			q.waiters[key] = waiters
+		}
+		if !waiters.Contains(w) {
+			waiters.Add(w)
+		}
+	}
+	if c != nil {
+		q.clients[c] = w
+	}
+	return w
+}
+
+// first 返回最早阻塞在 key 上的客户端
+func (q *blockingQueue) first(key string) *blockedClient {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiters, ok := q.waiters[key]
+	if !ok {
+		return nil
+	}
+	return waiters.Get(0).(*blockedClient)
+}
+
+// remove 将客户端移出等待队列, 已经被移出时返回 false
+func (q *blockingQueue) remove(w *blockedClient) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !w.queued {
+		return false
+	}
+	w.queued = false
+	for _, key := range w.op.keys {
+		if waiters, ok := q.waiters[key]; ok {
+			waiters.RemoveAllByVal(w)
+			if waiters.Len() == 0 {
+				delete(q.waiters, key)
+			}
+		}
+	}
+	if w.conn != nil && q.clients[w.conn] == w {
+		delete(q.clients, w.conn)
+	}
+	return true
+}
+
+// cancel 连接关闭时唤醒阻塞的客户端
+func (q *blockingQueue) cancel(c redis.Connection) {
+	q.mu.Lock()
+	w, ok := q.clients[c]
+	q.mu.Unlock()
+	if ok && q.remove(w) {
+		w.result <- w.op.null
+	}
+}
+
+// execBlocking 在 MULTI 之外执行阻塞命令
+func (db *DB) execBlocking(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	op, errReply := parseBlockingOp(strings.ToLower(string(cmdLine[0])), cmdLine[1:])
+	if errReply != nil {
+		return errReply
+	}
+	lockKeys := op.lockKeys()
+	db.execMu.RLock()
+	db.Locks(lockKeys...)
+	r := op.try(db)
+	var w *blockedClient
+	if r != nil {
+		db.AddVersion(lockKeys...)
+		db.updateSizes(lockKeys...)
+	} else {
+		// 持有锁时登记, 不会错过之后的写入
+		w = db.blocking.add(c, op)
+	}
+	db.Unlocks(lockKeys...)
+	db.execMu.RUnlock()
+	if r != nil {
+		if op.dest != "" {
+			db.ServeBlocked(op.dest)
+		}
+		return r
+	}
+
+	var timeout <-chan time.Time
+	if op.timeout > 0 {
+		timer := time.NewTimer(op.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var closed <-chan struct{}
+	if c != nil {
+		closed = c.Closed()
+	}
+	select {
+	case r := <-w.result:
+		return r
+	case <-timeout:
+	case <-closed:
+	}
+	if db.blocking.remove(w) {
+		return op.null
+	}
+	// 已经被写命令移出等待队列, 结果马上就会写入
+	return <-w.result
+}
+
+// ServeBlocked 按照阻塞的先后顺序为等待 keys 的客户端弹出元素, 调用者不能持有这些 key 的锁
+func (db *DB) ServeBlocked(keys ...string) {
+	for _, key := range keys {
+		for db.serveFirst(key) {
+		}
+	}
+}
+
+// serveFirst 为最早阻塞在 key 上的客户端弹出元素, 返回是否应当继续服务下一个客户端
+func (db *DB) serveFirst(key string) bool {
+	w := db.blocking.first(key)
+	if w == nil {
+		return false
+	}
+	lockKeys := w.op.lockKeys()
+	db.execMu.RLock()
+	db.Locks(lockKeys...)
+	// key 不是非空的列表时客户端继续阻塞
+	if list, errReply := db.getAsList(key); errReply != nil || list == nil {
+		db.Unlocks(lockKeys...)
+		db.execMu.RUnlock()
+		return false
+	}
+	if !db.blocking.remove(w) {
+		// 客户端已经超时
+		db.Unlocks(lockKeys...)
+		db.execMu.RUnlock()
+		return true
+	}
+	// 目标 key 不是列表时客户端收到错误并结束阻塞, 元素留在源列表中交给下一个客户端
+	if w.op.dest != "" {
+		if _, errReply := db.getAsList(w.op.dest); errReply != nil {
+			db.Unlocks(lockKeys...)
+			db.execMu.RUnlock()
+			w.result <- errReply
+			return true
+		}
+	}
+	r, errReply := w.op.pop(db, key)
+	if errReply != nil {
+		r = errReply
+	}
+	db.AddVersion(lockKeys...)
+	db.updateSizes(lockKeys...)
+	db.Unlocks(lockKeys...)
+	db.execMu.RUnlock()
+	w.result <- r
+	if w.op.dest != "" {
+		db.ServeBlocked(w.op.dest)
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/redis/connection"
+	"redisGo/redis/reply"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForBlocked 等待 key 上阻塞的客户端数量达到 n
+func waitForBlocked(t *testing.T, db *DB, key string, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		db.blocking.mu.Lock()
+		waiters, ok := db.blocking.waiters[key]
+		count := 0
+		if ok {
+			count = waiters.Len()
+		}
+		db.blocking.mu.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d clients blocked on %s", n, key)
+}
+
+// execAsync 在另一个 goroutine 中执行命令
+func execAsync(db *DB, c redis.Connection, args ...string) <-chan redis.Reply {
+	result := make(chan redis.Reply, 1)
+	go func() {
+		result <- db.Exec(c, toArgs(args...))
+	}()
+	return result
+}
+
+func TestBlockingPop(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	var mu sync.Mutex
+	aof := make([]string, 0)
+	db.addAof = func(_ int, cmdLines ...*reply.MultiBulkReply) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, cmdLine := range cmdLines {
+			aof = append(aof, string(cmdLine.ToBytes()))
+		}
+	}
+
+	// 按照阻塞的先后顺序被唤醒
+	first := execAsync(db, connection.NewFakeConn(), "blpop", "other", "list", "0")
+	waitForBlocked(t, db, "list", 1)
+	second := execAsync(db, connection.NewFakeConn(), "brpop", "list", "0")
+	waitForBlocked(t, db, "list", 2)
+	db.Exec(nil, toArgs("rpush", "list", "a", "b"))
+	if r := <-first; string(r.ToBytes()) != "*2\r\n$4\r\nlist\r\n$1\r\na\r\n" {
+		t.Errorf("unexpected blpop result: %q", r.ToBytes())
+	}
+	if r := <-second; string(r.ToBytes()) != "*2\r\n$4\r\nlist\r\n$1\r\nb\r\n" {
+		t.Errorf("unexpected brpop result: %q", r.ToBytes())
+	}
+	waitForBlocked(t, db, "other", 0)
+	if _, exists := db.Get("list"); exists {
+		t.Errorf("empty list should be removed")
+	}
+
+	// aof 中记录的是实际执行的非阻塞命令
+	mu.Lock()
+	logged := strings.Join(aof, "")
+	mu.Unlock()
+	expected := string(makeAofCmd("rpush", toArgs("list", "a", "b")).ToBytes()) +
+		string(makeAofCmd("lpop", toArgs("list")).ToBytes()) +
+		string(makeAofCmd("rpop", toArgs("list")).ToBytes())
+	if logged != expected {
+		t.Errorf("unexpected aof: %q", logged)
+	}
+
+	// 列表不为空时直接返回
+	db.Exec(nil, toArgs("rpush", "list", "c"))
+	if r := db.Exec(connection.NewFakeConn(), toArgs("blpop", "list", "0")); string(r.ToBytes()) != "*2\r\n$4\r\nlist\r\n$1\r\nc\r\n" {
+		t.Errorf("unexpected blpop result: %q", r.ToBytes())
+	}
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	conn := connection.NewFakeConn()
+	start := time.Now()
+	if r := db.Exec(conn, toArgs("blpop", "list", "0.05")); string(r.ToBytes()) != string((&reply.NullMultiBulkReply{}).ToBytes()) {
+		t.Errorf("expected null multi bulk, got %q", r.ToBytes())
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("blpop returned before timeout")
+	}
+	if r := db.Exec(conn, toArgs("brpoplpush", "src", "dst", "0.01")); string(r.ToBytes()) != "$-1\r\n" {
+		t.Errorf("expected null bulk, got %q", r.ToBytes())
+	}
+	waitForBlocked(t, db, "list", 0)
+
+	if r := db.Exec(conn, toArgs("blpop", "list", "-1")); string(r.ToBytes()) != "-ERR timeout is negative\r\n" {
+		t.Errorf("unexpected reply: %q", r.ToBytes())
+	}
+	if r := db.Exec(conn, toArgs("blpop", "list", "abc")); string(r.ToBytes()) != "-ERR timeout is not a float or out of range\r\n" {
+		t.Errorf("unexpected reply: %q", r.ToBytes())
+	}
+	db.Exec(conn, toArgs("set", "str", "1"))
+	if r := db.Exec(conn, toArgs("blpop", "list", "str", "0")); !strings.HasPrefix(string(r.ToBytes()), "-WRONGTYPE") {
+		t.Errorf("expected wrongtype error, got %q", r.ToBytes())
+	}
+
+	// MULTI 中不会阻塞
+	db.Exec(conn, toArgs("multi"))
+	db.Exec(conn, toArgs("blpop", "list", "0"))
+	if r := db.Exec(conn, toArgs("exec")); string(r.ToBytes()) != "*1\r\n*-1\r\n" {
+		t.Errorf("unexpected exec result: %q", r.ToBytes())
+	}
+}
+
+func TestBlockingMove(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+
+	// 移入 dst 的元素继续唤醒阻塞在 dst 上的客户端
+	move := execAsync(db, connection.NewFakeConn(), "blmove", "src", "dst", "RIGHT", "LEFT", "0")
+	waitForBlocked(t, db, "src", 1)
+	pop := execAsync(db, connection.NewFakeConn(), "blpop", "dst", "0")
+	waitForBlocked(t, db, "dst", 1)
+	db.Exec(nil, toArgs("lpush", "src", "x"))
+	if r := <-move; string(r.ToBytes()) != "$1\r\nx\r\n" {
+		t.Errorf("unexpected blmove result: %q", r.ToBytes())
+	}
+	if r := <-pop; string(r.ToBytes()) != "*2\r\n$3\r\ndst\r\n$1\r\nx\r\n" {
+		t.Errorf("unexpected blpop result: %q", r.ToBytes())
+	}
+
+	// LMOVE 唤醒阻塞在目标 key 上的客户端
+	pop = execAsync(db, connection.NewFakeConn(), "brpop", "dst", "0")
+	waitForBlocked(t, db, "dst", 1)
+	db.Exec(nil, toArgs("rpush", "src", "y"))
+	db.Exec(nil, toArgs("lmove", "src", "dst", "LEFT", "LEFT"))
+	if r := <-pop; string(r.ToBytes()) != "*2\r\n$3\r\ndst\r\n$1\r\ny\r\n" {
+		t.Errorf("unexpected brpop result: %q", r.ToBytes())
+	}
+}
+
+// 阻塞期间目标 key 变成其它类型时客户端收到 WRONGTYPE, 元素不会丢失
+func TestBlockingMoveWrongType(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+
+	move := execAsync(db, connection.NewFakeConn(), "blmove", "src", "dst", "LEFT", "RIGHT", "0")
+	waitForBlocked(t, db, "src", 1)
+	popPush := execAsync(db, connection.NewFakeConn(), "brpoplpush", "src", "dst", "0")
+	waitForBlocked(t, db, "src", 2)
+	pop := execAsync(db, connection.NewFakeConn(), "blpop", "src", "0")
+	waitForBlocked(t, db, "src", 3)
+	db.Exec(nil, toArgs("set", "dst", "str"))
+	db.Exec(nil, toArgs("rpush", "src", "x"))
+	for name, result := range map[string]<-chan redis.Reply{"blmove": move, "brpoplpush": popPush} {
+		if r := <-result; !strings.HasPrefix(string(r.ToBytes()), "-WRONGTYPE") {
+			t.Errorf("%s: expected wrongtype error, got %q", name, r.ToBytes())
+		}
+	}
+	// 元素留给之后阻塞的客户端
+	if r := <-pop; string(r.ToBytes()) != "*2\r\n$3\r\nsrc\r\n$1\r\nx\r\n" {
+		t.Errorf("unexpected blpop result: %q", r.ToBytes())
+	}
+	assertReply(t, db, "$3\r\nstr\r\n", "get", "dst")
+	waitForBlocked(t, db, "src", 0)
+}
+
+func TestBlockingClientClose(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	mdb := MakeMultiDB()
+	defer mdb.Close()
+	conn := connection.NewFakeConn()
+	result := make(chan redis.Reply, 1)
+	go func() {
+		result <- mdb.Exec(conn, toArgs("blpop", "list", "0"))
+	}()
+	db := mdb.GetDB(0)
+	waitForBlocked(t, db, "list", 1)
+	mdb.AfterClientClose(conn)
+	select {
+	case r := <-result:
+		if string(r.ToBytes()) != string((&reply.NullMultiBulkReply{}).ToBytes()) {
+			t.Errorf("expected null multi bulk, got %q", r.ToBytes())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked client should be woken up after close")
+	}
+	waitForBlocked(t, db, "list", 0)
+
+	// 关闭的客户端不会再消费之后写入的元素
+	mdb.Exec(connection.NewFakeConn(), toArgs("rpush", "list", "a"))
+	if r := mdb.Exec(connection.NewFakeConn(), toArgs("llen", "list")); string(r.ToBytes()) != ":1\r\n" {
+		t.Errorf("unexpected llen: %q", r.ToBytes())
+	}
+}
@@ -2,24 +2,26 @@ package db
 
 import (
 	"fmt"
-	"os"
-	"redisGo/config"
 	Dict "redisGo/datastruct/dict"
 	"redisGo/datastruct/lock"
 	"redisGo/interface/dict"
 	"redisGo/interface/redis"
 	"redisGo/lib/logger"
-	"redisGo/lib/timewheel"
-	"redisGo/pubsub"
 	"redisGo/redis/reply"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type DataEntity struct {
 	Data interface{}
+
+	// 以下字段由 DB 维护, 需要原子访问
+	lru  int64  // 最近一次访问的 unix 毫秒时间戳, 用于 LRU 淘汰和 OBJECT IDLETIME
+	lfu  uint64 // 高位为最近一次访问的时间 (分钟), 低 8 位为对数访问计数, 用于 LFU 淘汰和 OBJECT FREQ
+	size int64  // 已计入 DB.used 的估算内存
 }
 
 const (
@@ -32,23 +34,37 @@ const (
 type cmdFunc func(db *DB, args [][]byte) redis.Reply
 
 type DB struct {
+	used     int64 // 所有 key 的估算内存, 用于 maxmemory
+	index    int   // 在 MultiDB 中的下标, 写 aof 时用于生成 SELECT
 	data     dict.Dict
 	ttlMap   dict.Dict
 	locker   *lock.LockMap
 	interval time.Duration
 
-	hub *pubsub.Hub
+	// key -> version(uint32), 每次写入 key 时版本号加一, 用于 WATCH 检查 key 是否被修改
+	versionMap dict.Dict
+	// 普通写命令持有读锁, EXEC 持有写锁, 保证事务中的命令在 AOF 中是连续的
+	execMu sync.RWMutex
+	// EXEC 执行期间事务命令产生的 aof 先暂存于此, 结束后作为 MULTI ... EXEC 整体写入
+	txAofBuffer []*reply.MultiBulkReply
 
 	stopWorld sync.WaitGroup // DB 的全局锁，在某些场景下单独对某个key加锁是不够的
 
-	// main goroutine send command to aof goroutine through aofChan
-	aofChan     chan *reply.MultiBulkReply
-	aofFile     *os.File
-	aofFilename string
-	aofFinished chan struct{} // aof goroutine will send msg when aof finished
-
-	aofRewriteChan chan *reply.MultiBulkReply
-	pausingAof     sync.RWMutex
+	// 阻塞在 BLPOP 等命令上的客户端
+	blocking *blockingQueue
+
+	// 由 MultiDB 设置, 将命令连同 db 下标发送给 aof goroutine
+	addAof func(index int, cmdLines ...*reply.MultiBulkReply)
+	// 由 MultiDB 设置, 副本不主动删除过期的 key
+	isReplica func() bool
+	// 由 MultiDB 设置, 向频道发布键空间通知
+	publish func(channel string, message string)
+	// 由 MultiDB 设置, 事务中的 MOVE 据此找到目标数据库
+	dbByIndex func(raw []byte) (*DB, reply.ErrorReply)
+
+	// 创建时从配置中解析, 避免每次读写 key 时重新解析
+	notifyFlags    int    // notify-keyspace-events
+	evictionPolicy string // maxmemory-policy
 }
 
 /*
@@ -68,41 +84,26 @@ type DB struct {
 
 var router = makeRouter()
 
+// MakeDB 创建一个不带持久化的 DB, 持久化由 MultiDB 负责
 func MakeDB() *DB {
 	db := &DB{
-		data:     Dict.MakeConcurrent(dataDictSize),
-		ttlMap:   Dict.MakeConcurrent(ttlDictSize),
-		locker:   lock.Make(lockerSize),
-		interval: 5 * time.Second,
-		hub:      pubsub.MakeHub(),
-	}
-
-	if config.Properties.AppendOnly {
-		db.aofFilename = config.Properties.AppendFilename
-		db.loadAof(0)
-		aofFile, err := os.OpenFile(db.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
-		if err != nil {
-			logger.Warn(err)
-		} else {
-			db.aofFile = aofFile
-			db.aofChan = make(chan *reply.MultiBulkReply, aofQueueSize)
-		}
-		go func() {
-			db.handleAof()
-		}()
+		data:       Dict.MakeConcurrent(dataDictSize),
+		ttlMap:     Dict.MakeConcurrent(ttlDictSize),
+		locker:     lock.Make(lockerSize),
+		interval:   5 * time.Second,
+		versionMap: Dict.MakeConcurrent(dataDictSize),
+		blocking:   makeBlockingQueue(),
+		addAof:     func(int, ...*reply.MultiBulkReply) {},
+		isReplica:  func() bool { return false },
+		publish:    func(string, string) {},
+
+		notifyFlags:    notifyKeyspaceEvents(),
+		evictionPolicy: maxMemoryPolicy(),
 	}
 	return db
 }
 
-func (db *DB) Close() {
-	if db.aofFile != nil {
-		err := db.aofFile.Close()
-		if err != nil {
-			logger.Warn(err)
-		}
-	}
-}
-
+// Exec 在当前 DB 中执行命令, SELECT, PUBLISH 等跨 DB 的命令由 MultiDB 处理
 func (db *DB) Exec(c redis.Connection, args [][]byte) (result redis.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -113,31 +114,79 @@ func (db *DB) Exec(c redis.Connection, args [][]byte) (result redis.Reply) {
 
 	cmd := strings.ToLower(string(args[0]))
 
-	if cmd == "subscribe" {
+	if cmd == "multi" {
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return StartMulti(c)
+	} else if cmd == "discard" {
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return DiscardMulti(c)
+	} else if cmd == "exec" {
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return execMulti(db, c)
+	} else if cmd == "watch" {
 		if len(args) < 2 {
-			return &reply.ArgNumErrReply{Cmd: "subscribe"}
+			return &reply.ArgNumErrReply{Cmd: cmd}
 		}
-		return pubsub.Subscribe(db.hub, c, args[1:])
-	} else if cmd == "unsubscribe" {
-		return pubsub.UnSubscribe(db.hub, c, args[1:])
-	} else if cmd == "publish" {
-		return pubsub.Publish(db.hub, args[1:])
-	} else if cmd == "bgrewriteaof" {
-		return BGRewriteAOF(db, args[1:])
+		return Watch(db, c, args[1:])
+	} else if cmd == "unwatch" {
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return UnWatch(c)
+	}
+	if c != nil && c.InMultiState() {
+		return EnqueueCmd(c, args)
+	}
+	if isBlockingCommand(cmd) {
+		return db.execBlocking(c, args)
 	}
-	cmdFunc, ok := router[cmd]
+	return db.execNormalCommand(args)
+}
+
+// execNormalCommand 根据命令声明的 key 加锁后执行命令
+func (db *DB) execNormalCommand(cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := router[cmdName]
 	if !ok {
-		return reply.MakeErrReply("ERR unknown command `" + cmd + "`")
+		return reply.MakeErrReply("ERR unknown command `" + cmdName + "`")
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	// 释放锁之后再为阻塞在这些 key 上的客户端弹出元素
+	defer db.ServeBlocked(write...)
+	if cmd.flags&flagReadOnly == 0 {
+		db.execMu.RLock()
+		defer db.execMu.RUnlock()
 	}
-	if len(args) > 1 {
-		result = cmdFunc(db, args[1:])
-	} else {
-		result = cmdFunc(db, [][]byte{})
+	db.RWLocks(write, read)
+	defer db.RWUnLocks(write, read)
+	db.AddVersion(write...)
+	result := cmd.executor(db, cmdLine[1:])
+	db.updateSizes(write...)
+	return result
+}
+
+// ExecWithLock 执行命令, 调用者需要已经持有相关 key 的锁, 如 EXEC 和集群事务的回滚
+func (db *DB) ExecWithLock(cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := router[cmdName]
+	if !ok {
+		return reply.MakeErrReply("ERR unknown command `" + cmdName + "`")
 	}
-	return
+	write, _ := cmd.prepare(cmdLine[1:])
+	result := cmd.executor(db, cmdLine[1:])
+	db.updateSizes(write...)
+	return result
 }
 
 /* ---- Data Access ---- */
+
+// Get 返回未过期的 key 并记录一次访问, 已过期的 key 会被删除, 调用者需要持有 key 的锁
 func (db *DB) Get(key string) (*DataEntity, bool) {
 	db.stopWorld.Wait()
 
@@ -146,40 +195,100 @@ func (db *DB) Get(key string) (*DataEntity, bool) {
 		return nil, false
 	}
 	if db.IsExpired(key) {
+		db.expireLazily(key)
 		return nil, false
 	}
 	entity, _ := raw.(*DataEntity)
+	entity.touch(time.Now(), isLFUPolicy(db.evictionPolicy))
 	return entity, true
 }
 
+// Peek 与 Get 相同但不记录访问也不删除过期的 key, 用于 OBJECT 等只查看 key 信息的命令, 以及没有持有 key 锁的调用者
+func (db *DB) Peek(key string) (*DataEntity, bool) {
+	db.stopWorld.Wait()
+
+	raw, exists := db.data.Get(key)
+	if !exists {
+		return nil, false
+	}
+	if db.IsExpired(key) {
+		return nil, false
+	}
+	entity, _ := raw.(*DataEntity)
+	return entity, true
+}
+
+// getRaw 返回 key 对应的 entity, 不检查是否过期
+func (db *DB) getRaw(key string) *DataEntity {
+	raw, _ := db.data.Get(key)
+	entity, _ := raw.(*DataEntity)
+	return entity
+}
+
+// dropExpired 清除已过期但还没有删除的 key, 使写入时将其视为不存在. 在 EXEC 中或副本上访问过期的 key 时不会删除,
+// 不清除的话写入的新值会继承旧的过期时间而立即过期. 不写入 DEL, 随后的写命令会被传播, 调用者持有 key 的写锁
+func (db *DB) dropExpired(key string) {
+	if db.IsExpired(key) {
+		db.removeData(key)
+		db.ttlMap.Remove(key)
+	}
+}
+
 func (db *DB) Put(key string, entity *DataEntity) int {
 	db.stopWorld.Wait()
-	return db.data.Put(key, entity)
+	db.dropExpired(key)
+	old := db.getRaw(key)
+	result := db.data.Put(key, entity)
+	db.accountPut(key, entity, old)
+	if old == nil {
+		db.notify(notifyNew, "new", key)
+	}
+	return result
 }
 
 func (db *DB) PutIfExists(key string, entity *DataEntity) int {
 	db.stopWorld.Wait()
-	return db.data.PutIfExists(key, entity)
+	db.dropExpired(key)
+	old := db.getRaw(key)
+	result := db.data.PutIfExists(key, entity)
+	if result > 0 {
+		db.accountPut(key, entity, old)
+	}
+	return result
 }
 
 func (db *DB) PutIfAbsent(key string, entity *DataEntity) int {
 	db.stopWorld.Wait()
-	return db.data.PutIfAbsent(key, entity)
+	db.dropExpired(key)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.accountPut(key, entity, nil)
+		db.notify(notifyNew, "new", key)
+	}
+	return result
 }
 
 func (db *DB) Remove(key string) {
 	db.stopWorld.Wait()
-	db.data.Remove(key)
+	db.removeData(key)
 	db.ttlMap.Remove(key)
 }
 
+// removeData 从数据字典中删除 key 并扣除其估算内存
+func (db *DB) removeData(key string) bool {
+	raw, exists := db.data.Get(key)
+	if !exists || db.data.Remove(key) == 0 {
+		return false
+	}
+	db.accountRemove(raw)
+	return true
+}
+
 func (db *DB) Removes(keys ...string) int {
 	db.stopWorld.Wait()
 	deleted := 0
 	for _, key := range keys {
-		_, exists := db.data.Get(key)
-		if exists {
-			db.data.Remove(key)
+		if db.removeData(key) {
 			db.ttlMap.Remove(key)
 			deleted++
 		}
@@ -192,32 +301,80 @@ func (db *DB) Flush() {
 	db.stopWorld.Add(1)
 	defer db.stopWorld.Done()
 
+	// 所有 key 都被视为修改过, 使 WATCH 这些 key 的事务失败
+	db.addVersionForAll()
+
 	db.data = Dict.MakeConcurrent(dataDictSize)
 	db.ttlMap = Dict.MakeConcurrent(ttlDictSize)
 	db.locker = lock.Make(lockerSize)
+	atomic.StoreInt64(&db.used, 0)
 }
 
-/* ---- TTL Functions ---- */
-func genExpireTask(key string) string {
-	return "expire:" + key
+// ForEach 遍历所有未过期的 key, consumer 返回 false 时停止遍历
+func (db *DB) ForEach(consumer func(key string, entity *DataEntity) bool) {
+	db.stopWorld.Wait()
+	db.data.ForEach(func(key string, raw interface{}) bool {
+		if db.IsExpired(key) {
+			return true
+		}
+		entity, _ := raw.(*DataEntity)
+		return consumer(key, entity)
+	})
+}
+
+// ScanKeys 从 cursor 开始返回至少 count 个未过期的 key 以及下一次调用的 cursor, 遍历结束时 cursor 为 0
+func (db *DB) ScanKeys(cursor int, count int) ([]string, int) {
+	db.stopWorld.Wait()
+	keys, next := db.data.ScanKeys(cursor, count)
+	result := keys[:0]
+	for _, key := range keys {
+		if !db.IsExpired(key) {
+			result = append(result, key)
+		}
+	}
+	return result, next
+}
+
+// DBSize 返回 key 的数量, 包括已经过期但尚未删除的 key
+func (db *DB) DBSize() int {
+	return db.data.Len()
+}
+
+// RandomKey 随机返回一个未过期的 key, 数据库为空时返回 false
+func (db *DB) RandomKey() (string, bool) {
+	db.stopWorld.Wait()
+	// 多次随机选取, 避免大量过期 key 导致总是取不到
+	for i := 0; i < 100 && db.data.Len() > 0; i++ {
+		for _, key := range db.data.RandomKeys(1) {
+			if !db.IsExpired(key) {
+				return key, true
+			}
+		}
+	}
+	return "", false
 }
 
+/* ---- TTL Functions ---- */
+
+// Expire 设置过期时间, 过期的 key 在被访问时或由主动过期删除
 func (db *DB) Expire(key string, expireTime time.Time) {
 	db.stopWorld.Wait()
 	db.ttlMap.Put(key, expireTime)
-	taskKey := genExpireTask(key)
-	timewheel.At(expireTime, taskKey, func() {
-		logger.Info("expire: " + key)
-		db.ttlMap.Remove(key)
-		db.data.Remove(key)
-	})
 }
 
 func (db *DB) Persist(key string) {
 	db.stopWorld.Wait()
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
-	timewheel.Cancel(taskKey)
+}
+
+// ExpireTime 返回 key 的过期时间, 没有设置过期时间时 ok 为 false
+func (db *DB) ExpireTime(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	expireTime, _ := raw.(time.Time)
+	return expireTime, true
 }
 
 func (db *DB) IsExpired(key string) bool {
@@ -230,10 +387,6 @@ func (db *DB) IsExpired(key string) bool {
 	return expired
 }
 
-func (db *DB) TimerTask() {
-
-}
-
 /* ---- Lock Function ---------------*/
 
 func (db *DB) Lock(key string) {
@@ -268,6 +421,37 @@ func (db *DB) RUnlocks(keys ...string) {
 	db.locker.RUnlocks(keys...)
 }
 
-func (db *DB) AfterClientClose(c redis.Connection) {
-	pubsub.UnsubscribeAll(db.hub, c)
+func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWLocks(writeKeys, readKeys)
+}
+
+func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWUnLocks(writeKeys, readKeys)
+}
+
+/* ---- Version Functions ---- */
+
+// AddVersion 使 key 的版本号加一, 集群事务在提交和回滚时调用, 使 WATCH 这些 key 的事务失败
+func (db *DB) AddVersion(keys ...string) {
+	for _, key := range keys {
+		versionCode := db.GetVersion(key)
+		db.versionMap.Put(key, versionCode+1)
+	}
+}
+
+// addVersionForAll 使所有 key 的版本号加一, 用于 FLUSHDB, SWAPDB 等整体替换数据的命令
+func (db *DB) addVersionForAll() {
+	db.data.ForEach(func(key string, _ interface{}) bool {
+		db.AddVersion(key)
+		return true
+	})
+}
+
+// GetVersion 返回 key 当前的版本号, 从未写入过的 key 版本号为 0
+func (db *DB) GetVersion(key string) uint32 {
+	entity, ok := db.versionMap.Get(key)
+	if !ok {
+		return 0
+	}
+	return entity.(uint32)
 }
@@ -0,0 +1,357 @@
+package db
+
+import (
+	"math/rand"
+	"redisGo/config"
+	Dict "redisGo/datastruct/dict"
+	"redisGo/datastruct/set"
+	"redisGo/datastruct/sortedset"
+	"redisGo/interface/dict"
+	"redisGo/interface/list"
+	"redisGo/redis/reply"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 淘汰策略, 对应配置 maxmemory-policy
+const (
+	policyNoEviction     = "noeviction"
+	policyAllKeysLRU     = "allkeys-lru"
+	policyAllKeysLFU     = "allkeys-lfu"
+	policyAllKeysRandom  = "allkeys-random"
+	policyVolatileLRU    = "volatile-lru"
+	policyVolatileLFU    = "volatile-lfu"
+	policyVolatileRandom = "volatile-random"
+	policyVolatileTTL    = "volatile-ttl"
+)
+
+const (
+	defaultMaxMemorySamples = 5
+
+	// LFU 计数器与 Redis 相同: 新 key 的初始值为 5, 计数以对数方式增长, 每分钟没有访问时减一
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuMaxVal    = 255
+)
+
+var oomReply = reply.MakeErrReply("OOM command not allowed when used memory > 'maxmemory'.")
+
+func maxMemory() int64 {
+	if config.Properties == nil {
+		return 0
+	}
+	return int64(config.Properties.MaxMemory)
+}
+
+// maxMemoryPolicy 返回配置的淘汰策略, 未配置或无法识别时为 noeviction, 只在创建数据库时调用
+func maxMemoryPolicy() string {
+	if config.Properties == nil {
+		return policyNoEviction
+	}
+	policy := strings.ToLower(config.Properties.MaxMemoryPolicy)
+	switch policy {
+	case policyAllKeysLRU, policyAllKeysLFU, policyAllKeysRandom,
+		policyVolatileLRU, policyVolatileLFU, policyVolatileRandom, policyVolatileTTL:
+		return policy
+	}
+	return policyNoEviction
+}
+
+func isLFUPolicy(policy string) bool {
+	return policy == policyAllKeysLFU || policy == policyVolatileLFU
+}
+
+func maxMemorySamples() int {
+	if config.Properties == nil || config.Properties.MaxMemorySamples <= 0 {
+		return defaultMaxMemorySamples
+	}
+	return config.Properties.MaxMemorySamples
+}
+
+/* ---- 访问信息 ---- */
+
+func nowMinutes(now time.Time) uint64 {
+	return uint64(now.Unix() / 60)
+}
+
+// initAccess 新写入的 key, 覆盖已有的 key 时沿用原来的访问频率
+func (entity *DataEntity) initAccess(old *DataEntity, now time.Time) {
+	lfu := nowMinutes(now)<<8 | lfuInitVal
+	if old != nil {
+		lfu = atomic.LoadUint64(&old.lfu)
+	}
+	atomic.StoreUint64(&entity.lfu, lfu)
+	atomic.StoreInt64(&entity.lru, now.UnixMilli())
+}
+
+// touch 记录一次访问, 只有使用 LFU 策略时才更新访问频率
+func (entity *DataEntity) touch(now time.Time, lfu bool) {
+	atomic.StoreInt64(&entity.lru, now.UnixMilli())
+	if !lfu {
+		return
+	}
+	counter := entity.freq(now)
+	if counter < lfuMaxVal {
+		base := counter - lfuInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/float64(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	atomic.StoreUint64(&entity.lfu, nowMinutes(now)<<8|uint64(counter))
+}
+
+// freq 返回按距离上次访问的分钟数衰减后的访问频率
+func (entity *DataEntity) freq(now time.Time) int {
+	lfu := atomic.LoadUint64(&entity.lfu)
+	counter := int(lfu & 0xff)
+	if elapsed := nowMinutes(now) - lfu>>8; elapsed > 0 {
+		if elapsed >= uint64(counter) {
+			return 0
+		}
+		counter -= int(elapsed)
+	}
+	return counter
+}
+
+// idleTime 距离上次访问的时间
+func (entity *DataEntity) idleTime(now time.Time) time.Duration {
+	return now.Sub(time.UnixMilli(atomic.LoadInt64(&entity.lru)))
+}
+
+/* ---- 内存估算 ---- */
+
+// 估算使用的常量, 只需要与实际占用处于同一数量级
+const (
+	entryOverhead    = 96 // 数据字典中的一项以及 DataEntity
+	sliceOverhead    = 24
+	listpackEntry    = 2  // listpack 中每个元素的长度前缀
+	hashEntry        = 64 // map 中的一项以及字符串头
+	skiplistEntry    = 96 // 跳表节点以及 map 中的一项
+	estimateSamples  = 5
+	intsetEntryBytes = 8
+)
+
+// sampleAvg 返回 sampler 提供的前几个元素的平均长度
+func sampleAvg(sampler func(consumer func(size int) bool)) int {
+	total, n := 0, 0
+	sampler(func(size int) bool {
+		total += size
+		n++
+		return n < estimateSamples
+	})
+	if n == 0 {
+		return 0
+	}
+	return total / n
+}
+
+// estimateSize 估算 key 占用的内存, 集合类型根据元素数量和前几个元素的平均长度估算, 与 MEMORY USAGE 的采样方式相同
+func estimateSize(key string, entity *DataEntity) int64 {
+	size := entryOverhead + len(key)
+	switch val := entity.Data.(type) {
+	case []byte:
+		size += sliceOverhead + len(val)
+	case list.List:
+		avg := sampleAvg(func(consumer func(int) bool) {
+			val.ForEach(func(_ int, v interface{}) bool {
+				b, _ := v.([]byte)
+				return consumer(len(b))
+			})
+		})
+		size += val.Len() * (avg + listpackEntry)
+	case *set.Set:
+		avg := sampleAvg(func(consumer func(int) bool) {
+			val.ForEach(func(member string) bool {
+				return consumer(len(member))
+			})
+		})
+		switch val.Encoding() {
+		case "intset":
+			size += val.Len() * intsetEntryBytes
+		case "listpack":
+			size += val.Len() * (avg + listpackEntry)
+		default:
+			size += val.Len() * (avg + hashEntry)
+		}
+	case dict.Dict:
+		avg := sampleAvg(func(consumer func(int) bool) {
+			val.ForEach(func(field string, v interface{}) bool {
+				b, _ := v.([]byte)
+				return consumer(len(field) + len(b))
+			})
+		})
+		if compact, ok := val.(*Dict.CompactDict); ok && compact.Encoding() == "listpack" {
+			size += val.Len() * (avg + 2*listpackEntry)
+		} else {
+			size += val.Len() * (avg + hashEntry + sliceOverhead)
+		}
+	case *sortedset.SortedSet:
+		if val.Len() > 0 {
+			avg := sampleAvg(func(consumer func(int) bool) {
+				val.ForEachByRank(0, val.Len(), false, func(element *sortedset.Element) bool {
+					return consumer(len(element.Member))
+				})
+			})
+			size += int(val.Len()) * (avg + skiplistEntry)
+		}
+	}
+	return int64(size)
+}
+
+// accountPut 新的 entity 写入 key 之后调用, 替换 old 的内存统计并初始化访问信息
+func (db *DB) accountPut(key string, entity *DataEntity, old *DataEntity) {
+	if old == entity {
+		return
+	}
+	entity.initAccess(old, time.Now())
+	if old != nil {
+		atomic.AddInt64(&db.used, -atomic.SwapInt64(&old.size, 0))
+	}
+	size := estimateSize(key, entity)
+	atomic.StoreInt64(&entity.size, size)
+	atomic.AddInt64(&db.used, size)
+}
+
+// accountRemove key 被删除之后调用
+func (db *DB) accountRemove(raw interface{}) {
+	if entity, ok := raw.(*DataEntity); ok {
+		atomic.AddInt64(&db.used, -atomic.SwapInt64(&entity.size, 0))
+	}
+}
+
+// updateSizes 写命令执行之后重新估算 key 的内存, 调用者需要持有 key 的锁
+func (db *DB) updateSizes(keys ...string) {
+	for _, key := range keys {
+		raw, ok := db.data.Get(key)
+		if !ok {
+			continue
+		}
+		entity, _ := raw.(*DataEntity)
+		size := estimateSize(key, entity)
+		atomic.AddInt64(&db.used, size-atomic.SwapInt64(&entity.size, size))
+	}
+}
+
+/* ---- 淘汰 ---- */
+
+// UsedMemory 所有数据库中 key 的估算内存之和
+func (mdb *MultiDB) UsedMemory() int64 {
+	var used int64
+	for i := range mdb.dbSet {
+		used += atomic.LoadInt64(&mdb.GetDB(i).used)
+	}
+	return used
+}
+
+// denyOOM 超过内存上限时是否拒绝执行该命令, 只有可能增加内存的写命令会被拒绝
+func denyOOM(cmd string) bool {
+	c, ok := router[cmd]
+	return ok && c.flags&flagReadOnly == 0 && c.flags&flagAllowOOM == 0
+}
+
+// evictionCandidate 采样得到的候选 key, score 越大越应该被淘汰
+type evictionCandidate struct {
+	db    *DB
+	key   string
+	score float64
+}
+
+// sampleCandidate 从一个数据库中采样, 返回最应该被淘汰的 key
+func sampleCandidate(db *DB, policy string, now time.Time) *evictionCandidate {
+	volatile := strings.HasPrefix(policy, "volatile-")
+	pool := db.data
+	if volatile {
+		pool = db.ttlMap
+	}
+	if pool.Len() == 0 {
+		return nil
+	}
+	if policy == policyAllKeysRandom || policy == policyVolatileRandom {
+		for _, key := range pool.RandomKeys(1) {
+			return &evictionCandidate{db: db, key: key}
+		}
+		return nil
+	}
+	var best *evictionCandidate
+	for _, key := range pool.RandomDistinctKeys(maxMemorySamples()) {
+		raw, ok := db.data.Get(key)
+		if !ok {
+			continue
+		}
+		entity, _ := raw.(*DataEntity)
+		var score float64
+		switch policy {
+		case policyAllKeysLRU, policyVolatileLRU:
+			score = float64(entity.idleTime(now))
+		case policyAllKeysLFU, policyVolatileLFU:
+			score = float64(lfuMaxVal - entity.freq(now))
+		case policyVolatileTTL:
+			expireAt, ok := db.ExpireTime(key)
+			if !ok {
+				continue
+			}
+			score = -float64(expireAt.UnixMilli())
+		}
+		if best == nil || score > best.score {
+			best = &evictionCandidate{db: db, key: key, score: score}
+		}
+	}
+	return best
+}
+
+// evictOne 按淘汰策略删除一个 key, 没有可以淘汰的 key 时返回 false
+func (mdb *MultiDB) evictOne(policy string) bool {
+	now := time.Now()
+	var best *evictionCandidate
+	// 随机策略从上次淘汰的数据库之后开始轮流选取
+	start := int(atomic.AddUint32(&mdb.evictCursor, 1))
+	for i := range mdb.dbSet {
+		db := mdb.GetDB((start + i) % len(mdb.dbSet))
+		candidate := sampleCandidate(db, policy, now)
+		if candidate == nil {
+			continue
+		}
+		if policy == policyAllKeysRandom || policy == policyVolatileRandom {
+			best = candidate
+			break
+		}
+		if best == nil || candidate.score > best.score {
+			best = candidate
+		}
+	}
+	if best == nil {
+		return false
+	}
+	if best.db.evictKey(best.key) {
+		atomic.AddInt64(&mdb.evictedKeys, 1)
+	}
+	return true
+}
+
+// evictKey 与 DEL 命令相同地加锁, 删除 key 并写入 aof, 使 WATCH 失效
+func (db *DB) evictKey(key string) bool {
+	db.execMu.RLock()
+	defer db.execMu.RUnlock()
+	db.Lock(key)
+	defer db.Unlock(key)
+	return db.deleteAndPropagate(key, notifyEvicted, "evicted")
+}
+
+// performEvictions 淘汰 key 直到内存不超过 maxmemory, 无法满足时返回 false
+func (mdb *MultiDB) performEvictions() bool {
+	limit := maxMemory()
+	if limit <= 0 {
+		return true
+	}
+	policy := mdb.evictionPolicy
+	for mdb.UsedMemory() > limit {
+		if policy == policyNoEviction || !mdb.evictOne(policy) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makeEvictionDB 每个 key 的估算大小为 entryOverhead + 2 + sliceOverhead + 100 = 222, maxmemory 为 700 时可以容纳 3 个 key
+func makeEvictionDB(policy string) (*MultiDB, *connection.FakeConn) {
+	config.Properties = &config.PropertyHolder{
+		Databases:       2,
+		MaxMemory:       700,
+		MaxMemoryPolicy: policy,
+	}
+	return MakeMultiDB(), connection.NewFakeConn()
+}
+
+var evictionValue = strings.Repeat("x", 100)
+
+func assertExists(t *testing.T, mdb *MultiDB, conn *connection.FakeConn, key string, expected bool) {
+	t.Helper()
+	r := mdb.Exec(conn, toArgs("exists", key))
+	if (string(r.ToBytes()) == ":1\r\n") != expected {
+		t.Errorf("exists %s: expected %v, got %q", key, expected, r.ToBytes())
+	}
+}
+
+func TestUsedMemory(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	db.Exec(nil, toArgs("set", "k1", evictionValue))
+	if used := atomic.LoadInt64(&db.used); used != 222 {
+		t.Errorf("expected 222, got %d", used)
+	}
+	db.Exec(nil, toArgs("rpush", "list", "a", "b"))
+	afterPush := atomic.LoadInt64(&db.used)
+	db.Exec(nil, toArgs("rpush", "list", "c", "d"))
+	if atomic.LoadInt64(&db.used) <= afterPush {
+		t.Error("used memory should grow after rpush")
+	}
+	db.Exec(nil, toArgs("set", "k1", "short"))
+	db.Exec(nil, toArgs("del", "list"))
+	if used := atomic.LoadInt64(&db.used); used != entryOverhead+2+sliceOverhead+5 {
+		t.Errorf("unexpected used memory %d", used)
+	}
+	db.Exec(nil, toArgs("flushdb"))
+	if used := atomic.LoadInt64(&db.used); used != 0 {
+		t.Errorf("expected 0 after flushdb, got %d", used)
+	}
+}
+
+func TestNoEviction(t *testing.T) {
+	mdb, conn := makeEvictionDB("")
+	defer mdb.Close()
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		mdb.Exec(conn, toArgs("set", key, evictionValue))
+	}
+	r := mdb.Exec(conn, toArgs("set", "k5", evictionValue))
+	if !strings.HasPrefix(string(r.ToBytes()), "-OOM") {
+		t.Errorf("expected OOM error, got %q", r.ToBytes())
+	}
+	assertExists(t, mdb, conn, "k1", true)
+	if r := mdb.Exec(conn, toArgs("del", "k1")); string(r.ToBytes()) != ":1\r\n" {
+		t.Errorf("del should be allowed, got %q", r.ToBytes())
+	}
+	if r := mdb.Exec(conn, toArgs("set", "k5", evictionValue)); string(r.ToBytes()) != "+OK\r\n" {
+		t.Errorf("expected OK, got %q", r.ToBytes())
+	}
+
+	// 事务中被拒绝的命令使 EXEC 失败
+	mdb.Exec(conn, toArgs("multi"))
+	mdb.Exec(conn, toArgs("set", "k6", evictionValue))
+	if r := mdb.Exec(conn, toArgs("exec")); !strings.HasPrefix(string(r.ToBytes()), "-EXECABORT") {
+		t.Errorf("expected EXECABORT, got %q", r.ToBytes())
+	}
+}
+
+func TestEvictLRU(t *testing.T) {
+	mdb, conn := makeEvictionDB("allkeys-lru")
+	defer mdb.Close()
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		mdb.Exec(conn, toArgs("set", key, evictionValue))
+	}
+	atomic.StoreInt64(&mdb.GetDB(0).getRaw("k2").lru, time.Now().Add(-time.Hour).UnixMilli())
+	mdb.Exec(conn, toArgs("set", "k5", evictionValue))
+	assertExists(t, mdb, conn, "k2", false)
+	for _, key := range []string{"k1", "k3", "k4", "k5"} {
+		assertExists(t, mdb, conn, key, true)
+	}
+	if r := mdb.Exec(conn, toArgs("info", "stats")); !strings.Contains(string(r.ToBytes()), "evicted_keys:1\r\n") {
+		t.Errorf("expected evicted_keys:1, got %q", r.ToBytes())
+	}
+}
+
+func TestEvictVolatile(t *testing.T) {
+	mdb, conn := makeEvictionDB("volatile-ttl")
+	defer mdb.Close()
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		mdb.Exec(conn, toArgs("set", key, evictionValue))
+	}
+	mdb.Exec(conn, toArgs("expire", "k1", "100"))
+	mdb.Exec(conn, toArgs("expire", "k3", "50"))
+	mdb.Exec(conn, toArgs("set", "k5", evictionValue))
+	assertExists(t, mdb, conn, "k3", false)
+	mdb.Exec(conn, toArgs("set", "k6", evictionValue))
+	assertExists(t, mdb, conn, "k1", false)
+
+	// 没有设置过期时间的 key 不会被淘汰
+	r := mdb.Exec(conn, toArgs("set", "k7", evictionValue))
+	if !strings.HasPrefix(string(r.ToBytes()), "-OOM") {
+		t.Errorf("expected OOM error, got %q", r.ToBytes())
+	}
+}
+
+func TestObjectFreqIdleTime(t *testing.T) {
+	mdb, conn := makeEvictionDB("allkeys-lfu")
+	defer mdb.Close()
+	mdb.Exec(conn, toArgs("set", "k", "v"))
+	if r := mdb.Exec(conn, toArgs("object", "freq", "k")); string(r.ToBytes()) != ":5\r\n" {
+		t.Errorf("expected initial freq 5, got %q", r.ToBytes())
+	}
+	// 计数器为初始值时每次访问必定加一
+	mdb.Exec(conn, toArgs("get", "k"))
+	if r := mdb.Exec(conn, toArgs("object", "freq", "k")); string(r.ToBytes()) != ":6\r\n" {
+		t.Errorf("expected freq 6, got %q", r.ToBytes())
+	}
+
+	atomic.StoreInt64(&mdb.GetDB(0).getRaw("k").lru, time.Now().Add(-10*time.Second).UnixMilli())
+	if r := mdb.Exec(conn, toArgs("object", "idletime", "k")); string(r.ToBytes()) != ":10\r\n" {
+		t.Errorf("expected idletime 10, got %q", r.ToBytes())
+	}
+	mdb.Exec(conn, toArgs("get", "k"))
+	if r := mdb.Exec(conn, toArgs("object", "idletime", "k")); string(r.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected idletime 0 after access, got %q", r.ToBytes())
+	}
+
+	mdb.GetDB(0).evictionPolicy = policyAllKeysLRU
+	if r := mdb.Exec(conn, toArgs("object", "freq", "k")); !strings.HasPrefix(string(r.ToBytes()), "-ERR An LFU maxmemory policy is not selected") {
+		t.Errorf("expected error, got %q", r.ToBytes())
+	}
+}
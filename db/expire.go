@@ -0,0 +1,98 @@
+package db
+
+import (
+	"time"
+)
+
+// 主动过期的参数与 Redis 相同: 每 100 毫秒执行一次, 每次最多占用 25 毫秒,
+// 每轮从每个数据库采样 20 个设置了过期时间的 key, 过期的比例超过 10% 时继续下一轮
+const (
+	activeExpireInterval    = 100 * time.Millisecond
+	activeExpireTimeLimit   = 25 * time.Millisecond
+	activeExpireLookups     = 20
+	activeExpireAcceptStale = 10
+)
+
+// deleteAndPropagate 删除过期或被淘汰的 key, 并以 DEL 的形式写入 aof 以及复制流, 使重新加载和副本的数据与本节点一致,
+// 然后发送 event 通知. 调用者需要持有 key 的锁以及 execMu 的读锁
+func (db *DB) deleteAndPropagate(key string, class int, event string) bool {
+	if !db.removeData(key) {
+		return false
+	}
+	db.ttlMap.Remove(key)
+	db.AddVersion(key)
+	db.AddAof(makeAofCmd("del", [][]byte{[]byte(key)}))
+	db.notify(class, event, key)
+	return true
+}
+
+func (db *DB) deleteExpired(key string) bool {
+	return db.deleteAndPropagate(key, notifyExpired, "expired")
+}
+
+// expireLazily 访问到已过期的 key 时将其删除, 调用者持有 key 的锁.
+// 只有不阻塞地获得 execMu 的读锁时才删除, 避免与 EXEC, SWAPDB 交错写入 aof, 否则留给主动过期处理,
+// 在此之前写入该 key 的命令通过 dropExpired 将其视为不存在. 副本不删除过期的 key, 等待主节点同步 DEL
+func (db *DB) expireLazily(key string) {
+	if db.isReplica() || !db.execMu.TryRLock() {
+		return
+	}
+	defer db.execMu.RUnlock()
+	db.deleteExpired(key)
+}
+
+// expireKey 主动过期时检查并删除一个 key
+func (db *DB) expireKey(key string) bool {
+	db.execMu.RLock()
+	defer db.execMu.RUnlock()
+	db.Lock(key)
+	defer db.Unlock(key)
+	if !db.IsExpired(key) {
+		return false
+	}
+	return db.deleteExpired(key)
+}
+
+// activeExpireCycle 随机采样设置了过期时间的 key 并删除其中已过期的, 直到过期比例较低或超过 deadline
+func (db *DB) activeExpireCycle(deadline time.Time) {
+	for db.ttlMap.Len() > 0 {
+		keys := db.ttlMap.RandomDistinctKeys(activeExpireLookups)
+		expired := 0
+		for _, key := range keys {
+			if db.expireKey(key) {
+				expired++
+			}
+		}
+		if len(keys) == 0 || expired*100/len(keys) <= activeExpireAcceptStale || time.Now().After(deadline) {
+			return
+		}
+	}
+}
+
+// TimerTask 对当前数据库执行一次主动过期, 最多执行到 deadline. 副本不主动删除过期的 key
+func (db *DB) TimerTask(deadline time.Time) {
+	if db.isReplica() {
+		return
+	}
+	db.activeExpireCycle(deadline)
+}
+
+// expireCron 定期对所有数据库执行主动过期, 所有数据库共用一次的时间限制
+func (mdb *MultiDB) expireCron() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mdb.closed:
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(activeExpireTimeLimit)
+			for i := range mdb.dbSet {
+				mdb.GetDB(i).TimerTask(deadline)
+				if time.Now().After(deadline) {
+					break
+				}
+			}
+		}
+	}
+}
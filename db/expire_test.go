@@ -0,0 +1,131 @@
+package db
+
+import (
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"redisGo/redis/reply"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordAof 记录 db 写入 aof 的命令
+func recordAof(db *DB) func() []string {
+	var mu sync.Mutex
+	var cmds []string
+	db.addAof = func(index int, cmdLines ...*reply.MultiBulkReply) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, cmdLine := range cmdLines {
+			cmd := string(cmdLine.Args[0])
+			for _, arg := range cmdLine.Args[1:] {
+				cmd += " " + string(arg)
+			}
+			cmds = append(cmds, cmd)
+		}
+	}
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return cmds
+	}
+}
+
+func TestLazyExpire(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	db.Exec(nil, toArgs("set", "k", "v"))
+	db.Exec(nil, toArgs("pexpire", "k", "1"))
+	cmds := recordAof(db)
+	time.Sleep(5 * time.Millisecond)
+
+	if db.DBSize() != 1 {
+		t.Fatal("key should not be removed before it is accessed")
+	}
+	assertReply(t, db, "$-1\r\n", "get", "k")
+	if db.DBSize() != 0 || db.ttlMap.Len() != 0 {
+		t.Errorf("expired key should be removed, dbsize %d, ttl %d", db.DBSize(), db.ttlMap.Len())
+	}
+	if got := cmds(); len(got) != 1 || got[0] != "del k" {
+		t.Errorf("expected del k in aof, got %v", got)
+	}
+
+	// 副本只隐藏过期的 key, 等待主节点的 DEL
+	db.Exec(nil, toArgs("set", "k", "v"))
+	db.Exec(nil, toArgs("pexpire", "k", "1"))
+	db.isReplica = func() bool { return true }
+	time.Sleep(5 * time.Millisecond)
+	assertReply(t, db, "$-1\r\n", "get", "k")
+	db.TimerTask(time.Now().Add(activeExpireTimeLimit))
+	if db.DBSize() != 1 {
+		t.Error("replica should not delete expired keys")
+	}
+}
+
+// EXEC 持有 execMu 的写锁, 其中访问到的过期 key 不会被删除, 写入时需要将其视为不存在
+func TestExpireInMulti(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	db.Exec(nil, toArgs("rpush", "k", "old"))
+	db.Exec(nil, toArgs("pexpire", "k", "1"))
+	db.Exec(nil, toArgs("set", "s", "old"))
+	db.Exec(nil, toArgs("pexpire", "s", "1"))
+	time.Sleep(5 * time.Millisecond)
+
+	conn := connection.NewFakeConn()
+	db.Exec(conn, toArgs("multi"))
+	db.Exec(conn, toArgs("rpush", "k", "v"))
+	db.Exec(conn, toArgs("set", "s", "v", "NX"))
+	if r := db.Exec(conn, toArgs("exec")); string(r.ToBytes()) != "*2\r\n:1\r\n+OK\r\n" {
+		t.Fatalf("unexpected exec reply %q", r.ToBytes())
+	}
+	assertReply(t, db, "*1\r\n$1\r\nv\r\n", "lrange", "k", "0", "-1")
+	assertReply(t, db, ":-1\r\n", "pttl", "k")
+	assertReply(t, db, "$1\r\nv\r\n", "get", "s")
+	assertReply(t, db, ":-1\r\n", "pttl", "s")
+
+	// 过期的 key 对 XX 来说不存在
+	db.Exec(nil, toArgs("pexpire", "s", "1"))
+	time.Sleep(5 * time.Millisecond)
+	db.Exec(conn, toArgs("multi"))
+	db.Exec(conn, toArgs("set", "s", "new", "XX"))
+	db.Exec(conn, toArgs("exec"))
+	if db.DBSize() != 1 {
+		t.Errorf("expired key should not be updated by XX, dbsize %d", db.DBSize())
+	}
+}
+
+func TestActiveExpire(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	for i := 0; i < 200; i++ {
+		key := "k" + strconv.Itoa(i)
+		db.Exec(nil, toArgs("set", key, "v"))
+		if i < 150 {
+			db.Exec(nil, toArgs("pexpire", key, "1"))
+		} else {
+			db.Exec(nil, toArgs("expire", key, "100"))
+		}
+	}
+	cmds := recordAof(db)
+	time.Sleep(5 * time.Millisecond)
+
+	db.TimerTask(time.Now().Add(activeExpireTimeLimit))
+	// 采样中过期的比例不超过 10% 时才会停止, 因此一次就能删除大部分过期的 key
+	if db.DBSize() >= 100 {
+		t.Errorf("expected most expired keys to be removed, dbsize %d", db.DBSize())
+	}
+	for db.ttlMap.Len() > 50 {
+		db.TimerTask(time.Now().Add(activeExpireTimeLimit))
+	}
+	if db.DBSize() != 50 {
+		t.Errorf("expected 50 keys, got %d", db.DBSize())
+	}
+	if len(cmds()) != 150 {
+		t.Errorf("expected 150 del commands, got %d", len(cmds()))
+	}
+	if used := db.used; used != 50*estimateSize("k150", &DataEntity{Data: []byte("v")}) {
+		t.Errorf("unexpected used memory %d", used)
+	}
+}
@@ -1,15 +1,25 @@
 package db
 
 import (
+	"redisGo/config"
 	Dict "redisGo/datastruct/dict"
 	"redisGo/interface/dict"
 	"redisGo/interface/redis"
+	"redisGo/lib/wildcard"
 	"redisGo/redis/reply"
 	"strconv"
 
 	"github.com/shopspring/decimal"
 )
 
+// makeHash 新建的哈希表元素较少时使用 listpack 编码, 上限由 hash-max-listpack-* 配置决定
+func makeHash() *Dict.CompactDict {
+	if config.Properties == nil {
+		return Dict.MakeCompact(0, 0)
+	}
+	return Dict.MakeCompact(config.Properties.HashMaxListpackEntries, config.Properties.HashMaxListpackValue)
+}
+
 func (db *DB) getAsDict(key string) (dict.Dict, reply.ErrorReply) {
 	entity, exists := db.Get(key)
 	if !exists {
@@ -29,7 +39,7 @@ func (db *DB) getOrInitDict(key string) (dict dict.Dict, inited bool, errReply r
 	}
 	inited = false
 	if dict == nil {
-		dict = Dict.MakeSimple()
+		dict = makeHash()
 		db.Put(key, &DataEntity{Data: dict})
 		inited = true
 	}
@@ -43,14 +53,13 @@ func HSet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	field := string(args[1])
 	value := args[2]
-	db.Lock(key)
-	defer db.Unlock(key)
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
 	}
 	res := dict.Put(field, value)
 	db.AddAof(makeAofCmd("hset", args))
+	db.notify(notifyHash, "hset", key)
 	return reply.MakeIntReply(int64(res))
 }
 
@@ -61,8 +70,6 @@ func HSetNX(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	field := string(args[1])
 	value := args[2]
-	db.Lock(key)
-	defer db.Unlock(key)
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
@@ -70,6 +77,7 @@ func HSetNX(db *DB, args [][]byte) redis.Reply {
 	res := dict.PutIfAbsent(field, value)
 	if res > 0 {
 		db.AddAof(makeAofCmd("hsetnx", args))
+		db.notify(notifyHash, "hset", key)
 	}
 	return reply.MakeIntReply(int64(res))
 }
@@ -81,9 +89,6 @@ func HGet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	field := string(args[1])
 
-	db.RLock(key)
-	defer db.RUnlock(key)
-
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
 		return errReply
@@ -106,9 +111,6 @@ func HExists(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	field := string(args[1])
 
-	db.RLock(key)
-	defer db.RUnlock(key)
-
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
 		return errReply
@@ -133,9 +135,6 @@ func HDel(db *DB, args [][]byte) redis.Reply {
 		fields[i-1] = string(args[i])
 	}
 
-	db.Lock(key)
-	defer db.Unlock(key)
-
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
 		return errReply
@@ -152,6 +151,10 @@ func HDel(db *DB, args [][]byte) redis.Reply {
 	}
 	if count > 0 {
 		db.AddAof(makeAofCmd("hdel", args))
+		db.notify(notifyHash, "hdel", key)
+		if dict.Len() == 0 {
+			db.notify(notifyGeneric, "del", key)
+		}
 	}
 	return reply.MakeIntReply(int64(count))
 }
@@ -161,8 +164,6 @@ func HLen(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'hlen' command")
 	}
 	key := string(args[0])
-	db.RLock(key)
-	defer db.RUnlock(key)
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
 		return errReply
@@ -185,8 +186,6 @@ func HMSet(db *DB, args [][]byte) redis.Reply {
 		fields[i] = string(args[i*2+1])
 		values[i] = args[i*2+2]
 	}
-	db.Lock(key)
-	defer db.Unlock(key)
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
@@ -196,6 +195,7 @@ func HMSet(db *DB, args [][]byte) redis.Reply {
 		dict.Put(field, value)
 	}
 	db.AddAof(makeAofCmd("hmset", args))
+	db.notify(notifyHash, "hset", key)
 	return &reply.OkReply{}
 }
 
@@ -208,8 +208,6 @@ func HMGet(db *DB, args [][]byte) redis.Reply {
 	for i := 1; i < len(args); i++ {
 		fields[i-1] = string(args[i])
 	}
-	db.RLock(key)
-	defer db.RUnlock(key)
 
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
@@ -236,8 +234,6 @@ func HKeys(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'hkeys' command")
 	}
 	key := string(args[0])
-	db.RLock(key)
-	defer db.RUnlock(key)
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
 		return errReply
@@ -260,8 +256,6 @@ func HVals(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'hvals' command")
 	}
 	key := string(args[0])
-	db.RLock(key)
-	defer db.RUnlock(key)
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
 		return errReply
@@ -284,8 +278,6 @@ func HGetAll(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'hgetall' command")
 	}
 	key := string(args[0])
-	db.RLock(key)
-	defer db.RUnlock(key)
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
 		return errReply
@@ -315,8 +307,6 @@ func HIncrBy(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.Lock(key)
-	defer db.Unlock(key)
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
@@ -331,10 +321,12 @@ func HIncrBy(db *DB, args [][]byte) redis.Reply {
 		bytes := []byte(strconv.FormatInt(val, 10))
 		dict.Put(field, bytes)
 		db.AddAof(makeAofCmd("hincrby", args))
+		db.notify(notifyHash, "hincrby", key)
 		return reply.MakeBulkReply(bytes)
 	} else {
 		dict.Put(field, args[2])
 		db.AddAof(makeAofCmd("hset", args))
+		db.notify(notifyHash, "hincrby", key)
 		return reply.MakeBulkReply(args[2])
 	}
 }
@@ -349,8 +341,6 @@ func HIncrByFloat(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not a valid float")
 	}
-	db.Lock(key)
-	defer db.Unlock(key)
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
@@ -364,10 +354,47 @@ func HIncrByFloat(db *DB, args [][]byte) redis.Reply {
 		result := val.Add(delta)
 		dict.Put(field, []byte(result.String()))
 		db.AddAof(makeAofCmd("hincrbyfloat", args))
+		db.notify(notifyHash, "hincrbyfloat", key)
 		return reply.MakeBulkReply([]byte(result.String()))
 	} else {
 		dict.Put(field, args[2])
 		db.AddAof(makeAofCmd("hset", args))
+		db.notify(notifyHash, "hincrbyfloat", key)
 		return reply.MakeBulkReply(args[2])
 	}
 }
+
+// HScan HSCAN key cursor [MATCH pattern] [COUNT count], 返回匹配的 field 以及对应的 value
+func HScan(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'hscan' command")
+	}
+	cursor, errReply := parseCursor(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	options, errReply := ParseScanOptions(args[2:], false)
+	if errReply != nil {
+		return errReply
+	}
+	dict, errReply := db.getAsDict(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return makeScanReply(0, [][]byte{})
+	}
+	fields, next := dict.ScanKeys(cursor, options.Count)
+	result := make([][]byte, 0, 2*len(fields))
+	for _, field := range fields {
+		if options.Pattern != "" && !wildcard.Match(options.Pattern, field) {
+			continue
+		}
+		value, exists := dict.Get(field)
+		if !exists {
+			continue
+		}
+		result = append(result, []byte(field), value.([]byte))
+	}
+	return makeScanReply(next, result)
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"strings"
+	"sync/atomic"
+)
+
+// infoSection 生成 INFO 命令中的一个段落
+type infoSection struct {
+	name      string
+	generator func(mdb *MultiDB) [][2]string
+}
+
+var infoSections = []*infoSection{
+	{name: "memory", generator: memoryInfo},
+	{name: "persistence", generator: persistenceInfo},
+	{name: "stats", generator: statsInfo},
+	{name: "replication", generator: replicationInfo},
+	{name: "keyspace", generator: keyspaceInfo},
+}
+
+func boolToInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// memoryInfo used_memory 为所有 key 的估算内存, 与 maxmemory 比较的也是这个值
+func memoryInfo(mdb *MultiDB) [][2]string {
+	return [][2]string{
+		{"used_memory", fmt.Sprint(mdb.UsedMemory())},
+		{"maxmemory", fmt.Sprint(maxMemory())},
+		{"maxmemory_policy", mdb.evictionPolicy},
+	}
+}
+
+func statsInfo(mdb *MultiDB) [][2]string {
+	return [][2]string{
+		{"evicted_keys", fmt.Sprint(atomic.LoadInt64(&mdb.evictedKeys))},
+	}
+}
+
+func persistenceInfo(mdb *MultiDB) [][2]string {
+	info := [][2]string{
+		{"rdb_changes_since_last_save", fmt.Sprint(atomic.LoadInt64(&mdb.dirty))},
+		{"rdb_bgsave_in_progress", boolToInfo(atomic.LoadInt32(&mdb.saving) == 1)},
+		{"rdb_last_save_time", fmt.Sprint(atomic.LoadInt64(&mdb.lastSave))},
+		{"aof_enabled", boolToInfo(config.Properties.AppendOnly)},
+	}
+	if mdb.aofFile != nil {
+		info = append(info,
+			[2]string{"aof_fsync_policy", mdb.aofFsync},
+			[2]string{"aof_pending_bytes", fmt.Sprint(atomic.LoadInt64(&mdb.aofPendingBytes))},
+			[2]string{"aof_last_fsync_time", fmt.Sprint(atomic.LoadInt64(&mdb.aofLastFsync))},
+			[2]string{"aof_last_fsync_latency_us", fmt.Sprint(atomic.LoadInt64(&mdb.aofFsyncLatency))},
+			[2]string{"aof_fsync_lag_sec", fmt.Sprint(mdb.aofFsyncLag())},
+			[2]string{"aof_delayed_fsync", fmt.Sprint(atomic.LoadInt64(&mdb.aofDelayedFsync))},
+		)
+	}
+	return info
+}
+
+func keyspaceInfo(mdb *MultiDB) [][2]string {
+	info := make([][2]string, 0)
+	for i := range mdb.dbSet {
+		db := mdb.GetDB(i)
+		keys := db.data.Len()
+		if keys == 0 {
+			continue
+		}
+		info = append(info, [2]string{
+			fmt.Sprintf("db%d", i),
+			fmt.Sprintf("keys=%d,expires=%d", keys, db.ttlMap.Len()),
+		})
+	}
+	return info
+}
+
+// execInfo INFO [section], 不指定 section 或为 all 时返回全部段落
+func execInfo(mdb *MultiDB, args [][]byte) redis.Reply {
+	if len(args) > 1 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'info' command")
+	}
+	section := "all"
+	if len(args) == 1 {
+		section = strings.ToLower(string(args[0]))
+	}
+	buf := &bytes.Buffer{}
+	for _, s := range infoSections {
+		if section != "all" && section != "everything" && section != "default" && section != s.name {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString("# " + strings.ToUpper(s.name[:1]) + s.name[1:] + "\r\n")
+		for _, kv := range s.generator(mdb) {
+			buf.WriteString(kv[0] + ":" + kv[1] + "\r\n")
+		}
+	}
+	return reply.MakeBulkReply(buf.Bytes())
+}
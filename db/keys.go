@@ -1,11 +1,15 @@
 package db
 
 import (
-	"redisGo/datastruct/list"
+	"redisGo/datastruct/set"
+	"redisGo/datastruct/sortedset"
 	"redisGo/interface/dict"
+	"redisGo/interface/list"
 	"redisGo/interface/redis"
+	"redisGo/lib/wildcard"
 	"redisGo/redis/reply"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,13 +21,12 @@ func Del(db *DB, args [][]byte) redis.Reply {
 	for i, v := range args {
 		keys[i] = string(v)
 	}
-	db.Locks(keys...)
-	defer db.Unlocks(keys...)
 	deleted := 0
 	for _, key := range keys {
 		_, exists := db.Get(key)
 		if exists {
 			db.Remove(key)
+			db.notify(notifyGeneric, "del", key)
 			deleted++
 		}
 	}
@@ -33,16 +36,18 @@ func Del(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeIntReply(int64(deleted))
 }
 
+// Exists 返回存在的 key 的数量, 重复的 key 会被重复计数
 func Exists(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
+	if len(args) == 0 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'exists' command")
 	}
-	key := string(args[0])
-	_, exists := db.Get(key)
-	if !exists {
-		return reply.MakeIntReply(0)
+	var count int64
+	for _, arg := range args {
+		if _, exists := db.Get(string(arg)); exists {
+			count++
+		}
 	}
-	return reply.MakeIntReply(1)
+	return reply.MakeIntReply(count)
 }
 
 func FlushDB(db *DB, args [][]byte) redis.Reply {
@@ -54,13 +59,128 @@ func FlushDB(db *DB, args [][]byte) redis.Reply {
 	return &reply.OkReply{}
 }
 
-func FlushAll(db *DB, args [][]byte) redis.Reply {
+// Keys KEYS pattern
+func Keys(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'keys' command")
+	}
+	pattern := string(args[0])
+	keys := make([][]byte, 0)
+	db.ForEach(func(key string, _ *DataEntity) bool {
+		if wildcard.Match(pattern, key) {
+			keys = append(keys, []byte(key))
+		}
+		return true
+	})
+	return reply.MakeMultiBulkReply(keys)
+}
+
+// ScanOptions SCAN, SSCAN, HSCAN 游标之后的选项
+type ScanOptions struct {
+	Count   int    // 每次遍历的元素数量, 经过 MATCH 和 TYPE 过滤后返回的可能更少
+	Pattern string // 为空时不过滤
+	Type    string // 只有 SCAN 支持, 为空时不过滤
+}
+
+// ParseScanOptions 解析 [MATCH pattern] [COUNT count] [TYPE type], allowType 为 false 时不接受 TYPE
+func ParseScanOptions(args [][]byte, allowType bool) (*ScanOptions, reply.ErrorReply) {
+	options := &ScanOptions{Count: 10}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, &reply.SyntaxErrReply{}
+		}
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "count":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			options.Count = count
+		case "match":
+			if value != "*" {
+				options.Pattern = value
+			}
+		case "type":
+			if !allowType {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			options.Type = strings.ToLower(value)
+		default:
+			return nil, &reply.SyntaxErrReply{}
+		}
+	}
+	return options, nil
+}
+
+// parseCursor 游标是非负整数
+func parseCursor(arg []byte) (int, reply.ErrorReply) {
+	cursor, err := strconv.Atoi(string(arg))
+	if err != nil || cursor < 0 {
+		return 0, reply.MakeErrReply("ERR invalid cursor")
+	}
+	return cursor, nil
+}
+
+// makeScanReply 返回 [下一个游标, [元素...]]
+func makeScanReply(next int, items [][]byte) redis.Reply {
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte(strconv.Itoa(next))),
+		reply.MakeMultiBulkReply(items),
+	})
+}
+
+// Scan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type],
+// 遍历期间一直存在的 key 一定会被返回
+func Scan(db *DB, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'scan' command")
+	}
+	cursor, errReply := parseCursor(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	options, errReply := ParseScanOptions(args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	keys, next := db.ScanKeys(cursor, options.Count)
+	result := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if options.Pattern != "" && !wildcard.Match(options.Pattern, key) {
+			continue
+		}
+		if options.Type != "" {
+			// SCAN 没有锁定 key, 不能删除过期的 key
+			entity, exists := db.Peek(key)
+			if !exists || typeName(entity) != options.Type {
+				continue
+			}
+		}
+		result = append(result, []byte(key))
+	}
+	return makeScanReply(next, result)
+}
+
+func DBSize(db *DB, args [][]byte) redis.Reply {
 	if len(args) != 0 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'flushall' command")
+		return reply.MakeErrReply("ERR wrong number of arguments for 'dbsize' command")
 	}
-	db.Flush()
-	db.AddAof(makeAofCmd("flushall", args))
-	return &reply.OkReply{}
+	return reply.MakeIntReply(int64(db.DBSize()))
+}
+
+func RandomKey(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'randomkey' command")
+	}
+	key, ok := db.RandomKey()
+	if !ok {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeBulkReply([]byte(key))
 }
 
 func Type(db *DB, args [][]byte) redis.Reply {
@@ -72,15 +192,28 @@ func Type(db *DB, args [][]byte) redis.Reply {
 	if !exists {
 		return reply.MakeStatusReply("none")
 	}
+	name := typeName(entity)
+	if name == "" {
+		return &reply.UnknownErrReply{}
+	}
+	return reply.MakeStatusReply(name)
+}
+
+// typeName 返回 TYPE 命令使用的类型名, 未知的类型返回空字符串
+func typeName(entity *DataEntity) string {
 	switch entity.Data.(type) {
 	case []byte:
-		return reply.MakeStatusReply("string")
-	case *list.LinkedList:
-		return reply.MakeStatusReply("list")
+		return "string"
+	case list.List:
+		return "list"
 	case dict.Dict:
-		return reply.MakeStatusReply("hash")
+		return "hash"
+	case *set.Set:
+		return "set"
+	case *sortedset.SortedSet:
+		return "zset"
 	default:
-		return &reply.UnknownErrReply{}
+		return ""
 	}
 }
 
@@ -115,7 +248,10 @@ func Expire(db *DB, args [][]byte) redis.Reply {
 	if !exists {
 		return reply.MakeIntReply(0)
 	}
-	db.Expire(key, time.Now().Add(ttl))
+	expireAt := time.Now().Add(ttl)
+	db.Expire(key, expireAt)
+	db.AddAof(makeExpireCmd(key, expireAt))
+	db.notify(notifyGeneric, "expire", key)
 	return reply.MakeIntReply(1)
 }
 
@@ -134,6 +270,8 @@ func ExpireAt(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeIntReply(0)
 	}
 	db.Expire(key, timestamp)
+	db.AddAof(makeExpireCmd(key, timestamp))
+	db.notify(notifyGeneric, "expire", key)
 	return reply.MakeIntReply(1)
 }
 
@@ -152,7 +290,10 @@ func PExpire(db *DB, args [][]byte) redis.Reply {
 	if !exists {
 		return reply.MakeIntReply(0)
 	}
-	db.Expire(key, time.Now().Add(ttl))
+	expireAt := time.Now().Add(ttl)
+	db.Expire(key, expireAt)
+	db.AddAof(makeExpireCmd(key, expireAt))
+	db.notify(notifyGeneric, "expire", key)
 	return reply.MakeIntReply(1)
 }
 
@@ -172,6 +313,8 @@ func PExpireAt(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeIntReply(0)
 	}
 	db.Expire(key, timestamp)
+	db.AddAof(makeExpireCmd(key, timestamp))
+	db.notify(notifyGeneric, "expire", key)
 	return reply.MakeIntReply(1)
 }
 
@@ -228,6 +371,7 @@ func Persist(db *DB, args [][]byte) redis.Reply {
 	}
 	db.Persist(key)
 	db.AddAof(makeAofCmd("persist", args))
+	db.notify(notifyGeneric, "persist", key)
 	return reply.MakeIntReply(1)
 }
 
@@ -237,8 +381,6 @@ func Rename(db *DB, args [][]byte) redis.Reply {
 	}
 	oldKey := string(args[0])
 	newKey := string(args[1])
-	db.Locks(oldKey, newKey)
-	defer db.Unlocks(oldKey, newKey)
 	entity, exists := db.Get(oldKey)
 	if !exists {
 		return reply.MakeErrReply("ERR no such key")
@@ -246,11 +388,14 @@ func Rename(db *DB, args [][]byte) redis.Reply {
 	rawTTL, ok := db.ttlMap.Get(oldKey)
 	db.Persist(oldKey)
 	db.Persist(newKey)
+	db.Remove(oldKey)
 	db.Put(newKey, entity)
 	if ok {
 		db.Expire(newKey, rawTTL.(time.Time))
 	}
 	db.AddAof(makeAofCmd("rename", args))
+	db.notify(notifyGeneric, "rename_from", oldKey)
+	db.notify(notifyGeneric, "rename_to", newKey)
 	return &reply.OkReply{}
 }
 
@@ -260,8 +405,6 @@ func RenameNX(db *DB, args [][]byte) redis.Reply {
 	}
 	oldKey := string(args[0])
 	newKey := string(args[1])
-	db.Locks(oldKey, newKey)
-	defer db.Unlocks(oldKey, newKey)
 	_, exists := db.Get(newKey)
 	if exists {
 		return reply.MakeIntReply(0)
@@ -270,21 +413,27 @@ func RenameNX(db *DB, args [][]byte) redis.Reply {
 	if !ok {
 		return reply.MakeErrReply("ERR no such key")
 	}
+	rawTTL, ok := db.ttlMap.Get(oldKey)
 	db.Persist(oldKey)
 	db.Persist(newKey)
+	db.Remove(oldKey)
 	db.Put(newKey, entity)
-	rawTTL, ok := db.ttlMap.Get(oldKey)
 	if ok {
 		db.Expire(newKey, rawTTL.(time.Time))
 	}
 	db.AddAof(makeAofCmd("renamenx", args))
+	db.notify(notifyGeneric, "rename_from", oldKey)
+	db.notify(notifyGeneric, "rename_to", newKey)
 	return reply.MakeIntReply(1)
 }
 
-func BGRewriteAOF(db *DB, args [][]byte) redis.Reply {
+func BGRewriteAOF(mdb *MultiDB, args [][]byte) redis.Reply {
 	if len(args) != 0 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'bgsave' command")
+		return reply.MakeErrReply("ERR wrong number of arguments for 'bgrewriteaof' command")
+	}
+	if mdb.aofFile == nil {
+		return reply.MakeErrReply("ERR append only file is disabled")
 	}
-	go db.aofRewrite()
+	go mdb.aofRewrite()
 	return reply.MakeStatusReply("Background append only file rewrite started")
 }
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"redisGo/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scanAll 使用 SCAN 类命令遍历到游标为 0, 返回所有元素
+func scanAll(t *testing.T, db *DB, cmd string, key string, options ...string) []string {
+	items := make([]string, 0)
+	cursor := "0"
+	for i := 0; ; i++ {
+		args := []string{cmd}
+		if key != "" {
+			args = append(args, key)
+		}
+		args = append(append(args, cursor), options...)
+		r, ok := db.Exec(nil, toArgs(args...)).(*reply.MultiRawReply)
+		if !ok || i > 1000 {
+			t.Fatalf("%s failed at cursor %s", cmd, cursor)
+		}
+		cursor = string(r.Replies[0].(*reply.BulkReply).Arg)
+		for _, item := range r.Replies[1].(*reply.MultiBulkReply).Args {
+			items = append(items, string(item))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+func TestScan(t *testing.T) {
+	db := MakeDB()
+	for i := 0; i < 100; i++ {
+		db.Exec(nil, toArgs("set", "str"+strconv.Itoa(i), "1"))
+	}
+	db.Exec(nil, toArgs("rpush", "list1", "a"))
+	db.Exec(nil, toArgs("sadd", "set1", "a"))
+
+	if keys := scanAll(t, db, "scan", "", "count", "3"); len(keys) != 102 {
+		t.Errorf("scan should return all keys, got %d", len(keys))
+	}
+	if keys := scanAll(t, db, "scan", "", "match", "str1?"); len(keys) != 10 || keys[0] != "str10" {
+		t.Errorf("unexpected keys matching str1?: %v", keys)
+	}
+	if keys := scanAll(t, db, "scan", "", "match", "*1", "type", "list"); strings.Join(keys, ",") != "list1" {
+		t.Errorf("unexpected keys of type list: %v", keys)
+	}
+	if r := db.Exec(nil, toArgs("scan", "0", "count")); string(r.ToBytes()) != string((&reply.SyntaxErrReply{}).ToBytes()) {
+		t.Errorf("expected syntax error, got %q", r.ToBytes())
+	}
+	if r := db.Exec(nil, toArgs("scan", "-1")); string(r.ToBytes()) != "-ERR invalid cursor\r\n" {
+		t.Errorf("expected invalid cursor, got %q", r.ToBytes())
+	}
+	if r := db.Exec(nil, toArgs("keys", "str[0-1]")); string(r.ToBytes()) != "*2\r\n$4\r\nstr0\r\n$4\r\nstr1\r\n" &&
+		string(r.ToBytes()) != "*2\r\n$4\r\nstr1\r\n$4\r\nstr0\r\n" {
+		t.Errorf("unexpected keys: %q", r.ToBytes())
+	}
+}
+
+func TestSScanHScan(t *testing.T) {
+	db := MakeDB()
+	for i := 0; i < 50; i++ {
+		db.Exec(nil, toArgs("sadd", "set", "m"+strconv.Itoa(i)))
+	}
+	db.Exec(nil, toArgs("hset", "hash", "f1", "v1"))
+	db.Exec(nil, toArgs("hset", "hash", "f2", "v2"))
+	db.Exec(nil, toArgs("hset", "hash", "g1", "v3"))
+
+	if members := scanAll(t, db, "sscan", "set", "count", "5"); len(members) != 50 {
+		t.Errorf("sscan should return all members, got %d", len(members))
+	}
+	if members := scanAll(t, db, "sscan", "set", "match", "m4*"); len(members) != 11 {
+		t.Errorf("unexpected members matching m4*: %v", members)
+	}
+	if r := db.Exec(nil, toArgs("sscan", "set", "0", "type", "set")); string(r.ToBytes()) != string((&reply.SyntaxErrReply{}).ToBytes()) {
+		t.Errorf("sscan should not accept TYPE, got %q", r.ToBytes())
+	}
+	if items := scanAll(t, db, "hscan", "hash", "match", "f*"); strings.Join(items, ",") != "f1,f2,v1,v2" {
+		t.Errorf("unexpected hscan result: %v", items)
+	}
+	if items := scanAll(t, db, "hscan", "nosuchkey"); len(items) != 0 {
+		t.Errorf("hscan on absent key should be empty, got %v", items)
+	}
+	if r := db.Exec(nil, toArgs("hscan", "set", "0")); !strings.HasPrefix(string(r.ToBytes()), "-WRONGTYPE") {
+		t.Errorf("expected wrong type error, got %q", r.ToBytes())
+	}
+}
@@ -1,34 +1,46 @@
 package db
 
 import (
+	"redisGo/config"
 	List "redisGo/datastruct/list"
+	"redisGo/interface/list"
 	"redisGo/interface/redis"
 	"redisGo/redis/reply"
 	"strconv"
+	"strings"
 )
 
-func (db *DB) getAsList(key string) (*List.LinkedList, reply.ErrorReply) {
+// makeList 新建的列表使用 quicklist, 节点大小由 list-max-listpack-size 决定
+func makeList() *List.QuickList {
+	fill := 0
+	if config.Properties != nil {
+		fill = config.Properties.ListMaxListpackSize
+	}
+	return List.MakeQuickList(fill)
+}
+
+func (db *DB) getAsList(key string) (list.List, reply.ErrorReply) {
 	entity, exists := db.Get(key)
 	if !exists {
 		return nil, nil
 	}
-	bytes, ok := entity.Data.(*List.LinkedList)
+	l, ok := entity.Data.(list.List)
 	if !ok {
 		return nil, &reply.WrongTypeErrReply{}
 	}
-	return bytes, nil
+	return l, nil
 }
 
-func (db *DB) getOrInitList(key string) (*List.LinkedList, bool, reply.ErrorReply) {
-	list, errReply := db.getAsList(key)
+func (db *DB) getOrInitList(key string) (list.List, bool, reply.ErrorReply) {
+	l, errReply := db.getAsList(key)
 	if errReply != nil {
 		return nil, false, errReply
 	}
-	if list == nil {
-		list = &List.LinkedList{}
-		db.Put(key, &DataEntity{Data: list})
+	if l == nil {
+		l = makeList()
+		db.Put(key, &DataEntity{Data: l})
 	}
-	return list, true, nil
+	return l, true, nil
 }
 
 // 这个命令在list不存在的时候会新建一个list
@@ -39,9 +51,6 @@ func RPush(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	values := args[1:]
 
-	db.Lock(key)
-	defer db.Unlock(key)
-
 	list, _, errReply := db.getOrInitList(key)
 	if errReply != nil {
 		return errReply
@@ -51,6 +60,7 @@ func RPush(db *DB, args [][]byte) redis.Reply {
 		list.Add(value)
 	}
 	db.AddAof(makeAofCmd("rpush", args))
+	db.notify(notifyList, "rpush", key)
 	return reply.MakeIntReply(int64(list.Len()))
 
 }
@@ -66,9 +76,6 @@ func LIndex(db *DB, args [][]byte) redis.Reply {
 	}
 	index := int(index64)
 
-	db.RLock(key)
-	defer db.RUnlock(key)
-
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
@@ -100,28 +107,75 @@ func LLen(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
-func LPop(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lpop' command")
+// popN 从列表的一端弹出最多 count 个元素, 列表为空时删除 key
+func (db *DB) popN(key string, list list.List, count int, fromLeft bool) [][]byte {
+	if count > list.Len() {
+		count = list.Len()
 	}
-	key := string(args[0])
+	vals := make([][]byte, count)
+	for i := range vals {
+		if fromLeft {
+			vals[i], _ = list.Remove(0).([]byte)
+		} else {
+			vals[i], _ = list.RemoveLast().([]byte)
+		}
+	}
+	if count > 0 {
+		db.notify(notifyList, listEvent("pop", fromLeft), key)
+	}
+	if list.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return vals
+}
 
-	db.Lock(key)
-	defer db.Unlock(key)
+// listEvent 按操作的一端返回键空间通知的事件名, 如 lpush, rpop
+func listEvent(op string, left bool) string {
+	if left {
+		return "l" + op
+	}
+	return "r" + op
+}
+
+// execPop LPOP/RPOP key [count], 指定 count 时返回数组
+func execPop(db *DB, cmd string, args [][]byte, fromLeft bool) redis.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	key := string(args[0])
+	count := 1
+	withCount := len(args) == 2
+	if withCount {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil || n < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
 
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
 	}
 	if list == nil {
+		if withCount {
+			return &reply.NullMultiBulkReply{}
+		}
 		return &reply.NullBulkReply{}
 	}
-	val, _ := list.Remove(0).([]byte)
-	if list.Len() == 0 {
-		db.data.Remove(key)
+	vals := db.popN(key, list, count, fromLeft)
+	if len(vals) > 0 {
+		db.AddAof(makeAofCmd(cmd, args))
 	}
-	db.AddAof(makeAofCmd("lpop", args))
-	return reply.MakeBulkReply(val)
+	if withCount {
+		return reply.MakeMultiBulkReply(vals)
+	}
+	return reply.MakeBulkReply(vals[0])
+}
+
+func LPop(db *DB, args [][]byte) redis.Reply {
+	return execPop(db, "lpop", args, true)
 }
 
 func LPush(db *DB, args [][]byte) redis.Reply {
@@ -131,9 +185,6 @@ func LPush(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	values := args[1:]
 
-	db.Lock(key)
-	defer db.Unlock(key)
-
 	list, _, errReply := db.getOrInitList(key)
 	if errReply != nil {
 		return errReply
@@ -142,6 +193,7 @@ func LPush(db *DB, args [][]byte) redis.Reply {
 		list.Insert(0, values[i])
 	}
 	db.AddAof(makeAofCmd("lpush", args))
+	db.notify(notifyList, "lpush", key)
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
@@ -161,9 +213,6 @@ func LRange(db *DB, args [][]byte) redis.Reply {
 	}
 	stop := int(end64)
 
-	db.RLock(key)
-	defer db.RUnlock(key)
-
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
@@ -212,9 +261,6 @@ func LRem(db *DB, args [][]byte) redis.Reply {
 	count := int(count64)
 	val := args[2]
 
-	db.Lock(key)
-	defer db.Unlock(key)
-
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
@@ -230,8 +276,12 @@ func LRem(db *DB, args [][]byte) redis.Reply {
 	} else {
 		removed = list.ReverseRemoveByVal(val, -count)
 	}
+	if removed > 0 {
+		db.notify(notifyList, "lrem", key)
+	}
 	if list.Len() == 0 {
-		db.data.Remove(key)
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
 	}
 	db.AddAof(makeAofCmd("lrem", args))
 	return reply.MakeIntReply(int64(removed))
@@ -249,9 +299,6 @@ func LSet(db *DB, args [][]byte) redis.Reply {
 	index := int(index64)
 	val := args[2]
 
-	db.Lock(key)
-	defer db.Unlock(key)
-
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
@@ -269,62 +316,323 @@ func LSet(db *DB, args [][]byte) redis.Reply {
 	}
 	list.Set(index, val)
 	db.AddAof(makeAofCmd("lset", args))
+	db.notify(notifyList, "lset", key)
 	return &reply.OkReply{}
 }
 
 func RPop(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rpop' command")
+	return execPop(db, "rpop", args, false)
+}
+
+// lmove 从 src 的一端弹出元素插入 dst 的一端, src 不存在时返回 nil, 调用者需要持有两个 key 的锁
+func (db *DB) lmove(src string, dst string, fromLeft bool, toLeft bool) ([]byte, reply.ErrorReply) {
+	srcList, errReply := db.getAsList(src)
+	if errReply != nil {
+		return nil, errReply
 	}
-	key := string(args[0])
+	if srcList == nil {
+		return nil, nil
+	}
+	destList, errReply := db.getAsList(dst)
+	if errReply != nil {
+		return nil, errReply
+	}
+	var val []byte
+	if fromLeft {
+		val, _ = srcList.Remove(0).([]byte)
+	} else {
+		val, _ = srcList.RemoveLast().([]byte)
+	}
+	if destList == nil {
+		destList = makeList()
+		db.Put(dst, &DataEntity{Data: destList})
+	}
+	if toLeft {
+		destList.Insert(0, val)
+	} else {
+		destList.Add(val)
+	}
+	db.notify(notifyList, listEvent("pop", fromLeft), src)
+	db.notify(notifyList, listEvent("push", toLeft), dst)
+	if srcList.Len() == 0 {
+		db.Remove(src)
+		db.notify(notifyGeneric, "del", src)
+	}
+	return val, nil
+}
 
-	db.Lock(key)
-	defer db.Unlock(key)
+// parseDirection 解析 LEFT 或 RIGHT
+func parseDirection(arg []byte) (left bool, ok bool) {
+	switch strings.ToLower(string(arg)) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
+}
 
-	list, errReply := db.getAsList(key)
+func RPopLPush(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'rpoplpush' command")
+	}
+	val, errReply := db.lmove(string(args[0]), string(args[1]), false, true)
 	if errReply != nil {
 		return errReply
 	}
-	if list == nil {
+	if val == nil {
 		return &reply.NullBulkReply{}
 	}
-	val, _ := list.RemoveLast().([]byte)
-	if list.Len() == 0 {
-		db.data.Remove(key)
+	db.AddAof(makeAofCmd("rpoplpush", args))
+	return reply.MakeBulkReply(val)
+}
+
+// LMove LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func LMove(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 4 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'lmove' command")
 	}
-	db.AddAof(makeAofCmd("rpop", args))
+	fromLeft, ok1 := parseDirection(args[2])
+	toLeft, ok2 := parseDirection(args[3])
+	if !ok1 || !ok2 {
+		return &reply.SyntaxErrReply{}
+	}
+	val, errReply := db.lmove(string(args[0]), string(args[1]), fromLeft, toLeft)
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		return &reply.NullBulkReply{}
+	}
+	db.AddAof(makeAofCmd("lmove", args))
 	return reply.MakeBulkReply(val)
 }
 
-func RPopLPush(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rpoplpush' command")
+// execPushX LPUSHX/RPUSHX key element [element ...], 只在列表已经存在时插入
+func execPushX(db *DB, cmd string, args [][]byte, toLeft bool) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	list, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
 	}
-	sourceKey := string(args[0])
-	destKey := string(args[1])
+	for _, value := range args[1:] {
+		if toLeft {
+			list.Insert(0, value)
+		} else {
+			list.Add(value)
+		}
+	}
+	db.AddAof(makeAofCmd(cmd, args))
+	db.notify(notifyList, listEvent("push", toLeft), string(args[0]))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+func LPushX(db *DB, args [][]byte) redis.Reply {
+	return execPushX(db, "lpushx", args, true)
+}
+
+func RPushX(db *DB, args [][]byte) redis.Reply {
+	return execPushX(db, "rpushx", args, false)
+}
 
-	db.Locks(sourceKey, destKey)
-	defer db.Unlocks(sourceKey, destKey)
+// LInsert LINSERT key BEFORE|AFTER pivot element, 没有找到 pivot 时返回 -1
+func LInsert(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 4 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'linsert' command")
+	}
+	where := strings.ToLower(string(args[1]))
+	if where != "before" && where != "after" {
+		return &reply.SyntaxErrReply{}
+	}
+	list, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	var inserted bool
+	if where == "before" {
+		inserted = list.InsertBefore(args[2], args[3])
+	} else {
+		inserted = list.InsertAfter(args[2], args[3])
+	}
+	if !inserted {
+		return reply.MakeIntReply(-1)
+	}
+	db.AddAof(makeAofCmd("linsert", args))
+	db.notify(notifyList, "linsert", string(args[0]))
+	return reply.MakeIntReply(int64(list.Len()))
+}
 
-	sourceList, errReply := db.getAsList(sourceKey)
+// LTrim LTRIM key start stop, 只保留 [start, stop] 中的元素, 范围为空时删除 key
+func LTrim(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'ltrim' command")
+	}
+	key := string(args[0])
+	start, err1 := strconv.Atoi(string(args[1]))
+	stop, err2 := strconv.Atoi(string(args[2]))
+	if err1 != nil || err2 != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
 	}
-	if sourceList == nil {
-		return reply.MakeErrReply("ERR source list is nil")
+	if list == nil {
+		return &reply.OkReply{}
+	}
+	size := list.Len()
+	if start < 0 {
+		start += size
+		if start < 0 {
+			start = 0
+		}
+	}
+	if stop < 0 {
+		stop += size
 	}
-	destList, errReply := db.getAsList(destKey)
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop || start >= size {
+		db.Remove(key)
+	} else {
+		list.Trim(start, stop+1)
+	}
+	db.AddAof(makeAofCmd("ltrim", args))
+	db.notify(notifyList, "ltrim", key)
+	if start > stop || start >= size {
+		db.notify(notifyGeneric, "del", key)
+	}
+	return &reply.OkReply{}
+}
+
+// LPos LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+// RANK 为负数时从表尾开始查找, COUNT 为 0 时返回所有匹配的下标
+func LPos(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'lpos' command")
+	}
+	rank, count, maxLen := 1, 1, 0
+	withCount := false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return &reply.SyntaxErrReply{}
+		}
+		n, err := strconv.Atoi(string(args[i+1]))
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "rank":
+			if n == 0 {
+				return reply.MakeErrReply("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "count":
+			if n < 0 {
+				return reply.MakeErrReply("ERR COUNT can't be negative")
+			}
+			count = n
+			withCount = true
+		case "maxlen":
+			if n < 0 {
+				return reply.MakeErrReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = n
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+
+	list, errReply := db.getAsList(string(args[0]))
 	if errReply != nil {
 		return errReply
 	}
-	if destList == nil {
-		return reply.MakeErrReply("ERR dest list is nil")
+	positions := make([]int, 0)
+	if list != nil {
+		// 跳过前 |rank|-1 个匹配的元素
+		skip := rank - 1
+		if rank < 0 {
+			skip = -rank - 1
+		}
+		list.Search(args[1], rank < 0, maxLen, func(index int) bool {
+			if skip > 0 {
+				skip--
+				return true
+			}
+			positions = append(positions, index)
+			return count == 0 || len(positions) < count
+		})
+	}
+	if !withCount {
+		if len(positions) == 0 {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeIntReply(int64(positions[0]))
+	}
+	replies := make([]redis.Reply, len(positions))
+	for i, pos := range positions {
+		replies[i] = reply.MakeIntReply(int64(pos))
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// LMPop LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count], 从第一个非空的列表中弹出元素, 返回 [key, [元素...]]
+func LMPop(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'lmpop' command")
 	}
-	val, _ := sourceList.RemoveLast().([]byte)
-	destList.Insert(0, val)
-	if sourceList.Len() == 0 {
-		db.Remove(sourceKey)
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.AddAof(makeAofCmd("rpoplpush", args))
-	return reply.MakeBulkReply(val)
+	if numKeys <= 0 {
+		return reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if len(args) < numKeys+2 {
+		return &reply.SyntaxErrReply{}
+	}
+	keys := args[1 : numKeys+1]
+	fromLeft, ok := parseDirection(args[numKeys+1])
+	if !ok {
+		return &reply.SyntaxErrReply{}
+	}
+	count := 1
+	options := args[numKeys+2:]
+	if len(options) > 0 {
+		if len(options) != 2 || strings.ToLower(string(options[0])) != "count" {
+			return &reply.SyntaxErrReply{}
+		}
+		count, err = strconv.Atoi(string(options[1]))
+		if err != nil || count <= 0 {
+			return reply.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+
+	for _, key := range keys {
+		list, errReply := db.getAsList(string(key))
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil {
+			continue
+		}
+		vals := db.popN(string(key), list, count, fromLeft)
+		// aof 中记录实际执行的 LPOP/RPOP
+		aofCmd := "rpop"
+		if fromLeft {
+			aofCmd = "lpop"
+		}
+		db.AddAof(makeAofCmd(aofCmd, [][]byte{key, []byte(strconv.Itoa(len(vals)))}))
+		return reply.MakeMultiRawReply([]redis.Reply{reply.MakeBulkReply(key), reply.MakeMultiBulkReply(vals)})
+	}
+	return &reply.NullMultiBulkReply{}
 }
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"redisGo/config"
+	"redisGo/redis/reply"
+	"strings"
+	"testing"
+)
+
+// assertReply 比较回复的 RESP 编码
+func assertReply(t *testing.T, db *DB, expected string, args ...string) {
+	t.Helper()
+	if r := db.Exec(nil, toArgs(args...)); string(r.ToBytes()) != expected {
+		t.Errorf("%s: expected %q, got %q", strings.Join(args, " "), expected, r.ToBytes())
+	}
+}
+
+func TestListEdit(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+
+	assertReply(t, db, ":0\r\n", "lpushx", "list", "a")
+	assertReply(t, db, ":0\r\n", "rpushx", "list", "a")
+	db.Exec(nil, toArgs("rpush", "list", "b"))
+	assertReply(t, db, ":3\r\n", "lpushx", "list", "x", "a")
+	assertReply(t, db, ":4\r\n", "rpushx", "list", "c")
+	assertReply(t, db, "*4\r\n$1\r\na\r\n$1\r\nx\r\n$1\r\nb\r\n$1\r\nc\r\n", "lrange", "list", "0", "-1")
+
+	assertReply(t, db, ":5\r\n", "linsert", "list", "BEFORE", "b", "y")
+	assertReply(t, db, ":6\r\n", "linsert", "list", "after", "c", "z")
+	assertReply(t, db, ":-1\r\n", "linsert", "list", "after", "nope", "z")
+	assertReply(t, db, ":0\r\n", "linsert", "none", "after", "a", "z")
+	assertReply(t, db, string((&reply.SyntaxErrReply{}).ToBytes()), "linsert", "list", "middle", "a", "z")
+	assertReply(t, db, "*6\r\n$1\r\na\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nz\r\n", "lrange", "list", "0", "-1")
+
+	assertReply(t, db, "+OK\r\n", "ltrim", "list", "1", "-2")
+	assertReply(t, db, "*4\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nb\r\n$1\r\nc\r\n", "lrange", "list", "0", "-1")
+	assertReply(t, db, "+OK\r\n", "ltrim", "list", "-100", "100")
+	assertReply(t, db, ":4\r\n", "llen", "list")
+	assertReply(t, db, "+OK\r\n", "ltrim", "list", "3", "1")
+	assertReply(t, db, ":0\r\n", "exists", "list")
+}
+
+func TestLPos(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	db.Exec(nil, toArgs("rpush", "list", "a", "b", "c", "1", "2", "3", "c", "c"))
+
+	assertReply(t, db, ":2\r\n", "lpos", "list", "c")
+	assertReply(t, db, ":6\r\n", "lpos", "list", "c", "rank", "2")
+	assertReply(t, db, ":7\r\n", "lpos", "list", "c", "rank", "-1")
+	assertReply(t, db, "*2\r\n:2\r\n:6\r\n", "lpos", "list", "c", "count", "2")
+	assertReply(t, db, "*3\r\n:2\r\n:6\r\n:7\r\n", "lpos", "list", "c", "count", "0")
+	assertReply(t, db, "*2\r\n:6\r\n:2\r\n", "lpos", "list", "c", "rank", "-2", "count", "0")
+	assertReply(t, db, "*1\r\n:2\r\n", "lpos", "list", "c", "count", "0", "maxlen", "3")
+	assertReply(t, db, "$-1\r\n", "lpos", "list", "c", "maxlen", "2")
+	assertReply(t, db, "$-1\r\n", "lpos", "list", "x")
+	assertReply(t, db, "*0\r\n", "lpos", "none", "x", "count", "1")
+	assertReply(t, db, "-ERR COUNT can't be negative\r\n", "lpos", "list", "c", "count", "-1")
+	if r := db.Exec(nil, toArgs("lpos", "list", "c", "rank", "0")); !strings.HasPrefix(string(r.ToBytes()), "-ERR RANK can't be zero") {
+		t.Errorf("unexpected reply: %q", r.ToBytes())
+	}
+}
+
+func TestPopCount(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	aof := make([]string, 0)
+	db.addAof = func(_ int, cmdLines ...*reply.MultiBulkReply) {
+		for _, cmdLine := range cmdLines {
+			aof = append(aof, string(cmdLine.ToBytes()))
+		}
+	}
+	db.Exec(nil, toArgs("rpush", "list", "a", "b", "c", "d", "e"))
+
+	assertReply(t, db, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "lpop", "list", "2")
+	assertReply(t, db, "*1\r\n$1\r\ne\r\n", "rpop", "list", "1")
+	assertReply(t, db, "*0\r\n", "lpop", "list", "0")
+	assertReply(t, db, "-ERR value is out of range, must be positive\r\n", "lpop", "list", "-1")
+	assertReply(t, db, "*-1\r\n", "lpop", "none", "2")
+	assertReply(t, db, "$-1\r\n", "lpop", "none")
+
+	assertReply(t, db, "*-1\r\n", "lmpop", "2", "none", "other", "left")
+	assertReply(t, db, "*2\r\n$4\r\nlist\r\n*2\r\n$1\r\nd\r\n$1\r\nc\r\n", "lmpop", "2", "none", "list", "RIGHT", "COUNT", "10")
+	assertReply(t, db, ":0\r\n", "exists", "list")
+	assertReply(t, db, "-ERR numkeys should be greater than 0\r\n", "lmpop", "0", "list", "left")
+	assertReply(t, db, "-ERR count should be greater than 0\r\n", "lmpop", "1", "list", "left", "count", "0")
+	assertReply(t, db, string((&reply.SyntaxErrReply{}).ToBytes()), "lmpop", "2", "list", "left")
+
+	// LMPOP 在 aof 中记录为实际弹出的 RPOP key count
+	expected := string(makeAofCmd("rpop", toArgs("list", "2")).ToBytes())
+	if aof[len(aof)-1] != expected {
+		t.Errorf("unexpected aof: %q", aof[len(aof)-1])
+	}
+	if len(aof) != 4 {
+		t.Errorf("expected 4 aof entries, got %d", len(aof))
+	}
+}
@@ -0,0 +1,469 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/pubsub"
+	"redisGo/redis/reply"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultDatabases = 16
+
+// MultiDB 持有多个逻辑数据库, 负责 SELECT, SWAPDB 等跨数据库的命令以及发布订阅和 AOF 持久化
+type MultiDB struct {
+	dbSet []*atomic.Value // *DB
+
+	hub      *pubsub.Hub
+	shardHub *pubsub.Hub // SSUBSCRIBE 订阅的分片频道
+
+	// main goroutine send command to aof goroutine through aofChan
+	aofChan      chan *aofPayload
+	aofFile      *os.File
+	aofFilename  string
+	aofFinished  chan struct{} // aof goroutine will send msg when aof finished
+	aofCurrentDB int           // aof 文件中最后一次 SELECT 的数据库, -1 表示未知
+
+	aofRewriteChan chan *aofPayload
+	pausingAof     sync.RWMutex
+
+	// appendfsync 策略以及 fsync 统计, 用于 INFO persistence
+	aofFsync        string
+	aofPendingBytes int64 // 已写入但尚未 fsync 的字节数
+	aofLastFsync    int64 // 上次 fsync 完成的 unix 时间戳
+	aofFsyncLatency int64 // 最近一次 fsync 的耗时, 单位微秒
+	aofDelayedFsync int64 // 耗时超过 2 秒的 fsync 次数
+
+	// rdb 快照
+	saveParams []*saveParam
+	dirty      int64 // 上次保存以来的修改次数
+	lastSave   int64 // 上次成功保存的 unix 时间戳
+	saving     int32 // 是否正在后台保存
+	closed     chan struct{}
+
+	// 主从复制
+	repl *replication
+
+	// maxmemory 淘汰
+	evictionPolicy string // 创建时从配置中解析的 maxmemory-policy
+	evictedKeys    int64  // 被淘汰的 key 数量, 用于 INFO stats
+	evictCursor    uint32 // 随机淘汰策略下一次开始选取的数据库
+}
+
+// makeBasicMultiDB 创建不带持久化的 MultiDB, 用于 aof 重写等场景
+func makeBasicMultiDB() *MultiDB {
+	databases := config.Properties.Databases
+	if databases <= 0 {
+		databases = defaultDatabases
+	}
+	mdb := &MultiDB{
+		dbSet:        make([]*atomic.Value, databases),
+		hub:          pubsub.MakeHub(),
+		shardHub:     pubsub.MakeHub(),
+		aofCurrentDB: -1,
+		lastSave:     time.Now().Unix(),
+		closed:       make(chan struct{}),
+		repl:         makeReplication(),
+
+		evictionPolicy: maxMemoryPolicy(),
+	}
+	for i := range mdb.dbSet {
+		db := MakeDB()
+		db.index = i
+		db.addAof = mdb.addAof
+		db.isReplica = mdb.isReplica
+		db.publish = mdb.publishNotification
+		db.dbByIndex = mdb.dbByIndex
+		holder := &atomic.Value{}
+		holder.Store(db)
+		mdb.dbSet[i] = holder
+	}
+	return mdb
+}
+
+func MakeMultiDB() *MultiDB {
+	mdb := makeBasicMultiDB()
+	if config.Properties.AppendOnly {
+		mdb.aofFilename = config.Properties.AppendFilename
+		err := mdb.loadAof(0)
+		if err != nil {
+			mdb.handleAofLoadError(err)
+		}
+		aofFile, err := os.OpenFile(mdb.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			logger.Warn(err)
+		} else {
+			mdb.aofFile = aofFile
+			mdb.aofChan = make(chan *aofPayload, aofQueueSize)
+			mdb.aofFinished = make(chan struct{})
+			mdb.aofFsync = parseFsyncPolicy(config.Properties.AppendFsync)
+			mdb.aofLastFsync = time.Now().Unix()
+			go func() {
+				mdb.handleAof()
+			}()
+			if mdb.aofFsync == FsyncEverySec {
+				go mdb.aofSyncer()
+			}
+		}
+	} else {
+		// 开启 aof 时以 aof 为准, 否则从 rdb 快照恢复
+		mdb.loadRDB()
+	}
+	atomic.StoreInt64(&mdb.dirty, 0)
+	mdb.saveParams = parseSaveParams(config.Properties.Save)
+	if len(mdb.saveParams) > 0 {
+		go mdb.serverCron()
+	}
+	go mdb.expireCron()
+	if config.Properties.ReplicaOf != "" {
+		// replicaof <host> <port>
+		fields := strings.Fields(config.Properties.ReplicaOf)
+		if len(fields) == 2 {
+			mdb.becomeReplica(net.JoinHostPort(fields[0], fields[1]))
+		} else {
+			logger.Warn("illegal replicaof config: " + config.Properties.ReplicaOf)
+		}
+	}
+	return mdb
+}
+
+// GetDB 返回指定下标的数据库
+func (mdb *MultiDB) GetDB(index int) *DB {
+	return mdb.dbSet[index].Load().(*DB)
+}
+
+// dbByIndex 解析数据库下标并返回对应的数据库
+func (mdb *MultiDB) dbByIndex(raw []byte) (*DB, reply.ErrorReply) {
+	index, errReply := parseDBIndex(mdb, raw)
+	if errReply != nil {
+		return nil, errReply
+	}
+	return mdb.GetDB(index), nil
+}
+
+func (mdb *MultiDB) selectDB(c redis.Connection) *DB {
+	index := 0
+	if c != nil {
+		index = c.GetDBIndex()
+	}
+	return mdb.GetDB(index)
+}
+
+func (mdb *MultiDB) Exec(c redis.Connection, args [][]byte) redis.Reply {
+	cmd := strings.ToLower(string(args[0]))
+	// 副本只接受主节点发来的写命令
+	if isWriteCommand(cmd) && mdb.isReplica() {
+		if c != nil && c.InMultiState() {
+			c.SetTxDirty(true)
+		}
+		return reply.MakeErrReply("READONLY You can't write against a read only replica.")
+	}
+	// 可能增加内存的写命令执行前先淘汰 key, 仍然超过 maxmemory 时拒绝执行. 副本不主动淘汰, 由主节点同步删除
+	if denyOOM(cmd) && !mdb.isReplica() && !mdb.performEvictions() {
+		if c != nil && c.InMultiState() {
+			c.SetTxDirty(true)
+		}
+		return oomReply
+	}
+	return mdb.exec(c, args)
+}
+
+// exec 执行命令, 不检查副本的只读限制, 副本执行主节点的复制流时直接调用
+func (mdb *MultiDB) exec(c redis.Connection, args [][]byte) (result redis.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
+			result = &reply.UnknownErrReply{}
+		}
+	}()
+
+	cmd := strings.ToLower(string(args[0]))
+
+	if cmd == "subscribe" {
+		if len(args) < 2 {
+			return &reply.ArgNumErrReply{Cmd: "subscribe"}
+		}
+		return pubsub.Subscribe(mdb.hub, c, args[1:])
+	} else if cmd == "unsubscribe" {
+		return pubsub.UnSubscribe(mdb.hub, c, args[1:])
+	} else if cmd == "publish" {
+		return pubsub.Publish(mdb.hub, args[1:])
+	} else if cmd == "ssubscribe" {
+		if len(args) < 2 {
+			return &reply.ArgNumErrReply{Cmd: "ssubscribe"}
+		}
+		return pubsub.SSubscribe(mdb.shardHub, c, args[1:])
+	} else if cmd == "sunsubscribe" {
+		return pubsub.SUnsubscribe(mdb.shardHub, c, args[1:])
+	} else if cmd == "spublish" {
+		return pubsub.SPublish(mdb.shardHub, args[1:])
+	} else if cmd == "info" {
+		return execInfo(mdb, args[1:])
+	}
+
+	// 以下命令会跨越多个数据库或改变复制状态, 不能在事务中使用. MOVE 只涉及一个 key, 由 EXEC 一起加锁执行
+	if cmd == "select" || cmd == "swapdb" || cmd == "flushall" || cmd == "bgrewriteaof" ||
+		cmd == "save" || cmd == "bgsave" || cmd == "replicaof" || cmd == "slaveof" || cmd == "psync" || cmd == "replconf" {
+		if c != nil && c.InMultiState() {
+			return reply.MakeErrReply("ERR " + strings.ToUpper(cmd) + " inside MULTI is not allowed")
+		}
+	}
+	if cmd == "bgrewriteaof" {
+		return BGRewriteAOF(mdb, args[1:])
+	} else if cmd == "replicaof" || cmd == "slaveof" {
+		if len(args) != 3 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return execReplicaOf(mdb, args[1:])
+	} else if cmd == "psync" {
+		if len(args) != 3 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return execPSync(mdb, c, args[1:])
+	} else if cmd == "replconf" {
+		return execReplConf(mdb, c, args[1:])
+	} else if cmd == "save" || cmd == "bgsave" || cmd == "lastsave" {
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		if cmd == "save" {
+			return execSave(mdb)
+		} else if cmd == "bgsave" {
+			return execBGSave(mdb)
+		}
+		return execLastSave(mdb)
+	} else if cmd == "select" {
+		if len(args) != 2 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return execSelect(mdb, c, args[1:])
+	} else if cmd == "swapdb" {
+		if len(args) != 3 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return execSwapDB(mdb, c, args[1:])
+	} else if cmd == "move" {
+		if len(args) != 3 {
+			if c != nil && c.InMultiState() {
+				c.SetTxDirty(true)
+			}
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		if c != nil && c.InMultiState() {
+			c.EnqueueCmd(args)
+			return &reply.QueuedReply{}
+		}
+		return execMove(mdb, c, args[1:])
+	} else if cmd == "flushall" {
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return execFlushAll(mdb, c)
+	}
+
+	return mdb.selectDB(c).Exec(c, args)
+}
+
+func (mdb *MultiDB) AfterClientClose(c redis.Connection) {
+	pubsub.UnsubscribeAll(mdb.hub, c)
+	pubsub.SUnsubscribeAll(mdb.shardHub, c)
+	for _, dbSet := range mdb.dbSet {
+		dbSet.Load().(*DB).blocking.cancel(c)
+	}
+	mdb.repl.mu.Lock()
+	mdb.repl.removeReplica(c)
+	mdb.repl.mu.Unlock()
+}
+
+func (mdb *MultiDB) Close() {
+	close(mdb.closed)
+	mdb.repl.mu.Lock()
+	mdb.repl.stopLink()
+	mdb.repl.disconnectReplicas()
+	mdb.repl.mu.Unlock()
+	// 与 Redis 一致, 配置了 save 时关闭前保存一次快照
+	if len(mdb.saveParams) > 0 {
+		err := mdb.saveRDB()
+		if err != nil {
+			logger.Error("save before shutdown failed: " + err.Error())
+		}
+	}
+	if mdb.aofFile != nil {
+		close(mdb.aofChan)
+		<-mdb.aofFinished // wait for aof finished
+		err := mdb.aofFile.Sync()
+		if err != nil {
+			logger.Warn(err)
+		}
+		err = mdb.aofFile.Close()
+		if err != nil {
+			logger.Warn(err)
+		}
+	}
+}
+
+func parseFsyncPolicy(raw string) string {
+	switch strings.ToLower(raw) {
+	case FsyncAlways:
+		return FsyncAlways
+	case FsyncNo:
+		return FsyncNo
+	case "", FsyncEverySec:
+		return FsyncEverySec
+	}
+	logger.Warn("illegal appendfsync config: " + raw + ", use everysec")
+	return FsyncEverySec
+}
+
+func parseDBIndex(mdb *MultiDB, raw []byte) (int, reply.ErrorReply) {
+	index, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR invalid DB index")
+	}
+	if index < 0 || index >= len(mdb.dbSet) {
+		return 0, reply.MakeErrReply("ERR DB index is out of range")
+	}
+	return index, nil
+}
+
+// execSelect SELECT index
+func execSelect(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	index, errReply := parseDBIndex(mdb, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if c == nil {
+		return reply.MakeErrReply("ERR SELECT is not allowed here")
+	}
+	c.SelectDB(index)
+	return &reply.OkReply{}
+}
+
+// lockDBs 按下标顺序获取多个数据库的 execMu, 阻塞这些数据库上的写命令, 按顺序加锁避免死锁
+func lockDBs(dbs ...*DB) func() {
+	sorted := make([]*DB, len(dbs))
+	copy(sorted, dbs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].index < sorted[j].index
+	})
+	for _, db := range sorted {
+		db.execMu.Lock()
+	}
+	return func() {
+		for i := len(sorted) - 1; i >= 0; i-- {
+			sorted[i].execMu.Unlock()
+		}
+	}
+}
+
+func (mdb *MultiDB) lockAllDBs() func() {
+	dbs := make([]*DB, len(mdb.dbSet))
+	for i := range mdb.dbSet {
+		dbs[i] = mdb.GetDB(i)
+	}
+	return lockDBs(dbs...)
+}
+
+// execSwapDB SWAPDB index1 index2, 交换两个数据库中的全部数据, 连接的 SELECT 状态不变
+func execSwapDB(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	index1, errReply := parseDBIndex(mdb, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	index2, errReply := parseDBIndex(mdb, args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if index1 == index2 {
+		return &reply.OkReply{}
+	}
+	db1 := mdb.GetDB(index1)
+	db2 := mdb.GetDB(index2)
+	// 阻塞两个数据库上的写命令, 保证 SWAPDB 前后的 aof 落在正确的数据库中
+	unlock := lockDBs(db1, db2)
+	defer unlock()
+
+	db1.index, db2.index = index2, index1
+	mdb.dbSet[index1].Store(db2)
+	mdb.dbSet[index2].Store(db1)
+	db2.addVersionForAll()
+	db1.addVersionForAll()
+	mdb.addAof(mdb.selectDB(c).index, makeAofCmd("swapdb", args))
+	return &reply.OkReply{}
+}
+
+// execMove MOVE key db, 将 key 移动到另一个数据库, 目标数据库中已存在该 key 时不做任何操作
+func execMove(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	key := string(args[0])
+	dstIndex, errReply := parseDBIndex(mdb, args[1])
+	if errReply != nil {
+		return errReply
+	}
+	src := mdb.selectDB(c)
+	dst := mdb.GetDB(dstIndex)
+	if src == dst {
+		return reply.MakeErrReply("ERR source and destination objects are the same")
+	}
+
+	// 移入的列表可以唤醒目标数据库上阻塞的客户端
+	defer dst.ServeBlocked(key)
+	first, second := src, dst
+	if first.index > second.index {
+		first, second = second, first
+	}
+	first.execMu.RLock()
+	defer first.execMu.RUnlock()
+	second.execMu.RLock()
+	defer second.execMu.RUnlock()
+	first.Lock(key)
+	defer first.Unlock(key)
+	second.Lock(key)
+	defer second.Unlock(key)
+	return moveKey(src, dst, args)
+}
+
+// moveKey 将 key 从 src 移动到 dst, 调用者持有两个数据库的 execMu 和 key 锁
+func moveKey(src *DB, dst *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	entity, exists := src.Get(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if _, exists = dst.Get(key); exists {
+		return reply.MakeIntReply(0)
+	}
+	rawTTL, hasTTL := src.ttlMap.Get(key)
+	src.AddVersion(key)
+	dst.AddVersion(key)
+	dst.Put(key, entity)
+	if hasTTL {
+		dst.Expire(key, rawTTL.(time.Time))
+	}
+	src.Persist(key)
+	src.Remove(key)
+	src.AddAof(makeAofCmd("move", args))
+	src.notify(notifyGeneric, "move_from", key)
+	dst.notify(notifyGeneric, "move_to", key)
+	return reply.MakeIntReply(1)
+}
+
+// execFlushAll 清空所有数据库
+func execFlushAll(mdb *MultiDB, c redis.Connection) redis.Reply {
+	unlock := mdb.lockAllDBs()
+	defer unlock()
+	for i := range mdb.dbSet {
+		mdb.GetDB(i).Flush()
+	}
+	mdb.addAof(mdb.selectDB(c).index, makeAofCmd("flushall", nil))
+	return &reply.OkReply{}
+}
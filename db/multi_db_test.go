@@ -0,0 +1,159 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSelectMoveSwap(t *testing.T) {
+	config.Properties = &config.PropertyHolder{Databases: 4}
+	mdb := MakeMultiDB()
+	conn := connection.NewFakeConn()
+
+	mdb.Exec(conn, toArgs("set", "a", "0"))
+	if r := mdb.Exec(conn, toArgs("select", "4")); string(r.ToBytes()) != "-ERR DB index is out of range\r\n" {
+		t.Errorf("expected out of range error, got %q", r.ToBytes())
+	}
+	mdb.Exec(conn, toArgs("select", "1"))
+	if r := mdb.Exec(conn, toArgs("get", "a")); string(r.ToBytes()) != "$-1\r\n" {
+		t.Errorf("expected nil in db 1, got %q", r.ToBytes())
+	}
+	mdb.Exec(conn, toArgs("set", "a", "1"))
+	mdb.Exec(conn, toArgs("set", "b", "1"))
+
+	if r := mdb.Exec(conn, toArgs("move", "a", "0")); string(r.ToBytes()) != ":0\r\n" {
+		t.Errorf("move to db which has the key should fail, got %q", r.ToBytes())
+	}
+	if r := mdb.Exec(conn, toArgs("move", "b", "0")); string(r.ToBytes()) != ":1\r\n" {
+		t.Errorf("expected move success, got %q", r.ToBytes())
+	}
+	if r := mdb.Exec(conn, toArgs("exists", "b")); string(r.ToBytes()) != ":0\r\n" {
+		t.Errorf("b should be moved out of db 1, got %q", r.ToBytes())
+	}
+
+	mdb.Exec(conn, toArgs("swapdb", "0", "1"))
+	// 连接仍然在 1 号数据库, 看到的是原先 0 号数据库的数据
+	if r := mdb.Exec(conn, toArgs("get", "a")); string(r.ToBytes()) != "$1\r\n0\r\n" {
+		t.Errorf("expected a=0 after swapdb, got %q", r.ToBytes())
+	}
+	if r := mdb.Exec(conn, toArgs("get", "b")); string(r.ToBytes()) != "$1\r\n1\r\n" {
+		t.Errorf("expected b=1 after swapdb, got %q", r.ToBytes())
+	}
+
+	mdb.Exec(conn, toArgs("flushall"))
+	for i := 0; i < 4; i++ {
+		if mdb.GetDB(i).data.Len() != 0 {
+			t.Errorf("db %d should be empty after flushall", i)
+		}
+	}
+}
+
+// MULTI 中的 MOVE 与其它命令一起在 EXEC 时执行
+func TestMoveInMulti(t *testing.T) {
+	config.Properties = &config.PropertyHolder{Databases: 4}
+	mdb := MakeMultiDB()
+	defer mdb.Close()
+	conn := connection.NewFakeConn()
+	mdb.Exec(conn, toArgs("set", "a", "0"))
+	mdb.Exec(conn, toArgs("multi"))
+	if r := mdb.Exec(conn, toArgs("move", "a", "1")); string(r.ToBytes()) != "+QUEUED\r\n" {
+		t.Fatalf("move should be queued, got %q", r.ToBytes())
+	}
+	mdb.Exec(conn, toArgs("get", "a"))
+	mdb.Exec(conn, toArgs("move", "a", "0"))
+	mdb.Exec(conn, toArgs("move", "a", "9"))
+	r := mdb.Exec(conn, toArgs("exec"))
+	expected := "*4\r\n:1\r\n$-1\r\n-ERR source and destination objects are the same\r\n-ERR DB index is out of range\r\n"
+	if string(r.ToBytes()) != expected {
+		t.Errorf("unexpected exec result %q", r.ToBytes())
+	}
+	conn.SelectDB(1)
+	if r := mdb.Exec(conn, toArgs("get", "a")); string(r.ToBytes()) != "$1\r\n0\r\n" {
+		t.Errorf("a should be moved into db 1, got %q", r.ToBytes())
+	}
+
+	// 参数数量错误时整个事务被放弃
+	mdb.Exec(conn, toArgs("multi"))
+	mdb.Exec(conn, toArgs("move", "a"))
+	if r := mdb.Exec(conn, toArgs("exec")); !strings.HasPrefix(string(r.ToBytes()), "-EXECABORT") {
+		t.Errorf("expected execabort, got %q", r.ToBytes())
+	}
+
+	// 两个数据库上的事务互相 MOVE, 按照下标顺序加锁不会死锁
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(from int) {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			c.SelectDB(from)
+			for j := 0; j < 200; j++ {
+				mdb.Exec(c, toArgs("multi"))
+				mdb.Exec(c, toArgs("move", "a", strconv.Itoa(1-from)))
+				mdb.Exec(c, toArgs("exec"))
+				mdb.Exec(c, toArgs("move", "a", strconv.Itoa(1-from)))
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("transactions moving keys between databases deadlocked")
+	}
+	if mdb.GetDB(0).data.Len()+mdb.GetDB(1).data.Len() != 1 {
+		t.Error("key should exist in exactly one database")
+	}
+}
+
+func TestMultiDBAof(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	config.Properties = &config.PropertyHolder{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		Databases:      4,
+	}
+	mdb := MakeMultiDB()
+	conn := connection.NewFakeConn()
+	mdb.Exec(conn, toArgs("set", "a", "0"))
+	mdb.Exec(conn, toArgs("select", "2"))
+	mdb.Exec(conn, toArgs("set", "a", "2"))
+	mdb.Exec(conn, toArgs("set", "b", "2"))
+	mdb.Exec(conn, toArgs("multi"))
+	mdb.Exec(conn, toArgs("rpush", "list", "x"))
+	mdb.Exec(conn, toArgs("move", "b", "3"))
+	mdb.Exec(conn, toArgs("exec"))
+	mdb.Exec(conn, toArgs("move", "b", "3"))
+	mdb.Exec(conn, toArgs("swapdb", "0", "1"))
+	mdb.Close()
+
+	if _, err := os.Stat(aofFilename); err != nil {
+		t.Fatal(err)
+	}
+	loaded := MakeMultiDB()
+	defer loaded.Close()
+	conn = connection.NewFakeConn()
+	conn.SelectDB(1)
+	if r := loaded.Exec(conn, toArgs("get", "a")); string(r.ToBytes()) != "$1\r\n0\r\n" {
+		t.Errorf("expected a=0 in db 1, got %q", r.ToBytes())
+	}
+	conn.SelectDB(2)
+	if r := loaded.Exec(conn, toArgs("lrange", "list", "0", "-1")); string(r.ToBytes()) != "*1\r\n$1\r\nx\r\n" {
+		t.Errorf("expected list in db 2, got %q", r.ToBytes())
+	}
+	conn.SelectDB(3)
+	if r := loaded.Exec(conn, toArgs("get", "b")); string(r.ToBytes()) != "$1\r\n2\r\n" {
+		t.Errorf("expected b=2 in db 3, got %q", r.ToBytes())
+	}
+}
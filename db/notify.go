@@ -0,0 +1,95 @@
+package db
+
+import (
+	"redisGo/config"
+	"redisGo/pubsub"
+	"strconv"
+)
+
+// 键空间通知的类型, 与 Redis 的 notify-keyspace-events 相同
+const (
+	notifyKeyspace = 1 << iota // K, 发送到 __keyspace@<db>__:<key>, 消息为事件名
+	notifyKeyevent             // E, 发送到 __keyevent@<db>__:<event>, 消息为 key
+	notifyGeneric              // g, DEL, EXPIRE, RENAME 等与类型无关的命令
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x, key 过期被删除
+	notifyEvicted              // e, key 因 maxmemory 被淘汰
+	notifyNew                  // n, 新建 key, 不包含在 A 中
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet |
+		notifyExpired | notifyEvicted // A
+)
+
+// parseNotifyFlags 解析 notify-keyspace-events, 忽略不支持的类型
+func parseNotifyFlags(raw string) int {
+	flags := 0
+	for _, c := range raw {
+		switch c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'n':
+			flags |= notifyNew
+		case 'A':
+			flags |= notifyAll
+		}
+	}
+	return flags
+}
+
+// notifyKeyspaceEvents 解析配置中的通知类型, 只在创建 DB 时调用
+func notifyKeyspaceEvents() int {
+	if config.Properties == nil || config.Properties.NotifyKeyspaceEvents == "" {
+		return 0
+	}
+	return parseNotifyFlags(config.Properties.NotifyKeyspaceEvents)
+}
+
+// notify 发送键空间通知. 调用者持有 key 的锁, 保证通知的顺序与修改的顺序一致.
+// 与 Redis 相同, 需要开启 K 或 E 中至少一个以及事件所属的类型才会发送
+func (db *DB) notify(class int, event string, key string) {
+	flags := db.notifyFlags
+	if flags&class == 0 || flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return
+	}
+	prefix := "@" + strconv.Itoa(db.index) + "__:"
+	if flags&notifyKeyspace != 0 {
+		db.publish("__keyspace"+prefix+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		db.publish("__keyevent"+prefix+event, key)
+	}
+}
+
+// notifyKeys 对多个 key 发送同一个事件
+func (db *DB) notifyKeys(class int, event string, keys ...string) {
+	for _, key := range keys {
+		db.notify(class, event, key)
+	}
+}
+
+// publishNotification 将键空间通知发布给本节点的订阅者, 集群模式下与 Redis 相同不转发到其它节点
+func (mdb *MultiDB) publishNotification(channel string, message string) {
+	pubsub.Publish(mdb.hub, [][]byte{[]byte(channel), []byte(message)})
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"redisGo/redis/reply"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordNotify 记录 db 发布的键空间通知, 格式为 "channel message"
+func recordNotify(db *DB) func() []string {
+	var mu sync.Mutex
+	var msgs []string
+	db.publish = func(channel string, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		msgs = append(msgs, channel+" "+message)
+	}
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		result := msgs
+		msgs = nil
+		return result
+	}
+}
+
+func assertNotified(t *testing.T, got []string, expected ...string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+		return
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected, got)
+			return
+		}
+	}
+}
+
+func TestParseNotifyFlags(t *testing.T) {
+	if flags := parseNotifyFlags(""); flags != 0 {
+		t.Errorf("expected 0, got %d", flags)
+	}
+	if flags := parseNotifyFlags("KEA"); flags != notifyKeyspace|notifyKeyevent|notifyAll || flags&notifyNew != 0 {
+		t.Errorf("unexpected flags %b", flags)
+	}
+	if flags := parseNotifyFlags("Elh"); flags != notifyKeyevent|notifyList|notifyHash {
+		t.Errorf("unexpected flags %b", flags)
+	}
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	config.Properties = &config.PropertyHolder{NotifyKeyspaceEvents: "KA"}
+	db := MakeDB()
+	db.index = 3
+	events := recordNotify(db)
+
+	db.Exec(nil, toArgs("set", "k", "v", "EX", "100"))
+	assertNotified(t, events(), "__keyspace@3__:k set", "__keyspace@3__:k expire")
+	db.Exec(nil, toArgs("set", "k", "v", "NX"))
+	assertNotified(t, events())
+	db.Exec(nil, toArgs("rename", "k", "k2"))
+	assertNotified(t, events(), "__keyspace@3__:k rename_from", "__keyspace@3__:k2 rename_to")
+	db.Exec(nil, toArgs("del", "k2", "missing"))
+	assertNotified(t, events(), "__keyspace@3__:k2 del")
+
+	db.Exec(nil, toArgs("incr", "n"))
+	db.Exec(nil, toArgs("incrbyfloat", "n", "1.5"))
+	db.Exec(nil, toArgs("del", "n"))
+	assertNotified(t, events(), "__keyspace@3__:n incrby", "__keyspace@3__:n incrbyfloat", "__keyspace@3__:n del")
+
+	db.Exec(nil, toArgs("rpush", "l", "a", "b"))
+	db.Exec(nil, toArgs("lpop", "l"))
+	db.Exec(nil, toArgs("rpoplpush", "l", "l2"))
+	assertNotified(t, events(), "__keyspace@3__:l rpush", "__keyspace@3__:l lpop",
+		"__keyspace@3__:l rpop", "__keyspace@3__:l2 lpush", "__keyspace@3__:l del")
+
+	db.Exec(nil, toArgs("hset", "h", "f", "1"))
+	db.Exec(nil, toArgs("hincrby", "h", "f", "1"))
+	db.Exec(nil, toArgs("hdel", "h", "f"))
+	assertNotified(t, events(), "__keyspace@3__:h hset", "__keyspace@3__:h hincrby",
+		"__keyspace@3__:h hdel", "__keyspace@3__:h del")
+
+	db.Exec(nil, toArgs("sadd", "s", "a"))
+	db.Exec(nil, toArgs("sadd", "s", "a"))
+	db.Exec(nil, toArgs("smove", "s", "s2", "a"))
+	assertNotified(t, events(), "__keyspace@3__:s sadd", "__keyspace@3__:s srem",
+		"__keyspace@3__:s del", "__keyspace@3__:s2 sadd")
+
+	db.Exec(nil, toArgs("zadd", "z", "1", "a"))
+	db.Exec(nil, toArgs("zincrby", "z", "1", "a"))
+	db.Exec(nil, toArgs("zpopmin", "z"))
+	assertNotified(t, events(), "__keyspace@3__:z zadd", "__keyspace@3__:z zincr",
+		"__keyspace@3__:z zpopmin", "__keyspace@3__:z del")
+}
+
+func TestKeyeventClasses(t *testing.T) {
+	// 只开启 generic 和 expired 类型的事件
+	config.Properties = &config.PropertyHolder{NotifyKeyspaceEvents: "Egx"}
+	db := MakeDB()
+	events := recordNotify(db)
+
+	db.Exec(nil, toArgs("set", "k", "v"))
+	db.Exec(nil, toArgs("rpush", "l", "a"))
+	assertNotified(t, events())
+	db.Exec(nil, toArgs("pexpire", "k", "1"))
+	db.Exec(nil, toArgs("del", "l"))
+	assertNotified(t, events(), "__keyevent@0__:expire k", "__keyevent@0__:del l")
+
+	time.Sleep(5 * time.Millisecond)
+	db.TimerTask(time.Now().Add(activeExpireTimeLimit))
+	assertNotified(t, events(), "__keyevent@0__:expired k")
+
+	// 没有 K 或 E 时不发送任何通知
+	db.notifyFlags = parseNotifyFlags("A")
+	db.Exec(nil, toArgs("set", "k", "v"))
+	assertNotified(t, events())
+
+	db.notifyFlags = parseNotifyFlags("En")
+	db.Exec(nil, toArgs("set", "k", "v2"))
+	db.Exec(nil, toArgs("set", "k2", "v"))
+	assertNotified(t, events(), "__keyevent@0__:new k2")
+}
+
+func TestNotifySubscribers(t *testing.T) {
+	config.Properties = &config.PropertyHolder{
+		Databases:            2,
+		MaxMemory:            700,
+		MaxMemoryPolicy:      "allkeys-random",
+		NotifyKeyspaceEvents: "KEA",
+	}
+	mdb, conn := MakeMultiDB(), connection.NewFakeConn()
+	defer mdb.Close()
+	subscriber := connection.NewFakeConn()
+	mdb.Exec(subscriber, toArgs("subscribe", "__keyspace@1__:k", "__keyevent@0__:evicted", "__keyevent@1__:evicted"))
+	subscriber.Clean()
+
+	mdb.Exec(conn, toArgs("select", "1"))
+	mdb.Exec(conn, toArgs("set", "k", "v"))
+	expected := string(reply.MakeMultiBulkReply(toArgs("message", "__keyspace@1__:k", "set")).ToBytes())
+	if got := string(subscriber.Bytes()); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	subscriber.Clean()
+
+	// 超过上限时随机淘汰某个数据库中的 key
+	mdb.Exec(conn, toArgs("select", "0"))
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		mdb.Exec(conn, toArgs("set", key, evictionValue))
+	}
+	if !strings.Contains(string(subscriber.Bytes()), "evicted") {
+		t.Errorf("expected evicted notification, got %q", subscriber.Bytes())
+	}
+}
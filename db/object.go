@@ -0,0 +1,73 @@
+package db
+
+import (
+	Dict "redisGo/datastruct/dict"
+	"redisGo/datastruct/set"
+	"redisGo/datastruct/sortedset"
+	"redisGo/interface/dict"
+	"redisGo/interface/list"
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// embstrSizeLimit 与 Redis 相同, 不超过 44 字节的字符串报告为 embstr
+const embstrSizeLimit = 44
+
+// encodingName 返回 OBJECT ENCODING 使用的编码名称
+func encodingName(entity *DataEntity) string {
+	switch val := entity.Data.(type) {
+	case []byte:
+		if n, err := strconv.ParseInt(string(val), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(val) {
+			return "int"
+		}
+		if len(val) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case list.List:
+		return "quicklist"
+	case *set.Set:
+		return val.Encoding()
+	case *Dict.CompactDict:
+		return val.Encoding()
+	case dict.Dict:
+		return "hashtable"
+	case *sortedset.SortedSet:
+		return "skiplist"
+	}
+	return ""
+}
+
+// Object OBJECT ENCODING|FREQ|IDLETIME key, 查看 key 的信息不会被记录为一次访问
+func Object(db *DB, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'object' command")
+	}
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "encoding", "freq", "idletime":
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT HELP.")
+	}
+	if len(args) != 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'object|" + sub + "' command")
+	}
+	entity, exists := db.Peek(string(args[1]))
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	switch sub {
+	case "freq":
+		if !isLFUPolicy(db.evictionPolicy) {
+			return reply.MakeErrReply("ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return reply.MakeIntReply(int64(entity.freq(time.Now())))
+	case "idletime":
+		return reply.MakeIntReply(int64(entity.idleTime(time.Now()) / time.Second))
+	}
+	return reply.MakeBulkReply([]byte(encodingName(entity)))
+}
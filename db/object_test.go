@@ -0,0 +1,59 @@
+package db
+
+import (
+	"redisGo/config"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestObjectEncoding(t *testing.T) {
+	config.Properties = &config.PropertyHolder{
+		SetMaxIntsetEntries:    4,
+		SetMaxListpackEntries:  4,
+		HashMaxListpackEntries: 2,
+	}
+	defer func() {
+		config.Properties = &config.PropertyHolder{}
+	}()
+	db := MakeDB()
+
+	db.Exec(nil, toArgs("set", "int", "12345"))
+	db.Exec(nil, toArgs("set", "str", "hello"))
+	db.Exec(nil, toArgs("set", "raw", strings.Repeat("x", 45)))
+	db.Exec(nil, toArgs("rpush", "list", "a"))
+	db.Exec(nil, toArgs("zadd", "zset", "1", "a"))
+	assertReply(t, db, "$3\r\nint\r\n", "object", "encoding", "int")
+	assertReply(t, db, "$6\r\nembstr\r\n", "object", "encoding", "str")
+	assertReply(t, db, "$3\r\nraw\r\n", "object", "encoding", "raw")
+	assertReply(t, db, "$9\r\nquicklist\r\n", "object", "encoding", "list")
+	assertReply(t, db, "$8\r\nskiplist\r\n", "object", "encoding", "zset")
+	assertReply(t, db, "$-1\r\n", "object", "encoding", "none")
+
+	db.Exec(nil, toArgs("sadd", "set", "1", "2", "3"))
+	assertReply(t, db, "$6\r\nintset\r\n", "object", "encoding", "set")
+	db.Exec(nil, toArgs("sadd", "set", "a"))
+	assertReply(t, db, "$8\r\nlistpack\r\n", "object", "encoding", "set")
+	db.Exec(nil, toArgs("sadd", "set", "b"))
+	assertReply(t, db, "$9\r\nhashtable\r\n", "object", "encoding", "set")
+	assertReply(t, db, ":5\r\n", "scard", "set")
+
+	db.Exec(nil, toArgs("hset", "hash", "f1", "v1"))
+	db.Exec(nil, toArgs("hset", "hash", "f2", "v2"))
+	assertReply(t, db, "$8\r\nlistpack\r\n", "object", "encoding", "hash")
+	db.Exec(nil, toArgs("hset", "hash", "f3", "v3"))
+	assertReply(t, db, "$9\r\nhashtable\r\n", "object", "encoding", "hash")
+	assertReply(t, db, "$2\r\nv1\r\n", "hget", "hash", "f1")
+
+	// 集合运算的结果按配置选择编码
+	args := []string{"sadd", "big"}
+	for i := 0; i < 10; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	db.Exec(nil, toArgs(args...))
+	db.Exec(nil, toArgs("sinterstore", "dest", "big", "set"))
+	assertReply(t, db, "$6\r\nintset\r\n", "object", "encoding", "dest")
+
+	assertReply(t, db, "-ERR unknown subcommand 'foo'. Try OBJECT HELP.\r\n", "object", "foo", "int")
+	assertReply(t, db, "-ERR wrong number of arguments for 'object|encoding' command\r\n", "object", "encoding")
+}
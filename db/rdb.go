@@ -0,0 +1,359 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"redisGo/config"
+	"redisGo/datastruct/set"
+	SortedSet "redisGo/datastruct/sortedset"
+	"redisGo/interface/dict"
+	"redisGo/interface/list"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/lib/rdb"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultDBFilename = "dump.rdb"
+
+// saveParam 对应配置 save <seconds> <changes>: 距离上次保存超过 seconds 秒且至少有 changes 次修改时自动 BGSAVE
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveParams 解析形如 "900 1 300 10" 的配置
+func parseSaveParams(raw string) []*saveParam {
+	fields := strings.Fields(raw)
+	params := make([]*saveParam, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 {
+			logger.Warn("illegal save config: " + raw)
+			continue
+		}
+		params = append(params, &saveParam{seconds: seconds, changes: changes})
+	}
+	return params
+}
+
+func rdbFilename() string {
+	if config.Properties.DBFilename == "" {
+		return defaultDBFilename
+	}
+	return config.Properties.DBFilename
+}
+
+// writeRDB 将所有数据库写入 rdb 文件, 调用者需要保证写入期间数据不被修改
+func (mdb *MultiDB) writeRDB(writer io.Writer) error {
+	return writeSnapshot(writer, mdb.snapshot())
+}
+
+// snapshot 复制所有数据库中未过期的 key, 调用者需要保证复制期间数据不被修改.
+// 只复制容器本身, 写命令总是替换而不会原地修改字节切片, 因此值可以与数据库共享
+func (mdb *MultiDB) snapshot() [][]*rdb.Object {
+	now := time.Now()
+	dbs := make([][]*rdb.Object, len(mdb.dbSet))
+	for i := range mdb.dbSet {
+		db := mdb.GetDB(i)
+		objects := make([]*rdb.Object, 0, db.data.Len())
+		db.data.ForEach(func(key string, raw interface{}) bool {
+			var expireAt *time.Time
+			if rawTTL, ok := db.ttlMap.Get(key); ok {
+				t, _ := rawTTL.(time.Time)
+				if t.Before(now) {
+					return true
+				}
+				expireAt = &t
+			}
+			entity, _ := raw.(*DataEntity)
+			if obj := entityToObject(key, entity, expireAt); obj != nil {
+				obj.DB = i
+				objects = append(objects, obj)
+			}
+			return true
+		})
+		dbs[i] = objects
+	}
+	return dbs
+}
+
+// writeSnapshot 将 snapshot 复制出的数据编码为 rdb 格式, 不需要持有数据库的锁
+func writeSnapshot(writer io.Writer, dbs [][]*rdb.Object) error {
+	bufWriter := bufio.NewWriter(writer)
+	encoder := rdb.NewEncoder(bufWriter)
+	err := encoder.WriteHeader()
+	if err != nil {
+		return err
+	}
+	for i, objects := range dbs {
+		if len(objects) == 0 {
+			continue
+		}
+		ttlCount := 0
+		for _, obj := range objects {
+			if obj.ExpireAt != nil {
+				ttlCount++
+			}
+		}
+		err = encoder.WriteDBHeader(i, len(objects), ttlCount)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			err = writeObject(encoder, obj)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = encoder.WriteEnd()
+	if err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
+
+// entityToObject 复制 entity 中的数据, 不支持的类型返回 nil
+func entityToObject(key string, entity *DataEntity, expireAt *time.Time) *rdb.Object {
+	obj := &rdb.Object{Key: key, ExpireAt: expireAt}
+	switch val := entity.Data.(type) {
+	case []byte:
+		obj.Type = rdb.TypeString
+		obj.String = val
+	case list.List:
+		obj.Type = rdb.TypeList
+		obj.Values = make([][]byte, 0, val.Len())
+		val.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			obj.Values = append(obj.Values, bytes)
+			return true
+		})
+	case *set.Set:
+		obj.Type = rdb.TypeSet
+		obj.Values = make([][]byte, 0, val.Len())
+		val.ForEach(func(member string) bool {
+			obj.Values = append(obj.Values, []byte(member))
+			return true
+		})
+	case dict.Dict:
+		obj.Type = rdb.TypeHash
+		obj.Hash = make(map[string][]byte, val.Len())
+		val.ForEach(func(field string, v interface{}) bool {
+			obj.Hash[field], _ = v.([]byte)
+			return true
+		})
+	case *SortedSet.SortedSet:
+		obj.Type = rdb.TypeZSet2
+		obj.ZSet = make([]*rdb.ZSetEntry, 0, val.Len())
+		if val.Len() > 0 {
+			val.ForEachByRank(0, val.Len(), false, func(element *SortedSet.Element) bool {
+				obj.ZSet = append(obj.ZSet, &rdb.ZSetEntry{Member: element.Member, Score: element.Score})
+				return true
+			})
+		}
+	default:
+		return nil
+	}
+	return obj
+}
+
+func writeObject(encoder *rdb.Encoder, obj *rdb.Object) error {
+	switch obj.Type {
+	case rdb.TypeString:
+		return encoder.WriteStringObject(obj.Key, obj.String, obj.ExpireAt)
+	case rdb.TypeList:
+		return encoder.WriteListObject(obj.Key, obj.Values, obj.ExpireAt)
+	case rdb.TypeSet:
+		return encoder.WriteSetObject(obj.Key, obj.Values, obj.ExpireAt)
+	case rdb.TypeHash:
+		return encoder.WriteHashObject(obj.Key, obj.Hash, obj.ExpireAt)
+	case rdb.TypeZSet2:
+		return encoder.WriteZSetObject(obj.Key, obj.ZSet, obj.ExpireAt)
+	}
+	return nil
+}
+
+// saveRDB 只在复制快照时阻塞写命令, 编码和写文件在释放锁之后进行.
+// 先写临时文件再重命名, 避免留下不完整的 rdb 文件
+func (mdb *MultiDB) saveRDB() error {
+	filename := rdbFilename()
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	unlock := mdb.lockAllDBs()
+	dirty := atomic.LoadInt64(&mdb.dirty)
+	dbs := mdb.snapshot()
+	unlock()
+	err = writeSnapshot(tmpFile, dbs)
+	if err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Sync()
+	if err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile.Name(), filename)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&mdb.dirty, -dirty)
+	atomic.StoreInt64(&mdb.lastSave, time.Now().Unix())
+	return nil
+}
+
+// bgSaveRDB 在后台生成快照, 同一时间只允许一个后台保存
+func (mdb *MultiDB) bgSaveRDB() error {
+	if !atomic.CompareAndSwapInt32(&mdb.saving, 0, 1) {
+		return errors.New("ERR Background save already in progress")
+	}
+	go func() {
+		defer atomic.StoreInt32(&mdb.saving, 0)
+		err := mdb.saveRDB()
+		if err != nil {
+			logger.Error("background saving error: " + err.Error())
+			return
+		}
+		logger.Info("background saving terminated with success")
+	}()
+	return nil
+}
+
+// loadRDB 启动时从 rdb 文件恢复数据
+func (mdb *MultiDB) loadRDB() {
+	file, err := os.Open(rdbFilename())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error(err)
+		}
+		return
+	}
+	defer file.Close()
+
+	_, err = mdb.loadRDBFrom(file)
+	if err != nil {
+		logger.Error("load rdb failed: " + err.Error())
+	}
+}
+
+// loadRDBFrom 从 reader 中读取一个完整的 rdb 快照, 也用于加载 aof 文件的 rdb 前导部分, 返回读取的字节数
+func (mdb *MultiDB) loadRDBFrom(reader io.Reader) (int64, error) {
+	now := time.Now()
+	decoder := rdb.NewDecoder(reader)
+	err := decoder.Parse(func(obj *rdb.Object) bool {
+		if obj.DB >= len(mdb.dbSet) {
+			logger.Warn("db index out of range: " + strconv.Itoa(obj.DB))
+			return true
+		}
+		if obj.ExpireAt != nil && obj.ExpireAt.Before(now) {
+			return true
+		}
+		entity := objectToEntity(obj)
+		if entity == nil {
+			return true
+		}
+		db := mdb.GetDB(obj.DB)
+		db.Put(obj.Key, entity)
+		if obj.ExpireAt != nil {
+			db.Expire(obj.Key, *obj.ExpireAt)
+		}
+		return true
+	})
+	return decoder.Consumed(), err
+}
+
+func objectToEntity(obj *rdb.Object) *DataEntity {
+	switch obj.Type {
+	case rdb.TypeString:
+		return &DataEntity{Data: obj.String}
+	case rdb.TypeList:
+		l := makeList()
+		for _, value := range obj.Values {
+			l.Add(value)
+		}
+		return &DataEntity{Data: l}
+	case rdb.TypeSet:
+		s := makeSet()
+		for _, member := range obj.Values {
+			s.Add(string(member))
+		}
+		return &DataEntity{Data: s}
+	case rdb.TypeHash:
+		hash := makeHash()
+		for field, value := range obj.Hash {
+			hash.Put(field, value)
+		}
+		return &DataEntity{Data: hash}
+	case rdb.TypeZSet, rdb.TypeZSet2:
+		sortedSet := SortedSet.Make()
+		for _, entry := range obj.ZSet {
+			sortedSet.Add(entry.Member, entry.Score)
+		}
+		return &DataEntity{Data: sortedSet}
+	}
+	return nil
+}
+
+// serverCron 每秒检查一次是否满足 save 配置的条件
+func (mdb *MultiDB) serverCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mdb.closed:
+			return
+		case <-ticker.C:
+			elapsed := time.Now().Unix() - atomic.LoadInt64(&mdb.lastSave)
+			dirty := atomic.LoadInt64(&mdb.dirty)
+			for _, param := range mdb.saveParams {
+				if dirty >= param.changes && elapsed >= param.seconds {
+					logger.Info("save condition satisfied, background saving started")
+					_ = mdb.bgSaveRDB()
+					break
+				}
+			}
+		}
+	}
+}
+
+func execSave(mdb *MultiDB) redis.Reply {
+	if atomic.LoadInt32(&mdb.saving) == 1 {
+		return reply.MakeErrReply("ERR Background save already in progress")
+	}
+	err := mdb.saveRDB()
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return &reply.OkReply{}
+}
+
+func execBGSave(mdb *MultiDB) redis.Reply {
+	err := mdb.bgSaveRDB()
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeStatusReply("Background saving started")
+}
+
+func execLastSave(mdb *MultiDB) redis.Reply {
+	return reply.MakeIntReply(atomic.LoadInt64(&mdb.lastSave))
+}
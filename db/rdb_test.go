@@ -0,0 +1,110 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadRDB(t *testing.T) {
+	config.Properties = &config.PropertyHolder{
+		DBFilename: filepath.Join(t.TempDir(), "dump.rdb"),
+	}
+	mdb := MakeMultiDB()
+	conn := connection.NewFakeConn()
+	mdb.Exec(conn, toArgs("set", "str", "hello"))
+	mdb.Exec(conn, toArgs("expire", "str", "1000"))
+	mdb.Exec(conn, toArgs("rpush", "list", "a", "b", "c"))
+	mdb.Exec(conn, toArgs("select", "5"))
+	mdb.Exec(conn, toArgs("sadd", "set", "x", "y"))
+	mdb.Exec(conn, toArgs("hset", "hash", "f", "v"))
+	mdb.Exec(conn, toArgs("zadd", "zset", "1", "m", "2.5", "n"))
+	if r := mdb.Exec(conn, toArgs("save")); string(r.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("save failed: %q", r.ToBytes())
+	}
+	if r := mdb.Exec(conn, toArgs("lastsave")); string(r.ToBytes()) == ":0\r\n" {
+		t.Errorf("unexpected lastsave: %q", r.ToBytes())
+	}
+	mdb.Close()
+
+	loaded := MakeMultiDB()
+	defer loaded.Close()
+	conn = connection.NewFakeConn()
+	checks := []struct {
+		db       int
+		cmd      []string
+		expected string
+	}{
+		{0, []string{"get", "str"}, "$5\r\nhello\r\n"},
+		{0, []string{"lrange", "list", "0", "-1"}, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{5, []string{"scard", "set"}, ":2\r\n"},
+		{5, []string{"hget", "hash", "f"}, "$1\r\nv\r\n"},
+		{5, []string{"zscore", "zset", "n"}, "$3\r\n2.5\r\n"},
+	}
+	for _, check := range checks {
+		conn.SelectDB(check.db)
+		if r := loaded.Exec(conn, toArgs(check.cmd...)); string(r.ToBytes()) != check.expected {
+			t.Errorf("%v: expected %q, got %q", check.cmd, check.expected, r.ToBytes())
+		}
+	}
+	conn.SelectDB(0)
+	if r := loaded.Exec(conn, toArgs("ttl", "str")); string(r.ToBytes()) == ":-1\r\n" {
+		t.Errorf("ttl should be restored, got %q", r.ToBytes())
+	}
+}
+
+// BGSAVE 只在复制快照时阻塞写命令, 编码和写文件期间写命令不需要等待
+func TestBGSaveDoesNotBlockWrites(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.PropertyHolder{
+		DBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	mdb := MakeMultiDB()
+	defer mdb.Close()
+	conn := connection.NewFakeConn()
+	value := strings.Repeat("x", 32*1024)
+	for i := 0; i < 2000; i++ {
+		mdb.Exec(conn, toArgs("set", "key"+strconv.Itoa(i), value))
+	}
+
+	if r := mdb.Exec(conn, toArgs("bgsave")); string(r.ToBytes()) != "+Background saving started\r\n" {
+		t.Fatalf("bgsave failed: %q", r.ToBytes())
+	}
+	// 临时文件中出现数据说明已经开始编码
+	encoding := func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "temp-*.rdb"))
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil && info.Size() > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	for !encoding() {
+		if atomic.LoadInt32(&mdb.saving) == 0 {
+			t.Fatal("background save finished before it was observed")
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+	start := time.Now()
+	if r := mdb.Exec(conn, toArgs("set", "during", "save")); string(r.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("set failed: %q", r.ToBytes())
+	}
+	writeLatency := time.Since(start)
+	for atomic.LoadInt32(&mdb.saving) == 1 {
+		time.Sleep(time.Millisecond)
+	}
+	saveDuration := time.Since(start)
+	if writeLatency > saveDuration/4 {
+		t.Errorf("write took %v while the rest of the background save took %v", writeLatency, saveDuration)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dump.rdb")); err != nil {
+		t.Errorf("rdb file should be saved: %v", err)
+	}
+}
@@ -0,0 +1,406 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/redis/connection"
+	"redisGo/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	roleMaster = iota
+	roleReplica
+)
+
+const (
+	defaultBacklogSize = 1 << 20 // 1MB
+	replicaQueueSize   = 1024    // 每个副本等待发送的复制流数量上限, 超过后断开该副本
+)
+
+// replBacklog 复制积压缓冲区, 是一个环形缓冲区, 保存复制流中最近的数据, 用于断线重连后的部分重同步
+type replBacklog struct {
+	buf   []byte
+	start int64 // 缓冲区中最早一个字节的复制偏移量
+	end   int64 // 缓冲区中最后一个字节之后的复制偏移量, 即当前的复制偏移量
+}
+
+func makeReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{
+		buf:   make([]byte, size),
+		start: offset,
+		end:   offset,
+	}
+}
+
+func (b *replBacklog) write(data []byte) {
+	size := int64(len(b.buf))
+	if int64(len(data)) > size {
+		// 只需要保留最后 size 个字节
+		b.end += int64(len(data)) - size
+		data = data[int64(len(data))-size:]
+	}
+	for len(data) > 0 {
+		n := copy(b.buf[b.end%size:], data)
+		data = data[n:]
+		b.end += int64(n)
+	}
+	if b.end-b.start > size {
+		b.start = b.end - size
+	}
+}
+
+// readFrom 返回从 offset 开始到当前的全部数据, offset 已经被覆盖时返回 false
+func (b *replBacklog) readFrom(offset int64) ([]byte, bool) {
+	if offset < b.start || offset > b.end {
+		return nil, false
+	}
+	size := int64(len(b.buf))
+	result := make([]byte, 0, b.end-offset)
+	for offset < b.end {
+		chunk := b.buf[offset%size:]
+		if int64(len(chunk)) > b.end-offset {
+			chunk = chunk[:b.end-offset]
+		}
+		result = append(result, chunk...)
+		offset += int64(len(chunk))
+	}
+	return result, true
+}
+
+// replicaInfo 主节点上记录的一个副本连接
+type replicaInfo struct {
+	conn          redis.Connection
+	listeningPort string
+	sendCh        chan []byte // 完成 PSYNC 后才不为 nil, 由单独的 goroutine 写入连接
+	ackOffset     int64
+	lastAck       time.Time
+}
+
+func (r *replicaInfo) serve(ch chan []byte) {
+	for data := range ch {
+		if err := r.conn.Write(data); err != nil {
+			logger.Warn("write to replica failed: " + err.Error())
+			return
+		}
+	}
+}
+
+// replication 保存主从复制的状态, 所有字段由 mu 保护, role 和 linkGen 可以原子读取
+type replication struct {
+	mu   sync.Mutex
+	role int32
+
+	replId           string
+	replId2          string // 成为主节点前所属主节点的 replid, 持有它的副本仍然可以部分重同步
+	secondReplOffset int64  // replId2 的有效范围截止的偏移量
+	offset           int64  // 复制偏移量, 即复制流的总长度
+	backlog          *replBacklog
+	backlogSize      int
+	currentDB        int // 复制流中最后一次 SELECT 的数据库, -1 表示需要重新发送 SELECT
+
+	replicas map[redis.Connection]*replicaInfo
+
+	// 作为副本时的状态
+	masterAddr   string
+	masterConn   net.Conn
+	masterClient *connection.FakeConn // 执行主节点发来的命令, 跨越部分重同步保持 SELECT 状态
+	linkUp       bool
+	lastIO       time.Time
+	linkGen      int64 // 每次 REPLICAOF 时加一, 旧的同步 goroutine 发现后退出
+	announcePort int
+}
+
+func makeReplication() *replication {
+	size := config.Properties.ReplBacklogSize
+	if size <= 0 {
+		size = defaultBacklogSize
+	}
+	return &replication{
+		role:             roleMaster,
+		replId:           genReplId(),
+		secondReplOffset: -1,
+		backlogSize:      size,
+		currentDB:        -1,
+		replicas:         make(map[redis.Connection]*replicaInfo),
+		announcePort:     config.Properties.Port,
+	}
+}
+
+// genReplId 生成 40 个字符的随机 replication id
+func genReplId() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (mdb *MultiDB) isReplica() bool {
+	return atomic.LoadInt32(&mdb.repl.role) == roleReplica
+}
+
+// feedReplication 将写命令追加到复制流, 只有主节点并且曾经有副本连接(积压缓冲区已创建)时才需要
+func (mdb *MultiDB) feedReplication(dbIndex int, cmdLines []*reply.MultiBulkReply) {
+	repl := mdb.repl
+	if atomic.LoadInt32(&repl.role) != roleMaster {
+		return
+	}
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.backlog == nil {
+		return
+	}
+	buf := &bytes.Buffer{}
+	if dbIndex != repl.currentDB {
+		buf.Write(makeSelectCmd(dbIndex).ToBytes())
+		repl.currentDB = dbIndex
+	}
+	for _, cmdLine := range cmdLines {
+		buf.Write(cmdLine.ToBytes())
+	}
+	repl.appendStream(buf.Bytes())
+}
+
+// appendStream 写入积压缓冲区并发送给所有已完成同步的副本, 调用者需持有 mu
+func (repl *replication) appendStream(data []byte) {
+	repl.backlog.write(data)
+	repl.offset += int64(len(data))
+	for _, r := range repl.replicas {
+		if r.sendCh == nil {
+			continue
+		}
+		select {
+		case r.sendCh <- data:
+		default:
+			// 副本消费过慢, 断开连接, 副本重连后会重新同步
+			logger.Warn("replica " + r.conn.RemoteAddr() + " output buffer overflow, disconnecting")
+			repl.removeReplica(r.conn)
+			if closer, ok := r.conn.(io.Closer); ok {
+				go closer.Close()
+			}
+		}
+	}
+}
+
+// getReplica 返回连接对应的副本, 不存在时创建, 调用者需持有 mu
+func (repl *replication) getReplica(c redis.Connection) *replicaInfo {
+	r, ok := repl.replicas[c]
+	if !ok {
+		r = &replicaInfo{conn: c}
+		repl.replicas[c] = r
+	}
+	return r
+}
+
+// attachReplica 开始向副本发送复制流, first 为同步的第一部分数据, 调用者需持有 mu
+func (repl *replication) attachReplica(c redis.Connection, first []byte) {
+	r := repl.getReplica(c)
+	if r.sendCh != nil {
+		close(r.sendCh)
+	}
+	r.sendCh = make(chan []byte, replicaQueueSize)
+	r.lastAck = time.Now()
+	r.sendCh <- first
+	go r.serve(r.sendCh)
+}
+
+// removeReplica 调用者需持有 mu
+func (repl *replication) removeReplica(c redis.Connection) {
+	r, ok := repl.replicas[c]
+	if !ok {
+		return
+	}
+	if r.sendCh != nil {
+		close(r.sendCh)
+	}
+	delete(repl.replicas, c)
+}
+
+// disconnectReplicas 关闭所有副本连接, 调用者需持有 mu
+func (repl *replication) disconnectReplicas() {
+	for c := range repl.replicas {
+		repl.removeReplica(c)
+		if closer, ok := c.(io.Closer); ok {
+			go closer.Close()
+		}
+	}
+}
+
+// execPSync PSYNC replid offset, offset 为副本已经处理的复制流字节数, 无法部分重同步时进行全量同步
+func execPSync(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	if mdb.isReplica() {
+		return reply.MakeErrReply("ERR chained replication is not supported")
+	}
+	replId := string(args[0])
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if mdb.tryPartialResync(c, replId, offset) {
+		return &reply.NoReply{}
+	}
+	err = mdb.fullResync(c)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return &reply.NoReply{}
+}
+
+func (mdb *MultiDB) tryPartialResync(c redis.Connection, replId string, offset int64) bool {
+	repl := mdb.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.backlog == nil {
+		return false
+	}
+	if replId != repl.replId && (replId != repl.replId2 || offset > repl.secondReplOffset) {
+		return false
+	}
+	data, ok := repl.backlog.readFrom(offset)
+	if !ok {
+		return false
+	}
+	logger.Info(fmt.Sprintf("partial resynchronization request from %s accepted, sending %d bytes of backlog",
+		c.RemoteAddr(), len(data)))
+	repl.attachReplica(c, append([]byte("+CONTINUE "+repl.replId+"\r\n"), data...))
+	return true
+}
+
+// fullResync 阻塞写命令, 生成 rdb 快照并发送给副本, 快照之后的写命令从快照对应的偏移量开始发送
+func (mdb *MultiDB) fullResync(c redis.Connection) error {
+	unlock := mdb.lockAllDBs()
+	defer unlock()
+	repl := mdb.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+
+	if repl.backlog == nil {
+		repl.backlog = makeReplBacklog(repl.backlogSize, repl.offset)
+	}
+	snapshot := &bytes.Buffer{}
+	err := mdb.writeRDB(snapshot)
+	if err != nil {
+		return err
+	}
+	// 快照不包含 SELECT 状态, 之后的命令需要重新指定数据库
+	repl.currentDB = -1
+	logger.Info(fmt.Sprintf("starting full resynchronization with replica %s, offset %d", c.RemoteAddr(), repl.offset))
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", repl.replId, repl.offset, snapshot.Len())
+	repl.attachReplica(c, append([]byte(header), snapshot.Bytes()...))
+	return nil
+}
+
+// execReplConf REPLCONF option value [option value ...], 副本用来报告监听端口以及已处理的偏移量
+func execReplConf(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return reply.MakeErrReply("ERR syntax error")
+	}
+	repl := mdb.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	for i := 0; i < len(args); i += 2 {
+		option := strings.ToLower(string(args[i]))
+		switch option {
+		case "listening-port":
+			repl.getReplica(c).listeningPort = string(args[i+1])
+		case "ack":
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return &reply.NoReply{}
+			}
+			if r, ok := repl.replicas[c]; ok {
+				r.ackOffset = offset
+				r.lastAck = time.Now()
+			}
+			// ACK 不需要回复
+			return &reply.NoReply{}
+		case "capa", "ip-address":
+		default:
+			return reply.MakeErrReply("ERR Unrecognized REPLCONF option: " + option)
+		}
+	}
+	return &reply.OkReply{}
+}
+
+func replicationInfo(mdb *MultiDB) [][2]string {
+	repl := mdb.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	info := make([][2]string, 0)
+	if repl.role == roleReplica {
+		host, port, _ := net.SplitHostPort(repl.masterAddr)
+		linkStatus := "down"
+		if repl.linkUp {
+			linkStatus = "up"
+		}
+		lastIO := int64(-1)
+		if !repl.lastIO.IsZero() {
+			lastIO = int64(time.Since(repl.lastIO).Seconds())
+		}
+		info = append(info,
+			[2]string{"role", "slave"},
+			[2]string{"master_host", host},
+			[2]string{"master_port", port},
+			[2]string{"master_link_status", linkStatus},
+			[2]string{"master_last_io_seconds_ago", strconv.FormatInt(lastIO, 10)},
+			[2]string{"slave_repl_offset", strconv.FormatInt(repl.offset, 10)},
+			[2]string{"slave_read_only", "1"},
+			[2]string{"connected_slaves", "0"},
+		)
+	} else {
+		replicas := make([]*replicaInfo, 0, len(repl.replicas))
+		for _, r := range repl.replicas {
+			if r.sendCh != nil {
+				replicas = append(replicas, r)
+			}
+		}
+		sort.Slice(replicas, func(i, j int) bool {
+			return replicas[i].conn.RemoteAddr() < replicas[j].conn.RemoteAddr()
+		})
+		info = append(info,
+			[2]string{"role", "master"},
+			[2]string{"connected_slaves", strconv.Itoa(len(replicas))},
+		)
+		for i, r := range replicas {
+			ip, _, _ := net.SplitHostPort(r.conn.RemoteAddr())
+			info = append(info, [2]string{
+				"slave" + strconv.Itoa(i),
+				fmt.Sprintf("ip=%s,port=%s,state=online,offset=%d,lag=%d",
+					ip, r.listeningPort, r.ackOffset, int64(time.Since(r.lastAck).Seconds())),
+			})
+		}
+	}
+	info = append(info,
+		[2]string{"master_replid", repl.replId},
+		[2]string{"master_replid2", replId2OrZero(repl.replId2)},
+		[2]string{"master_repl_offset", strconv.FormatInt(repl.offset, 10)},
+		[2]string{"second_repl_offset", strconv.FormatInt(repl.secondReplOffset, 10)},
+		[2]string{"repl_backlog_active", boolToInfo(repl.backlog != nil)},
+		[2]string{"repl_backlog_size", strconv.Itoa(repl.backlogSize)},
+	)
+	if repl.backlog != nil {
+		info = append(info,
+			[2]string{"repl_backlog_first_byte_offset", strconv.FormatInt(repl.backlog.start, 10)},
+			[2]string{"repl_backlog_histlen", strconv.FormatInt(repl.backlog.end-repl.backlog.start, 10)},
+		)
+	}
+	return info
+}
+
+func replId2OrZero(id string) string {
+	if id == "" {
+		return strings.Repeat("0", 40)
+	}
+	return id
+}
@@ -0,0 +1,360 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/redis/connection"
+	"redisGo/redis/parser"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	masterDialTimeout  = 3 * time.Second
+	replicaAckInterval = time.Second
+	reconnectInterval  = time.Second
+)
+
+var errLinkStopped = errors.New("replication link stopped")
+
+// execReplicaOf REPLICAOF host port 成为指定主节点的副本, REPLICAOF NO ONE 停止复制并成为主节点
+func execReplicaOf(mdb *MultiDB, args [][]byte) redis.Reply {
+	if strings.EqualFold(string(args[0]), "no") && strings.EqualFold(string(args[1]), "one") {
+		mdb.becomeMaster()
+		return &reply.OkReply{}
+	}
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid master port")
+	}
+	if !mdb.becomeReplica(net.JoinHostPort(string(args[0]), strconv.Itoa(port))) {
+		return reply.MakeStatusReply("OK Already connected to specified master")
+	}
+	return &reply.OkReply{}
+}
+
+// becomeReplica 开始与主节点同步, 已经在同步该主节点时返回 false
+func (mdb *MultiDB) becomeReplica(addr string) bool {
+	repl := mdb.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.role == roleReplica && repl.masterAddr == addr {
+		return false
+	}
+	repl.stopLink()
+	// 不支持级联复制, 断开原有副本, 它们需要重新选择主节点
+	repl.disconnectReplicas()
+	atomic.StoreInt32(&repl.role, roleReplica)
+	repl.masterAddr = addr
+	gen := repl.linkGen
+	logger.Info("connecting to master " + addr)
+	go mdb.replicationLoop(addr, gen)
+	return true
+}
+
+// becomeMaster 停止复制, 保留原来的 replid 作为 replid2, 使其它副本切换到本节点时可以部分重同步
+func (mdb *MultiDB) becomeMaster() {
+	repl := mdb.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.role == roleMaster {
+		return
+	}
+	repl.stopLink()
+	repl.replId2 = repl.replId
+	repl.secondReplOffset = repl.offset
+	repl.replId = genReplId()
+	repl.currentDB = -1
+	repl.masterAddr = ""
+	atomic.StoreInt32(&repl.role, roleMaster)
+	logger.Info("replication stopped, now acting as master")
+}
+
+// stopLink 使当前的同步 goroutine 失效并断开与主节点的连接, 调用者需持有 mu
+func (repl *replication) stopLink() {
+	atomic.AddInt64(&repl.linkGen, 1)
+	if repl.masterConn != nil {
+		_ = repl.masterConn.Close()
+		repl.masterConn = nil
+	}
+	repl.linkUp = false
+}
+
+func (mdb *MultiDB) linkAlive(gen int64) bool {
+	select {
+	case <-mdb.closed:
+		return false
+	default:
+	}
+	return atomic.LoadInt64(&mdb.repl.linkGen) == gen
+}
+
+// replicationLoop 与主节点保持连接, 连接断开后自动重连并尝试部分重同步
+func (mdb *MultiDB) replicationLoop(addr string, gen int64) {
+	for mdb.linkAlive(gen) {
+		err := mdb.syncWithMaster(addr, gen)
+		if !mdb.linkAlive(gen) {
+			return
+		}
+		logger.Warn("replication link with master " + addr + " broken: " + err.Error())
+		mdb.repl.mu.Lock()
+		if atomic.LoadInt64(&mdb.repl.linkGen) == gen {
+			mdb.repl.linkUp = false
+			mdb.repl.masterConn = nil
+		}
+		mdb.repl.mu.Unlock()
+		select {
+		case <-mdb.closed:
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+func sendCommand(conn net.Conn, args ...string) error {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	_, err := conn.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+	return err
+}
+
+func readStatusLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "-") {
+		return "", errors.New(line[1:])
+	}
+	return line, nil
+}
+
+// syncWithMaster 完成一次握手与同步, 然后持续接收主节点的复制流直到连接断开
+func (mdb *MultiDB) syncWithMaster(addr string, gen int64) error {
+	conn, err := net.DialTimeout("tcp", addr, masterDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	repl := mdb.repl
+	repl.mu.Lock()
+	if atomic.LoadInt64(&repl.linkGen) != gen {
+		repl.mu.Unlock()
+		return errLinkStopped
+	}
+	repl.masterConn = conn
+	replId, offset := repl.replId, repl.offset
+	if repl.backlog == nil {
+		// 从未参与过复制, 直接请求全量同步
+		replId, offset = "?", -1
+	}
+	repl.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	err = sendCommand(conn, "REPLCONF", "listening-port", strconv.Itoa(repl.announcePort))
+	if err != nil {
+		return err
+	}
+	if _, err = readStatusLine(reader); err != nil {
+		return err
+	}
+	err = sendCommand(conn, "PSYNC", replId, strconv.FormatInt(offset, 10))
+	if err != nil {
+		return err
+	}
+	line, err := readStatusLine(reader)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 0:
+		return errors.New("empty PSYNC reply")
+	case fields[0] == "+FULLRESYNC" && len(fields) == 3:
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return errors.New("illegal FULLRESYNC reply: " + line)
+		}
+		err = mdb.fullSyncFromMaster(reader, gen, fields[1], masterOffset)
+		if err != nil {
+			return err
+		}
+	case fields[0] == "+CONTINUE":
+		repl.mu.Lock()
+		if len(fields) == 2 && fields[1] != repl.replId {
+			// 主节点发生了切换, 新的 replid 从当前偏移量开始生效
+			repl.replId2 = repl.replId
+			repl.secondReplOffset = repl.offset
+			repl.replId = fields[1]
+		}
+		repl.mu.Unlock()
+		logger.Info("partial resynchronization with master " + addr + " succeeded")
+	default:
+		return errors.New("unexpected PSYNC reply: " + line)
+	}
+
+	repl.mu.Lock()
+	if atomic.LoadInt64(&repl.linkGen) != gen {
+		repl.mu.Unlock()
+		return errLinkStopped
+	}
+	repl.linkUp = true
+	repl.lastIO = time.Now()
+	repl.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go mdb.sendAcks(conn, done)
+	return mdb.receiveStream(reader, gen)
+}
+
+// fullSyncFromMaster 读取主节点发送的 rdb 快照, 清空本地数据后加载
+func (mdb *MultiDB) fullSyncFromMaster(reader *bufio.Reader, gen int64, replId string, masterOffset int64) error {
+	line, err := readStatusLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return errors.New("illegal snapshot header: " + line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return errors.New("illegal snapshot header: " + line)
+	}
+	// 先完整读取快照, 避免在网络传输期间阻塞本地的读写
+	snapshot := make([]byte, size)
+	_, err = io.ReadFull(reader, snapshot)
+	if err != nil {
+		return err
+	}
+
+	unlock := mdb.lockAllDBs()
+	defer unlock()
+	if !mdb.linkAlive(gen) {
+		return errLinkStopped
+	}
+	for i := range mdb.dbSet {
+		mdb.GetDB(i).Flush()
+	}
+	_, err = mdb.loadRDBFrom(bytes.NewReader(snapshot))
+	if err != nil {
+		return err
+	}
+
+	repl := mdb.repl
+	repl.mu.Lock()
+	repl.replId = replId
+	repl.replId2 = ""
+	repl.secondReplOffset = -1
+	repl.offset = masterOffset
+	repl.backlog = makeReplBacklog(repl.backlogSize, masterOffset)
+	repl.masterClient = connection.NewFakeConn()
+	repl.mu.Unlock()
+
+	if config.Properties.AppendOnly {
+		mdb.appendDatasetToAof()
+	}
+	logger.Info("full resynchronization with master finished, " + strconv.FormatInt(size, 10) + " bytes loaded")
+	return nil
+}
+
+// appendDatasetToAof 全量同步后本地数据被整体替换, 将新的数据集写入 aof, 调用者需阻塞所有写命令
+func (mdb *MultiDB) appendDatasetToAof() {
+	mdb.addAof(0, makeAofCmd("flushall", nil))
+	for i := range mdb.dbSet {
+		db := mdb.GetDB(i)
+		cmdLines := make([]*reply.MultiBulkReply, 0, db.data.Len())
+		db.data.ForEach(func(key string, raw interface{}) bool {
+			entity, _ := raw.(*DataEntity)
+			cmdLines = append(cmdLines, EntityToCmd(key, entity))
+			if rawTTL, ok := db.ttlMap.Get(key); ok {
+				cmdLines = append(cmdLines, makeExpireCmd(key, rawTTL.(time.Time)))
+			}
+			return true
+		})
+		if len(cmdLines) > 0 {
+			mdb.addAof(i, cmdLines...)
+		}
+	}
+}
+
+// receiveStream 执行主节点发来的写命令, 同时写入本地积压缓冲区, 使本节点被提升为主节点后其它副本可以部分重同步
+func (mdb *MultiDB) receiveStream(reader *bufio.Reader, gen int64) error {
+	repl := mdb.repl
+	var streamErr error
+	var lastOffset int64
+	parser.ParseStream(reader, func(p *parser.Payload) bool {
+		if p.Err != nil {
+			streamErr = p.Err
+			return false
+		}
+		if !mdb.linkAlive(gen) {
+			streamErr = errLinkStopped
+			return false
+		}
+		size := p.Offset - lastOffset
+		lastOffset = p.Offset
+		cmdLine, ok := p.Data.(*reply.MultiBulkReply)
+		if !ok || len(cmdLine.Args) == 0 {
+			streamErr = errors.New("illegal command from master")
+			return false
+		}
+
+		repl.mu.Lock()
+		client := repl.masterClient
+		if client == nil {
+			client = connection.NewFakeConn()
+			repl.masterClient = client
+		}
+		repl.mu.Unlock()
+		mdb.exec(client, cmdLine.Args)
+		// 回复不需要发送给主节点
+		client.Clean()
+
+		repl.mu.Lock()
+		if repl.backlog == nil {
+			repl.backlog = makeReplBacklog(repl.backlogSize, repl.offset)
+		}
+		repl.backlog.write(cmdLine.ToBytes())
+		repl.offset += size
+		repl.lastIO = time.Now()
+		repl.mu.Unlock()
+		return true
+	})
+	if streamErr == nil {
+		streamErr = io.EOF
+	}
+	return streamErr
+}
+
+// sendAcks 每秒向主节点报告已经处理的偏移量
+func (mdb *MultiDB) sendAcks(conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(replicaAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			mdb.repl.mu.Lock()
+			offset := mdb.repl.offset
+			mdb.repl.mu.Unlock()
+			err := sendCommand(conn, "REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"bytes"
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitForBytes(conn *connection.FakeConn, expected []byte) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if bytes.Contains(conn.Bytes(), expected) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestReplBacklog(t *testing.T) {
+	backlog := makeReplBacklog(8, 100)
+	backlog.write([]byte("abcde"))
+	backlog.write([]byte("fghij"))
+	if backlog.start != 102 || backlog.end != 110 {
+		t.Fatalf("unexpected range [%d, %d)", backlog.start, backlog.end)
+	}
+	if data, ok := backlog.readFrom(104); !ok || string(data) != "efghij" {
+		t.Errorf("unexpected data: %q", data)
+	}
+	if _, ok := backlog.readFrom(101); ok {
+		t.Errorf("overwritten offset should not be readable")
+	}
+	backlog.write([]byte("0123456789"))
+	if data, ok := backlog.readFrom(112); !ok || string(data) != "23456789" {
+		t.Errorf("unexpected data: %q", data)
+	}
+}
+
+func TestPSync(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	mdb := MakeMultiDB()
+	defer mdb.Close()
+	client := connection.NewFakeConn()
+	mdb.Exec(client, toArgs("set", "a", "1"))
+
+	replica := connection.NewFakeConn()
+	mdb.Exec(replica, toArgs("psync", "?", "-1"))
+	if !waitForBytes(replica, []byte("+FULLRESYNC "+mdb.repl.replId+" 0\r\n$")) {
+		t.Fatalf("unexpected full resync: %q", replica.Bytes())
+	}
+	mdb.Exec(client, toArgs("set", "b", "2"))
+	expected := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1\r\n2\r\n"
+	if !waitForBytes(replica, []byte(expected)) {
+		t.Fatalf("command should be propagated to replica: %q", replica.Bytes())
+	}
+
+	// 从偏移量 0 开始部分重同步, 重新发送积压缓冲区中的全部数据
+	another := connection.NewFakeConn()
+	mdb.Exec(another, toArgs("psync", mdb.repl.replId, "0"))
+	if !waitForBytes(another, []byte("+CONTINUE "+mdb.repl.replId+"\r\n"+expected)) {
+		t.Errorf("unexpected partial resync: %q", another.Bytes())
+	}
+	info := string(mdb.Exec(client, toArgs("info", "replication")).ToBytes())
+	if !strings.Contains(info, "connected_slaves:2") || !strings.Contains(info, "master_repl_offset:"+
+		strconv.Itoa(len(expected))) {
+		t.Errorf("unexpected info: %q", info)
+	}
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	mdb := MakeMultiDB()
+	defer mdb.Close()
+	// 指向一个不可达的地址, 同步失败也不影响只读检查
+	mdb.Exec(nil, toArgs("replicaof", "127.0.0.1", "1"))
+	conn := connection.NewFakeConn()
+	if r := mdb.Exec(conn, toArgs("set", "a", "1")); !strings.HasPrefix(string(r.ToBytes()), "-READONLY") {
+		t.Errorf("write should be rejected on replica, got %q", r.ToBytes())
+	}
+	if r := mdb.Exec(conn, toArgs("get", "a")); string(r.ToBytes()) != "$-1\r\n" {
+		t.Errorf("read should be allowed on replica, got %q", r.ToBytes())
+	}
+	mdb.Exec(nil, toArgs("replicaof", "no", "one"))
+	if r := mdb.Exec(conn, toArgs("set", "a", "1")); string(r.ToBytes()) != "+OK\r\n" {
+		t.Errorf("write should be allowed after REPLICAOF NO ONE, got %q", r.ToBytes())
+	}
+}
+
+func TestExpirePropagation(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	cmds := recordAof(db)
+	db.Exec(nil, toArgs("set", "a", "1", "EX", "100"))
+	db.Exec(nil, toArgs("set", "b", "2"))
+	db.Exec(nil, toArgs("expire", "b", "100"))
+	db.Exec(nil, toArgs("pexpireat", "b", "4102444800000"))
+	db.Exec(nil, toArgs("set", "a", "3", "NX", "PX", "100"))
+
+	got := cmds()
+	if len(got) != 5 {
+		t.Fatalf("expected 5 commands, got %q", got)
+	}
+	// 相对的过期时间被转换为绝对时间
+	for i, prefix := range []string{"set a 1", "PEXPIREAT a ", "set b 2", "PEXPIREAT b ", "PEXPIREAT b 4102444800000"} {
+		if !strings.HasPrefix(got[i], prefix) {
+			t.Errorf("expected %q, got %q", prefix, got[i])
+		}
+	}
+	expireAt, _ := db.ExpireTime("a")
+	if got[1] != "PEXPIREAT a "+strconv.FormatInt(expireAt.UnixMilli(), 10) {
+		t.Errorf("unexpected expire command %q", got[1])
+	}
+}
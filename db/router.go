@@ -1,74 +1,254 @@
 package db
 
-func makeRouter() map[string]cmdFunc {
-	cmdMap := make(map[string]cmdFunc)
-	cmdMap["ping"] = Ping
-	cmdMap["get"] = Get
-	cmdMap["set"] = Set
-	cmdMap["del"] = Del
-	cmdMap["mset"] = MSet
-	cmdMap["mget"] = MGet
-	cmdMap["getset"] = GetSet
-	cmdMap["incr"] = Incr
-	cmdMap["incrby"] = IncrBy
-	cmdMap["incrbyfloat"] = IncrByFloat
-	cmdMap["decr"] = Decr
-	cmdMap["decrby"] = DecrBy
-	cmdMap["decrbyfloat"] = DecrByFloat
-
-	cmdMap["isexpired"] = IsExpired
-	cmdMap["expire"] = Expire
-	cmdMap["expireat"] = ExpireAt
-	cmdMap["pexpire"] = PExpire
-	cmdMap["pexpireat"] = PExpireAt
-	cmdMap["ttl"] = TTL
-	cmdMap["pttl"] = PTTL
-	cmdMap["persist"] = Persist
-	cmdMap["exists"] = Exists
-	cmdMap["type"] = Type
-	cmdMap["rename"] = Rename
-	cmdMap["renamenx"] = RenameNX
-
-	cmdMap["rpush"] = RPush
-	cmdMap["lindex"] = LIndex
-	cmdMap["llen"] = LLen
-	cmdMap["lpop"] = LPop
-	cmdMap["lpush"] = LPush
-	cmdMap["lrange"] = LRange
-	cmdMap["lrem"] = LRem
-	cmdMap["lset"] = LSet
-	cmdMap["rpop"] = RPop
-	cmdMap["rpoplpush"] = RPopLPush
-
-	cmdMap["hset"] = HSet
-	cmdMap["hsetnx"] = HSetNX
-	cmdMap["hget"] = HGet
-	cmdMap["hexists"] = HExists
-	cmdMap["hdel"] = HDel
-	cmdMap["hlen"] = HLen
-	cmdMap["hmset"] = HMSet
-	cmdMap["hmget"] = HMGet
-	cmdMap["hkeys"] = HKeys
-	cmdMap["hvals"] = HVals
-	cmdMap["hgetall"] = HGetAll
-	cmdMap["hincrby"] = HIncrBy
-	cmdMap["hincrbyfloat"] = HIncrByFloat
-
-	cmdMap["sadd"] = SAdd
-	cmdMap["sismember"] = SIsMember
-	cmdMap["srem"] = SRem
-	cmdMap["scard"] = SCard
-	cmdMap["smembers"] = SMembers
-	cmdMap["sinter"] = SInter
-	cmdMap["sinterstore"] = SInterStore
-	cmdMap["sunion"] = SUnion
-	cmdMap["sunionstore"] = SUnionStore
-	cmdMap["sdiff"] = SDiff
-	cmdMap["sdiffstore"] = SDiffStore
-	cmdMap["srandmember"] = SRandMember
-
-	cmdMap["flushdb"] = FlushDB   // 清空数据库内容
-	cmdMap["flushall"] = FlushAll // 清空数据库内容
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	flagWrite    = 0
+	flagReadOnly = 1
+	// flagAllowOOM 不会增加内存的写命令, 超过 maxmemory 时仍然可以执行
+	flagAllowOOM = 2
+)
+
+// preFunc 返回命令会写入和读取的 key, 用于在执行前统一加锁以及维护 WATCH 所需的版本号
+type preFunc func(args [][]byte) ([]string, []string)
+
+type command struct {
+	executor cmdFunc
+	prepare  preFunc
+	flags    int
+}
+
+func makeRouter() map[string]*command {
+	cmdMap := make(map[string]*command)
+	register := func(name string, executor cmdFunc, prepare preFunc, flags int) {
+		cmdMap[name] = &command{
+			executor: executor,
+			prepare:  prepare,
+			flags:    flags,
+		}
+	}
+
+	register("ping", Ping, noPrepare, flagReadOnly)
+	register("get", Get, readFirstKey, flagReadOnly)
+	register("set", Set, writeFirstKey, flagWrite)
+	register("del", Del, writeAllKeys, flagWrite|flagAllowOOM)
+	register("mset", MSet, prepareMSet, flagWrite)
+	register("mget", MGet, readAllKeys, flagReadOnly)
+	register("getset", GetSet, writeFirstKey, flagWrite)
+	register("incr", Incr, writeFirstKey, flagWrite)
+	register("incrby", IncrBy, writeFirstKey, flagWrite)
+	register("incrbyfloat", IncrByFloat, writeFirstKey, flagWrite)
+	register("decr", Decr, writeFirstKey, flagWrite)
+	register("decrby", DecrBy, writeFirstKey, flagWrite)
+	register("decrbyfloat", DecrByFloat, writeFirstKey, flagWrite)
+
+	register("isexpired", IsExpired, readFirstKey, flagReadOnly)
+	register("expire", Expire, writeFirstKey, flagWrite|flagAllowOOM)
+	register("expireat", ExpireAt, writeFirstKey, flagWrite|flagAllowOOM)
+	register("pexpire", PExpire, writeFirstKey, flagWrite|flagAllowOOM)
+	register("pexpireat", PExpireAt, writeFirstKey, flagWrite|flagAllowOOM)
+	register("ttl", TTL, readFirstKey, flagReadOnly)
+	register("pttl", PTTL, readFirstKey, flagReadOnly)
+	register("persist", Persist, writeFirstKey, flagWrite|flagAllowOOM)
+	register("exists", Exists, readAllKeys, flagReadOnly)
+	register("type", Type, readFirstKey, flagReadOnly)
+	register("object", Object, prepareObject, flagReadOnly)
+	register("rename", Rename, writeAllKeys, flagWrite|flagAllowOOM)
+	register("renamenx", RenameNX, writeAllKeys, flagWrite|flagAllowOOM)
+	register("keys", Keys, noPrepare, flagReadOnly)
+	register("scan", Scan, noPrepare, flagReadOnly)
+	register("dbsize", DBSize, noPrepare, flagReadOnly)
+	register("randomkey", RandomKey, noPrepare, flagReadOnly)
+
+	register("rpush", RPush, writeFirstKey, flagWrite)
+	register("lindex", LIndex, readFirstKey, flagReadOnly)
+	register("llen", LLen, readFirstKey, flagReadOnly)
+	register("lpop", LPop, writeFirstKey, flagWrite|flagAllowOOM)
+	register("lpush", LPush, writeFirstKey, flagWrite)
+	register("lrange", LRange, readFirstKey, flagReadOnly)
+	register("lrem", LRem, writeFirstKey, flagWrite|flagAllowOOM)
+	register("lset", LSet, writeFirstKey, flagWrite)
+	register("rpop", RPop, writeFirstKey, flagWrite|flagAllowOOM)
+	register("rpoplpush", RPopLPush, writeFirstTwoKeys, flagWrite)
+	register("lmove", LMove, writeFirstTwoKeys, flagWrite)
+	register("lpushx", LPushX, writeFirstKey, flagWrite)
+	register("rpushx", RPushX, writeFirstKey, flagWrite)
+	register("linsert", LInsert, writeFirstKey, flagWrite)
+	register("ltrim", LTrim, writeFirstKey, flagWrite|flagAllowOOM)
+	register("lpos", LPos, readFirstKey, flagReadOnly)
+	register("lmpop", LMPop, prepareLMPop, flagWrite|flagAllowOOM)
+	// 阻塞命令在 DB.Exec 中单独处理, 这里的执行函数用于 MULTI 中, 此时不会阻塞
+	register("blpop", BLPop, writeKeysExceptLast, flagWrite|flagAllowOOM)
+	register("brpop", BRPop, writeKeysExceptLast, flagWrite|flagAllowOOM)
+	register("brpoplpush", BRPopLPush, writeFirstTwoKeys, flagWrite)
+	register("blmove", BLMove, writeFirstTwoKeys, flagWrite)
+
+	register("hset", HSet, writeFirstKey, flagWrite)
+	register("hsetnx", HSetNX, writeFirstKey, flagWrite)
+	register("hget", HGet, readFirstKey, flagReadOnly)
+	register("hexists", HExists, readFirstKey, flagReadOnly)
+	register("hdel", HDel, writeFirstKey, flagWrite|flagAllowOOM)
+	register("hlen", HLen, readFirstKey, flagReadOnly)
+	register("hmset", HMSet, writeFirstKey, flagWrite)
+	register("hmget", HMGet, readFirstKey, flagReadOnly)
+	register("hkeys", HKeys, readFirstKey, flagReadOnly)
+	register("hvals", HVals, readFirstKey, flagReadOnly)
+	register("hgetall", HGetAll, readFirstKey, flagReadOnly)
+	register("hincrby", HIncrBy, writeFirstKey, flagWrite)
+	register("hincrbyfloat", HIncrByFloat, writeFirstKey, flagWrite)
+	register("hscan", HScan, readFirstKey, flagReadOnly)
+
+	register("sadd", SAdd, writeFirstKey, flagWrite)
+	register("sismember", SIsMember, readFirstKey, flagReadOnly)
+	register("srem", SRem, writeFirstKey, flagWrite|flagAllowOOM)
+	register("smove", SMove, writeFirstTwoKeys, flagWrite|flagAllowOOM)
+	register("scard", SCard, readFirstKey, flagReadOnly)
+	register("smembers", SMembers, readFirstKey, flagReadOnly)
+	register("sinter", SInter, readAllKeys, flagReadOnly)
+	register("sinterstore", SInterStore, prepareStore, flagWrite)
+	register("sunion", SUnion, readAllKeys, flagReadOnly)
+	register("sunionstore", SUnionStore, prepareStore, flagWrite)
+	register("sdiff", SDiff, readAllKeys, flagReadOnly)
+	register("sdiffstore", SDiffStore, prepareStore, flagWrite)
+	register("srandmember", SRandMember, readFirstKey, flagReadOnly)
+	register("sscan", SScan, readFirstKey, flagReadOnly)
+
+	register("zadd", ZAdd, writeFirstKey, flagWrite)
+	register("zscore", ZScore, readFirstKey, flagReadOnly)
+	register("zincrby", ZIncrBy, writeFirstKey, flagWrite)
+	register("zrem", ZRem, writeFirstKey, flagWrite|flagAllowOOM)
+	register("zcard", ZCard, readFirstKey, flagReadOnly)
+	register("zcount", ZCount, readFirstKey, flagReadOnly)
+	register("zrange", ZRange, readFirstKey, flagReadOnly)
+	register("zrevrange", ZRevRange, readFirstKey, flagReadOnly)
+	register("zrangebyscore", ZRangeByScore, readFirstKey, flagReadOnly)
+	register("zrevrangebyscore", ZRevRangeByScore, readFirstKey, flagReadOnly)
+	register("zrank", ZRank, readFirstKey, flagReadOnly)
+	register("zrevrank", ZRevRank, readFirstKey, flagReadOnly)
+	register("zremrangebyscore", ZRemRangeByScore, writeFirstKey, flagWrite|flagAllowOOM)
+	register("zremrangebyrank", ZRemRangeByRank, writeFirstKey, flagWrite|flagAllowOOM)
+	register("zpopmin", ZPopMin, writeFirstKey, flagWrite|flagAllowOOM)
+	register("zpopmax", ZPopMax, writeFirstKey, flagWrite|flagAllowOOM)
+
+	register("flushdb", FlushDB, noPrepare, flagWrite|flagAllowOOM) // 清空数据库内容
 
 	return cmdMap
 }
+
+// isWriteCommand 判断命令是否会修改数据, 副本会拒绝客户端执行这些命令
+func isWriteCommand(cmd string) bool {
+	switch cmd {
+	case "flushall", "swapdb", "move":
+		return true
+	}
+	c, ok := router[cmd]
+	return ok && c.flags&flagReadOnly == 0
+}
+
+// GetRelatedKeys 返回命令会写入和读取的 key, 集群事务据此在参与者上加锁并记录回滚日志, 命令不存在时 ok 为 false
+func GetRelatedKeys(cmdLine [][]byte) (writeKeys []string, readKeys []string, ok bool) {
+	cmd, ok := router[strings.ToLower(string(cmdLine[0]))]
+	if !ok {
+		return nil, nil, false
+	}
+	writeKeys, readKeys = cmd.prepare(cmdLine[1:])
+	return writeKeys, readKeys, true
+}
+
+/* ---- prepare functions ---- */
+
+func noPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+func writeFirstKey(args [][]byte) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return []string{string(args[0])}, nil
+}
+
+func readFirstKey(args [][]byte) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return nil, []string{string(args[0])}
+}
+
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return keys, nil
+}
+
+func readAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return nil, keys
+}
+
+// prepareMSet: mset key1 value1 key2 value2 ...
+func prepareMSet(args [][]byte) ([]string, []string) {
+	size := len(args) / 2
+	keys := make([]string, size)
+	for i := 0; i < size; i++ {
+		keys[i] = string(args[2*i])
+	}
+	return keys, nil
+}
+
+// writeFirstTwoKeys: SMOVE, LMOVE 等命令的前两个参数是源 key 和目标 key
+func writeFirstTwoKeys(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
+// prepareObject: OBJECT subcommand key
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+// writeKeysExceptLast: BLPOP key [key ...] timeout
+func writeKeysExceptLast(args [][]byte) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return writeAllKeys(args[:len(args)-1])
+}
+
+// prepareLMPop: LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func prepareLMPop(args [][]byte) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 || numKeys >= len(args) {
+		return nil, nil
+	}
+	return writeAllKeys(args[1 : numKeys+1])
+}
+
+// prepareStore: xxxstore dest key1 key2 ...
+func prepareStore(args [][]byte) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	dest := string(args[0])
+	keys := make([]string, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		keys[i-1] = string(args[i])
+	}
+	return []string{dest}, keys
+}
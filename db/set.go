@@ -1,12 +1,37 @@
 package db
 
 import (
+	"redisGo/config"
 	HashSet "redisGo/datastruct/set"
 	"redisGo/interface/redis"
+	"redisGo/lib/wildcard"
 	"redisGo/redis/reply"
 	"strconv"
 )
 
+// makeSet 新建的集合根据 set-max-* 配置选择 intset, listpack 或哈希表编码
+func makeSet() *HashSet.Set {
+	var limits HashSet.Limits
+	if config.Properties != nil {
+		limits = HashSet.Limits{
+			MaxIntsetEntries:   config.Properties.SetMaxIntsetEntries,
+			MaxListpackEntries: config.Properties.SetMaxListpackEntries,
+			MaxListpackValue:   config.Properties.SetMaxListpackValue,
+		}
+	}
+	return HashSet.MakeWithLimits(0, limits)
+}
+
+// makeSetFrom 复制集合运算的结果用于保存
+func makeSetFrom(src *HashSet.Set) *HashSet.Set {
+	set := makeSet()
+	src.ForEach(func(member string) bool {
+		set.Add(member)
+		return true
+	})
+	return set
+}
+
 func (db *DB) getAsSet(key string) (*HashSet.Set, reply.ErrorReply) {
 	entity, exists := db.Get(key)
 	if !exists {
@@ -26,7 +51,7 @@ func (db *DB) getOrInitSet(key string) (set *HashSet.Set, inited bool, errReply
 	}
 	inited = false
 	if set == nil {
-		set = HashSet.Make(0)
+		set = makeSet()
 		db.Put(key, &DataEntity{Data: set})
 		inited = true
 	}
@@ -40,9 +65,6 @@ func SAdd(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	members := args[1:]
 
-	db.Lock(key)
-	defer db.Unlock(key)
-
 	set, _, errReply := db.getOrInitSet(key)
 	if errReply != nil {
 		return errReply
@@ -53,6 +75,7 @@ func SAdd(db *DB, args [][]byte) redis.Reply {
 	}
 	if counter > 0 {
 		db.AddAof(makeAofCmd("sadd", args))
+		db.notify(notifySet, "sadd", key)
 	}
 	return reply.MakeIntReply(int64(counter))
 }
@@ -82,9 +105,6 @@ func SRem(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	members := args[1:]
 
-	db.Lock(key)
-	defer db.Unlock(key)
-
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
@@ -102,10 +122,53 @@ func SRem(db *DB, args [][]byte) redis.Reply {
 	}
 	if counter > 0 {
 		db.AddAof(makeAofCmd("srem", args))
+		db.notify(notifySet, "srem", key)
+		if set.Len() == 0 {
+			db.notify(notifyGeneric, "del", key)
+		}
 	}
 	return reply.MakeIntReply(int64(counter))
 }
 
+// SMove 将 member 从 source 移动到 destination
+func SMove(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'smove' command")
+	}
+	src := string(args[0])
+	dest := string(args[1])
+	member := string(args[2])
+
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return reply.MakeIntReply(0)
+	}
+	srcSet.Remove(member)
+	srcDeleted := srcSet.Len() == 0
+	if srcDeleted {
+		db.Remove(src)
+	}
+	if destSet == nil {
+		destSet = makeSet()
+		db.Put(dest, &DataEntity{Data: destSet})
+	}
+	destSet.Add(member)
+	db.AddAof(makeAofCmd("smove", args))
+	db.notify(notifySet, "srem", src)
+	if srcDeleted {
+		db.notify(notifyGeneric, "del", src)
+	}
+	db.notify(notifySet, "sadd", dest)
+	return reply.MakeIntReply(1)
+}
+
 func SCard(db *DB, args [][]byte) redis.Reply {
 	if len(args) != 1 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'scard' command")
@@ -127,8 +190,6 @@ func SMembers(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'smembers' command")
 	}
 	key := string(args[0])
-	db.RLock(key)
-	defer db.RUnlock(key)
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
@@ -155,8 +216,6 @@ func SInter(db *DB, args [][]byte) redis.Reply {
 	for i, arg := range args {
 		keys[i] = string(arg)
 	}
-	db.RLocks(keys...)
-	defer db.RUnlocks(keys...)
 
 	var result *HashSet.Set
 	for _, key := range keys {
@@ -186,6 +245,14 @@ func SInter(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeMultiBulkReply(arr)
 }
 
+// removeStoreDest *STORE 命令的结果为空时删除 destination
+func (db *DB) removeStoreDest(dest string) {
+	if _, exists := db.Get(dest); exists {
+		db.Remove(dest)
+		db.notify(notifyGeneric, "del", dest)
+	}
+}
+
 func SInterStore(db *DB, args [][]byte) redis.Reply {
 	if len(args) < 2 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'sinterstore' command")
@@ -195,11 +262,6 @@ func SInterStore(db *DB, args [][]byte) redis.Reply {
 	for i := 1; i < len(args); i++ {
 		keys[i-1] = string(args[i])
 	}
-	db.RLocks(keys...)
-	defer db.RUnlocks(keys...)
-	db.Lock(dest)
-	db.Unlock(dest)
-
 	var result *HashSet.Set
 	for _, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -207,7 +269,7 @@ func SInterStore(db *DB, args [][]byte) redis.Reply {
 			return errReply
 		}
 		if set == nil {
-			db.Remove(dest)
+			db.removeStoreDest(dest)
 			return &reply.EmptyMultiBulkReply{}
 		}
 		if result == nil {
@@ -215,17 +277,18 @@ func SInterStore(db *DB, args [][]byte) redis.Reply {
 		} else {
 			result = result.Intersect(set)
 			if result.Len() == 0 {
-				db.Remove(dest)
+				db.removeStoreDest(dest)
 				return reply.MakeIntReply(0)
 			}
 		}
 	}
 
-	set := HashSet.MakeFromVals(result.ToSlice()...)
+	set := makeSetFrom(result)
 	db.Put(dest, &DataEntity{
 		Data: set,
 	})
 	db.AddAof(makeAofCmd("sinterstore", args))
+	db.notify(notifySet, "sinterstore", dest)
 	return reply.MakeIntReply(int64(set.Len()))
 }
 
@@ -274,11 +337,6 @@ func SUnionStore(db *DB, args [][]byte) redis.Reply {
 	for i := 1; i < len(args); i++ {
 		keys[i-1] = string(args[i])
 	}
-	db.RLocks(keys...)
-	defer db.RUnlocks(keys...)
-	db.Lock(dest)
-	defer db.Unlock(dest)
-
 	var result *HashSet.Set
 	for _, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -294,15 +352,17 @@ func SUnionStore(db *DB, args [][]byte) redis.Reply {
 			result = result.Union(set)
 		}
 	}
-	db.Remove(dest)
 	if result == nil {
+		db.removeStoreDest(dest)
 		return &reply.EmptyMultiBulkReply{}
 	}
-	set := HashSet.MakeFromVals(result.ToSlice()...)
+	set := makeSetFrom(result)
 	db.Put(dest, &DataEntity{
 		Data: set,
 	})
+	db.Persist(dest)
 	db.AddAof(makeAofCmd("sunionstore", args))
+	db.notify(notifySet, "sunionstore", dest)
 	return reply.MakeIntReply(int64(set.Len()))
 }
 
@@ -315,9 +375,6 @@ func SDiff(db *DB, args [][]byte) redis.Reply {
 		keys[i] = string(arg)
 	}
 
-	db.RLocks(keys...)
-	defer db.RUnlocks(keys...)
-
 	var result *HashSet.Set
 	for i, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -364,11 +421,6 @@ func SDiffStore(db *DB, args [][]byte) redis.Reply {
 		keys[i-1] = string(args[i])
 	}
 
-	db.RLocks(keys...)
-	defer db.RUnlocks(keys...)
-	db.Lock(dest)
-	defer db.Unlock(dest)
-
 	var result *HashSet.Set
 	for i, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -377,7 +429,7 @@ func SDiffStore(db *DB, args [][]byte) redis.Reply {
 		}
 		if set == nil {
 			if i == 0 {
-				db.Remove(dest)
+				db.removeStoreDest(dest)
 				return &reply.EmptyMultiBulkReply{}
 			} else {
 				continue
@@ -388,20 +440,21 @@ func SDiffStore(db *DB, args [][]byte) redis.Reply {
 		} else {
 			result = result.Diff(set)
 			if result.Len() == 0 {
-				db.Remove(dest)
+				db.removeStoreDest(dest)
 				return &reply.EmptyMultiBulkReply{}
 			}
 		}
 	}
 	db.AddAof(makeAofCmd("sdiffstore", args))
 	if result == nil {
-		db.Remove(dest)
+		db.removeStoreDest(dest)
 		return &reply.EmptyMultiBulkReply{}
 	} else {
-		set := HashSet.MakeFromVals(result.ToSlice()...)
+		set := makeSetFrom(result)
 		db.Put(dest, &DataEntity{
 			Data: set,
 		})
+		db.notify(notifySet, "sdiffstore", dest)
 		return reply.MakeIntReply(int64(set.Len()))
 	}
 }
@@ -411,8 +464,6 @@ func SRandMember(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'srandmember' command")
 	}
 	key := string(args[0])
-	db.RLock(key)
-	defer db.RUnlock(key)
 
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
@@ -450,3 +501,33 @@ func SRandMember(db *DB, args [][]byte) redis.Reply {
 		}
 	}
 }
+
+// SScan SSCAN key cursor [MATCH pattern] [COUNT count]
+func SScan(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'sscan' command")
+	}
+	cursor, errReply := parseCursor(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	options, errReply := ParseScanOptions(args[2:], false)
+	if errReply != nil {
+		return errReply
+	}
+	set, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return makeScanReply(0, [][]byte{})
+	}
+	members, next := set.ScanMembers(cursor, options.Count)
+	result := make([][]byte, 0, len(members))
+	for _, member := range members {
+		if options.Pattern == "" || wildcard.Match(options.Pattern, member) {
+			result = append(result, []byte(member))
+		}
+	}
+	return makeScanReply(next, result)
+}
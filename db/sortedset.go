@@ -0,0 +1,707 @@
+package db
+
+import (
+	"errors"
+	"math"
+	SortedSet "redisGo/datastruct/sortedset"
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsSortedSet(key string) (*SortedSet.SortedSet, reply.ErrorReply) {
+	entity, exists := db.Get(key)
+	if !exists {
+		return nil, nil
+	}
+	sortedSet, ok := entity.Data.(*SortedSet.SortedSet)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return sortedSet, nil
+}
+
+func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, inited bool, errReply reply.ErrorReply) {
+	sortedSet, errReply = db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if sortedSet == nil {
+		sortedSet = SortedSet.Make()
+		db.Put(key, &DataEntity{Data: sortedSet})
+		inited = true
+	}
+	return sortedSet, inited, nil
+}
+
+// formatScore 与 redis 保持一致, 无穷大输出为 inf 和 -inf
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	} else if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+func parseScore(raw []byte) (float64, error) {
+	s := strings.ToLower(string(raw))
+	if s == "inf" || s == "+inf" {
+		return math.Inf(1), nil
+	} else if s == "-inf" {
+		return math.Inf(-1), nil
+	}
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(score) {
+		return 0, errors.New("score is not a number")
+	}
+	return score, nil
+}
+
+func elementsToReply(elements []*SortedSet.Element, withScores bool) redis.Reply {
+	if len(elements) == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	var result [][]byte
+	if withScores {
+		result = make([][]byte, 0, len(elements)*2)
+	} else {
+		result = make([][]byte, 0, len(elements))
+	}
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, formatScore(element.Score))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// ZAdd key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func ZAdd(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zadd' command")
+	}
+	key := string(args[0])
+
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+	for ; i < len(args); i++ {
+		flag := strings.ToUpper(string(args[i]))
+		if flag == "NX" {
+			nx = true
+		} else if flag == "XX" {
+			xx = true
+		} else if flag == "GT" {
+			gt = true
+		} else if flag == "LT" {
+			lt = true
+		} else if flag == "CH" {
+			ch = true
+		} else if flag == "INCR" {
+			incr = true
+		} else {
+			break
+		}
+	}
+	if nx && xx {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return &reply.SyntaxErrReply{}
+	}
+	if incr && len(pairs) != 2 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	size := len(pairs) / 2
+	elements := make([]*SortedSet.Element, size)
+	for j := 0; j < size; j++ {
+		score, err := parseScore(pairs[2*j])
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not a valid float")
+		}
+		elements[j] = &SortedSet.Element{
+			Member: string(pairs[2*j+1]),
+			Score:  score,
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil && xx {
+		if incr {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeIntReply(0)
+	}
+	if sortedSet == nil {
+		sortedSet, _, errReply = db.getOrInitSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+	}
+
+	added := 0
+	changed := 0
+	var incrResult *float64
+	for _, e := range elements {
+		old, exists := sortedSet.Get(e.Member)
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+		score := e.Score
+		if incr && exists {
+			score += old.Score
+			if math.IsNaN(score) {
+				return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists && ((gt && score <= old.Score) || (lt && score >= old.Score)) {
+			continue
+		}
+		if exists {
+			if score != old.Score {
+				changed++
+			}
+		} else {
+			added++
+		}
+		sortedSet.Add(e.Member, score)
+		s := score
+		incrResult = &s
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if added > 0 || changed > 0 {
+		db.AddAof(makeAofCmd("zadd", args))
+		if incr {
+			db.notify(notifyZSet, "zincr", key)
+		} else {
+			db.notify(notifyZSet, "zadd", key)
+		}
+	}
+	if incr {
+		if incrResult == nil {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply(formatScore(*incrResult))
+	}
+	if ch {
+		return reply.MakeIntReply(int64(added + changed))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+func ZScore(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zscore' command")
+	}
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+	element, exists := sortedSet.Get(member)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeBulkReply(formatScore(element.Score))
+}
+
+func ZIncrBy(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zincrby' command")
+	}
+	key := string(args[0])
+	delta, err := parseScore(args[1])
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+	member := string(args[2])
+
+	sortedSet, _, errReply := db.getOrInitSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	score := delta
+	element, exists := sortedSet.Get(member)
+	if exists {
+		score += element.Score
+		if math.IsNaN(score) {
+			return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+		}
+	}
+	sortedSet.Add(member, score)
+	db.AddAof(makeAofCmd("zincrby", args))
+	db.notify(notifyZSet, "zincr", key)
+	return reply.MakeBulkReply(formatScore(score))
+}
+
+func ZRem(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zrem' command")
+	}
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	var deleted int64 = 0
+	for _, member := range args[1:] {
+		if sortedSet.Remove(string(member)) {
+			deleted++
+		}
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if deleted > 0 {
+		db.AddAof(makeAofCmd("zrem", args))
+		db.notify(notifyZSet, "zrem", key)
+		if sortedSet.Len() == 0 {
+			db.notify(notifyGeneric, "del", key)
+		}
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+func ZCard(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zcard' command")
+	}
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.Len())
+}
+
+func ZCount(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zcount' command")
+	}
+	key := string(args[0])
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.RangeCount(min, max))
+}
+
+// normalizeRankRange 将 redis 风格的 [start, stop] (支持负数下标) 转换为 [start, stop), ok 为 false 表示区间为空
+func normalizeRankRange(start int64, stop int64, size int64) (int64, int64, bool) {
+	if start < -1*size {
+		start = 0
+	} else if start < 0 {
+		start = size + start
+	} else if start >= size {
+		return 0, 0, false
+	}
+	if stop < -1*size {
+		stop = 0
+	} else if stop < 0 {
+		stop = size + stop + 1
+	} else if stop < size {
+		stop = stop + 1
+	} else {
+		stop = size
+	}
+	if stop <= start {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+func (db *DB) zRangeByRank(key string, start int64, stop int64, withScores bool, desc bool) redis.Reply {
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	start, stop, ok := normalizeRankRange(start, stop, sortedSet.Len())
+	if !ok {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	return elementsToReply(sortedSet.RangeByRank(start, stop, desc), withScores)
+}
+
+func (db *DB) zRangeByBorder(key string, min SortedSet.Border, max SortedSet.Border, offset int64, limit int64, withScores bool, desc bool) redis.Reply {
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	return elementsToReply(sortedSet.Range(min, max, offset, limit, desc), withScores)
+}
+
+// ZRange key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func ZRange(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zrange' command")
+	}
+	key := string(args[0])
+	var byScore, byLex, rev, withScores, hasLimit bool
+	var offset, limit int64 = 0, -1
+	for i := 3; i < len(args); i++ {
+		flag := strings.ToUpper(string(args[i]))
+		switch flag {
+		case "BYSCORE":
+			byScore = true
+		case "BYLEX":
+			byLex = true
+		case "REV":
+			rev = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return &reply.SyntaxErrReply{}
+			}
+			var err error
+			offset, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			limit, err = strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			hasLimit = true
+			i += 2
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+	if byScore && byLex {
+		return &reply.SyntaxErrReply{}
+	}
+	if hasLimit && !byScore && !byLex {
+		return reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && byLex {
+		return reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	if byScore || byLex {
+		// REV 模式下 start 为上边界, stop 为下边界
+		minArg, maxArg := string(args[1]), string(args[2])
+		if rev {
+			minArg, maxArg = maxArg, minArg
+		}
+		parseBorder := SortedSet.ParseScoreBorder
+		if byLex {
+			parseBorder = SortedSet.ParseLexBorder
+		}
+		min, err := parseBorder(minArg)
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		max, err := parseBorder(maxArg)
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return db.zRangeByBorder(key, min, max, offset, limit, withScores, rev)
+	}
+
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return db.zRangeByRank(key, start, stop, withScores, rev)
+}
+
+// ZRevRange key start stop [WITHSCORES]
+func ZRevRange(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 3 && len(args) != 4 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zrevrange' command")
+	}
+	withScores := false
+	if len(args) == 4 {
+		if strings.ToUpper(string(args[3])) != "WITHSCORES" {
+			return &reply.SyntaxErrReply{}
+		}
+		withScores = true
+	}
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return db.zRangeByRank(key, start, stop, withScores, true)
+}
+
+// parseRangeByScoreOptions 解析 [WITHSCORES] [LIMIT offset count]
+func parseRangeByScoreOptions(args [][]byte) (withScores bool, offset int64, limit int64, errReply redis.Reply) {
+	limit = -1
+	for i := 0; i < len(args); i++ {
+		flag := strings.ToUpper(string(args[i]))
+		if flag == "WITHSCORES" {
+			withScores = true
+		} else if flag == "LIMIT" {
+			if i+2 >= len(args) {
+				return false, 0, 0, &reply.SyntaxErrReply{}
+			}
+			var err error
+			offset, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return false, 0, 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			limit, err = strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return false, 0, 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			i += 2
+		} else {
+			return false, 0, 0, &reply.SyntaxErrReply{}
+		}
+	}
+	return withScores, offset, limit, nil
+}
+
+// ZRangeByScore key min max [WITHSCORES] [LIMIT offset count]
+func ZRangeByScore(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zrangebyscore' command")
+	}
+	key := string(args[0])
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	withScores, offset, limit, errReply := parseRangeByScoreOptions(args[3:])
+	if errReply != nil {
+		return errReply
+	}
+	return db.zRangeByBorder(key, min, max, offset, limit, withScores, false)
+}
+
+// ZRevRangeByScore key max min [WITHSCORES] [LIMIT offset count]
+func ZRevRangeByScore(db *DB, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zrevrangebyscore' command")
+	}
+	key := string(args[0])
+	max, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	min, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	withScores, offset, limit, errReply := parseRangeByScoreOptions(args[3:])
+	if errReply != nil {
+		return errReply
+	}
+	return db.zRangeByBorder(key, min, max, offset, limit, withScores, true)
+}
+
+func (db *DB) zRank(key string, member string, desc bool) redis.Reply {
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+	rank := sortedSet.GetRank(member, desc)
+	if rank < 0 {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeIntReply(rank)
+}
+
+func ZRank(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zrank' command")
+	}
+	return db.zRank(string(args[0]), string(args[1]), false)
+}
+
+func ZRevRank(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zrevrank' command")
+	}
+	return db.zRank(string(args[0]), string(args[1]), true)
+}
+
+func ZRemRangeByScore(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zremrangebyscore' command")
+	}
+	key := string(args[0])
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveRange(min, max)
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if removed > 0 {
+		db.AddAof(makeAofCmd("zremrangebyscore", args))
+		db.notify(notifyZSet, "zremrangebyscore", key)
+		if sortedSet.Len() == 0 {
+			db.notify(notifyGeneric, "del", key)
+		}
+	}
+	return reply.MakeIntReply(removed)
+}
+
+func ZRemRangeByRank(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'zremrangebyrank' command")
+	}
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	start, stop, ok := normalizeRankRange(start, stop, sortedSet.Len())
+	if !ok {
+		return reply.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveByRank(start, stop)
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if removed > 0 {
+		db.AddAof(makeAofCmd("zremrangebyrank", args))
+		db.notify(notifyZSet, "zremrangebyrank", key)
+		if sortedSet.Len() == 0 {
+			db.notify(notifyGeneric, "del", key)
+		}
+	}
+	return reply.MakeIntReply(removed)
+}
+
+func (db *DB) zPop(cmd string, args [][]byte, max bool) redis.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil || count == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	var removed []*SortedSet.Element
+	if max {
+		removed = sortedSet.PopMax(count)
+	} else {
+		removed = sortedSet.PopMin(count)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(removed) > 0 {
+		db.AddAof(makeAofCmd(cmd, args))
+		db.notify(notifyZSet, cmd, key)
+		if sortedSet.Len() == 0 {
+			db.notify(notifyGeneric, "del", key)
+		}
+	}
+	return elementsToReply(removed, true)
+}
+
+// ZPopMin key [count]
+func ZPopMin(db *DB, args [][]byte) redis.Reply {
+	return db.zPop("zpopmin", args, false)
+}
+
+// ZPopMax key [count]
+func ZPopMax(db *DB, args [][]byte) redis.Reply {
+	return db.zPop("zpopmax", args, true)
+}
@@ -107,21 +107,29 @@ func Set(db *DB, args [][]byte) redis.Reply {
 	entity := &DataEntity{
 		Data: value,
 	}
+	written := true
 	switch policy {
 	case upsertPolicy:
 		db.Put(key, entity)
 	case insertPolicy:
-		db.PutIfAbsent(key, entity)
+		written = db.PutIfAbsent(key, entity) > 0
 	case updatePolicy:
-		db.PutIfExists(key, entity)
+		written = db.PutIfExists(key, entity) > 0
 	}
+	if !written {
+		return &reply.OkReply{}
+	}
+	// 过期时间以 PEXPIREAT 的形式传播, 副本和重新加载时根据绝对时间计算
+	db.AddAof(makeAofCmd("set", [][]byte{args[0], value}))
+	db.notify(notifyString, "set", key)
 	if ttl != unlimitedTTL {
 		expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
 		db.Expire(key, expireTime)
+		db.AddAof(makeExpireCmd(key, expireTime))
+		db.notify(notifyGeneric, "expire", key)
 	} else {
 		db.Persist(key)
 	}
-	db.AddAof(makeAofCmd("set", args))
 	return &reply.OkReply{}
 }
 
@@ -133,8 +141,6 @@ func MSet(db *DB, args [][]byte) redis.Reply {
 	for i := 0; i < len(args)/2; i++ {
 		keys[i] = string(args[i*2])
 	}
-	db.Locks(keys...)
-	defer db.Unlocks(keys...)
 
 	for i := 0; i < len(args); {
 		key := string(args[i])
@@ -146,6 +152,7 @@ func MSet(db *DB, args [][]byte) redis.Reply {
 		i += 2
 	}
 	db.AddAof(makeAofCmd("mset", args))
+	db.notifyKeys(notifyString, "set", keys...)
 	return &reply.OkReply{}
 }
 
@@ -157,8 +164,6 @@ func MGet(db *DB, args [][]byte) redis.Reply {
 	for i, v := range args {
 		keys[i] = string(v)
 	}
-	db.RLocks(keys...)
-	defer db.RUnlocks(keys...)
 	values := make([][]byte, len(args))
 	for i, key := range keys {
 		entity, exists := db.Get(key)
@@ -181,8 +186,9 @@ func GetSet(db *DB, args [][]byte) redis.Reply {
 	if errReply != nil {
 		return errReply
 	}
-	db.PutIfExists(key, &DataEntity{Data: value})
+	db.Put(key, &DataEntity{Data: value})
 	db.AddAof(makeAofCmd("getset", args))
+	db.notify(notifyString, "set", key)
 	return reply.MakeBulkReply(bytes)
 }
 
@@ -191,8 +197,6 @@ func Incr(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'incr' command")
 	}
 	key := string(args[0])
-	db.Lock(key)
-	defer db.Unlock(key)
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
@@ -204,8 +208,9 @@ func Incr(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.PutIfExists(key, &DataEntity{Data: []byte(strconv.FormatInt(i+1, 10))})
+	db.Put(key, &DataEntity{Data: []byte(strconv.FormatInt(i+1, 10))})
 	db.AddAof(makeAofCmd("incr", args))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(i + 1)
 }
 
@@ -219,8 +224,6 @@ func IncrBy(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.Lock(key)
-	defer db.Unlock(key)
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
@@ -232,8 +235,9 @@ func IncrBy(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.PutIfExists(key, &DataEntity{Data: []byte(strconv.FormatInt(i+delta, 10))})
+	db.Put(key, &DataEntity{Data: []byte(strconv.FormatInt(i+delta, 10))})
 	db.AddAof(makeAofCmd("incrby", args))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(i + delta)
 }
 
@@ -247,8 +251,6 @@ func IncrByFloat(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not a valid float")
 	}
-	db.Lock(key)
-	defer db.Unlock(key)
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
@@ -261,8 +263,9 @@ func IncrByFloat(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR value is not a valid float")
 	}
 	resultBytes := []byte(i.Add(delta).String())
-	db.PutIfExists(key, &DataEntity{Data: resultBytes})
+	db.Put(key, &DataEntity{Data: resultBytes})
 	db.AddAof(makeAofCmd("incrbyfloat", args))
+	db.notify(notifyString, "incrbyfloat", key)
 	return reply.MakeBulkReply(resultBytes)
 }
 
@@ -271,8 +274,6 @@ func Decr(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'decr' command")
 	}
 	key := string(args[0])
-	db.Lock(key)
-	defer db.Unlock(key)
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
@@ -284,8 +285,9 @@ func Decr(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.PutIfExists(key, &DataEntity{Data: []byte(strconv.FormatInt(i-1, 10))})
+	db.Put(key, &DataEntity{Data: []byte(strconv.FormatInt(i-1, 10))})
 	db.AddAof(makeAofCmd("decr", args))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(i - 1)
 }
 
@@ -299,8 +301,6 @@ func DecrBy(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.Lock(key)
-	defer db.Unlock(key)
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
@@ -312,8 +312,9 @@ func DecrBy(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.PutIfExists(key, &DataEntity{Data: []byte(strconv.FormatInt(i-delta, 10))})
+	db.Put(key, &DataEntity{Data: []byte(strconv.FormatInt(i-delta, 10))})
 	db.AddAof(makeAofCmd("decrby", args))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(i - delta)
 }
 
@@ -327,8 +328,6 @@ func DecrByFloat(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not a valid float")
 	}
-	db.Lock(key)
-	defer db.Unlock(key)
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
@@ -341,7 +340,8 @@ func DecrByFloat(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR value is not a valid float")
 	}
 	resultBytes := []byte(i.Sub(delta).String())
-	db.PutIfExists(key, &DataEntity{Data: resultBytes})
+	db.Put(key, &DataEntity{Data: resultBytes})
 	db.AddAof(makeAofCmd("decrbyfloat", args))
+	db.notify(notifyString, "incrbyfloat", key)
 	return reply.MakeBulkReply(resultBytes)
 }
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"redisGo/config"
+	"testing"
+)
+
+// 不存在的 key 视为 0 或空值, 命令执行后创建该 key
+func TestIncrMissingKey(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	exec := func(expected string, args ...string) {
+		t.Helper()
+		cmdLine := make([][]byte, len(args))
+		for i, arg := range args {
+			cmdLine[i] = []byte(arg)
+		}
+		if r := db.Exec(nil, cmdLine); string(r.ToBytes()) != expected {
+			t.Errorf("%v: expected %q, got %q", args, expected, r.ToBytes())
+		}
+	}
+
+	exec(":1\r\n", "incr", "a")
+	exec(":5\r\n", "incrby", "b", "5")
+	exec(":-1\r\n", "decr", "c")
+	exec(":-5\r\n", "decrby", "d", "5")
+	exec("$3\r\n1.5\r\n", "incrbyfloat", "e", "1.5")
+	exec("$4\r\n-1.5\r\n", "decrbyfloat", "f", "1.5")
+	db.Exec(nil, [][]byte{[]byte("getset"), []byte("g"), []byte("v")})
+	exec("*7\r\n$1\r\n1\r\n$1\r\n5\r\n$2\r\n-1\r\n$2\r\n-5\r\n$3\r\n1.5\r\n$4\r\n-1.5\r\n$1\r\nv\r\n",
+		"mget", "a", "b", "c", "d", "e", "f", "g")
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"redisGo/interface/redis"
+	"redisGo/redis/reply"
+	"sort"
+	"strings"
+)
+
+var (
+	multiCmd = []byte("MULTI")
+	execCmd  = []byte("EXEC")
+)
+
+// StartMulti 开启事务, 之后的命令会进入队列直到 EXEC 或 DISCARD
+func StartMulti(c redis.Connection) redis.Reply {
+	if c == nil {
+		return reply.MakeErrReply("ERR MULTI is not allowed here")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	c.SetMultiState(true)
+	return &reply.OkReply{}
+}
+
+// EnqueueCmd 将命令加入事务队列, 未知命令会使事务被标记为 dirty, EXEC 时整体放弃
+func EnqueueCmd(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	_, ok := router[cmdName]
+	if !ok {
+		c.SetTxDirty(true)
+		return reply.MakeErrReply("ERR unknown command `" + cmdName + "`")
+	}
+	c.EnqueueCmd(cmdLine)
+	return &reply.QueuedReply{}
+}
+
+// DiscardMulti 放弃事务, 同时取消所有 WATCH
+func DiscardMulti(c redis.Connection) redis.Reply {
+	if c == nil || !c.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	c.SetMultiState(false)
+	return &reply.OkReply{}
+}
+
+// Watch 记录 key 当前的版本号, EXEC 时若版本号发生变化则放弃事务
+func Watch(db *DB, c redis.Connection, args [][]byte) redis.Reply {
+	if c == nil {
+		return reply.MakeErrReply("ERR WATCH is not allowed here")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	watching := c.GetWatching()
+	for _, bkey := range args {
+		key := string(bkey)
+		watching[key] = db.GetVersion(key)
+	}
+	return &reply.OkReply{}
+}
+
+// UnWatch 取消所有 WATCH
+func UnWatch(c redis.Connection) redis.Reply {
+	if c == nil {
+		return &reply.OkReply{}
+	}
+	watching := c.GetWatching()
+	for key := range watching {
+		delete(watching, key)
+	}
+	return &reply.OkReply{}
+}
+
+func isWatchingChanged(db *DB, watching map[string]uint32) bool {
+	for key, ver := range watching {
+		currentVersion := db.GetVersion(key)
+		if ver != currentVersion {
+			return true
+		}
+	}
+	return false
+}
+
+func execMulti(db *DB, c redis.Connection) redis.Reply {
+	if c == nil || !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer c.SetMultiState(false)
+	if c.IsTxDirty() {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return db.ExecMulti(c.GetWatching(), c.GetQueuedCmdLine())
+}
+
+// ExecMulti 先对所有相关的 key 加锁, 再依次执行命令, 执行过程中不会与其它命令交错
+func (db *DB) ExecMulti(watching map[string]uint32, cmdLines [][][]byte) redis.Reply {
+	writeKeys := make([]string, 0) // may contain duplicate
+	readKeys := make([]string, 0)
+	moveDsts := db.moveDsts(cmdLines)
+	for _, cmdLine := range cmdLines {
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		if isMoveCmd(cmdLine) {
+			writeKeys = append(writeKeys, string(cmdLine[1]))
+			continue
+		}
+		cmd, ok := router[cmdName]
+		if !ok {
+			continue
+		}
+		write, read := cmd.prepare(cmdLine[1:])
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
+	}
+	for key := range watching {
+		readKeys = append(readKeys, key)
+	}
+
+	defer db.ServeBlocked(writeKeys...)
+	// MOVE 移入的列表可以唤醒目标数据库上阻塞的客户端
+	for i, dst := range moveDsts {
+		if dst != nil {
+			defer dst.ServeBlocked(string(cmdLines[i][1]))
+		}
+	}
+	unlock := db.lockForMulti(moveDsts)
+	defer unlock()
+	db.RWLocks(writeKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
+	unlockDsts := lockMoveDsts(cmdLines, moveDsts)
+	defer unlockDsts()
+
+	if isWatchingChanged(db, watching) {
+		return &reply.NullMultiBulkReply{}
+	}
+
+	// 命令产生的 aof 先写入 txAofBuffer, 执行完毕后整体写入
+	db.txAofBuffer = make([]*reply.MultiBulkReply, 0, len(cmdLines))
+	defer func() {
+		db.txAofBuffer = nil
+	}()
+	results := make([]redis.Reply, 0, len(cmdLines))
+	for i, cmdLine := range cmdLines {
+		if isMoveCmd(cmdLine) {
+			results = append(results, db.execMoveInMulti(cmdLine[1:], moveDsts[i]))
+			continue
+		}
+		results = append(results, db.ExecWithLock(cmdLine))
+	}
+	db.AddVersion(writeKeys...)
+
+	aofCmds := db.txAofBuffer
+	db.txAofBuffer = nil
+	if len(aofCmds) > 0 {
+		block := make([]*reply.MultiBulkReply, 0, len(aofCmds)+2)
+		block = append(block, reply.MakeMultiBulkReply([][]byte{multiCmd}))
+		block = append(block, aofCmds...)
+		block = append(block, reply.MakeMultiBulkReply([][]byte{execCmd}))
+		db.addAof(db.index, block...)
+	}
+	return reply.MakeMultiRawReply(results)
+}
+
+func isMoveCmd(cmdLine [][]byte) bool {
+	return len(cmdLine) == 3 && strings.ToLower(string(cmdLine[0])) == "move"
+}
+
+// moveDsts 在加锁之前解析事务中 MOVE 的目标数据库, 下标与 cmdLines 对应,
+// 其它命令以及下标不合法或者目标就是当前数据库的 MOVE 为 nil
+func (db *DB) moveDsts(cmdLines [][][]byte) []*DB {
+	dsts := make([]*DB, len(cmdLines))
+	if db.dbByIndex == nil {
+		return dsts
+	}
+	for i, cmdLine := range cmdLines {
+		if !isMoveCmd(cmdLine) {
+			continue
+		}
+		if dst, errReply := db.dbByIndex(cmdLine[2]); errReply == nil && dst != db {
+			dsts[i] = dst
+		}
+	}
+	return dsts
+}
+
+// lockForMulti 获取当前数据库 execMu 的写锁以及 MOVE 目标数据库的读锁,
+// 与 MOVE, SWAPDB 相同按照下标顺序加锁避免死锁
+func (db *DB) lockForMulti(moveDsts []*DB) func() {
+	dbs := []*DB{db}
+	for _, dst := range moveDsts {
+		if dst != nil && !containsDB(dbs, dst) {
+			dbs = append(dbs, dst)
+		}
+	}
+	sort.Slice(dbs, func(i, j int) bool {
+		return dbs[i].index < dbs[j].index
+	})
+	for _, d := range dbs {
+		if d == db {
+			d.execMu.Lock()
+		} else {
+			d.execMu.RLock()
+		}
+	}
+	return func() {
+		for i := len(dbs) - 1; i >= 0; i-- {
+			if dbs[i] == db {
+				dbs[i].execMu.Unlock()
+			} else {
+				dbs[i].execMu.RUnlock()
+			}
+		}
+	}
+}
+
+func containsDB(dbs []*DB, target *DB) bool {
+	for _, d := range dbs {
+		if d == target {
+			return true
+		}
+	}
+	return false
+}
+
+// lockMoveDsts 锁定 MOVE 在目标数据库中的 key, 当前数据库的 key 已经随其它写入的 key 一起加锁
+func lockMoveDsts(cmdLines [][][]byte, moveDsts []*DB) func() {
+	keys := make(map[*DB][]string)
+	dbs := make([]*DB, 0)
+	for i, dst := range moveDsts {
+		if dst == nil {
+			continue
+		}
+		if _, ok := keys[dst]; !ok {
+			dbs = append(dbs, dst)
+		}
+		keys[dst] = append(keys[dst], string(cmdLines[i][1]))
+	}
+	sort.Slice(dbs, func(i, j int) bool {
+		return dbs[i].index < dbs[j].index
+	})
+	for _, dst := range dbs {
+		dst.Locks(keys[dst]...)
+	}
+	return func() {
+		for i := len(dbs) - 1; i >= 0; i-- {
+			dbs[i].Unlocks(keys[dbs[i]]...)
+		}
+	}
+}
+
+// execMoveInMulti 执行事务中的 MOVE, dst 为加锁之前解析出的目标数据库
+func (db *DB) execMoveInMulti(args [][]byte, dst *DB) redis.Reply {
+	if dst != nil {
+		return moveKey(db, dst, args)
+	}
+	if db.dbByIndex == nil {
+		return reply.MakeErrReply("ERR MOVE is not allowed here")
+	}
+	if _, errReply := db.dbByIndex(args[1]); errReply != nil {
+		return errReply
+	}
+	return reply.MakeErrReply("ERR source and destination objects are the same")
+}
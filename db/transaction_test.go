@@ -0,0 +1,90 @@
+package db
+
+import (
+	"redisGo/config"
+	"redisGo/redis/connection"
+	"redisGo/redis/reply"
+	"testing"
+)
+
+func toArgs(cmd ...string) [][]byte {
+	args := make([][]byte, len(cmd))
+	for i, s := range cmd {
+		args[i] = []byte(s)
+	}
+	return args
+}
+
+func TestMultiExec(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	conn := connection.NewFakeConn()
+
+	db.Exec(conn, toArgs("multi"))
+	if r := db.Exec(conn, toArgs("set", "a", "1")); string(r.ToBytes()) != "+QUEUED\r\n" {
+		t.Errorf("expected QUEUED, got %q", r.ToBytes())
+	}
+	db.Exec(conn, toArgs("rpush", "list", "x", "y"))
+	db.Exec(conn, toArgs("get", "a"))
+	result := db.Exec(conn, toArgs("exec"))
+	expected := "*3\r\n+OK\r\n:2\r\n$1\r\n1\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, got %q", expected, result.ToBytes())
+	}
+	if conn.InMultiState() {
+		t.Error("connection should leave multi state after exec")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	conn := connection.NewFakeConn()
+	other := connection.NewFakeConn()
+
+	db.Exec(conn, toArgs("set", "a", "1"))
+	db.Exec(conn, toArgs("watch", "a"))
+	db.Exec(conn, toArgs("multi"))
+	db.Exec(conn, toArgs("set", "a", "2"))
+	db.Exec(other, toArgs("set", "a", "3"))
+	result := db.Exec(conn, toArgs("exec"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null reply, got %q", result.ToBytes())
+	}
+	if r := db.Exec(conn, toArgs("get", "a")); string(r.ToBytes()) != "$1\r\n3\r\n" {
+		t.Errorf("expected 3, got %q", r.ToBytes())
+	}
+
+	// unchanged watched key
+	db.Exec(conn, toArgs("watch", "a"))
+	db.Exec(conn, toArgs("multi"))
+	db.Exec(conn, toArgs("set", "a", "2"))
+	result = db.Exec(conn, toArgs("exec"))
+	if string(result.ToBytes()) != "*1\r\n+OK\r\n" {
+		t.Errorf("expected exec succeed, got %q", result.ToBytes())
+	}
+}
+
+func TestDiscardAndExecAbort(t *testing.T) {
+	config.Properties = &config.PropertyHolder{}
+	db := MakeDB()
+	conn := connection.NewFakeConn()
+
+	db.Exec(conn, toArgs("multi"))
+	db.Exec(conn, toArgs("set", "a", "1"))
+	db.Exec(conn, toArgs("discard"))
+	if r := db.Exec(conn, toArgs("get", "a")); string(r.ToBytes()) != "$-1\r\n" {
+		t.Errorf("expected nil, got %q", r.ToBytes())
+	}
+
+	db.Exec(conn, toArgs("multi"))
+	db.Exec(conn, toArgs("set", "a", "1"))
+	db.Exec(conn, toArgs("nosuchcommand"))
+	result := db.Exec(conn, toArgs("exec"))
+	if !reply.IsErrorReply(result) {
+		t.Errorf("expected EXECABORT, got %q", result.ToBytes())
+	}
+	if r := db.Exec(conn, toArgs("get", "a")); string(r.ToBytes()) != "$-1\r\n" {
+		t.Errorf("expected nil, got %q", r.ToBytes())
+	}
+}
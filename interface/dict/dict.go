@@ -13,4 +13,6 @@ type Dict interface {
 	Keys() []string
 	RandomKeys(n int) []string
 	RandomDistinctKeys(n int) []string
+	// ScanKeys 从 cursor 开始返回至少 count 个 key (不足时返回剩余的全部 key) 以及下一次调用的 cursor, 遍历结束时返回 0
+	ScanKeys(cursor int, count int) ([]string, int)
 }
@@ -0,0 +1,26 @@
+package list
+
+// List 列表的公共接口, 由 datastruct/list 中的 LinkedList 和 QuickList 实现
+type List interface {
+	Add(val interface{})
+	Get(index int) (val interface{})
+	Set(index int, val interface{})
+	Insert(index int, val interface{})
+	// InsertBefore 和 InsertAfter 在第一个与 pivot 相等的元素前后插入, 没有找到 pivot 时返回 false
+	InsertBefore(pivot interface{}, val interface{}) bool
+	InsertAfter(pivot interface{}, val interface{}) bool
+	Remove(index int) (val interface{})
+	RemoveLast() (val interface{})
+	RemoveAllByVal(val interface{}) int
+	RemoveByVal(val interface{}, count int) int
+	ReverseRemoveByVal(val interface{}, count int) int
+	// Trim 只保留下标在 [start, stop) 中的元素
+	Trim(start int, stop int)
+	// Search 从表头 (reverse 为 true 时从表尾) 开始查找与 val 相等的元素, 对每个匹配的下标调用 consumer, consumer 返回 false 时停止.
+	// maxLen 大于 0 时最多比较 maxLen 个元素
+	Search(val interface{}, reverse bool, maxLen int, consumer func(index int) bool)
+	Len() int
+	ForEach(consumer func(int, interface{}) bool)
+	Contains(val interface{}) bool
+	Range(start int, stop int) []interface{}
+}
@@ -8,4 +8,29 @@ type Connection interface {
 	UnSubsChannel(channel string)
 	SubsCount() int
 	GetChannels() []string
+	// sharded channels subscribed by SSUBSCRIBE are kept apart from normal channels
+	SubsShardChannel(channel string)
+	UnSubsShardChannel(channel string)
+	GetShardChannels() []string
+
+	// used for `Multi` command
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	ClearQueuedCmds()
+	GetWatching() map[string]uint32
+	// dirty flag is set when a queued command is rejected, EXEC will abort the transaction
+	SetTxDirty(bool)
+	IsTxDirty() bool
+
+	// used for multi database
+	GetDBIndex() int
+	SelectDB(int)
+
+	// closed when the connection is closed, wakes up blocking commands such as BLPOP
+	Closed() <-chan struct{}
+
+	// used for replication, returns ip:port of the peer
+	RemoteAddr() string
 }
@@ -45,17 +45,17 @@ func (m *Map) Add(keys ...string) {
 	sort.Ints(m.keys)
 }
 
-// support hash tag
-func getPartitionKey(key string) string {
+// GetPartitionKey 支持 hash tag, 与 Redis Cluster 一致, 使用第一个 { 与其后第一个 } 之间的非空内容计算哈希
+func GetPartitionKey(key string) string {
 	beg := strings.Index(key, "{")
 	if beg == -1 {
 		return key
 	}
-	end := strings.Index(key, "}")
-	if end == -1 || end == beg+1 {
+	end := strings.Index(key[beg+1:], "}")
+	if end <= 0 {
 		return key
 	}
-	return key[beg+1 : end]
+	return key[beg+1 : beg+1+end]
 }
 
 // 获取某个key实际对应的服务器
@@ -63,7 +63,7 @@ func (m *Map) Get(key string) string {
 	if len(m.keys) == 0 {
 		return ""
 	}
-	partitionKey := getPartitionKey(key)
+	partitionKey := GetPartitionKey(key)
 	hash := int(m.hashFunc([]byte(partitionKey)))
 	// Binary search
 	idx := sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= hash })
@@ -0,0 +1,10 @@
+package crc16
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// Redis Cluster 规范中给出的校验值
+	if crc := Checksum([]byte("123456789")); crc != 0x31C3 {
+		t.Errorf("expected 0x31C3, got %#x", crc)
+	}
+}
@@ -34,11 +34,11 @@ type IdGenerator struct {
 func MakeGenerator(cluster string, node string) *IdGenerator {
 	fnv64 := fnv.New64()
 	_, _ = fnv64.Write([]byte(cluster))
-	dataCenterId := int64(fnv64.Sum64())
+	dataCenterId := int64(fnv64.Sum64()) & maxDatacenterId
 
 	fnv64.Reset()
 	_, _ = fnv64.Write([]byte(node))
-	workerId := int64(fnv64.Sum64())
+	workerId := int64(fnv64.Sum64()) & maxWorkerId
 
 	return &IdGenerator{
 		mu:           &sync.Mutex{},
@@ -23,7 +23,7 @@ var (
 	F                  *os.File
 	DefaultPrefix      = ""
 	DefaultCallerDepth = 2
-	logger             *log.Logger
+	logger             = log.New(os.Stdout, DefaultPrefix, log.Lmicroseconds|log.LstdFlags) // 未调用 Setup 时输出到标准输出
 	mu                 sync.Mutex
 	logPrefix          = ""
 	levelFlags         = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
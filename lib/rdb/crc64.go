@@ -0,0 +1,34 @@
+package rdb
+
+/*
+ * Redis 使用的 crc64 为 Jones 多项式, 输入输出均反转, 初始值和结果异或值都为 0,
+ * 与标准库 hash/crc64 的 ISO, ECMA 不同, 因此在这里单独实现
+ */
+
+const jonesPoly = 0x95ac9329ac4bc9b5 // 0xad93d23594c935a9 的反转
+
+var crc64Table = makeCRC64Table(jonesPoly)
+
+func makeCRC64Table(poly uint64) *[256]uint64 {
+	table := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = (crc >> 1) ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// CRC64 在 crc 的基础上继续计算 data 的校验和, 首次计算时 crc 传 0
+func CRC64(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crc64Table[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
@@ -0,0 +1,413 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Object 从 rdb 文件中读取出的一个 key, 根据 Type 使用对应的字段
+type Object struct {
+	DB       int
+	Key      string
+	Type     byte
+	ExpireAt *time.Time
+
+	String []byte            // TypeString
+	Values [][]byte          // TypeList, TypeSet
+	Hash   map[string][]byte // TypeHash
+	ZSet   []*ZSetEntry      // TypeZSet, TypeZSet2
+}
+
+var ErrChecksum = errors.New("rdb checksum mismatch")
+
+// Decoder 按 rdb 格式读取数据, 同时计算 crc64 校验和
+type Decoder struct {
+	reader   *bufio.Reader
+	crc      uint64
+	buf      []byte
+	consumed int64
+}
+
+// NewDecoder 若 reader 本身就是 *bufio.Reader 则直接使用, 解析结束后调用方可以继续从中读取 rdb 之后的数据
+func NewDecoder(reader io.Reader) *Decoder {
+	bufReader, ok := reader.(*bufio.Reader)
+	if !ok {
+		bufReader = bufio.NewReader(reader)
+	}
+	return &Decoder{
+		reader: bufReader,
+		buf:    make([]byte, 8),
+	}
+}
+
+func (dec *Decoder) readFull(p []byte) error {
+	n, err := io.ReadFull(dec.reader, p)
+	dec.consumed += int64(n)
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	dec.crc = CRC64(dec.crc, p)
+	return nil
+}
+
+// Consumed 返回已经读取的字节数, Parse 成功返回后即为 rdb 数据的长度
+func (dec *Decoder) Consumed() int64 {
+	return dec.consumed
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	err := dec.readFull(dec.buf[:1])
+	if err != nil {
+		return 0, err
+	}
+	return dec.buf[0], nil
+}
+
+// readLength 返回长度, 若为特殊编码的字符串则 special 为 true, 此时返回值为编码类型
+func (dec *Decoder) readLength() (length uint64, special bool, err error) {
+	first, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3F), false, nil
+	case len14Bit:
+		next, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case lenEncVal:
+		return uint64(first & 0x3F), true, nil
+	}
+	switch first {
+	case len32Bit:
+		err = dec.readFull(dec.buf[:4])
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(dec.buf[:4])), false, nil
+	case len64Bit:
+		err = dec.readFull(dec.buf[:8])
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(dec.buf[:8]), false, nil
+	}
+	return 0, false, fmt.Errorf("illegal length encoding: %x", first)
+}
+
+func (dec *Decoder) readPlainLength() (uint64, error) {
+	length, special, err := dec.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if special {
+		return 0, errors.New("unexpected string encoding")
+	}
+	return length, nil
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, special, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !special {
+		buf := make([]byte, length)
+		err = dec.readFull(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	switch length {
+	case encInt8:
+		b, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(b)))), nil
+	case encInt16:
+		err = dec.readFull(dec.buf[:2])
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(dec.buf[:2]))))), nil
+	case encInt32:
+		err = dec.readFull(dec.buf[:4])
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(dec.buf[:4]))))), nil
+	case encLZF:
+		compressedLen, err := dec.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := dec.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed := make([]byte, compressedLen)
+		err = dec.readFull(compressed)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(rawLen))
+	}
+	return nil, fmt.Errorf("unknown string encoding: %d", length)
+}
+
+func (dec *Decoder) readValues() ([][]byte, error) {
+	size, err := dec.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, size)
+	for i := uint64(0); i < size; i++ {
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// readScore 读取 ZSET 格式中以字符串保存的分数
+func (dec *Decoder) readScore() (float64, error) {
+	length, err := dec.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, length)
+	err = dec.readFull(buf)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func (dec *Decoder) readObject(obj *Object) error {
+	key, err := dec.readString()
+	if err != nil {
+		return err
+	}
+	obj.Key = string(key)
+	switch obj.Type {
+	case TypeString:
+		obj.String, err = dec.readString()
+	case TypeList, TypeSet:
+		obj.Values, err = dec.readValues()
+	case TypeHash:
+		var size uint64
+		size, err = dec.readPlainLength()
+		if err != nil {
+			return err
+		}
+		obj.Hash = make(map[string][]byte, size)
+		for i := uint64(0); i < size; i++ {
+			field, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			value, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			obj.Hash[string(field)] = value
+		}
+	case TypeZSet, TypeZSet2:
+		var size uint64
+		size, err = dec.readPlainLength()
+		if err != nil {
+			return err
+		}
+		obj.ZSet = make([]*ZSetEntry, 0, size)
+		for i := uint64(0); i < size; i++ {
+			member, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			var score float64
+			if obj.Type == TypeZSet2 {
+				err = dec.readFull(dec.buf[:8])
+				score = math.Float64frombits(binary.LittleEndian.Uint64(dec.buf[:8]))
+			} else {
+				score, err = dec.readScore()
+			}
+			if err != nil {
+				return err
+			}
+			obj.ZSet = append(obj.ZSet, &ZSetEntry{Member: string(member), Score: score})
+		}
+	default:
+		return fmt.Errorf("unsupported object type: %d", obj.Type)
+	}
+	return err
+}
+
+// Parse 读取整个 rdb 文件, 每读取一个 key 调用一次 consumer, consumer 返回 false 时停止读取
+func (dec *Decoder) Parse(consumer func(obj *Object) bool) error {
+	header := make([]byte, 9)
+	err := dec.readFull(header)
+	if err != nil {
+		return err
+	}
+	if string(header[:5]) != magic {
+		return errors.New("not a rdb file")
+	}
+	ver, err := strconv.Atoi(string(header[5:]))
+	if err != nil || ver < 1 || ver > version {
+		return fmt.Errorf("unsupported rdb version: %s", header[5:])
+	}
+
+	dbIndex := 0
+	var expireAt *time.Time
+	for {
+		opCode, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch opCode {
+		case opCodeEOF:
+			return dec.checkTail(ver)
+		case opCodeSelectDB:
+			index, err := dec.readPlainLength()
+			if err != nil {
+				return err
+			}
+			dbIndex = int(index)
+		case opCodeResizeDB:
+			if _, err = dec.readPlainLength(); err != nil {
+				return err
+			}
+			if _, err = dec.readPlainLength(); err != nil {
+				return err
+			}
+		case opCodeAux:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opCodeExpireTimeMs:
+			err = dec.readFull(dec.buf[:8])
+			if err != nil {
+				return err
+			}
+			t := time.Unix(0, int64(binary.LittleEndian.Uint64(dec.buf[:8]))*int64(time.Millisecond))
+			expireAt = &t
+		case opCodeExpireTime:
+			err = dec.readFull(dec.buf[:4])
+			if err != nil {
+				return err
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(dec.buf[:4])), 0)
+			expireAt = &t
+		case opCodeIdle:
+			if _, err = dec.readPlainLength(); err != nil {
+				return err
+			}
+		case opCodeFreq:
+			if _, err = dec.readByte(); err != nil {
+				return err
+			}
+		default:
+			obj := &Object{
+				DB:       dbIndex,
+				Type:     opCode,
+				ExpireAt: expireAt,
+			}
+			expireAt = nil
+			err = dec.readObject(obj)
+			if err != nil {
+				return err
+			}
+			if !consumer(obj) {
+				return nil
+			}
+		}
+	}
+}
+
+// checkTail 校验 crc64, 校验和为 0 表示写入时关闭了校验
+func (dec *Decoder) checkTail(ver int) error {
+	if ver < 5 {
+		return nil
+	}
+	expected := dec.crc
+	checksum := make([]byte, 8)
+	n, err := io.ReadFull(dec.reader, checksum)
+	dec.consumed += int64(n)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	actual := binary.LittleEndian.Uint64(checksum)
+	if actual != 0 && actual != expected {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// lzfDecompress 解压 Redis 使用 lzf 压缩的字符串
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 { // 字面量
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errors.New("lzf: invalid literal run")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// 回溯引用
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errors.New("lzf: invalid back reference")
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errors.New("lzf: invalid back reference")
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("lzf: invalid back reference")
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errors.New("lzf: length mismatch")
+	}
+	return out, nil
+}
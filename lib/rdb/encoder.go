@@ -0,0 +1,309 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+/*
+ * rdb 文件格式与 Redis RDB v9 兼容:
+ * REDIS0009 | AUX 字段 | SELECTDB index | RESIZEDB size expires | [EXPIRETIME_MS] type key value ... | EOF | crc64
+ */
+
+const (
+	version = 9
+	magic   = "REDIS"
+)
+
+// 操作码
+const (
+	opCodeIdle         = 0xF8
+	opCodeFreq         = 0xF9
+	opCodeAux          = 0xFA
+	opCodeResizeDB     = 0xFB
+	opCodeExpireTimeMs = 0xFC
+	opCodeExpireTime   = 0xFD
+	opCodeSelectDB     = 0xFE
+	opCodeEOF          = 0xFF
+)
+
+// 对象类型
+const (
+	TypeString = 0
+	TypeList   = 1
+	TypeSet    = 2
+	TypeZSet   = 3
+	TypeHash   = 4
+	TypeZSet2  = 5
+)
+
+// 长度编码
+const (
+	len6Bit   = 0
+	len14Bit  = 1
+	len32Bit  = 0x80
+	len64Bit  = 0x81
+	lenEncVal = 3
+)
+
+// 特殊的字符串编码
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// ZSetEntry 有序集合中的一个成员
+type ZSetEntry struct {
+	Member string
+	Score  float64
+}
+
+// Encoder 将数据按 rdb 格式写入 writer, 同时计算 crc64 校验和
+type Encoder struct {
+	writer io.Writer
+	crc    uint64
+	buf    []byte
+}
+
+func NewEncoder(writer io.Writer) *Encoder {
+	return &Encoder{
+		writer: writer,
+		buf:    make([]byte, 8),
+	}
+}
+
+func (enc *Encoder) write(p []byte) error {
+	_, err := enc.writer.Write(p)
+	if err != nil {
+		return err
+	}
+	enc.crc = CRC64(enc.crc, p)
+	return nil
+}
+
+func (enc *Encoder) writeByte(b byte) error {
+	enc.buf[0] = b
+	return enc.write(enc.buf[:1])
+}
+
+func (enc *Encoder) writeLength(length uint64) error {
+	var buf []byte
+	if length <= 0x3F {
+		buf = []byte{byte(length) | len6Bit<<6}
+	} else if length <= 0x3FFF {
+		buf = []byte{byte(length>>8) | len14Bit<<6, byte(length)}
+	} else if length <= math.MaxUint32 {
+		buf = make([]byte, 5)
+		buf[0] = len32Bit
+		binary.BigEndian.PutUint32(buf[1:], uint32(length))
+	} else {
+		buf = make([]byte, 9)
+		buf[0] = len64Bit
+		binary.BigEndian.PutUint64(buf[1:], length)
+	}
+	return enc.write(buf)
+}
+
+// writeString 能表示为 32 位整数的字符串使用整数编码, 与 Redis 保持一致
+func (enc *Encoder) writeString(s []byte) error {
+	if len(s) <= 11 && len(s) > 0 {
+		if value, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(value, 10) == string(s) {
+			return enc.writeInt(value)
+		}
+	}
+	err := enc.writeLength(uint64(len(s)))
+	if err != nil {
+		return err
+	}
+	return enc.write(s)
+}
+
+func (enc *Encoder) writeInt(value int64) error {
+	var buf []byte
+	if value >= math.MinInt8 && value <= math.MaxInt8 {
+		buf = []byte{lenEncVal<<6 | encInt8, byte(value)}
+	} else if value >= math.MinInt16 && value <= math.MaxInt16 {
+		buf = make([]byte, 3)
+		buf[0] = lenEncVal<<6 | encInt16
+		binary.LittleEndian.PutUint16(buf[1:], uint16(value))
+	} else {
+		buf = make([]byte, 5)
+		buf[0] = lenEncVal<<6 | encInt32
+		binary.LittleEndian.PutUint32(buf[1:], uint32(value))
+	}
+	return enc.write(buf)
+}
+
+// WriteHeader 写入文件头及 redis-ver 等辅助字段
+func (enc *Encoder) WriteHeader() error {
+	err := enc.write([]byte(magic + "000" + strconv.Itoa(version)))
+	if err != nil {
+		return err
+	}
+	aux := [][2]string{
+		{"redis-ver", "6.0.0"},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	for _, field := range aux {
+		err = enc.WriteAux(field[0], field[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) WriteAux(key string, value string) error {
+	err := enc.writeByte(opCodeAux)
+	if err != nil {
+		return err
+	}
+	err = enc.writeString([]byte(key))
+	if err != nil {
+		return err
+	}
+	return enc.writeString([]byte(value))
+}
+
+// WriteDBHeader 开始写入一个数据库, keyCount 和 ttlCount 仅用于加载时预分配空间
+func (enc *Encoder) WriteDBHeader(index int, keyCount int, ttlCount int) error {
+	err := enc.writeByte(opCodeSelectDB)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(index))
+	if err != nil {
+		return err
+	}
+	err = enc.writeByte(opCodeResizeDB)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(keyCount))
+	if err != nil {
+		return err
+	}
+	return enc.writeLength(uint64(ttlCount))
+}
+
+// writeObjectHeader expireAt 为 nil 表示没有过期时间
+func (enc *Encoder) writeObjectHeader(objType byte, key string, expireAt *time.Time) error {
+	if expireAt != nil {
+		err := enc.writeByte(opCodeExpireTimeMs)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf, uint64(expireAt.UnixNano()/int64(time.Millisecond)))
+		err = enc.write(enc.buf[:8])
+		if err != nil {
+			return err
+		}
+	}
+	err := enc.writeByte(objType)
+	if err != nil {
+		return err
+	}
+	return enc.writeString([]byte(key))
+}
+
+func (enc *Encoder) WriteStringObject(key string, value []byte, expireAt *time.Time) error {
+	err := enc.writeObjectHeader(TypeString, key, expireAt)
+	if err != nil {
+		return err
+	}
+	return enc.writeString(value)
+}
+
+func (enc *Encoder) writeValues(values [][]byte) error {
+	err := enc.writeLength(uint64(len(values)))
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		err = enc.writeString(value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) WriteListObject(key string, values [][]byte, expireAt *time.Time) error {
+	err := enc.writeObjectHeader(TypeList, key, expireAt)
+	if err != nil {
+		return err
+	}
+	return enc.writeValues(values)
+}
+
+func (enc *Encoder) WriteSetObject(key string, members [][]byte, expireAt *time.Time) error {
+	err := enc.writeObjectHeader(TypeSet, key, expireAt)
+	if err != nil {
+		return err
+	}
+	return enc.writeValues(members)
+}
+
+func (enc *Encoder) WriteHashObject(key string, hash map[string][]byte, expireAt *time.Time) error {
+	err := enc.writeObjectHeader(TypeHash, key, expireAt)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(hash)))
+	if err != nil {
+		return err
+	}
+	for field, value := range hash {
+		err = enc.writeString([]byte(field))
+		if err != nil {
+			return err
+		}
+		err = enc.writeString(value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteZSetObject 使用 ZSET_2 格式, 分数以 8 字节小端浮点数保存
+func (enc *Encoder) WriteZSetObject(key string, entries []*ZSetEntry, expireAt *time.Time) error {
+	err := enc.writeObjectHeader(TypeZSet2, key, expireAt)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(entries)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = enc.writeString([]byte(entry.Member))
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf, math.Float64bits(entry.Score))
+		err = enc.write(enc.buf[:8])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEnd 写入 EOF 以及 crc64 校验和
+func (enc *Encoder) WriteEnd() error {
+	err := enc.writeByte(opCodeEOF)
+	if err != nil {
+		return err
+	}
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, enc.crc)
+	_, err = enc.writer.Write(checksum)
+	return err
+}
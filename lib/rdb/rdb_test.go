@@ -0,0 +1,104 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRC64(t *testing.T) {
+	// Redis src/crc64.c 中的测试用例
+	if crc := CRC64(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("unexpected crc64: %x", crc)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	expireAt := time.Unix(1700000000, 123*int64(time.Millisecond))
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	_ = enc.WriteDBHeader(0, 2, 1)
+	_ = enc.WriteStringObject("str", []byte("hello"), &expireAt)
+	_ = enc.WriteStringObject("int", []byte("-40000"), nil)
+	_ = enc.WriteDBHeader(3, 3, 0)
+	_ = enc.WriteListObject("list", [][]byte{[]byte("a"), []byte(strings.Repeat("b", 100))}, nil)
+	_ = enc.WriteHashObject("hash", map[string][]byte{"f": []byte("v")}, nil)
+	_ = enc.WriteZSetObject("zset", []*ZSetEntry{{Member: "m", Score: 1.5}, {Member: "n", Score: math.Inf(-1)}}, nil)
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	objects := make(map[string]*Object)
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(obj *Object) bool {
+		objects[obj.Key] = obj
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj := objects["str"]; obj == nil || string(obj.String) != "hello" || obj.ExpireAt == nil || !obj.ExpireAt.Equal(expireAt) {
+		t.Errorf("unexpected string object: %+v", obj)
+	}
+	if obj := objects["int"]; obj == nil || string(obj.String) != "-40000" || obj.ExpireAt != nil {
+		t.Errorf("unexpected int object: %+v", obj)
+	}
+	if obj := objects["list"]; obj == nil || obj.DB != 3 || len(obj.Values) != 2 || len(obj.Values[1]) != 100 {
+		t.Errorf("unexpected list object: %+v", obj)
+	}
+	if obj := objects["hash"]; obj == nil || string(obj.Hash["f"]) != "v" {
+		t.Errorf("unexpected hash object: %+v", obj)
+	}
+	if obj := objects["zset"]; obj == nil || len(obj.ZSet) != 2 || obj.ZSet[0].Score != 1.5 || !math.IsInf(obj.ZSet[1].Score, -1) {
+		t.Errorf("unexpected zset object: %+v", obj)
+	}
+
+	// 篡改数据后校验和不匹配
+	corrupted := append([]byte{}, buf.Bytes()...)
+	corrupted[len(corrupted)-20] ^= 0xFF
+	err = NewDecoder(bytes.NewReader(corrupted)).Parse(func(obj *Object) bool { return true })
+	if err == nil {
+		t.Error("expected error for corrupted file")
+	}
+}
+
+func TestDecodeFixture(t *testing.T) {
+	// 按 Redis 的写法构造: lzf 压缩的字符串, int8 编码的值, 秒级过期时间以及 ZSET(v1) 格式
+	fixture := []byte("REDIS0009")
+	fixture = append(fixture, opCodeAux, 9)
+	fixture = append(fixture, "redis-ver"...)
+	fixture = append(fixture, 5)
+	fixture = append(fixture, "7.0.0"...)
+	fixture = append(fixture, opCodeSelectDB, 0, opCodeResizeDB, 3, 1)
+	fixture = append(fixture, TypeString, 3, 'l', 'z', 'f', 0xC3, 5, 20, 0x00, 'a', 0xE0, 10, 0x00)
+	fixture = append(fixture, opCodeExpireTime, 0x00, 0xF1, 0x53, 0x65)
+	fixture = append(fixture, TypeString, 3, 'n', 'u', 'm', 0xC0, 0x7B)
+	fixture = append(fixture, TypeZSet, 1, 'z', 1, 1, 'm', 3, '2', '.', '5')
+	fixture = append(fixture, opCodeEOF)
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, CRC64(0, fixture))
+	fixture = append(fixture, checksum...)
+
+	objects := make(map[string]*Object)
+	err := NewDecoder(bytes.NewReader(fixture)).Parse(func(obj *Object) bool {
+		objects[obj.Key] = obj
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj := objects["lzf"]; obj == nil || string(obj.String) != strings.Repeat("a", 20) {
+		t.Errorf("unexpected lzf object: %+v", obj)
+	}
+	if obj := objects["num"]; obj == nil || string(obj.String) != "123" || obj.ExpireAt == nil || obj.ExpireAt.Unix() != 0x6553F100 {
+		t.Errorf("unexpected num object: %+v", obj)
+	}
+	if obj := objects["z"]; obj == nil || len(obj.ZSet) != 1 || obj.ZSet[0].Score != 2.5 {
+		t.Errorf("unexpected zset object: %+v", obj)
+	}
+}
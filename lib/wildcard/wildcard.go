@@ -0,0 +1,98 @@
+// Package wildcard 实现 KEYS, SCAN MATCH 等命令使用的通配符匹配, 语法与 Redis 的 stringmatch 相同
+package wildcard
+
+// Match 判断 s 是否匹配 pattern:
+// * 匹配任意长度的字符串, ? 匹配单个字符, [abc] 和 [a-z] 匹配集合中的字符, [^abc] 匹配集合以外的字符,
+// \ 将下一个字符作为普通字符, 包括在 [] 中. 没有闭合的 [ 在 pattern 结尾处结束
+func Match(pattern string, s string) bool {
+	p, i := 0, 0
+	// 最近一个 * 在 pattern 中的位置, 以及此时 s 中已经匹配到的位置, 用于回溯
+	star, mark := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, mark = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if end, matched := matchClass(pattern, p+1, s[i]); matched {
+					p = end
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == s[i] {
+						p += 2
+						i++
+						continue
+					}
+				} else if s[i] == '\\' {
+					// 结尾的 \ 作为普通字符
+					p++
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// 让 * 多匹配一个字符
+		mark++
+		p, i = star+1, mark
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass 匹配从 pattern[start] 开始的字符集合, 返回 ] 之后的位置以及 c 是否属于该集合
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	j := start
+	not := j < len(pattern) && pattern[j] == '^'
+	if not {
+		j++
+	}
+	matched := false
+	for j < len(pattern) && pattern[j] != ']' {
+		switch {
+		case pattern[j] == '\\' && j+1 < len(pattern):
+			if pattern[j+1] == c {
+				matched = true
+			}
+			j += 2
+		case j+2 < len(pattern) && pattern[j+1] == '-' && pattern[j+2] != ']':
+			low, high := pattern[j], pattern[j+2]
+			if low > high {
+				low, high = high, low
+			}
+			if low <= c && c <= high {
+				matched = true
+			}
+			j += 3
+		default:
+			if pattern[j] == c {
+				matched = true
+			}
+			j++
+		}
+	}
+	if j < len(pattern) {
+		// 跳过 ]
+		j++
+	}
+	return j, matched != not
+}
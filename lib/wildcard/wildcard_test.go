@@ -0,0 +1,45 @@
+package wildcard
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		matched bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"*:name", "user:1:name", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"abc", "abcd", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"key[0-9]*", "key42", true},
+		{"*[0-9]", "key", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h\?`, "h?", true},
+		{`[\]]`, "]", true},
+		{`[\-]`, "-", true},
+		{"[a-]", "-", true},
+		{"[abc", "b", true},
+		{"[]", "a", false},
+		{`a\`, `a\`, true},
+	}
+	for _, c := range cases {
+		if Match(c.pattern, c.s) != c.matched {
+			t.Errorf("Match(%q, %q) should be %v", c.pattern, c.s, c.matched)
+		}
+	}
+}
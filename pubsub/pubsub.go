@@ -7,11 +7,38 @@ import (
 	"strconv"
 )
 
+// namespace 普通频道和分片频道 (SSUBSCRIBE) 使用不同的 Hub 和消息类型, 连接上分别记录两者的订阅
+type namespace struct {
+	subscribe   string
+	unsubscribe string
+	message     []byte
+	subs        func(c redis.Connection, channel string)
+	unsubs      func(c redis.Connection, channel string)
+	channels    func(c redis.Connection) []string
+	count       func(c redis.Connection) int
+}
+
 var (
-	_subscribe         = "subscribe"
-	_unsubscribe       = "unsubscribe"
-	messageBytes       = []byte("message")
-	unSubscribeNothing = []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\n:0\r\n")
+	normalChannels = &namespace{
+		subscribe:   "subscribe",
+		unsubscribe: "unsubscribe",
+		message:     []byte("message"),
+		subs:        redis.Connection.SubsChannel,
+		unsubs:      redis.Connection.UnSubsChannel,
+		channels:    redis.Connection.GetChannels,
+		count:       redis.Connection.SubsCount,
+	}
+	shardChannels = &namespace{
+		subscribe:   "ssubscribe",
+		unsubscribe: "sunsubscribe",
+		message:     []byte("smessage"),
+		subs:        redis.Connection.SubsShardChannel,
+		unsubs:      redis.Connection.UnSubsShardChannel,
+		channels:    redis.Connection.GetShardChannels,
+		count: func(c redis.Connection) int {
+			return len(c.GetShardChannels())
+		},
+	}
 )
 
 func MakeMsg(t string, channel string, code int64) []byte {
@@ -20,11 +47,16 @@ func MakeMsg(t string, channel string, code int64) []byte {
 		":" + strconv.FormatInt(code, 10) + reply.CRLF)
 }
 
+// makeNothingMsg 没有订阅任何频道时 UNSUBSCRIBE 的回复
+func makeNothingMsg(t string) []byte {
+	return []byte("*3\r\n$" + strconv.Itoa(len(t)) + reply.CRLF + t + reply.CRLF + "$-1\r\n:0\r\n")
+}
+
 /*
  * invoker should lock channel
  */
-func subscribe0(hub *Hub, channel string, client redis.Connection) bool {
-	client.SubsChannel(channel)
+func subscribe0(hub *Hub, ns *namespace, channel string, client redis.Connection) bool {
+	ns.subs(client, channel)
 
 	// add into db.subs
 	raw, ok := hub.subs.Get(channel)
@@ -42,8 +74,8 @@ func subscribe0(hub *Hub, channel string, client redis.Connection) bool {
 	return true
 }
 
-func unsubscribe0(hub *Hub, channel string, client redis.Connection) bool {
-	client.UnSubsChannel(channel)
+func unsubscribe0(hub *Hub, ns *namespace, channel string, client redis.Connection) bool {
+	ns.unsubs(client, channel)
 
 	raw, ok := hub.subs.Get(channel)
 	if ok {
@@ -57,7 +89,7 @@ func unsubscribe0(hub *Hub, channel string, client redis.Connection) bool {
 	return false
 }
 
-func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+func subscribe(hub *Hub, ns *namespace, c redis.Connection, args [][]byte) redis.Reply {
 	channels := make([]string, len(args))
 	for i, b := range args {
 		channels[i] = string(b)
@@ -66,24 +98,24 @@ func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
 	defer hub.subsLocker.Unlocks(channels...)
 
 	for _, channel := range channels {
-		if subscribe0(hub, channel, c) {
-			_ = c.Write(MakeMsg(_subscribe, channel, int64(c.SubsCount())))
+		if subscribe0(hub, ns, channel, c) {
+			_ = c.Write(MakeMsg(ns.subscribe, channel, int64(ns.count(c))))
 		}
 	}
 	return &reply.NoReply{}
 }
 
-func UnsubscribeAll(hub *Hub, c redis.Connection) {
-	channels := c.GetChannels()
+func unsubscribeAll(hub *Hub, ns *namespace, c redis.Connection) {
+	channels := ns.channels(c)
 	hub.subsLocker.Locks(channels...)
 	defer hub.subsLocker.Unlocks(channels...)
 
 	for _, channel := range channels {
-		unsubscribe0(hub, channel, c)
+		unsubscribe0(hub, ns, channel, c)
 	}
 }
 
-func UnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+func unsubscribe(hub *Hub, ns *namespace, c redis.Connection, args [][]byte) redis.Reply {
 	var channels []string
 	if len(args) > 0 {
 		channels = make([]string, len(args))
@@ -91,28 +123,25 @@ func UnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
 			channels[i] = string(b)
 		}
 	} else {
-		channels = c.GetChannels()
+		channels = ns.channels(c)
 	}
 	hub.subsLocker.Locks(channels...)
 	defer hub.subsLocker.Unlocks(channels...)
 
 	if len(channels) == 0 {
-		_ = c.Write(unSubscribeNothing)
+		_ = c.Write(makeNothingMsg(ns.unsubscribe))
 		return &reply.NoReply{}
 	}
 
 	for _, channel := range channels {
-		if unsubscribe0(hub, channel, c) {
-			_ = c.Write(MakeMsg(_unsubscribe, channel, int64(c.SubsCount())))
+		if unsubscribe0(hub, ns, channel, c) {
+			_ = c.Write(MakeMsg(ns.unsubscribe, channel, int64(ns.count(c))))
 		}
 	}
 	return &reply.NoReply{}
 }
 
-func Publish(hub *Hub, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return &reply.ArgNumErrReply{Cmd: "publish"}
-	}
+func publish(hub *Hub, ns *namespace, args [][]byte) redis.Reply {
 	channel := string(args[0])
 	message := args[1]
 
@@ -127,7 +156,7 @@ func Publish(hub *Hub, args [][]byte) redis.Reply {
 	subscribers.ForEach(func(_ int, c interface{}) bool {
 		client, _ := c.(redis.Connection)
 		replyArgs := make([][]byte, 3)
-		replyArgs[0] = messageBytes
+		replyArgs[0] = ns.message
 		replyArgs[1] = []byte(channel)
 		replyArgs[2] = message
 		_ = client.Write(reply.MakeMultiBulkReply(replyArgs).ToBytes())
@@ -135,3 +164,42 @@ func Publish(hub *Hub, args [][]byte) redis.Reply {
 	})
 	return reply.MakeIntReply(int64(subscribers.Len()))
 }
+
+func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	return subscribe(hub, normalChannels, c, args)
+}
+
+func UnsubscribeAll(hub *Hub, c redis.Connection) {
+	unsubscribeAll(hub, normalChannels, c)
+}
+
+func UnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	return unsubscribe(hub, normalChannels, c, args)
+}
+
+func Publish(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return &reply.ArgNumErrReply{Cmd: "publish"}
+	}
+	return publish(hub, normalChannels, args)
+}
+
+// SSubscribe 订阅分片频道, hub 应当与普通频道的 hub 不同
+func SSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	return subscribe(hub, shardChannels, c, args)
+}
+
+func SUnsubscribeAll(hub *Hub, c redis.Connection) {
+	unsubscribeAll(hub, shardChannels, c)
+}
+
+func SUnsubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	return unsubscribe(hub, shardChannels, c, args)
+}
+
+func SPublish(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return &reply.ArgNumErrReply{Cmd: "spublish"}
+	}
+	return publish(hub, shardChannels, args)
+}
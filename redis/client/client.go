@@ -77,6 +77,7 @@ func (client *Client) Send(args [][]byte) redis.Reply {
 	}
 	request.waiting.Add(1)
 	client.working.Add(1)
+	defer client.working.Done()
 	client.pendingReqs <- request
 	timeout := request.waiting.WaitWithTimeout(maxWait)
 	if timeout {
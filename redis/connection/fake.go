@@ -0,0 +1,147 @@
+package connection
+
+import (
+	"bytes"
+	"sync"
+)
+
+// FakeConn 实现了 redis.Connection, 用于重放 aof 以及测试, 写入的数据保存在内存中
+type FakeConn struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+
+	subs      map[string]bool
+	shardSubs map[string]bool
+
+	multiState bool
+	queue      [][][]byte
+	watching   map[string]uint32
+	txDirty    bool
+
+	// selected db index
+	selectedDB int
+}
+
+func NewFakeConn() *FakeConn {
+	return &FakeConn{}
+}
+
+func (c *FakeConn) Write(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf.Write(b)
+	return nil
+}
+
+// Bytes 返回写入连接的所有数据
+func (c *FakeConn) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Bytes()
+}
+
+// Clean 清空已写入的数据
+func (c *FakeConn) Clean() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf.Reset()
+}
+
+func (c *FakeConn) SubsChannel(channel string) {
+	if c.subs == nil {
+		c.subs = make(map[string]bool)
+	}
+	c.subs[channel] = true
+}
+
+func (c *FakeConn) UnSubsChannel(channel string) {
+	delete(c.subs, channel)
+}
+
+func (c *FakeConn) SubsCount() int {
+	return len(c.subs)
+}
+
+func (c *FakeConn) GetChannels() []string {
+	channels := make([]string, 0, len(c.subs))
+	for channel := range c.subs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (c *FakeConn) SubsShardChannel(channel string) {
+	if c.shardSubs == nil {
+		c.shardSubs = make(map[string]bool)
+	}
+	c.shardSubs[channel] = true
+}
+
+func (c *FakeConn) UnSubsShardChannel(channel string) {
+	delete(c.shardSubs, channel)
+}
+
+func (c *FakeConn) GetShardChannels() []string {
+	channels := make([]string, 0, len(c.shardSubs))
+	for channel := range c.shardSubs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (c *FakeConn) InMultiState() bool {
+	return c.multiState
+}
+
+func (c *FakeConn) SetMultiState(state bool) {
+	if !state {
+		c.watching = nil
+		c.queue = nil
+		c.txDirty = false
+	}
+	c.multiState = state
+}
+
+func (c *FakeConn) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+func (c *FakeConn) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+func (c *FakeConn) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+func (c *FakeConn) GetWatching() map[string]uint32 {
+	if c.watching == nil {
+		c.watching = make(map[string]uint32)
+	}
+	return c.watching
+}
+
+func (c *FakeConn) SetTxDirty(dirty bool) {
+	c.txDirty = dirty
+}
+
+func (c *FakeConn) IsTxDirty() bool {
+	return c.txDirty
+}
+
+func (c *FakeConn) GetDBIndex() int {
+	return c.selectedDB
+}
+
+func (c *FakeConn) SelectDB(dbNum int) {
+	c.selectedDB = dbNum
+}
+
+// Closed FakeConn 不会被关闭, 返回的 channel 永远不会就绪
+func (c *FakeConn) Closed() <-chan struct{} {
+	return nil
+}
+
+func (c *FakeConn) RemoteAddr() string {
+	return ""
+}
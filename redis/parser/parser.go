@@ -15,8 +15,11 @@ import (
 type Payload struct {
 	Data redis.Reply
 	Err  error
+	// Offset 到目前为止最后一条完整消息的结束位置, 即输入流中可以安全截断的字节偏移量
+	Offset int64
 }
 
+// Parse 在后台 goroutine 中解析, 通过 channel 返回结果
 func Parse(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload)
 	go func() {
@@ -25,13 +28,23 @@ func Parse(reader io.Reader) <-chan *Payload {
 				logger.Error(debug.Stack())
 			}
 		}()
-		parse0(reader, ch)
+		parse0(reader, func(payload *Payload) bool {
+			ch <- payload
+			return true
+		})
+		close(ch)
 	}()
 	return ch
 }
 
+// ParseStream 在当前 goroutine 中同步解析, consumer 返回 false 时停止解析, 用于读取 aof 等文件
+func ParseStream(reader io.Reader, consumer func(payload *Payload) bool) {
+	parse0(reader, consumer)
+}
+
 type readState struct {
 	downloading       bool
+	readingBody       bool // 已读取 $<len> 头部, 下一行为字符串内容
 	expectedArgsCount int
 	receivedCount     int
 	msgType           byte
@@ -43,11 +56,24 @@ func (s *readState) finished() bool {
 	return s.expectedArgsCount > 0 && s.receivedCount == s.expectedArgsCount
 }
 
-func parse0(reader io.Reader, ch chan<- *Payload) {
+func parse0(reader io.Reader, emit func(payload *Payload) bool) {
 	bufReader := bufio.NewReader(reader)
 	var state readState
 	var err error
 	var msg []byte
+	var consumed int64 // 已读取的字节数
+	var offset int64   // 最后一条完整消息的结束位置
+
+	// send 发送一条完整的消息, 并更新 offset
+	send := func(data redis.Reply, err error) bool {
+		offset = consumed
+		return emit(&Payload{Data: data, Err: err, Offset: offset})
+	}
+	// sendErr 发送错误并丢弃当前正在解析的消息
+	sendErr := func(err error) bool {
+		state = readState{}
+		return emit(&Payload{Err: err, Offset: offset})
+	}
 
 	for {
 		// read line
@@ -55,15 +81,20 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 		msg, ioErr, err = readLine(bufReader, &state)
 		if err != nil {
 			if ioErr {
-				ch <- &Payload{Err: err}
-				close(ch)
+				// 消息读取到一半时遇到 EOF, 说明数据被截断
+				if err == io.EOF && (state.downloading || len(msg) > 0) {
+					err = io.ErrUnexpectedEOF
+				}
+				sendErr(err)
+				return
+			}
+			consumed += int64(len(msg))
+			if !sendErr(err) {
 				return
-			} else {
-				ch <- &Payload{Err: err}
-				state = readState{}
-				continue
 			}
+			continue
 		}
+		consumed += int64(len(msg))
 
 		// parse line
 		if !state.downloading {
@@ -72,38 +103,47 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 				// multi bulk response
 				err = parseMultiBulkHeader(msg, &state)
 				if err != nil {
-					ch <- &Payload{Err: errors.New("protocol error: " + string(msg))}
-					state = readState{}
+					if !sendErr(errors.New("protocol error: " + string(msg))) {
+						return
+					}
 					continue
 				}
 				if state.expectedArgsCount == 0 {
-					ch <- &Payload{Data: &reply.EmptyMultiBulkReply{}}
 					state = readState{}
+					if !send(&reply.EmptyMultiBulkReply{}, nil) {
+						return
+					}
 					continue
 				}
 			} else if msg[0] == '$' {
 				err = parseBulkHeader(msg, &state)
 				if err != nil {
-					ch <- &Payload{Err: errors.New("protocol error: " + string(msg))}
-					state = readState{}
+					if !sendErr(errors.New("protocol error: " + string(msg))) {
+						return
+					}
 					continue
 				}
 				if state.fixedLen == -1 {
-					ch <- &Payload{Data: &reply.NullBulkReply{}}
 					state = readState{}
+					if !send(&reply.NullBulkReply{}, nil) {
+						return
+					}
 					continue
 				}
 			} else {
 				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{Data: result, Err: err}
 				state = readState{}
+				if !send(result, err) {
+					return
+				}
 				continue
 			}
 		} else {
 			err = readBulkBody(msg, &state)
 			if err != nil {
-				ch <- &Payload{Err: errors.New("protocol error: " + string(msg))}
-				state = readState{}
+				if !sendErr(errors.New("protocol error: " + string(msg))) {
+					return
+				}
 				continue
 			}
 			if state.finished() {
@@ -113,8 +153,10 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 				} else if state.msgType == '$' {
 					result = reply.MakeBulkReply(state.args[0])
 				}
-				ch <- &Payload{Data: result, Err: err}
 				state = readState{}
+				if !send(result, nil) {
+					return
+				}
 			}
 		}
 	}
@@ -126,19 +168,19 @@ func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 	if state.fixedLen == 0 { // read normal line
 		msg, err = bufReader.ReadBytes('\n')
 		if err != nil {
-			return nil, true, err
+			return msg, true, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+		if len(msg) < 2 || msg[len(msg)-2] != '\r' {
+			return msg, false, errors.New("protocol error: " + string(msg))
 		}
 	} else {
 		msg = make([]byte, state.fixedLen+2)
-		_, err = io.ReadFull(bufReader, msg)
+		n, err := io.ReadFull(bufReader, msg)
 		if err != nil {
-			return nil, true, err
+			return msg[:n], true, err
 		}
 		if msg[len(msg)-2] != '\r' || msg[len(msg)-1] != '\n' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+			return msg, false, errors.New("protocol error: " + string(msg))
 		}
 		state.fixedLen = 0
 	}
@@ -176,9 +218,11 @@ func parseBulkHeader(msg []byte, state *readState) error {
 	}
 	if state.fixedLen == -1 {
 		return nil
-	} else if state.fixedLen > 0 {
+	} else if state.fixedLen >= 0 {
+		// 长度为 0 时内容只有 "\r\n", 按普通行读取即可
 		state.msgType = msg[0]
 		state.downloading = true
+		state.readingBody = true
 		state.expectedArgsCount = 1
 		state.receivedCount = 0
 		state.args = make([][]byte, 1)
@@ -215,20 +259,26 @@ func parseSingleLineReply(msg []byte) (redis.Reply, error) {
 
 func readBulkBody(msg []byte, state *readState) error {
 	line := msg[0 : len(msg)-2]
-	var err error
-	if line[0] == '$' {
-		state.fixedLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
-			return errors.New("protocol error: " + string(msg))
-		}
-		if state.fixedLen <= 0 {
-			state.args[state.receivedCount] = []byte{}
-			state.receivedCount++
-			state.fixedLen = 0
-		}
-	} else {
+	if state.readingBody {
 		state.args[state.receivedCount] = line
 		state.receivedCount++
+		state.readingBody = false
+		return nil
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return errors.New("protocol error: " + string(msg))
+	}
+	bulkLen, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || bulkLen < -1 {
+		return errors.New("protocol error: " + string(msg))
+	}
+	if bulkLen == -1 { // null bulk
+		state.args[state.receivedCount] = nil
+		state.receivedCount++
+		return nil
 	}
+	// 长度为 0 时内容只有 "\r\n", 按普通行读取即可
+	state.fixedLen = bulkLen
+	state.readingBody = true
 	return nil
 }
@@ -0,0 +1,39 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"redisGo/redis/reply"
+	"testing"
+)
+
+func TestParseStream(t *testing.T) {
+	first := reply.MakeMultiBulkReply([][]byte{[]byte("SET"), []byte("$key"), []byte("")}).ToBytes()
+	second := reply.MakeMultiBulkReply([][]byte{[]byte("GET"), []byte("a")}).ToBytes()
+	data := append(append([]byte{}, first...), second...)
+
+	payloads := make([]*Payload, 0)
+	ParseStream(bytes.NewReader(data), func(payload *Payload) bool {
+		payloads = append(payloads, payload)
+		return true
+	})
+	if len(payloads) != 3 || payloads[2].Err != io.EOF {
+		t.Fatalf("expected 2 replies and EOF, got %d payloads", len(payloads))
+	}
+	if !bytes.Equal(payloads[0].Data.ToBytes(), first) || payloads[0].Offset != int64(len(first)) {
+		t.Errorf("unexpected first payload: %q, offset %d", payloads[0].Data.ToBytes(), payloads[0].Offset)
+	}
+	if payloads[1].Offset != int64(len(data)) {
+		t.Errorf("unexpected offset of second payload: %d", payloads[1].Offset)
+	}
+
+	// 截断的数据返回 ErrUnexpectedEOF, Offset 为最后一条完整消息的结束位置
+	var last *Payload
+	ParseStream(bytes.NewReader(data[:len(data)-3]), func(payload *Payload) bool {
+		last = payload
+		return true
+	})
+	if last.Err != io.ErrUnexpectedEOF || last.Offset != int64(len(first)) {
+		t.Errorf("expected unexpected EOF at offset %d, got %v at %d", len(first), last.Err, last.Offset)
+	}
+}
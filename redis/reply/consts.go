@@ -40,6 +40,24 @@ func MakeEmptyMultiBulkReply() *EmptyMultiBulkReply {
 	return &EmptyMultiBulkReply{}
 }
 
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+// NullMultiBulkReply 表示空数组, 如 WATCH 的 key 被修改后 EXEC 的返回值
+type NullMultiBulkReply struct{}
+
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+var queuedBytes = []byte("+QUEUED\r\n")
+
+// QueuedReply 事务中命令入队后的回复
+type QueuedReply struct{}
+
+func (r *QueuedReply) ToBytes() []byte {
+	return queuedBytes
+}
+
 // reply nothing, for commands like subscribe
 type NoReply struct{}
 
@@ -1,12 +1,13 @@
 package reply
 
 import (
+	"bytes"
 	"redisGo/interface/redis"
 	"strconv"
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1")
+	nullBulkReplyBytes = []byte("$-1\r\n")
 	CRLF               = "\r\n"
 )
 
@@ -16,7 +17,7 @@ type BulkReply struct {
 }
 
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
+	if r.Arg == nil {
 		return nullBulkReplyBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
@@ -52,6 +53,29 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return []byte(res)
 }
 
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply 由多个任意类型的 reply 组成, 用于 EXEC 等需要返回嵌套结果的命令
+type MultiRawReply struct {
+	Replies []redis.Reply
+}
+
+func MakeMultiRawReply(replies []redis.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+func (r *MultiRawReply) ToBytes() []byte {
+	argLen := len(r.Replies)
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(arg.ToBytes())
+	}
+	return buf.Bytes()
+}
+
 /* ---- Error Reply ----- */
 type ErrorReply interface {
 	Error() string
@@ -17,10 +17,25 @@ type Client struct {
 	mu sync.Mutex
 
 	// subscribing channels
-	subs map[string]bool
+	subs      map[string]bool
+	shardSubs map[string]bool
+
+	// transaction state, only accessed by the goroutine serving this connection
+	multiState bool
+	queue      [][][]byte
+	watching   map[string]uint32
+	txDirty    bool
+
+	// selected db index
+	selectedDB int
+
+	// 连接关闭时关闭, 唤醒阻塞中的命令
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 func (c *Client) Close() error {
+	c.markClosed()
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
 	c.conn.Close()
 	return nil
@@ -28,10 +43,22 @@ func (c *Client) Close() error {
 
 func MakeClient(conn net.Conn) *Client {
 	return &Client{
-		conn: conn,
+		conn:   conn,
+		closed: make(chan struct{}),
 	}
 }
 
+// markClosed 对端已经断开, 唤醒阻塞中的命令
+func (c *Client) markClosed() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+}
+
+func (c *Client) Closed() <-chan struct{} {
+	return c.closed
+}
+
 func (c *Client) Write(b []byte) error {
 	if len(b) == 0 {
 		return nil
@@ -82,3 +109,83 @@ func (c *Client) GetChannels() []string {
 	}
 	return channels
 }
+
+func (c *Client) SubsShardChannel(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.shardSubs == nil {
+		c.shardSubs = make(map[string]bool)
+	}
+	c.shardSubs[channel] = true
+}
+
+func (c *Client) UnSubsShardChannel(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.shardSubs, channel)
+}
+
+func (c *Client) GetShardChannels() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	channels := make([]string, 0, len(c.shardSubs))
+	for channel := range c.shardSubs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (c *Client) InMultiState() bool {
+	return c.multiState
+}
+
+func (c *Client) SetMultiState(state bool) {
+	if !state { // reset data when cancel multi
+		c.watching = nil
+		c.queue = nil
+		c.txDirty = false
+	}
+	c.multiState = state
+}
+
+func (c *Client) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+func (c *Client) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+func (c *Client) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+func (c *Client) GetWatching() map[string]uint32 {
+	if c.watching == nil {
+		c.watching = make(map[string]uint32)
+	}
+	return c.watching
+}
+
+func (c *Client) SetTxDirty(dirty bool) {
+	c.txDirty = dirty
+}
+
+func (c *Client) IsTxDirty() bool {
+	return c.txDirty
+}
+
+func (c *Client) GetDBIndex() int {
+	return c.selectedDB
+}
+
+func (c *Client) SelectDB(dbNum int) {
+	c.selectedDB = dbNum
+}
+
+func (c *Client) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
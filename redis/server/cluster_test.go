@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"net"
+	"redisGo/config"
+	"redisGo/redis/client"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startClusterNode 使用已经创建的 listener 启动一个集群节点
+func startClusterNode(t *testing.T, listener net.Listener, peers []string) {
+	config.Properties = &config.PropertyHolder{
+		Port:  listener.Addr().(*net.TCPAddr).Port,
+		Self:  listener.Addr().String(),
+		Peers: peers,
+	}
+	handler := MakeRedisHandler()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handler.Handle(context.Background(), conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		_ = handler.Close()
+	})
+}
+
+func listenLocal(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener
+}
+
+// startCluster 在随机端口上启动 n 个集群节点, 返回各节点的地址
+func startCluster(t *testing.T, n int) []string {
+	listeners := make([]net.Listener, n)
+	addrs := make([]string, n)
+	for i := range listeners {
+		listeners[i] = listenLocal(t)
+		addrs[i] = listeners[i].Addr().String()
+	}
+	for i, listener := range listeners {
+		peers := make([]string, 0, n-1)
+		for j, addr := range addrs {
+			if j != i {
+				peers = append(peers, addr)
+			}
+		}
+		startClusterNode(t, listener, peers)
+	}
+	return addrs
+}
+
+func TestClusterRouting(t *testing.T) {
+	addrs := startCluster(t, 2)
+	c0 := connect(t, addrs[0])
+	c1 := connect(t, addrs[1])
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		if r := send(c0, "set", keys[i], strconv.Itoa(i)); r != "+OK\r\n" {
+			t.Fatalf("set %s failed: %q", keys[i], r)
+		}
+	}
+	// 任意节点都能读到其它节点上的 key
+	for i, key := range keys {
+		expected := "$" + strconv.Itoa(len(strconv.Itoa(i))) + "\r\n" + strconv.Itoa(i) + "\r\n"
+		if r := send(c1, "get", key); r != expected {
+			t.Errorf("get %s: expected %q, got %q", key, expected, r)
+		}
+	}
+
+	// 多 key 命令
+	r := send(c1, "mget", "key1", "missing", "key2", "key3")
+	if r != "*4\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n$1\r\n3\r\n" {
+		t.Errorf("unexpected mget reply: %q", r)
+	}
+	if r := send(c0, append([]string{"exists"}, keys...)...); r != ":20\r\n" {
+		t.Errorf("unexpected exists reply: %q", r)
+	}
+	// 节点地址是随机的, 使用全部 key 保证它们分布在不同节点上, 跨节点的命令通过两阶段提交执行
+	if r := send(c0, append([]string{"sinterstore", "dest"}, keys...)...); !strings.HasPrefix(r, "-WRONGTYPE") {
+		t.Errorf("errors of cross-node commands should be returned, got %q", r)
+	}
+	msetArgs := []string{"mset"}
+	for _, key := range keys {
+		msetArgs = append(msetArgs, key, "x")
+	}
+	if r := send(c0, msetArgs...); r != "+OK\r\n" {
+		t.Errorf("cross-node mset failed: %q", r)
+	}
+	for _, key := range keys {
+		if r := send(c1, "get", key); r != "$1\r\nx\r\n" {
+			t.Errorf("get %s after mset: got %q", key, r)
+		}
+	}
+	if r := send(c0, "mset", "{tag}a", "1", "{tag}b", "2"); r != "+OK\r\n" {
+		t.Errorf("keys with same hash tag should be accepted, got %q", r)
+	}
+	if r := send(c1, "rename", "{tag}a", "{tag}c"); r != "+OK\r\n" {
+		t.Errorf("rename with same hash tag failed: %q", r)
+	}
+	if r := send(c1, append([]string{"del"}, keys...)...); r != ":20\r\n" {
+		t.Errorf("unexpected del reply: %q", r)
+	}
+	if r := send(c0, append([]string{"exists"}, keys...)...); r != ":0\r\n" {
+		t.Errorf("keys should be deleted, got %q", r)
+	}
+	if r := send(c0, "select", "1"); !strings.HasPrefix(r, "-ERR") {
+		t.Errorf("select should be rejected in cluster mode, got %q", r)
+	}
+}
+
+const slotsPerNode = 16384 / 3
+
+// ownedSlots 从 CLUSTER NODES 的输出中统计节点负责的槽的数量
+func ownedSlots(nodes string, addr string) int {
+	count := 0
+	for _, line := range strings.Split(nodes, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 8 || !strings.HasPrefix(fields[1], addr+"@") {
+			continue
+		}
+		for _, field := range fields[8:] {
+			if strings.HasPrefix(field, "[") {
+				continue
+			}
+			bounds := strings.Split(field, "-")
+			start, _ := strconv.Atoi(bounds[0])
+			end := start
+			if len(bounds) == 2 {
+				end, _ = strconv.Atoi(bounds[1])
+			}
+			count += end - start + 1
+		}
+	}
+	return count
+}
+
+func TestClusterAddNode(t *testing.T) {
+	addrs := startCluster(t, 2)
+	c0 := connect(t, addrs[0])
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		send(c0, "set", keys[i], strconv.Itoa(i))
+	}
+	send(c0, "rpush", "list", "a", "b", "c")
+	send(c0, "pexpire", "list", "100000")
+
+	// 新节点单独启动, 由 ADDNODE 加入集群并迁移槽
+	listener := listenLocal(t)
+	newAddr := listener.Addr().String()
+	startClusterNode(t, listener, nil)
+	if r := send(c0, "cluster", "addnode", newAddr); r != "+OK\r\n" {
+		t.Fatalf("addnode failed: %q", r)
+	}
+
+	c2 := connect(t, newAddr)
+	// 槽在后台迁移, 等待新节点负责三分之一的槽
+	var nodes string
+	for i := 0; i < 500; i++ {
+		nodes = send(c2, "cluster", "nodes")
+		if ownedSlots(nodes, newAddr) >= slotsPerNode && !strings.Contains(nodes, "[") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := ownedSlots(nodes, newAddr); n < slotsPerNode {
+		t.Fatalf("new node should own at least %d slots, got %d", slotsPerNode, n)
+	}
+	if strings.Count(nodes, "connected") != 3 {
+		t.Errorf("new node should know all nodes, got %q", nodes)
+	}
+	local := 0
+	for i, key := range keys {
+		expected := "$" + strconv.Itoa(len(strconv.Itoa(i))) + "\r\n" + strconv.Itoa(i) + "\r\n"
+		for _, c := range []*client.Client{c0, c2} {
+			if r := send(c, "get", key); r != expected {
+				t.Errorf("get %s: expected %q, got %q", key, expected, r)
+			}
+		}
+		if r := send(c2, "cluster", "countkeysinslot", strings.TrimPrefix(strings.TrimSuffix(send(c2, "cluster", "keyslot", key), "\r\n"), ":")); r != ":0\r\n" {
+			local++
+		}
+	}
+	if local == 0 {
+		t.Error("some keys should be migrated to the new node")
+	}
+	if r := send(c2, "lrange", "list", "0", "-1"); r != "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n" {
+		t.Errorf("unexpected list after migration: %q", r)
+	}
+	if r := send(c2, "pttl", "list"); r == ":-1\r\n" || r == ":-2\r\n" {
+		t.Errorf("ttl should be kept after migration, got %q", r)
+	}
+}
+
+func TestClusterTransaction(t *testing.T) {
+	addrs := startCluster(t, 2)
+	c0 := connect(t, addrs[0])
+	c1 := connect(t, addrs[1])
+	// 找到分别位于两个节点上的 key, 只有 key 所在的节点能统计到槽中的 key
+	keyOn := make(map[bool]string)
+	for i := 0; len(keyOn) < 2; i++ {
+		key := "key" + strconv.Itoa(i)
+		send(c0, "set", key, "x")
+		slot := strings.TrimSuffix(strings.TrimPrefix(send(c0, "cluster", "keyslot", key), ":"), "\r\n")
+		local := send(c0, "cluster", "countkeysinslot", slot) != ":0\r\n"
+		send(c0, "del", key)
+		if _, ok := keyOn[local]; !ok {
+			keyOn[local] = key
+		}
+	}
+	a, b := keyOn[true], keyOn[false]
+
+	send(c0, "set", a, "1")
+	send(c0, "pexpire", a, "100000")
+	if r := send(c1, "rename", a, b); r != "+OK\r\n" {
+		t.Fatalf("cross-node rename failed: %q", r)
+	}
+	if r := send(c0, "exists", a); r != ":0\r\n" {
+		t.Errorf("source key should be removed, got %q", r)
+	}
+	if r := send(c0, "get", b); r != "$1\r\n1\r\n" {
+		t.Errorf("unexpected value after rename: %q", r)
+	}
+	if r := send(c0, "pttl", b); r == ":-1\r\n" || r == ":-2\r\n" {
+		t.Errorf("ttl should be kept after rename, got %q", r)
+	}
+	if r := send(c0, "rename", a, b); !strings.HasPrefix(r, "-ERR no such key") {
+		t.Errorf("errors should be returned and locks released, got %q", r)
+	}
+	if r := send(c1, "del", b); r != ":1\r\n" {
+		t.Errorf("unexpected del reply: %q", r)
+	}
+
+	send(c0, "sadd", a, "m1", "m2")
+	if r := send(c1, "smove", a, b, "m1"); r != ":1\r\n" {
+		t.Fatalf("cross-node smove failed: %q", r)
+	}
+	send(c1, "sadd", b, "m2")
+	if r := send(c0, "sinterstore", "dest", a, b); r != ":1\r\n" {
+		t.Errorf("cross-node sinterstore failed: %q", r)
+	}
+	if r := send(c1, "smembers", "dest"); r != "*1\r\n$2\r\nm2\r\n" {
+		t.Errorf("unexpected members of dest: %q", r)
+	}
+	if r := send(c0, "scard", b); r != ":2\r\n" {
+		t.Errorf("unexpected members of %s: %q", b, r)
+	}
+}
+
+func TestClusterMode(t *testing.T) {
+	config.Properties = &config.PropertyHolder{Peers: []string{"127.0.0.1:7000", "127.0.0.1:7001"}}
+	if _, err := clusterMode(); err == nil {
+		t.Error("peers without self should be rejected")
+	}
+	config.Properties = &config.PropertyHolder{Self: "127.0.0.1:7000", Peers: []string{"127.0.0.1:7000", "127.0.0.1:7001"}}
+	if enabled, err := clusterMode(); err != nil || !enabled {
+		t.Errorf("expected cluster mode, got %v, %v", enabled, err)
+	}
+	config.Properties = &config.PropertyHolder{}
+	if enabled, err := clusterMode(); err != nil || enabled {
+		t.Errorf("expected standalone mode, got %v, %v", enabled, err)
+	}
+}
@@ -2,9 +2,13 @@ package server
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
+	"redisGo/cluster"
+	"redisGo/config"
 	"redisGo/db"
+	idb "redisGo/interface/db"
 	"redisGo/lib/logger"
 	"redisGo/lib/sync/atomic"
 	"redisGo/redis/parser"
@@ -23,22 +27,46 @@ var (
 
 type RedisHandler struct {
 	activeConn sync.Map // *client -> placeholder
-	db         db.DB
+	db         idb.DB
 	closing    atomic.AtomicBool
 }
 
+// MakeRedisHandler 配置了 self 时以集群模式运行, 否则使用单机数据库
 func MakeRedisHandler() *RedisHandler {
+	clusterEnabled, err := clusterMode()
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	var database idb.DB
+	if clusterEnabled {
+		database = cluster.MakeCluster()
+	} else {
+		database = db.MakeMultiDB()
+	}
 	return &RedisHandler{
-		db: *db.MakeDB(),
+		db: database,
 	}
 }
 
+// clusterMode 配置了 peers 却没有配置 self 时无法确定自己负责的 key, 拒绝启动而不是以单机模式运行
+func clusterMode() (bool, error) {
+	if config.Properties.Self == "" && len(config.Properties.Peers) > 0 {
+		return false, errors.New("config error: peers is set but self is missing, set self to this node's address to run in cluster mode")
+	}
+	return config.Properties.Self != "", nil
+}
+
 func (s *RedisHandler) closeClient(client *Client) {
 	_ = client.Close()
 	s.db.AfterClientClose(client)
 	s.activeConn.Delete(client)
 }
 
+// pendingCmdSize 每个连接上等待执行的命令的数量上限
+const pendingCmdSize = 64
+
+// Handle 读取命令和执行命令分别在两个 goroutine 中进行:
+// 执行 BLPOP 等阻塞命令时仍然能够发现连接断开, 从而唤醒阻塞的命令
 func (h *RedisHandler) Handle(ctx context.Context, conn net.Conn) {
 	if h.closing.Get() {
 		_ = conn.Close()
@@ -48,21 +76,37 @@ func (h *RedisHandler) Handle(ctx context.Context, conn net.Conn) {
 	client := MakeClient(conn)
 	h.activeConn.Store(client, 1)
 
+	pending := make(chan *parser.Payload, pendingCmdSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.execLoop(client, pending)
+	}()
+
 	ch := parser.Parse(conn)
 	for payload := range ch {
 		if payload.Err != nil {
 			if payload.Err == io.EOF || payload.Err == io.ErrUnexpectedEOF || strings.Contains(payload.Err.Error(), "use of closed network connection") {
-				h.closeClient(client)
-				logger.Info("connection closed: " + client.conn.RemoteAddr().String())
-				return
+				break
 			}
-		} else {
+		}
+		pending <- payload
+	}
+	client.markClosed()
+	close(pending)
+	<-done
+	h.closeClient(client)
+	logger.Info("connection closed: " + client.conn.RemoteAddr().String())
+}
+
+// execLoop 按顺序执行连接上的命令. 对端关闭写方向之前已经发送的命令仍然会执行,
+// 连接关闭只用于唤醒阻塞中的命令
+func (h *RedisHandler) execLoop(client *Client, pending <-chan *parser.Payload) {
+	for payload := range pending {
+		if payload.Err != nil {
 			errReply := reply.MakeErrReply(payload.Err.Error())
-			err := client.Write(errReply.ToBytes())
-			if err != nil {
-				h.closeClient(client)
-				logger.Info("connection closed: " + client.conn.RemoteAddr().String())
-				return
+			if err := client.Write(errReply.ToBytes()); err != nil {
+				_ = client.conn.Close()
 			}
 			continue
 		}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBlockingCommand(t *testing.T) {
+	addr := startServer(t)
+	c := connect(t, addr)
+
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	reader := bufio.NewReader(blocked)
+	_, _ = blocked.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$4\r\nlist\r\n$1\r\n0\r\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	// 阻塞的连接不影响其它连接
+	if r := send(c, "set", "a", "1"); r != "+OK\r\n" {
+		t.Errorf("unexpected reply: %q", r)
+	}
+	if r := send(c, "rpush", "list", "x"); r != ":1\r\n" {
+		t.Errorf("unexpected reply: %q", r)
+	}
+	_ = blocked.SetReadDeadline(time.Now().Add(time.Second))
+	for _, expected := range []string{"*2\r\n", "$4\r\n", "list\r\n", "$1\r\n", "x\r\n"} {
+		line, err := reader.ReadString('\n')
+		if err != nil || line != expected {
+			t.Fatalf("expected %q, got %q (%v)", expected, line, err)
+		}
+	}
+
+	// 断开的连接不再等待, 之后写入的元素保留在列表中
+	_, _ = blocked.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$4\r\nlist\r\n$1\r\n0\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	_ = blocked.Close()
+	time.Sleep(100 * time.Millisecond)
+	send(c, "rpush", "list", "y")
+	if r := send(c, "lrange", "list", "0", "-1"); !strings.Contains(r, "y") {
+		t.Errorf("element should not be consumed by closed connection, got %q", r)
+	}
+}
+
+// 对端关闭写方向之前已经发送的命令都会执行
+func TestHalfClose(t *testing.T) {
+	addr := startServer(t)
+	const clients, pushes = 20, 100
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			var buf bytes.Buffer
+			for j := 0; j < pushes; j++ {
+				buf.WriteString("*3\r\n$5\r\nRPUSH\r\n$4\r\nlist\r\n$1\r\nx\r\n")
+			}
+			_, _ = conn.Write(buf.Bytes())
+			_ = conn.(*net.TCPConn).CloseWrite()
+			// 读取所有回复直到服务端关闭连接
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _ = io.Copy(io.Discard, conn)
+		}()
+	}
+	wg.Wait()
+	c := connect(t, addr)
+	expected := ":" + strconv.Itoa(clients*pushes) + "\r\n"
+	if r := send(c, "llen", "list"); r != expected {
+		t.Errorf("expected %q, got %q", expected, r)
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"net"
+	"redisGo/config"
+	"redisGo/interface/redis"
+	"redisGo/redis/client"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer 在随机端口上启动一个服务, 返回监听地址
+func startServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Properties = &config.PropertyHolder{
+		Port: listener.Addr().(*net.TCPAddr).Port,
+	}
+	handler := MakeRedisHandler()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handler.Handle(context.Background(), conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		_ = handler.Close()
+	})
+	return listener.Addr().String()
+}
+
+func connect(t *testing.T, addr string) *client.Client {
+	c, err := client.MakeClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	t.Cleanup(c.Close)
+	return c
+}
+
+func send(c *client.Client, args ...string) string {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	var r redis.Reply = c.Send(cmdLine)
+	return string(r.ToBytes())
+}
+
+func waitFor(c *client.Client, expected string, args ...string) string {
+	var actual string
+	for i := 0; i < 100; i++ {
+		actual = send(c, args...)
+		if actual == expected {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return actual
+}
+
+func TestReplication(t *testing.T) {
+	masterAddr := startServer(t)
+	replicaAddr := startServer(t)
+	master := connect(t, masterAddr)
+	replica := connect(t, replicaAddr)
+
+	send(master, "set", "a", "1")
+	send(master, "select", "1")
+	send(master, "rpush", "list", "x", "y")
+
+	host, port, _ := net.SplitHostPort(masterAddr)
+	if r := send(replica, "replicaof", host, port); r != "+OK\r\n" {
+		t.Fatalf("replicaof failed: %q", r)
+	}
+	// 全量同步的快照
+	if r := waitFor(replica, "$1\r\n1\r\n", "get", "a"); r != "$1\r\n1\r\n" {
+		t.Errorf("snapshot not loaded, got %q", r)
+	}
+	// 同步之后的命令流
+	send(master, "rpush", "list", "z")
+	send(replica, "select", "1")
+	expected := "*3\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nz\r\n"
+	if r := waitFor(replica, expected, "lrange", "list", "0", "-1"); r != expected {
+		t.Errorf("command stream not applied, got %q", r)
+	}
+
+	if r := send(replica, "set", "b", "2"); !strings.HasPrefix(r, "-READONLY") {
+		t.Errorf("replica should be read only, got %q", r)
+	}
+	info := send(master, "info", "replication")
+	if !strings.Contains(info, "connected_slaves:1") ||
+		!strings.Contains(info, "port="+strconv.Itoa(config.Properties.Port)) {
+		t.Errorf("unexpected master info: %q", info)
+	}
+	info = send(replica, "info", "replication")
+	if !strings.Contains(info, "role:slave") || !strings.Contains(info, "master_link_status:up") {
+		t.Errorf("unexpected replica info: %q", info)
+	}
+
+	// 提升为主节点后可以写入
+	if r := send(replica, "replicaof", "no", "one"); r != "+OK\r\n" {
+		t.Fatalf("replicaof no one failed: %q", r)
+	}
+	if r := send(replica, "set", "b", "2"); r != "+OK\r\n" {
+		t.Errorf("promoted replica should accept writes, got %q", r)
+	}
+}
+
+func TestReplicationTTL(t *testing.T) {
+	masterAddr := startServer(t)
+	replicaAddr := startServer(t)
+	master := connect(t, masterAddr)
+	replica := connect(t, replicaAddr)
+
+	host, port, _ := net.SplitHostPort(masterAddr)
+	if r := send(replica, "replicaof", host, port); r != "+OK\r\n" {
+		t.Fatalf("replicaof failed: %q", r)
+	}
+	// 全量同步完成之后的命令才会通过命令流传播
+	for i := 0; i < 100 && !strings.Contains(send(master, "info", "replication"), "connected_slaves:1"); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	send(master, "set", "t", "v")
+	send(master, "expire", "t", "100")
+	send(master, "set", "s", "v", "EX", "100")
+	send(master, "set", "done", "1")
+	if r := waitFor(replica, "$1\r\n1\r\n", "get", "done"); r != "$1\r\n1\r\n" {
+		t.Fatalf("command stream not applied, got %q", r)
+	}
+	for _, key := range []string{"t", "s"} {
+		r := send(replica, "ttl", key)
+		ttl, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(r, ":")))
+		if err != nil || ttl < 95 || ttl > 100 {
+			t.Errorf("ttl of %s on replica: expected about 100, got %q", key, r)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package sentinel
+
+import (
+	"math/rand"
+	"net"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/redis/reply"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	cronPeriod = 100 * time.Millisecond
+	maxDesync  = time.Second // 发起选举前的最大随机延迟
+)
+
+func randomDesync() time.Duration {
+	return time.Duration(rand.Int63n(int64(maxDesync)))
+}
+
+// cronLoop 定期向其它哨兵广播当前配置, 并在主节点主观下线时判断是否客观下线
+func (s *Sentinel) cronLoop() {
+	ticker := time.NewTicker(cronPeriod)
+	defer ticker.Stop()
+	var lastHello time.Time
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(lastHello) >= s.period {
+			lastHello = time.Now()
+			s.sendHello()
+		}
+		s.checkObjectivelyDown()
+	}
+}
+
+// broadcast 并发地向所有其它哨兵发送命令, 返回成功的回复
+func (s *Sentinel) broadcast(args ...string) []redis.Reply {
+	s.mu.Lock()
+	peers := make([]*instance, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	s.mu.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	replies := make([]redis.Reply, 0, len(peers))
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer *instance) {
+			defer wg.Done()
+			result, err := peer.send(args...)
+			if err != nil || reply.IsErrorReply(result) {
+				return
+			}
+			mu.Lock()
+			replies = append(replies, result)
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return replies
+}
+
+// sendHello 将主节点地址及其配置纪元告诉其它哨兵, 故障转移的结果通过它传播
+func (s *Sentinel) sendHello() {
+	s.mu.Lock()
+	host, port, _ := net.SplitHostPort(s.master.addr)
+	args := []string{"SENTINEL", "hello", s.runId, strconv.FormatInt(s.currentEpoch, 10), s.masterName,
+		host, port, strconv.FormatInt(s.configEpoch, 10)}
+	s.mu.Unlock()
+	s.broadcast(args...)
+}
+
+// receiveHello 收到其它哨兵的 hello, 配置纪元更新时切换到新的主节点
+func (s *Sentinel) receiveHello(epoch int64, masterName string, masterAddr string, configEpoch int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if masterName != s.masterName {
+		return
+	}
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+	}
+	if configEpoch > s.configEpoch {
+		s.switchMaster(masterAddr, configEpoch)
+	}
+}
+
+// voteLeader 每个纪元只投票一次, 先到先得, 调用者需持有 mu
+func (s *Sentinel) voteLeader(runId string, epoch int64) (string, int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+	}
+	if s.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		s.leader = runId
+		s.leaderEpoch = s.currentEpoch
+		logger.Info("+vote-for-leader " + runId + " " + strconv.FormatInt(epoch, 10))
+		if runId != s.runId {
+			// 已经投票给其它哨兵, 一段时间内不再发起自己的选举
+			s.failoverAt = time.Now().Add(s.failoverTimeout)
+		}
+	}
+	return s.leader, s.leaderEpoch
+}
+
+// isMasterDown 回复其它哨兵的询问, runId 不为 * 时同时请求投票
+func (s *Sentinel) isMasterDown(addr string, epoch int64, runId string) (bool, string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	down := addr == s.master.addr && s.master.sdown
+	if runId == "*" {
+		return down, "*", 0
+	}
+	leader, leaderEpoch := s.voteLeader(runId, epoch)
+	return down, leader, leaderEpoch
+}
+
+// checkObjectivelyDown 询问其它哨兵, 足够多的哨兵认为主节点下线时发起领头哨兵选举
+func (s *Sentinel) checkObjectivelyDown() {
+	s.mu.Lock()
+	if !s.master.sdown || s.failingOver {
+		if !s.master.sdown && s.odown {
+			logger.Info("-odown " + s.master.addr)
+			s.odown = false
+		}
+		s.mu.Unlock()
+		return
+	}
+	masterAddr := s.master.addr
+	host, port, _ := net.SplitHostPort(masterAddr)
+	epoch := strconv.FormatInt(s.currentEpoch, 10)
+	s.mu.Unlock()
+
+	votes := 1
+	for _, result := range s.broadcast("SENTINEL", "is-master-down-by-addr", host, port, epoch, "*") {
+		if r, ok := result.(*reply.MultiBulkReply); ok && len(r.Args) == 3 && string(r.Args[0]) == "1" {
+			votes++
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.master.addr != masterAddr || s.failingOver {
+		return
+	}
+	odown := votes >= s.quorum
+	if odown != s.odown {
+		s.odown = odown
+		if odown {
+			logger.Warn("+odown " + masterAddr + " #quorum " + strconv.Itoa(votes) + "/" + strconv.Itoa(s.quorum))
+			// 随机延迟, 避免多个哨兵同时发起选举导致选票被瓜分
+			desync := time.Now().Add(randomDesync())
+			if s.failoverAt.Before(desync) {
+				s.failoverAt = desync
+			}
+		}
+	}
+	if !odown || time.Now().Before(s.failoverAt) {
+		return
+	}
+	s.currentEpoch++
+	s.failoverAt = time.Now().Add(s.failoverTimeout + randomDesync())
+	electionEpoch := s.currentEpoch
+	s.voteLeader(s.runId, electionEpoch)
+	logger.Info("+new-epoch " + strconv.FormatInt(electionEpoch, 10))
+	go s.startElection(masterAddr, electionEpoch)
+}
+
+// startElection 请求其它哨兵投票, 获得多数票且不少于 quorum 时成为领头哨兵并执行故障转移
+func (s *Sentinel) startElection(masterAddr string, epoch int64) {
+	host, port, _ := net.SplitHostPort(masterAddr)
+	epochStr := strconv.FormatInt(epoch, 10)
+	votes := 1
+	for _, result := range s.broadcast("SENTINEL", "is-master-down-by-addr", host, port, epochStr, s.runId) {
+		r, ok := result.(*reply.MultiBulkReply)
+		if ok && len(r.Args) == 3 && string(r.Args[1]) == s.runId && string(r.Args[2]) == epochStr {
+			votes++
+		}
+	}
+	s.mu.Lock()
+	needed := (len(s.peers)+1)/2 + 1
+	if needed < s.quorum {
+		needed = s.quorum
+	}
+	s.mu.Unlock()
+	if votes < needed {
+		logger.Info("-failover-abort-not-elected, votes " + strconv.Itoa(votes) + "/" + strconv.Itoa(needed))
+		return
+	}
+	logger.Info("+elected-leader " + s.runId + " epoch " + epochStr)
+	s.failover(epoch)
+}
+
+// selectReplica 选择在线且复制偏移量最大的副本, 偏移量相同时按地址排序, 调用者需持有 mu
+func (s *Sentinel) selectReplica() *instance {
+	candidates := make([]*instance, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		if r.sdown || r.role != roleReplica || time.Since(r.infoRefresh) > 5*s.period {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].replOffset != candidates[j].replOffset {
+			return candidates[i].replOffset > candidates[j].replOffset
+		}
+		return candidates[i].addr < candidates[j].addr
+	})
+	return candidates[0]
+}
+
+// failover 提升选中的副本为主节点, 然后让其它副本复制新的主节点
+func (s *Sentinel) failover(epoch int64) {
+	s.mu.Lock()
+	if s.failingOver {
+		s.mu.Unlock()
+		return
+	}
+	s.failingOver = true
+	candidate := s.selectReplica()
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.failingOver = false
+		s.mu.Unlock()
+	}()
+	if candidate == nil {
+		logger.Warn("-failover-abort-no-good-slave")
+		return
+	}
+	logger.Info("+selected-slave " + candidate.addr)
+
+	_, err := candidate.send("REPLICAOF", "NO", "ONE")
+	if err != nil || !s.waitForPromotion(candidate) {
+		logger.Warn("-failover-abort-slave-timeout " + candidate.addr)
+		return
+	}
+	logger.Info("+promoted-slave " + candidate.addr)
+
+	s.mu.Lock()
+	s.switchMaster(candidate.addr, epoch)
+	others := make([]*instance, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		if !r.sdown {
+			others = append(others, r)
+		}
+	}
+	s.mu.Unlock()
+
+	host, port, _ := net.SplitHostPort(candidate.addr)
+	var wg sync.WaitGroup
+	for _, r := range others {
+		wg.Add(1)
+		go func(r *instance) {
+			defer wg.Done()
+			if _, err := r.send("REPLICAOF", host, port); err == nil {
+				logger.Info("+slave-reconf-sent " + r.addr)
+			}
+		}(r)
+	}
+	wg.Wait()
+	s.sendHello()
+	logger.Info("+failover-end " + candidate.addr)
+}
+
+// waitForPromotion 等待副本确认自己已经成为主节点
+func (s *Sentinel) waitForPromotion(candidate *instance) bool {
+	deadline := time.Now().Add(s.failoverTimeout)
+	for time.Now().Before(deadline) {
+		result, err := candidate.send("INFO", "replication")
+		if err == nil {
+			if bulk, ok := result.(*reply.BulkReply); ok && parseInfo(string(bulk.Arg))["role"] == roleMaster {
+				return true
+			}
+		}
+		time.Sleep(s.period)
+	}
+	return false
+}
+
+// switchMaster 使用新的主节点, 旧的主节点作为副本继续监控, 恢复后会被重新配置, 调用者需持有 mu
+func (s *Sentinel) switchMaster(addr string, configEpoch int64) {
+	s.configEpoch = configEpoch
+	s.odown = false
+	if addr == s.master.addr {
+		return
+	}
+	logger.Info("+switch-master " + s.masterName + " " + s.master.addr + " " + addr)
+	old := s.master
+	newMaster, ok := s.replicas[addr]
+	if ok {
+		delete(s.replicas, addr)
+	} else {
+		newMaster = s.newInstance(addr)
+		s.watch(newMaster)
+	}
+	s.master = newMaster
+	s.replicas[old.addr] = old
+}
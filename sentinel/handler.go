@@ -0,0 +1,246 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/lib/sync/atomic"
+	"redisGo/redis/parser"
+	"redisGo/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler 实现 tcp.Handler, 处理客户端以及其它哨兵发来的命令
+type Handler struct {
+	sentinel   *Sentinel
+	activeConn sync.Map // net.Conn -> placeholder
+	closing    atomic.AtomicBool
+}
+
+func MakeHandler(s *Sentinel) *Handler {
+	return &Handler{
+		sentinel: s,
+	}
+}
+
+func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
+	if h.closing.Get() {
+		_ = conn.Close()
+		return
+	}
+	h.activeConn.Store(conn, 1)
+	defer func() {
+		h.activeConn.Delete(conn)
+		_ = conn.Close()
+	}()
+
+	ch := parser.Parse(conn)
+	for payload := range ch {
+		if payload.Err != nil {
+			if payload.Err == io.EOF || payload.Err == io.ErrUnexpectedEOF ||
+				strings.Contains(payload.Err.Error(), "use of closed network connection") {
+				return
+			}
+			if _, err := conn.Write(reply.MakeErrReply(payload.Err.Error()).ToBytes()); err != nil {
+				return
+			}
+			continue
+		}
+		r, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(r.Args) == 0 {
+			logger.Error("require multi bulk reply")
+			continue
+		}
+		result := h.sentinel.Exec(r.Args)
+		if _, err := conn.Write(result.ToBytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (h *Handler) Close() error {
+	logger.Info("sentinel shuting down...")
+	h.closing.Set(true)
+	h.activeConn.Range(func(key, _ any) bool {
+		_ = key.(net.Conn).Close()
+		return true
+	})
+	h.sentinel.Close()
+	return nil
+}
+
+// Exec 执行哨兵支持的命令
+func (s *Sentinel) Exec(args [][]byte) redis.Reply {
+	cmd := strings.ToLower(string(args[0]))
+	switch cmd {
+	case "ping":
+		return &reply.PongReply{}
+	case "info":
+		return s.execInfo()
+	case "sentinel":
+		if len(args) < 2 {
+			return &reply.ArgNumErrReply{Cmd: cmd}
+		}
+		return s.execSentinel(args[1:])
+	}
+	return reply.MakeErrReply("ERR unknown command '" + cmd + "'")
+}
+
+func (s *Sentinel) execInfo() redis.Reply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := "ok"
+	if s.odown {
+		status = "odown"
+	} else if s.master.sdown {
+		status = "sdown"
+	}
+	info := fmt.Sprintf("# Sentinel\r\nsentinel_masters:1\r\nmaster0:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+		s.masterName, status, s.master.addr, len(s.replicas), len(s.peers)+1)
+	return reply.MakeBulkReply([]byte(info))
+}
+
+func (s *Sentinel) execSentinel(args [][]byte) redis.Reply {
+	sub := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch sub {
+	case "get-master-addr-by-name":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "sentinel " + sub}
+		}
+		if string(args[0]) != s.masterName {
+			return &reply.NullMultiBulkReply{}
+		}
+		host, port, _ := net.SplitHostPort(s.GetMasterAddr())
+		return reply.MakeMultiBulkReply([][]byte{[]byte(host), []byte(port)})
+	case "master", "masters":
+		if sub == "master" && (len(args) != 1 || string(args[0]) != s.masterName) {
+			return reply.MakeErrReply("ERR No such master with that name")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		state := s.describe(s.master, roleMaster)
+		state = append(state, "name", s.masterName, "quorum", strconv.Itoa(s.quorum),
+			"config-epoch", strconv.FormatInt(s.configEpoch, 10), "num-slaves", strconv.Itoa(len(s.replicas)))
+		if sub == "masters" {
+			return reply.MakeMultiRawReply([]redis.Reply{toMultiBulk(state)})
+		}
+		return toMultiBulk(state)
+	case "replicas", "slaves", "sentinels":
+		if len(args) != 1 || string(args[0]) != s.masterName {
+			return reply.MakeErrReply("ERR No such master with that name")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		instances, kind := s.replicas, roleReplica
+		if sub == "sentinels" {
+			instances, kind = s.peers, "sentinel"
+		}
+		addrs := make([]string, 0, len(instances))
+		for addr := range instances {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		replies := make([]redis.Reply, 0, len(addrs))
+		for _, addr := range addrs {
+			replies = append(replies, toMultiBulk(s.describe(instances[addr], kind)))
+		}
+		return reply.MakeMultiRawReply(replies)
+	case "is-master-down-by-addr":
+		// SENTINEL is-master-down-by-addr ip port current-epoch runid
+		// 回复中的纪元也以字符串返回, 因为 redis/client 只能解析由字符串组成的数组
+		if len(args) != 4 {
+			return &reply.ArgNumErrReply{Cmd: "sentinel " + sub}
+		}
+		epoch, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		down, leader, leaderEpoch := s.isMasterDown(net.JoinHostPort(string(args[0]), string(args[1])), epoch, string(args[3]))
+		downFlag := "0"
+		if down {
+			downFlag = "1"
+		}
+		return toMultiBulk([]string{downFlag, leader, strconv.FormatInt(leaderEpoch, 10)})
+	case "hello":
+		// SENTINEL hello runid current-epoch master-name master-ip master-port config-epoch
+		if len(args) != 6 {
+			return &reply.ArgNumErrReply{Cmd: "sentinel " + sub}
+		}
+		epoch, err1 := strconv.ParseInt(string(args[1]), 10, 64)
+		configEpoch, err2 := strconv.ParseInt(string(args[5]), 10, 64)
+		if err1 != nil || err2 != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		s.receiveHello(epoch, string(args[2]), net.JoinHostPort(string(args[3]), string(args[4])), configEpoch)
+		return &reply.OkReply{}
+	case "failover":
+		// 不需要其它哨兵同意, 直接进行故障转移
+		if len(args) != 1 || string(args[0]) != s.masterName {
+			return reply.MakeErrReply("ERR No such master with that name")
+		}
+		s.mu.Lock()
+		if s.failingOver {
+			s.mu.Unlock()
+			return reply.MakeErrReply("INPROG Failover already in progress")
+		}
+		if s.selectReplica() == nil {
+			s.mu.Unlock()
+			return reply.MakeErrReply("NOGOODSLAVE No suitable replica to promote")
+		}
+		s.currentEpoch++
+		epoch := s.currentEpoch
+		s.mu.Unlock()
+		go s.failover(epoch)
+		return &reply.OkReply{}
+	}
+	return reply.MakeErrReply("ERR Unknown sentinel subcommand '" + sub + "'")
+}
+
+// describe 返回节点状态的字段与值, 调用者需持有 mu
+func (s *Sentinel) describe(inst *instance, kind string) []string {
+	host, port, _ := net.SplitHostPort(inst.addr)
+	flags := kind
+	if inst.sdown {
+		flags += ",s_down"
+	}
+	if inst == s.master && s.odown {
+		flags += ",o_down"
+	}
+	state := []string{
+		"ip", host,
+		"port", port,
+		"flags", flags,
+		"last-ok-ping-reply", strconv.FormatInt(time.Since(inst.lastPong).Milliseconds(), 10),
+	}
+	if kind == roleReplica {
+		state = append(state,
+			"master-host-port", inst.masterAddr,
+			"master-link-status", linkStatus(inst.masterLinkUp),
+			"slave-repl-offset", strconv.FormatInt(inst.replOffset, 10),
+		)
+	}
+	return state
+}
+
+func linkStatus(up bool) string {
+	if up {
+		return "ok"
+	}
+	return "err"
+}
+
+func toMultiBulk(values []string) *reply.MultiBulkReply {
+	args := make([][]byte, len(values))
+	for i, v := range values {
+		args[i] = []byte(v)
+	}
+	return reply.MakeMultiBulkReply(args)
+}
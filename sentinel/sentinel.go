@@ -0,0 +1,342 @@
+package sentinel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"redisGo/interface/redis"
+	"redisGo/lib/logger"
+	"redisGo/redis/client"
+	"redisGo/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDownAfter       = 30 * time.Second
+	defaultFailoverTimeout = 3 * time.Minute
+	maxPingPeriod          = time.Second
+)
+
+// Config 一个哨兵监控一个主节点, 其它哨兵的地址通过 Peers 静态配置
+type Config struct {
+	MasterName      string
+	MasterAddr      string // host:port
+	Quorum          int    // 认为主节点客观下线所需的哨兵数量
+	DownAfter       time.Duration
+	FailoverTimeout time.Duration
+	Peers           []string // 其它哨兵的地址
+}
+
+const (
+	roleMaster  = "master"
+	roleReplica = "slave"
+)
+
+// instance 被监控的节点, 包括主节点, 副本和其它哨兵
+type instance struct {
+	addr string
+
+	linkMu sync.Mutex // 保护 client, 同一节点上的命令依次发送
+	client *client.Client
+
+	lastPong time.Time // 最近一次收到有效 PING 回复的时间
+	sdown    bool      // 主观下线
+
+	// 从 INFO 中获取的信息, 其它哨兵不使用
+	role            string
+	infoRefresh     time.Time
+	masterAddr      string // 作为副本时所属的主节点
+	masterLinkUp    bool
+	replOffset      int64
+	reconfigureSent time.Time // 最近一次发送 REPLICAOF 的时间
+}
+
+// send 通过该节点的连接发送命令, 连接失败或超时时关闭连接, 下次使用时重新建立
+func (inst *instance) send(args ...string) (redis.Reply, error) {
+	inst.linkMu.Lock()
+	defer inst.linkMu.Unlock()
+	if inst.client == nil {
+		c, err := client.MakeClient(inst.addr)
+		if err != nil {
+			return nil, err
+		}
+		c.Start()
+		inst.client = c
+	}
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	result := inst.client.Send(cmdLine)
+	errReply, ok := result.(reply.ErrorReply)
+	if ok && (errReply.Error() == "server time out" || errReply.Error() == "request failed" ||
+		strings.Contains(errReply.Error(), "EOF") || strings.Contains(errReply.Error(), "closed network connection")) {
+		inst.closeClientLocked()
+		return nil, errors.New(errReply.Error())
+	}
+	return result, nil
+}
+
+func (inst *instance) closeClient() {
+	inst.linkMu.Lock()
+	defer inst.linkMu.Unlock()
+	inst.closeClientLocked()
+}
+
+func (inst *instance) closeClientLocked() {
+	if inst.client != nil {
+		// Close 会等待未完成的请求, 不阻塞监控流程
+		go inst.client.Close()
+		inst.client = nil
+	}
+}
+
+// Sentinel 监控主节点及其副本, 与其它哨兵协商后完成故障转移
+type Sentinel struct {
+	runId           string
+	masterName      string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	period          time.Duration
+
+	mu       sync.Mutex
+	master   *instance
+	replicas map[string]*instance // addr -> instance
+	peers    map[string]*instance // addr -> instance
+
+	currentEpoch int64
+	configEpoch  int64  // 当前主节点配置对应的纪元, 通过 hello 在哨兵之间传播
+	leader       string // 本哨兵在 leaderEpoch 中投票选出的领头哨兵
+	leaderEpoch  int64
+	odown        bool      // 主节点客观下线
+	failoverAt   time.Time // 最早可以开始下一次选举的时间
+	failingOver  bool
+
+	closed chan struct{}
+}
+
+func genRunId() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func MakeSentinel(cfg *Config) *Sentinel {
+	s := &Sentinel{
+		runId:           genRunId(),
+		masterName:      cfg.MasterName,
+		quorum:          cfg.Quorum,
+		downAfter:       cfg.DownAfter,
+		failoverTimeout: cfg.FailoverTimeout,
+		replicas:        make(map[string]*instance),
+		peers:           make(map[string]*instance),
+		closed:          make(chan struct{}),
+	}
+	if s.quorum <= 0 {
+		s.quorum = 1
+	}
+	if s.downAfter <= 0 {
+		s.downAfter = defaultDownAfter
+	}
+	if s.failoverTimeout <= 0 {
+		s.failoverTimeout = defaultFailoverTimeout
+	}
+	// 下线判定时间内至少发送 3 次 PING
+	s.period = s.downAfter / 3
+	if s.period > maxPingPeriod {
+		s.period = maxPingPeriod
+	}
+	s.master = s.newInstance(cfg.MasterAddr)
+	s.master.role = roleMaster
+	for _, peer := range cfg.Peers {
+		s.peers[peer] = s.newInstance(peer)
+	}
+	return s
+}
+
+func (s *Sentinel) newInstance(addr string) *instance {
+	return &instance{
+		addr:     addr,
+		lastPong: time.Now(),
+	}
+}
+
+// Start 为每个节点启动监控 goroutine, 并启动定时任务
+func (s *Sentinel) Start() {
+	s.mu.Lock()
+	for _, inst := range s.allInstances() {
+		s.watch(inst)
+	}
+	s.mu.Unlock()
+	go s.cronLoop()
+}
+
+func (s *Sentinel) Close() {
+	close(s.closed)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, inst := range s.allInstances() {
+		inst.closeClient()
+	}
+}
+
+// allInstances 调用者需持有 mu
+func (s *Sentinel) allInstances() []*instance {
+	result := []*instance{s.master}
+	for _, r := range s.replicas {
+		result = append(result, r)
+	}
+	for _, p := range s.peers {
+		result = append(result, p)
+	}
+	return result
+}
+
+// watch 开始监控一个节点, 调用者需持有 mu
+func (s *Sentinel) watch(inst *instance) {
+	go s.pingLoop(inst)
+}
+
+// pingLoop 定期 PING 节点判断是否主观下线, 对数据节点还会通过 INFO 获取复制信息
+func (s *Sentinel) pingLoop(inst *instance) {
+	ticker := time.NewTicker(s.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		_, isPeer := s.peers[inst.addr]
+		s.mu.Unlock()
+
+		result, err := inst.send("PING")
+		s.mu.Lock()
+		if err == nil && isValidPong(result) {
+			inst.lastPong = time.Now()
+		}
+		sdown := time.Since(inst.lastPong) > s.downAfter
+		if sdown != inst.sdown {
+			inst.sdown = sdown
+			if sdown {
+				logger.Warn("+sdown " + inst.addr)
+			} else {
+				logger.Info("-sdown " + inst.addr)
+			}
+		}
+		s.mu.Unlock()
+		if isPeer || err != nil {
+			continue
+		}
+
+		result, err = inst.send("INFO", "replication")
+		if err != nil {
+			continue
+		}
+		bulk, ok := result.(*reply.BulkReply)
+		if !ok {
+			continue
+		}
+		s.refreshInfo(inst, parseInfo(string(bulk.Arg)))
+	}
+}
+
+// isValidPong 与 Redis 一致, LOADING 和 MASTERDOWN 也认为节点存活
+func isValidPong(result redis.Reply) bool {
+	switch r := result.(type) {
+	case *reply.StatusReply:
+		return r.Status == "PONG"
+	case *reply.PongReply:
+		return true
+	case reply.ErrorReply:
+		return strings.HasPrefix(r.Error(), "LOADING") || strings.HasPrefix(r.Error(), "MASTERDOWN")
+	}
+	return false
+}
+
+func parseInfo(raw string) map[string]string {
+	info := make(map[string]string)
+	for _, line := range strings.Split(raw, "\r\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		pivot := strings.Index(line, ":")
+		if pivot < 0 {
+			continue
+		}
+		info[line[:pivot]] = line[pivot+1:]
+	}
+	return info
+}
+
+// refreshInfo 根据 INFO 更新节点状态, 发现新的副本, 并纠正角色与配置不一致的节点
+func (s *Sentinel) refreshInfo(inst *instance, info map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst.role = info["role"]
+	inst.infoRefresh = time.Now()
+	inst.replOffset, _ = strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+	inst.masterLinkUp = info["master_link_status"] == "up"
+	if inst.role == roleReplica {
+		inst.masterAddr = net.JoinHostPort(info["master_host"], info["master_port"])
+	} else {
+		inst.masterAddr = ""
+	}
+
+	if inst == s.master && inst.role == roleMaster {
+		// 主节点的 INFO 中列出了所有已连接的副本
+		for key, value := range info {
+			if !strings.HasPrefix(key, "slave") || !strings.Contains(value, "ip=") {
+				continue
+			}
+			fields := make(map[string]string)
+			for _, kv := range strings.Split(value, ",") {
+				pivot := strings.Index(kv, "=")
+				if pivot > 0 {
+					fields[kv[:pivot]] = kv[pivot+1:]
+				}
+			}
+			addr := net.JoinHostPort(fields["ip"], fields["port"])
+			if _, ok := s.replicas[addr]; !ok && addr != s.master.addr {
+				logger.Info("+slave " + addr)
+				replica := s.newInstance(addr)
+				s.replicas[addr] = replica
+				s.watch(replica)
+			}
+		}
+		return
+	}
+
+	if _, ok := s.replicas[inst.addr]; !ok || s.failingOver || !s.masterLooksSane() {
+		return
+	}
+	// 副本的角色或主节点与当前配置不一致, 例如故障转移后恢复的旧主节点, 需要重新指向当前主节点
+	if (inst.role == roleMaster || inst.masterAddr != s.master.addr) &&
+		time.Since(inst.reconfigureSent) > s.failoverTimeout {
+		inst.reconfigureSent = time.Now()
+		host, port, _ := net.SplitHostPort(s.master.addr)
+		logger.Info("+convert-to-slave " + inst.addr)
+		go func() {
+			_, _ = inst.send("REPLICAOF", host, port)
+		}()
+	}
+}
+
+// masterLooksSane 主节点在线且确认自己是主节点时才纠正其它节点的配置, 避免使用过期的配置, 调用者需持有 mu
+func (s *Sentinel) masterLooksSane() bool {
+	return !s.master.sdown && s.master.role == roleMaster &&
+		time.Since(s.master.infoRefresh) < 2*s.period+s.downAfter
+}
+
+// GetMasterAddr 返回当前主节点的地址
+func (s *Sentinel) GetMasterAddr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.master.addr
+}
@@ -0,0 +1,157 @@
+package sentinel
+
+import (
+	"context"
+	"net"
+	"redisGo/config"
+	"redisGo/interface/tcp"
+	"redisGo/redis/client"
+	"redisGo/redis/server"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener
+}
+
+// serve 返回的函数用于模拟节点宕机
+func serve(listener net.Listener, handler tcp.Handler) func() {
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handler.Handle(context.Background(), conn)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			_ = listener.Close()
+			_ = handler.Close()
+		})
+	}
+}
+
+func startRedis(t *testing.T) (string, func()) {
+	listener := listen(t)
+	config.Properties = &config.PropertyHolder{
+		Port: listener.Addr().(*net.TCPAddr).Port,
+	}
+	shutdown := serve(listener, server.MakeRedisHandler())
+	t.Cleanup(shutdown)
+	return listener.Addr().String(), shutdown
+}
+
+func send(t *testing.T, addr string, args ...string) string {
+	c, err := client.MakeClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Close()
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	return string(c.Send(cmdLine).ToBytes())
+}
+
+func waitUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+func TestFailover(t *testing.T) {
+	masterAddr, stopMaster := startRedis(t)
+	replicaAddr, _ := startRedis(t)
+	host, port, _ := net.SplitHostPort(masterAddr)
+	if r := send(t, replicaAddr, "replicaof", host, port); r != "+OK\r\n" {
+		t.Fatalf("replicaof failed: %q", r)
+	}
+	send(t, masterAddr, "set", "a", "1")
+
+	listeners := make([]net.Listener, 3)
+	addrs := make([]string, 3)
+	for i := range listeners {
+		listeners[i] = listen(t)
+		addrs[i] = listeners[i].Addr().String()
+	}
+	sentinels := make([]*Sentinel, 3)
+	for i := range sentinels {
+		peers := make([]string, 0, 2)
+		for j, addr := range addrs {
+			if j != i {
+				peers = append(peers, addr)
+			}
+		}
+		sentinels[i] = MakeSentinel(&Config{
+			MasterName:      "mymaster",
+			MasterAddr:      masterAddr,
+			Quorum:          2,
+			DownAfter:       300 * time.Millisecond,
+			FailoverTimeout: time.Second,
+			Peers:           peers,
+		})
+		sentinels[i].Start()
+		t.Cleanup(serve(listeners[i], MakeHandler(sentinels[i])))
+	}
+
+	// 通过主节点的 INFO 发现副本
+	discovered := waitUntil(5*time.Second, func() bool {
+		for _, s := range sentinels {
+			s.mu.Lock()
+			replica, ok := s.replicas[replicaAddr]
+			ready := ok && replica.role == roleReplica
+			s.mu.Unlock()
+			if !ready {
+				return false
+			}
+		}
+		return true
+	})
+	if !discovered {
+		t.Fatal("replica should be discovered by all sentinels")
+	}
+
+	stopMaster()
+	switched := waitUntil(10*time.Second, func() bool {
+		for _, s := range sentinels {
+			if s.GetMasterAddr() != replicaAddr {
+				return false
+			}
+		}
+		return true
+	})
+	if !switched {
+		t.Fatalf("failover not finished, masters: %s %s %s",
+			sentinels[0].GetMasterAddr(), sentinels[1].GetMasterAddr(), sentinels[2].GetMasterAddr())
+	}
+
+	replicaHost, replicaPort, _ := net.SplitHostPort(replicaAddr)
+	expected := "*2\r\n$" + strconv.Itoa(len(replicaHost)) + "\r\n" + replicaHost + "\r\n$" + strconv.Itoa(len(replicaPort)) + "\r\n" + replicaPort + "\r\n"
+	if r := send(t, addrs[0], "sentinel", "get-master-addr-by-name", "mymaster"); r != expected {
+		t.Errorf("unexpected master addr: %q", r)
+	}
+	if info := send(t, replicaAddr, "info", "replication"); !strings.Contains(info, "role:master") {
+		t.Errorf("replica should be promoted, got %q", info)
+	}
+	if r := send(t, replicaAddr, "get", "a"); r != "$1\r\n1\r\n" {
+		t.Errorf("data should be kept after failover, got %q", r)
+	}
+}
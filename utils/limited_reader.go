@@ -22,8 +22,14 @@ func (r *LimitedReader) Read(p []byte) (n int, err error) {
 	if r.src == nil {
 		return 0, errors.New("no data source")
 	}
-	if r.limit > 0 && r.n >= r.limit {
-		return 0, io.EOF
+	if r.limit > 0 {
+		if r.n >= r.limit {
+			return 0, io.EOF
+		}
+		// 不能读取超过 limit 的数据
+		if len(p) > r.limit-r.n {
+			p = p[:r.limit-r.n]
+		}
 	}
 	n, err = r.src.Read(p)
 	if err != nil {